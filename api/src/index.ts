@@ -1,6 +1,7 @@
 import { serve } from '@hono/node-server'
-import { Hono } from 'hono'
+import { Hono, type MiddlewareHandler } from 'hono'
 import { Redis } from 'ioredis';
+import { createHmac, timingSafeEqual } from 'node:crypto';
 
 const app = new Hono()
 const redis = new Redis();
@@ -10,6 +11,10 @@ type Statuses = {
     status: string,
     result: any,
     timestamp: number,
+    attempt?: number,
+    progress?: number,
+    progressMessage?: string,
+    correlationId?: string,
     completedAt?: number | null
   }
 }
@@ -21,14 +26,19 @@ app.post('/produce', async (c) => {
   try {
     const { message } = await c.req.json();
     const id = Date.now().toString();
-    const messageId = await redis.xadd("mystream", "*", "id", id, "body", message);
+    // Follow the request through the worker with the caller's correlation ID
+    const correlationId = c.req.header('X-Correlation-ID') || id;
+    const messageId = await redis.xadd("mystream", "*", "id", id, "body", message,
+      "correlation_id", correlationId, "producer", "api", "enqueued_at", new Date().toISOString());
 
     messageStatuses[id] = {
       status: 'pending',
       result: null,
-      timestamp: Date.now()
+      timestamp: Date.now(),
+      correlationId
     };
-    return c.json({ success: true, messageId, id })
+    c.header('X-Correlation-ID', correlationId);
+    return c.json({ success: true, messageId, id, correlationId })
   } catch (e) {
     return c.json({ error: e })
   }
@@ -46,15 +56,42 @@ app.get('/status/:id', (c) => {
 });
 
 
+// Reject status updates without a valid signature when the worker signs them
+// with HTTP_SIGNING_SECRET, or with a timestamp older than 5 minutes
+const signingSecret = process.env.HTTP_SIGNING_SECRET;
+
+const verifySignature: MiddlewareHandler = async (c, next) => {
+  if (!signingSecret) {
+    return next();
+  }
+  const timestamp = c.req.header('X-Signature-Timestamp') ?? '';
+  const signature = c.req.header('X-Signature') ?? '';
+  if (Math.abs(Date.now() / 1000 - Number(timestamp)) > 300) {
+    return c.json({ error: 'Invalid signature timestamp' }, { status: 401 });
+  }
+  const body = await c.req.text();
+  const expected = 'sha256=' + createHmac('sha256', signingSecret).update(`${timestamp}.${body}`).digest('hex');
+  if (signature.length !== expected.length || !timingSafeEqual(Buffer.from(signature), Buffer.from(expected))) {
+    return c.json({ error: 'Invalid signature' }, { status: 401 });
+  }
+  return next();
+};
+
+app.use('/update-status', verifySignature);
+app.use('/update-status/*', verifySignature);
+
 // API to update message status (called by the backend consumer)
 app.post('/update-status', async (c) => {
-  const { id, status, result } = await c.req.json();
+  const { id, status, result, attempt, progress, progress_message } = await c.req.json();
 
   if (messageStatuses[id]) {
     messageStatuses[id] = {
       ...messageStatuses[id],
       status,
       result,
+      attempt,
+      progress: progress ?? (status === 'completed' ? 100 : messageStatuses[id].progress),
+      progressMessage: progress_message,
       completedAt: status === 'completed' ? Date.now() : null
     };
     return c.json({ success: true });
@@ -63,6 +100,27 @@ app.post('/update-status', async (c) => {
   }
 });
 
+app.post('/update-status/batch', async (c) => {
+  const { updates } = await c.req.json();
+
+  const results = updates.map(({ id, status, result, attempt, progress, progress_message }: any) => {
+    if (!messageStatuses[id]) {
+      return { id, status: 404, error: 'Message not found' };
+    }
+    messageStatuses[id] = {
+      ...messageStatuses[id],
+      status,
+      result,
+      attempt,
+      progress: progress ?? (status === 'completed' ? 100 : messageStatuses[id].progress),
+      progressMessage: progress_message,
+      completedAt: status === 'completed' ? Date.now() : null
+    };
+    return { id, status: 200 };
+  });
+  return c.json({ results });
+});
+
 
 serve({
   fetch: app.fetch,
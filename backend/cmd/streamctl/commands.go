@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// newEnqueueCommand creates the command adding a message to the stream
+func newEnqueueCommand(a *app) *cobra.Command {
+	var (
+		jobType string
+		id      string
+		delay   time.Duration
+		maxLen  int64
+	)
+	cmd := &cobra.Command{
+		Use:   "enqueue [body]",
+		Short: "Add a message to the stream",
+		Long:  "Add a message to the stream, now or after --delay. The body is sent as is; an empty body is used if it is omitted.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := ""
+			if len(args) == 1 {
+				body = args[0]
+			}
+			var opts []producer.Option
+			if jobType != "" {
+				opts = append(opts, producer.WithType(jobType))
+			}
+			if id != "" {
+				opts = append(opts, producer.WithID(id))
+			}
+			if maxLen > 0 {
+				opts = append(opts, producer.WithApproxMaxLen(maxLen))
+			}
+
+			p := producer.New(a.client, producer.WithKeyPrefix(a.config.KeyPrefix))
+			if delay > 0 {
+				jobID, err := p.EnqueueIn(cmd.Context(), a.config.StreamName, delay, body, opts...)
+				if err != nil {
+					return err
+				}
+				return a.print(cmd, map[string]any{"job_id": jobID, "run_at": time.Now().Add(delay)}, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "Scheduled job %s in %s\n", jobID, delay)
+				})
+			}
+			entryID, err := p.Enqueue(cmd.Context(), a.config.StreamName, body, opts...)
+			if err != nil {
+				return err
+			}
+			return a.print(cmd, map[string]any{"entry_id": entryID}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Added entry %s to %s\n", entryID, a.config.StreamName)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&jobType, "type", "", "job type selecting the handler")
+	cmd.Flags().StringVar(&id, "id", "", "job ID (random if empty)")
+	cmd.Flags().DurationVar(&delay, "delay", 0, "schedule the message instead of adding it now")
+	cmd.Flags().Int64Var(&maxLen, "maxlen", 0, "approximately trim the stream to this length")
+	return cmd
+}
+
+// newPendingCommand creates the command listing pending entries
+func newPendingCommand(a *app) *cobra.Command {
+	var (
+		consumer string
+		start    string
+		count    int64
+		summary  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "pending",
+		Short: "List entries pending in the consumer group",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if summary {
+				s, err := a.inspector.Pending(cmd.Context())
+				if err != nil {
+					return err
+				}
+				return a.print(cmd, s, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "CONSUMER\tPENDING")
+					names := make([]string, 0, len(s.Consumers))
+					for name := range s.Consumers {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					for _, name := range names {
+						fmt.Fprintf(tw, "%s\t%d\n", name, s.Consumers[name])
+					}
+					fmt.Fprintf(tw, "TOTAL\t%d\n", s.Count)
+				})
+			}
+
+			entries, err := a.inspector.PendingEntries(cmd.Context(), consumer, start, count)
+			if err != nil {
+				return err
+			}
+			return a.print(cmd, entries, func(tw *tabwriter.Writer) {
+				fmt.Fprintln(tw, "ID\tCONSUMER\tIDLE\tDELIVERIES")
+				for _, e := range entries {
+					idle := (time.Duration(e.IdleMillis) * time.Millisecond).Round(time.Second)
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", e.ID, e.Consumer, idle, e.Deliveries)
+				}
+			})
+		},
+	}
+	cmd.Flags().StringVar(&consumer, "consumer", "", "only list entries of this consumer")
+	cmd.Flags().StringVar(&start, "start", "-", "first entry ID to list")
+	cmd.Flags().Int64Var(&count, "count", 100, "maximum number of entries to list")
+	cmd.Flags().BoolVar(&summary, "summary", false, "only print the number of pending entries per consumer")
+	return cmd
+}
+
+// newDLQCommand creates the dead-letter stream commands
+func newDLQCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspect and replay the dead-letter stream",
+	}
+
+	var (
+		start string
+		count int64
+	)
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List dead-lettered entries, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := a.inspector.DeadLetters(cmd.Context(), start, count)
+			if err != nil {
+				return err
+			}
+			return a.print(cmd, entries, func(tw *tabwriter.Writer) {
+				fmt.Fprintln(tw, "ID\tMESSAGE\tTYPE\tATTEMPTS\tERROR")
+				for _, e := range entries {
+					fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%v\n", e.ID, e.Values["id"], valueOr(e.Values["type"], "-"),
+						e.Values[worker.DeadLetterPrefix+"attempts"], e.Values[worker.DeadLetterPrefix+"error"])
+				}
+			})
+		},
+	}
+	list.Flags().StringVar(&start, "start", "-", "first entry ID to list")
+	list.Flags().Int64Var(&count, "count", 100, "maximum number of entries to list")
+
+	var (
+		all      bool
+		replayed int64
+		rewrites []string
+	)
+	replay := &cobra.Command{
+		Use:   "replay [id...]",
+		Short: "Move dead-lettered entries back to the stream",
+		Long: "Move the given dead-lettered entries back to the stream, or the oldest --count of them with --all. They are " +
+			"processed again from their first attempt. Each --rewrite fixes their fields on the way, setting a path as in " +
+			"'.body.version = 2' or deleting one as in 'del(.body.legacy)'.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) > 0) {
+				return errors.New("give either entry IDs or --all")
+			}
+			transforms, err := worker.ParseRewrites(rewrites)
+			if err != nil {
+				return err
+			}
+
+			if all {
+				n, err := a.inspector.ReplayDeadLetters(cmd.Context(), replayed, transforms...)
+				if err != nil {
+					return fmt.Errorf("failed after replaying %d entries: %w", n, err)
+				}
+				return a.print(cmd, map[string]any{"replayed": n}, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "Replayed %d entries\n", n)
+				})
+			}
+			results := map[string]string{}
+			for _, id := range args {
+				newID, err := a.inspector.ReplayDeadLetter(cmd.Context(), id, transforms...)
+				if err != nil {
+					return fmt.Errorf("failed to replay %s: %w", id, err)
+				}
+				results[id] = newID
+				if !a.jsonMode {
+					fmt.Fprintf(cmd.OutOrStdout(), "Replayed %s as %s\n", id, newID)
+				}
+			}
+			if a.jsonMode {
+				return a.print(cmd, results, nil)
+			}
+			return nil
+		},
+	}
+	replay.Flags().BoolVar(&all, "all", false, "replay the oldest entries instead of the given ones")
+	replay.Flags().Int64Var(&replayed, "count", 100, "maximum number of entries to replay with --all")
+	replay.Flags().StringArrayVar(&rewrites, "rewrite", nil, "jq-style rewrite of the fields of the entries, repeatable")
+
+	cmd.AddCommand(list, replay)
+	return cmd
+}
+
+// newTrimCommand creates the command trimming the stream
+func newTrimCommand(a *app) *cobra.Command {
+	var (
+		maxLen int64
+		minID  string
+		exact  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "trim",
+		Short: "Remove the oldest entries of the stream",
+		Long: "Remove the oldest entries of the stream beyond --maxlen, or older than --minid. Trimming is approximate " +
+			"unless --exact is set. Entries still pending in the consumer group are removed too and won't be processed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				removed int64
+				err     error
+			)
+			switch {
+			case cmd.Flags().Changed("maxlen") == (minID != ""):
+				return errors.New("give either --maxlen or --minid")
+			case minID != "":
+				removed, err = a.inspector.TrimBefore(cmd.Context(), minID, !exact)
+			default:
+				removed, err = a.inspector.Trim(cmd.Context(), maxLen, !exact)
+			}
+			if err != nil {
+				return err
+			}
+			return a.print(cmd, map[string]any{"removed": removed}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Removed %d entries from %s\n", removed, a.config.StreamName)
+			})
+		},
+	}
+	cmd.Flags().Int64Var(&maxLen, "maxlen", 0, "number of entries to keep")
+	cmd.Flags().StringVar(&minID, "minid", "", "remove entries with a lower ID")
+	cmd.Flags().BoolVar(&exact, "exact", false, "trim exactly instead of whole nodes, which is slower")
+	return cmd
+}
+
+// newReplayCommand creates the command replaying the stream from a checkpoint
+func newReplayCommand(a *app) *cobra.Command {
+	var (
+		from  string
+		to    string
+		count int64
+		group string
+	)
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Process the entries of the stream again from an entry ID or time",
+		Long: "Add copies of the entries of the stream from --from to --to to the end of the stream, so that the group " +
+			"processes them again, e.g. after fixing a bug in their handler. Positions are entry IDs or RFC 3339 times. " +
+			"With --new-group, create that consumer group right before --from instead, and run workers with it as " +
+			"GROUP_NAME to process the entries again without copying them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			start, err := replayPosition(from)
+			if err != nil {
+				return fmt.Errorf("invalid --from: %w", err)
+			}
+			end, err := replayPosition(to)
+			if err != nil {
+				return fmt.Errorf("invalid --to: %w", err)
+			}
+
+			if group != "" {
+				if cmd.Flags().Changed("to") || cmd.Flags().Changed("count") {
+					return errors.New("--to and --count can't be used with --new-group")
+				}
+				at, err := a.inspector.ReplayIntoGroup(cmd.Context(), group, start)
+				if err != nil {
+					return err
+				}
+				return a.print(cmd, map[string]any{"group": group, "created_at": at}, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "Created group %s on %s at %s\n", group, a.config.StreamName, at)
+				})
+			}
+			n, err := a.inspector.Replay(cmd.Context(), start, end, count)
+			if err != nil {
+				return fmt.Errorf("failed after replaying %d entries: %w", n, err)
+			}
+			return a.print(cmd, map[string]any{"replayed": n}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Replayed %d entries of %s\n", n, a.config.StreamName)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "first entry ID or time to replay (required)")
+	cmd.Flags().StringVar(&to, "to", "+", "last entry ID or time to replay")
+	cmd.Flags().Int64Var(&count, "count", 0, "maximum number of entries to replay, 0 for all")
+	cmd.Flags().StringVar(&group, "new-group", "", "create this consumer group at --from instead of copying entries")
+	cmd.MarkFlagRequired("from")
+	return cmd
+}
+
+// replayPosition converts an RFC 3339 time to the entry IDs of its
+// millisecond, leaving entry IDs, "-" and "+" as they are
+func replayPosition(s string) (string, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	}
+	if s == "-" || s == "+" {
+		return s, nil
+	}
+	ms, seq, _ := strings.Cut(s, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return "", fmt.Errorf("%q is neither an entry ID nor an RFC 3339 time", s)
+	}
+	if _, err := strconv.ParseUint(seq, 10, 64); seq != "" && err != nil {
+		return "", fmt.Errorf("%q is neither an entry ID nor an RFC 3339 time", s)
+	}
+	return s, nil
+}
+
+// newStatsCommand creates the command printing the state of the queue
+func newStatsCommand(a *app) *cobra.Command {
+	var failures int64
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the stream length, group lag, consumers and recent failures",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o, err := a.inspector.Overview(cmd.Context(), failures)
+			if err != nil {
+				return err
+			}
+			return a.print(cmd, o, func(tw *tabwriter.Writer) {
+				lag := "n/a"
+				if o.Lag != nil {
+					lag = fmt.Sprint(*o.Lag)
+				}
+				fmt.Fprintf(tw, "Stream\t%s\n", o.Stream)
+				fmt.Fprintf(tw, "Group\t%s\n", o.Group)
+				fmt.Fprintf(tw, "Length\t%d\n", o.Length)
+				fmt.Fprintf(tw, "Lag\t%s\n", lag)
+				fmt.Fprintf(tw, "Last delivered\t%s\n", valueOr(o.LastDeliveredID, "-"))
+				fmt.Fprintf(tw, "Pending\t%d\n", o.Pending)
+				fmt.Fprintf(tw, "Paused\t%t\n", o.Paused)
+				fmt.Fprintf(tw, "Dead-lettered\t%d\n", o.DeadLetters)
+
+				if len(o.Consumers) > 0 {
+					fmt.Fprintln(tw, "\nCONSUMER\tPENDING\tIDLE")
+					for _, c := range o.Consumers {
+						idle := (time.Duration(c.IdleMillis) * time.Millisecond).Round(time.Second)
+						fmt.Fprintf(tw, "%s\t%d\t%s\n", c.Name, c.Pending, idle)
+					}
+				}
+				if len(o.RecentFailures) > 0 {
+					fmt.Fprintln(tw, "\nFAILED\tMESSAGE\tERROR")
+					for _, e := range o.RecentFailures {
+						cause, _ := e.Values[worker.DeadLetterPrefix+"error"].(string)
+						fmt.Fprintf(tw, "%s\t%v\t%s\n", e.ID, e.Values["id"], strings.TrimSpace(cause))
+					}
+				}
+			})
+		},
+	}
+	cmd.Flags().Int64Var(&failures, "failures", 10, "number of recent failures to show")
+	return cmd
+}
+
+// newPauseCommand creates the command pausing the consumer group
+func newPauseCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause",
+		Short: "Stop the workers of the group from reading messages",
+		Long: "Stop every worker of the consumer group from reading new messages until resumed. Workers finish the " +
+			"messages they already read and keep their pending entries.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.inspector.Pause(cmd.Context()); err != nil {
+				return err
+			}
+			return a.print(cmd, map[string]any{"paused": true}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Paused %s / %s\n", a.config.StreamName, a.config.GroupName)
+			})
+		},
+	}
+}
+
+// newResumeCommand creates the command resuming the consumer group
+func newResumeCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "Let the workers of a paused group read messages again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.inspector.Resume(cmd.Context()); err != nil {
+				return err
+			}
+			return a.print(cmd, map[string]any{"paused": false}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Resumed %s / %s\n", a.config.StreamName, a.config.GroupName)
+			})
+		},
+	}
+}
+
+// newCancelCommand creates the command cancelling a job
+func newCancelCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <job-id>",
+		Short: "Cancel a queued or running job",
+		Long: "Cancel a job: a worker running it cancels the context of its handler, and workers reading it later " +
+			"skip it. Either way the job is acknowledged and reported with the cancelled status.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.inspector.Cancel(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+			return a.print(cmd, map[string]any{"cancelled": args[0]}, func(tw *tabwriter.Writer) {
+				fmt.Fprintf(tw, "Cancelled job %s\n", args[0])
+			})
+		},
+	}
+}
+
+// valueOr returns v, or fallback if v is nil or empty
+func valueOr(v any, fallback string) any {
+	if v == nil || v == "" {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,131 @@
+// Command streamctl inspects and repairs the queue of a stream worker: it
+// enqueues test messages, lists pending and dead-lettered entries, replays
+// them or the stream from a checkpoint and trims the stream. It reads the
+// same environment and .env file as the worker.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// app holds what the subcommands share once the root command has set it up
+type app struct {
+	config    *worker.Config
+	client    redisclient.Client
+	inspector *worker.Inspector
+
+	stream   string
+	group    string
+	jsonMode bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := newRootCommand().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newRootCommand creates the streamctl command with its subcommands
+func newRootCommand() *cobra.Command {
+	a := &app{}
+	root := &cobra.Command{
+		Use:          "streamctl",
+		Short:        "Inspect and repair the queue of a Redis stream worker",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return a.setup(cmd.Context())
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			if a.client != nil {
+				a.client.Close()
+			}
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.stream, "stream", "", "stream to operate on (defaults to STREAM_NAME)")
+	flags.StringVar(&a.group, "group", "", "consumer group to operate on (defaults to GROUP_NAME)")
+	flags.BoolVar(&a.jsonMode, "json", false, "print results as JSON")
+
+	root.AddCommand(
+		newEnqueueCommand(a),
+		newPendingCommand(a),
+		newDLQCommand(a),
+		newTrimCommand(a),
+		newReplayCommand(a),
+		newStatsCommand(a),
+		newPauseCommand(a),
+		newResumeCommand(a),
+		newCancelCommand(a),
+	)
+	return root
+}
+
+// setup loads the configuration and connects to Redis
+func (a *app) setup(ctx context.Context) error {
+	// Like the worker, a missing .env file is fine
+	_ = godotenv.Load(".env")
+
+	config, err := worker.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	// Default to the first of STREAMS, or to the group it lists for --stream
+	for _, s := range config.Streams {
+		if a.stream == "" || a.stream == s.Name {
+			config.StreamName = s.Name
+			if s.Group != "" {
+				config.GroupName = s.Group
+			}
+			break
+		}
+	}
+	if a.stream != "" {
+		config.StreamName = a.stream
+	}
+	if a.group != "" {
+		config.GroupName = a.group
+	}
+
+	client, err := worker.NewRedisClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Redis client: %w", err)
+	}
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	a.config = config
+	a.client = client
+	a.inspector = worker.NewInspector(client, config)
+	return nil
+}
+
+// print writes v as JSON if --json is set, or else calls table with a
+// tabwriter to write it for humans
+func (a *app) print(cmd *cobra.Command, v any, table func(tw *tabwriter.Writer)) error {
+	if a.jsonMode {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// aliases are shorter flags for the most common settings
+var aliases = map[string]string{
+	"config":  "CONFIG_FILE",
+	"stream":  "STREAM_NAME",
+	"group":   "GROUP_NAME",
+	"workers": "WORKER_COUNT",
+}
+
+// settingAnnotation annotates each flag with the setting it overrides
+const settingAnnotation = "setting"
+
+// newCommand creates the worker command, with a flag for every environment
+// variable, e.g. --redis-url for REDIS_URL, taking precedence over it
+func newCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "worker",
+		Short:        "Process the jobs of Redis streams through consumer groups",
+		Long:         "Process the jobs of Redis streams through consumer groups.\n\nEvery setting is read from its flag, then its environment variable, then CONFIG_FILE, then its default.",
+		Version:      buildVersion(),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			overrides, err := flagOverrides(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			run(func() (*worker.Config, error) {
+				return worker.LoadConfigWithOverrides(overrides)
+			})
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	for _, key := range worker.ConfigKeys() {
+		name := strings.ToLower(strings.ReplaceAll(key.Name, "_", "-"))
+		flags.String(name, "", "overrides $"+key.Name)
+		flags.SetAnnotation(name, settingAnnotation, []string{key.Name})
+		if key.Bool {
+			flags.Lookup(name).NoOptDefVal = "true"
+		}
+	}
+	for name, key := range aliases {
+		flags.String(name, "", "same as --"+strings.ToLower(strings.ReplaceAll(key, "_", "-")))
+		flags.SetAnnotation(name, settingAnnotation, []string{key})
+	}
+	return cmd
+}
+
+// flagOverrides returns the settings given as flags, by environment variable,
+// failing if two flags set the same one, e.g. --stream and --stream-name
+func flagOverrides(flags *pflag.FlagSet) (map[string]string, error) {
+	overrides := map[string]string{}
+	set := map[string]string{}
+	var err error
+	flags.Visit(func(f *pflag.Flag) {
+		keys := f.Annotations[settingAnnotation]
+		if len(keys) == 0 {
+			return
+		}
+		key := keys[0]
+		if other, ok := set[key]; ok {
+			err = fmt.Errorf("--%s and --%s both set %s", other, f.Name, key)
+		}
+		overrides[key], set[key] = f.Value.String(), f.Name
+	})
+	return overrides, err
+}
+
+// buildVersion returns the version of the module the binary was built from,
+// along with its commit and Go version when known
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	details := []string{info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			details = append(details, "commit "+setting.Value[:min(len(setting.Value), 12)])
+		case "vcs.time":
+			details = append(details, "built "+setting.Value)
+		case "vcs.modified":
+			if setting.Value == "true" {
+				details = append(details, "modified")
+			}
+		}
+	}
+	return fmt.Sprintf("%s (%s)", version, strings.Join(details, ", "))
+}
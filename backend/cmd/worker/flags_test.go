@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlagOverrides(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want map[string]string
+		err  string
+	}{
+		{name: "none", args: nil, want: map[string]string{}},
+		{
+			name: "settings",
+			args: []string{"--redis-url=redis://cache:6379/1", "--workers", "8", "--recover-pending"},
+			want: map[string]string{"REDIS_URL": "redis://cache:6379/1", "WORKER_COUNT": "8", "RECOVER_PENDING": "true"},
+		},
+		{name: "false boolean", args: []string{"--dlq-enabled=false"}, want: map[string]string{"DLQ_ENABLED": "false"}},
+		{name: "alias", args: []string{"--stream", "orders"}, want: map[string]string{"STREAM_NAME": "orders"}},
+		{name: "alias and flag", args: []string{"--stream", "orders", "--stream-name", "jobs"}, err: "--stream and --stream-name both set STREAM_NAME"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newCommand()
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			overrides, err := flagOverrides(cmd.Flags())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(overrides, tt.want) {
+				t.Errorf("got overrides %v, want %v", overrides, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq" // Postgres driver for STATUS_BACKEND=postgres and ARCHIVE_BACKEND=postgres
+
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// run runs the worker with the configuration returned by load, until a
+// termination signal
+func run(load func() (*worker.Config, error)) {
+	// Setup logger, replaced once the configured level and format are known
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	// Load .env file if it exists
+	if err := godotenv.Load(".env"); err != nil {
+		// Just log and continue, this is not fatal as env vars might be set another way
+		logger.Warn("Error loading .env file", "error", err)
+	}
+
+	// Load configuration
+	config, err := load()
+	if err != nil {
+		fatal(logger, "Failed to load configuration", err)
+	}
+
+	logger = worker.NewLogger(os.Stdout, config)
+	slog.SetDefault(logger)
+	logger.Info("Starting worker", "config", fmt.Sprintf("%+v", config.Redacted()))
+
+	// Setup tracing
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal(logger, "Failed to set up tracing", err)
+	}
+
+	// Create Redis client
+	redisClient, err := worker.NewRedisClient(config)
+	if err != nil {
+		fatal(logger, "Failed to create Redis client", err)
+	}
+
+	// Ping Redis to ensure connection
+	if err := redisClient.Ping(context.Background()); err != nil {
+		fatal(logger, "Failed to connect to Redis", err)
+	}
+
+	// Cancel the context on termination signals to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	w := worker.New(redisClient, worker.WithConfig(config), worker.WithLogger(logger))
+
+	// Apply configuration changes without restarting
+	go watchConfig(ctx, w, load, logger)
+
+	if err := w.Run(ctx); err != nil {
+		fatal(logger, "Worker stopped", err)
+	}
+
+	// Close Redis connection
+	if err := redisClient.Close(); err != nil {
+		logger.Error("Error closing Redis connection", "error", err)
+	}
+
+	// Flush remaining spans
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("Error shutting down tracing", "error", err)
+	}
+}
+
+// fatal logs err and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// watchConfig reloads the configuration returned by load into w on SIGHUP
+// and, every ConfigWatchInterval, when the content of CONFIG_FILE changed,
+// until ctx is done
+func watchConfig(ctx context.Context, w *worker.Worker, load func() (*worker.Config, error), logger *slog.Logger) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var changed <-chan time.Time
+	file := w.Config().ConfigFile
+	last, _ := os.ReadFile(file)
+	if interval := w.Config().ConfigWatchInterval; file != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		changed = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			logger.Info("Received SIGHUP, reloading configuration")
+		case <-changed:
+			content, err := os.ReadFile(file)
+			if err != nil || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+			logger.Info("Configuration file changed, reloading it", "file", file)
+		}
+
+		config, err := load()
+		if err == nil {
+			err = w.Reload(config)
+		}
+		if err != nil {
+			logger.Error("Failed to reload configuration, keeping the current one", "error", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package compression compresses and decompresses message bodies with the
+// encodings named in their content_encoding field. It is shared by the
+// producer and the worker so both agree on the formats.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// ErrTooLarge is returned by Decompress for data decompressing to more than
+// its limit
+var ErrTooLarge = errors.New("decompressed size exceeds the limit")
+
+// The zstd encoder is safe for concurrent EncodeAll calls, so one is shared
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// Compress compresses data with encoding
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case Gzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// Decompress decompresses data compressed with encoding, failing with
+// ErrTooLarge rather than decompressing more than limit bytes, so that a small
+// body can't exhaust the memory of the worker
+func Decompress(encoding string, data []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case Zstd:
+		// Windows are a power of two, at most twice the size of what they
+		// decompress to
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(2*uint64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) || int64(len(decompressed)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrTooLarge, limit)
+	}
+	return decompressed, err
+}
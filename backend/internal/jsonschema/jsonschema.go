@@ -0,0 +1,515 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema (draft 2020-12) that payload schemas use: types, enums and consts,
+// object properties, array items, string and number bounds, patterns, the
+// allOf, anyOf, oneOf and not combinators and local $refs. Schemas using
+// other keywords, such as format, are rejected rather than half enforced, bar
+// annotations such as title and description.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	root *node
+}
+
+// Error is a place where a document doesn't match its schema
+type Error struct {
+	Path    string // JSON pointer to the value, "" for the document
+	Message string
+}
+
+// Error implements error
+func (e Error) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + e.Message
+}
+
+// node is a compiled schema or subschema
+type node struct {
+	always *bool // set for the true and false schemas
+
+	types      []string
+	enum       []any
+	hasConst   bool
+	constant   any
+	properties map[string]*node
+	required   []string
+	additional *node // nil to allow any
+	items      *node
+	minItems   *int
+	maxItems   *int
+	minLength  *int
+	maxLength  *int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+	exclMin    *float64
+	exclMax    *float64
+	allOf      []*node
+	anyOf      []*node
+	oneOf      []*node
+	not        *node
+	ref        *node
+}
+
+// keywords are the keywords Compile accepts: those it enforces, and the
+// annotations and identifiers that don't affect validation
+var keywords = map[string]bool{
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true, "$ref": true,
+
+	"$schema": true, "$id": true, "$comment": true, "$defs": true, "definitions": true,
+	"title": true, "description": true, "default": true, "examples": true,
+	"deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+// compiler compiles a schema document, resolving its $refs
+type compiler struct {
+	doc  any
+	refs map[string]*node
+}
+
+// Compile parses and compiles a JSON Schema
+func Compile(data []byte) (*Schema, error) {
+	doc, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	c := &compiler{doc: doc, refs: map[string]*node{}}
+	root, err := c.compile(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate validates the JSON document data, returning what doesn't match
+// sorted by path, or nil if it is valid
+func (s *Schema) Validate(data []byte) []Error {
+	doc, err := decode(data)
+	if err != nil {
+		return []Error{{Message: "invalid JSON: " + err.Error()}}
+	}
+	errs := s.root.validate(doc, "")
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// compile compiles the schema v found at the JSON pointer at
+func (c *compiler) compile(v any, at string) (*node, error) {
+	if b, ok := v.(bool); ok {
+		return &node{always: &b}, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema at %q must be an object or a boolean", at)
+	}
+	for _, keyword := range slices.Sorted(maps.Keys(obj)) {
+		if !keywords[keyword] {
+			return nil, fmt.Errorf("unsupported keyword %s at %q", keyword, at)
+		}
+	}
+	n := &node{}
+
+	if ref, ok := obj["$ref"].(string); ok {
+		target, err := c.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		n.ref = target
+	}
+
+	switch t := obj["type"].(type) {
+	case nil:
+	case string:
+		n.types = []string{t}
+	case []any:
+		for _, name := range t {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid type at %q", at)
+			}
+			n.types = append(n.types, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid type at %q", at)
+	}
+
+	if e, ok := obj["enum"]; ok {
+		list, ok := e.([]any)
+		if !ok {
+			return nil, fmt.Errorf("enum at %q must be an array", at)
+		}
+		n.enum = list
+	}
+	n.constant, n.hasConst = obj["const"]
+
+	if props, ok := obj["properties"].(map[string]any); ok {
+		n.properties = map[string]*node{}
+		for name, sub := range props {
+			compiled, err := c.compile(sub, at+"/properties/"+escape(name))
+			if err != nil {
+				return nil, err
+			}
+			n.properties[name] = compiled
+		}
+	}
+	if req, ok := obj["required"].([]any); ok {
+		for _, name := range req {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("required at %q must list strings", at)
+			}
+			n.required = append(n.required, s)
+		}
+	}
+
+	var err error
+	if sub, ok := obj["additionalProperties"]; ok {
+		if n.additional, err = c.compile(sub, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if sub, ok := obj["items"]; ok {
+		if n.items, err = c.compile(sub, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if sub, ok := obj["not"]; ok {
+		if n.not, err = c.compile(sub, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	combinators := []struct {
+		keyword string
+		dst     *[]*node
+	}{{"allOf", &n.allOf}, {"anyOf", &n.anyOf}, {"oneOf", &n.oneOf}}
+	for _, comb := range combinators {
+		keyword, dst := comb.keyword, comb.dst
+		list, ok := obj[keyword].([]any)
+		if !ok {
+			continue
+		}
+		for i, sub := range list {
+			compiled, err := c.compile(sub, fmt.Sprintf("%s/%s/%d", at, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, compiled)
+		}
+	}
+
+	counts := []struct {
+		keyword string
+		dst     **int
+	}{{"minItems", &n.minItems}, {"maxItems", &n.maxItems}, {"minLength", &n.minLength}, {"maxLength", &n.maxLength}}
+	for _, count := range counts {
+		keyword, dst := count.keyword, count.dst
+		if v, ok := obj[keyword]; ok {
+			f, ok := number(v)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%s at %q must be a non-negative integer", keyword, at)
+			}
+			i := int(f)
+			*dst = &i
+		}
+	}
+	bounds := []struct {
+		keyword string
+		dst     **float64
+	}{{"minimum", &n.minimum}, {"maximum", &n.maximum}, {"exclusiveMinimum", &n.exclMin}, {"exclusiveMaximum", &n.exclMax}}
+	for _, bound := range bounds {
+		keyword, dst := bound.keyword, bound.dst
+		if v, ok := obj[keyword]; ok {
+			f, ok := number(v)
+			if !ok {
+				return nil, fmt.Errorf("%s at %q must be a number", keyword, at)
+			}
+			*dst = &f
+		}
+	}
+	if p, ok := obj["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid pattern at %q: %w", at, err)
+		}
+	}
+	return n, nil
+}
+
+// resolve compiles the subschema a local $ref such as "#/$defs/address"
+// points to, once however many refs point to it
+func (c *compiler) resolve(ref string) (*node, error) {
+	if n, ok := c.refs[ref]; ok {
+		return n, nil
+	}
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q, only local refs are", ref)
+	}
+	target := c.doc
+	if pointer != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch v := target.(type) {
+			case map[string]any:
+				target, ok = v[token]
+			case []any:
+				i, err := strconv.Atoi(token)
+				ok = err == nil && i >= 0 && i < len(v)
+				if ok {
+					target = v[i]
+				}
+			default:
+				ok = false
+			}
+			if !ok {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+		}
+	}
+
+	// Register the node before compiling it so that recursive refs end
+	n := &node{}
+	c.refs[ref] = n
+	compiled, err := c.compile(target, pointer)
+	if err != nil {
+		return nil, err
+	}
+	*n = *compiled
+	return n, nil
+}
+
+// validate returns what doesn't match in v, found at the JSON pointer at
+func (n *node) validate(v any, at string) []Error {
+	if n.always != nil {
+		if *n.always {
+			return nil
+		}
+		return []Error{{Path: at, Message: "no value is allowed"}}
+	}
+
+	var errs []Error
+	fail := func(format string, args ...any) {
+		errs = append(errs, Error{Path: at, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n.ref != nil {
+		errs = append(errs, n.ref.validate(v, at)...)
+	}
+	if len(n.types) > 0 && !slices.ContainsFunc(n.types, func(t string) bool { return hasType(v, t) }) {
+		fail("expected %s, got %s", strings.Join(n.types, " or "), typeName(v))
+		// The other keywords would only repeat the mismatch
+		return errs
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e any) bool { return equal(v, e) }) {
+		fail("must be one of %s", encode(n.enum))
+	}
+	if n.hasConst && !equal(v, n.constant) {
+		fail("must be %s", encode(n.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range n.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := at + "/" + escape(name)
+			if sub, ok := n.properties[name]; ok {
+				errs = append(errs, sub.validate(v[name], path)...)
+			} else if n.additional != nil {
+				if n.additional.always != nil && !*n.additional.always {
+					errs = append(errs, Error{Path: path, Message: "additional property not allowed"})
+				} else {
+					errs = append(errs, n.additional.validate(v[name], path)...)
+				}
+			}
+		}
+	case []any:
+		if n.minItems != nil && len(v) < *n.minItems {
+			fail("must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			fail("must have at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, item := range v {
+				errs = append(errs, n.items.validate(item, at+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if n.minLength != nil && length < *n.minLength {
+			fail("must be at least %d characters long", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail("must be at most %d characters long", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail("must match %q", n.pattern.String())
+		}
+	case json.Number:
+		f, _ := number(v)
+		if n.minimum != nil && f < *n.minimum {
+			fail("must be >= %v", *n.minimum)
+		}
+		if n.maximum != nil && f > *n.maximum {
+			fail("must be <= %v", *n.maximum)
+		}
+		if n.exclMin != nil && f <= *n.exclMin {
+			fail("must be > %v", *n.exclMin)
+		}
+		if n.exclMax != nil && f >= *n.exclMax {
+			fail("must be < %v", *n.exclMax)
+		}
+	}
+
+	for _, sub := range n.allOf {
+		errs = append(errs, sub.validate(v, at)...)
+	}
+	if len(n.anyOf) > 0 && !slices.ContainsFunc(n.anyOf, func(sub *node) bool { return len(sub.validate(v, at)) == 0 }) {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if len(n.oneOf) > 0 {
+		matched := 0
+		for _, sub := range n.oneOf {
+			if len(sub.validate(v, at)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matched)
+		}
+	}
+	if n.not != nil && len(n.not.validate(v, at)) == 0 {
+		fail("must not match the not schema")
+	}
+	return errs
+}
+
+// hasType reports whether v is of the JSON Schema type t
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := number(v)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	default:
+		return typeName(v) == t
+	}
+}
+
+// typeName returns the JSON type of a decoded value
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// number returns v as a float64 if it is a number
+func number(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// equal reports whether two decoded values are the same JSON value, numbers
+// being compared by value
+func equal(a, b any) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !equal(va, vb) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// decode decodes a single JSON value, keeping numbers as json.Number
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the value")
+	}
+	return v, nil
+}
+
+// encode returns the JSON text of v for error messages
+func encode(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// escape escapes a property name for a JSON pointer
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
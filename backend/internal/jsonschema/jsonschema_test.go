@@ -0,0 +1,226 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		keyword string
+		schema  string
+		valid   []string
+		invalid map[string]string // document to the error it must report
+	}{
+		{
+			keyword: "true and false schemas",
+			schema:  `{"properties": {"any": true, "none": false}}`,
+			valid:   []string{`{"any": 1}`, `{}`},
+			invalid: map[string]string{`{"none": 1}`: "/none: no value is allowed"},
+		},
+		{
+			keyword: "type",
+			schema:  `{"type": "string"}`,
+			valid:   []string{`"a"`, `""`},
+			invalid: map[string]string{`1`: "/: expected string, got number", `null`: "/: expected string, got null"},
+		},
+		{
+			keyword: "type list",
+			schema:  `{"type": ["integer", "null"]}`,
+			valid:   []string{`1`, `1.0`, `null`},
+			invalid: map[string]string{`1.5`: "/: expected integer or null, got number", `"1"`: "/: expected integer or null, got string"},
+		},
+		{
+			keyword: "enum",
+			schema:  `{"enum": ["a", 1, null]}`,
+			valid:   []string{`"a"`, `1.0`, `null`},
+			invalid: map[string]string{`"b"`: `/: must be one of ["a",1,null]`},
+		},
+		{
+			keyword: "const",
+			schema:  `{"const": {"a": [1, 2]}}`,
+			valid:   []string{`{"a": [1, 2.0]}`},
+			invalid: map[string]string{`{"a": [2, 1]}`: `/: must be {"a":[1,2]}`},
+		},
+		{
+			keyword: "properties",
+			schema:  `{"properties": {"a": {"type": "string"}, "b/c": {"type": "number"}}}`,
+			valid:   []string{`{"a": "x", "b/c": 1}`, `{"other": true}`, `[]`},
+			invalid: map[string]string{`{"a": 1}`: "/a: expected string, got number", `{"b/c": "x"}`: "/b~1c: expected number, got string"},
+		},
+		{
+			keyword: "required",
+			schema:  `{"required": ["a"]}`,
+			valid:   []string{`{"a": null}`, `"not an object"`},
+			invalid: map[string]string{`{}`: `/: missing required property "a"`},
+		},
+		{
+			keyword: "additionalProperties false",
+			schema:  `{"properties": {"a": {}}, "additionalProperties": false}`,
+			valid:   []string{`{"a": 1}`},
+			invalid: map[string]string{`{"a": 1, "b": 2}`: "/b: additional property not allowed"},
+		},
+		{
+			keyword: "additionalProperties schema",
+			schema:  `{"additionalProperties": {"type": "integer"}}`,
+			valid:   []string{`{"a": 1}`},
+			invalid: map[string]string{`{"a": "1"}`: "/a: expected integer, got string"},
+		},
+		{
+			keyword: "items",
+			schema:  `{"items": {"type": "boolean"}}`,
+			valid:   []string{`[]`, `[true, false]`},
+			invalid: map[string]string{`[true, 0]`: "/1: expected boolean, got number"},
+		},
+		{
+			keyword: "minItems",
+			schema:  `{"minItems": 2}`,
+			valid:   []string{`[1, 2]`, `{}`},
+			invalid: map[string]string{`[1]`: "/: must have at least 2 items"},
+		},
+		{
+			keyword: "maxItems",
+			schema:  `{"maxItems": 1}`,
+			valid:   []string{`[]`, `[1]`},
+			invalid: map[string]string{`[1, 2]`: "/: must have at most 1 items"},
+		},
+		{
+			keyword: "minLength",
+			schema:  `{"minLength": 2}`,
+			valid:   []string{`"ab"`, `"é€"`, `1`},
+			invalid: map[string]string{`"é"`: "/: must be at least 2 characters long"},
+		},
+		{
+			keyword: "maxLength",
+			schema:  `{"maxLength": 2}`,
+			valid:   []string{`"é€"`},
+			invalid: map[string]string{`"abc"`: "/: must be at most 2 characters long"},
+		},
+		{
+			keyword: "pattern",
+			schema:  `{"pattern": "^[a-z]+$"}`,
+			valid:   []string{`"abc"`},
+			invalid: map[string]string{`"aBc"`: `/: must match "^[a-z]+$"`},
+		},
+		{
+			keyword: "minimum",
+			schema:  `{"minimum": 1}`,
+			valid:   []string{`1`, `2.5`, `"0"`},
+			invalid: map[string]string{`0.9`: "/: must be >= 1"},
+		},
+		{
+			keyword: "maximum",
+			schema:  `{"maximum": 1}`,
+			valid:   []string{`1`},
+			invalid: map[string]string{`1.1`: "/: must be <= 1"},
+		},
+		{
+			keyword: "exclusiveMinimum",
+			schema:  `{"exclusiveMinimum": 0}`,
+			valid:   []string{`0.1`},
+			invalid: map[string]string{`0`: "/: must be > 0"},
+		},
+		{
+			keyword: "exclusiveMaximum",
+			schema:  `{"exclusiveMaximum": 10}`,
+			valid:   []string{`9`},
+			invalid: map[string]string{`10`: "/: must be < 10"},
+		},
+		{
+			keyword: "allOf",
+			schema:  `{"allOf": [{"minimum": 1}, {"maximum": 2}]}`,
+			valid:   []string{`1.5`},
+			invalid: map[string]string{`3`: "/: must be <= 2"},
+		},
+		{
+			keyword: "anyOf",
+			schema:  `{"anyOf": [{"type": "string"}, {"minimum": 5}]}`,
+			valid:   []string{`"a"`, `5`},
+			invalid: map[string]string{`4`: "/: must match at least one of the anyOf schemas"},
+		},
+		{
+			keyword: "oneOf",
+			schema:  `{"oneOf": [{"type": "integer"}, {"minimum": 5}]}`,
+			valid:   []string{`1`, `5.5`},
+			invalid: map[string]string{`6`: "/: must match exactly one of the oneOf schemas, matched 2", `4.5`: "matched 0"},
+		},
+		{
+			keyword: "not",
+			schema:  `{"not": {"type": "null"}}`,
+			valid:   []string{`0`},
+			invalid: map[string]string{`null`: "/: must not match the not schema"},
+		},
+		{
+			keyword: "$ref",
+			schema: `{
+				"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}, "value": {"type": "integer"}}}},
+				"$ref": "#/$defs/node"
+			}`,
+			valid:   []string{`{"value": 1, "next": {"value": 2, "next": {}}}`},
+			invalid: map[string]string{`{"next": {"next": {"value": "3"}}}`: "/next/next/value: expected integer, got string"},
+		},
+		{
+			keyword: "annotations",
+			schema: `{
+				"$schema": "https://json-schema.org/draft/2020-12/schema", "$id": "order", "$comment": "c",
+				"title": "t", "description": "d", "default": {}, "examples": [{}],
+				"deprecated": false, "readOnly": false, "writeOnly": false, "type": "object"
+			}`,
+			valid:   []string{`{}`},
+			invalid: map[string]string{`[]`: "/: expected object, got array"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			for _, doc := range tt.valid {
+				if errs := schema.Validate([]byte(doc)); errs != nil {
+					t.Errorf("Validate(%s) = %v, want valid", doc, errs)
+				}
+			}
+			for doc, want := range tt.invalid {
+				errs := schema.Validate([]byte(doc))
+				if len(errs) == 0 {
+					t.Errorf("Validate(%s) is valid, want %q", doc, want)
+					continue
+				}
+				if got := errs[0].Error(); !strings.Contains(got, want) {
+					t.Errorf("Validate(%s) = %q, want %q", doc, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"format", `{"type": "string", "format": "email"}`, `unsupported keyword format at ""`},
+		{"nested unknown keyword", `{"properties": {"tags": {"uniqueItems": true}}}`, `unsupported keyword uniqueItems at "/properties/tags"`},
+		{"unknown keyword in a ref", `{"$defs": {"a": {"multipleOf": 2}}, "$ref": "#/$defs/a"}`, `unsupported keyword multipleOf at "/$defs/a"`},
+		{"misspelled keyword", `{"require": ["a"]}`, "unsupported keyword require"},
+		{"not a schema", `[]`, "must be an object or a boolean"},
+		{"invalid type", `{"type": 1}`, "invalid type"},
+		{"invalid enum", `{"enum": 1}`, "enum at \"\" must be an array"},
+		{"invalid count", `{"minLength": -1}`, "minLength at \"\" must be a non-negative integer"},
+		{"invalid bound", `{"minimum": "1"}`, "minimum at \"\" must be a number"},
+		{"invalid pattern", `{"pattern": "("}`, "invalid pattern"},
+		{"remote ref", `{"$ref": "https://example.com/schema.json"}`, "only local refs are"},
+		{"unresolved ref", `{"$ref": "#/$defs/missing"}`, "unresolved $ref"},
+		{"invalid JSON", `{`, "invalid schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile(%s) = %v, want an error containing %q", tt.schema, err, tt.want)
+			}
+		})
+	}
+}
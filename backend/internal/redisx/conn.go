@@ -0,0 +1,49 @@
+package redisx
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Conn is the go-redis client behind a redisclient.Client made by
+// redisclient.Adapt, which returns it from its RedisConn method
+type Conn struct {
+	client redis.UniversalClient
+}
+
+// NewConn wraps client
+func NewConn(client redis.UniversalClient) *Conn {
+	return &Conn{client: client}
+}
+
+// connHolder is implemented by the clients of redisclient.Adapt
+type connHolder interface {
+	RedisConn() *Conn
+}
+
+// Universal returns the go-redis client behind client. The worker and
+// producer need the whole client, for scripts, transactions and pub/sub, so
+// they panic when given a client made another way.
+func Universal(client any) redis.UniversalClient {
+	holder, ok := client.(connHolder)
+	if !ok {
+		panic("redis client must be made by redisclient.Adapt or worker.NewRedisClient")
+	}
+	return holder.RedisConn().client
+}
+
+// Tx queues the commands of a redisclient.Tx in a go-redis transaction
+type Tx struct {
+	pipe redis.Pipeliner
+}
+
+// NewTx adapts pipe
+func NewTx(pipe redis.Pipeliner) *Tx {
+	return &Tx{pipe: pipe}
+}
+
+// Do queues a command in the transaction
+func (t *Tx) Do(ctx context.Context, args ...any) {
+	t.pipe.Do(ctx, args...)
+}
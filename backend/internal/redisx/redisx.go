@@ -0,0 +1,163 @@
+// Package redisx holds what depends on the Redis client library beyond its
+// typed commands: raw commands and the shape of their replies, which changes
+// with the protocol version the client speaks. Swapping or upgrading the
+// client only touches this package and the imports of the others.
+package redisx
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scanCount is how many keys SCAN looks at per call
+const scanCount = 1000
+
+// NodeForKey returns the client to send raw commands about key to. Commands
+// such as XINFO or ROLE don't declare their key position, so a cluster client
+// would send them to a random node; they must go to the master owning the key.
+func NodeForKey(ctx context.Context, client redis.UniversalClient, key string) (redis.UniversalClient, error) {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return client, nil
+	}
+	return cluster.MasterForKey(ctx, key)
+}
+
+// XInfo runs an XINFO subcommand listing groups or consumers of stream and
+// returns the fields of each. It is sent as a raw command since the typed
+// commands turn the fields Redis can't tell, such as the lag, into zeros.
+func XInfo(ctx context.Context, client redis.UniversalClient, subcommand, stream string, args ...any) ([]map[string]any, error) {
+	node, err := NodeForKey(ctx, client, stream)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := node.Do(ctx, append([]any{"XINFO", subcommand, stream}, args...)...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]any, 0, len(reply))
+	for _, item := range reply {
+		list = append(list, fields(item))
+	}
+	return list, nil
+}
+
+// ScanStreams returns the names of the streams matching the glob pattern,
+// sorted. A cluster client scans every master, since SCAN only covers the
+// keys of the node it is sent to.
+func ScanStreams(ctx context.Context, client redis.UniversalClient, pattern string) ([]string, error) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	scan := func(ctx context.Context, node redis.Cmdable) error {
+		iter := node.ScanType(ctx, 0, pattern, scanCount, "stream").Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			names = append(names, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node)
+		})
+	} else {
+		err = scan(ctx, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// SCAN may return a key more than once
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// XInfoStream runs XINFO STREAM and returns its fields, like XInfo
+func XInfoStream(ctx context.Context, client redis.UniversalClient, stream string) (map[string]any, error) {
+	node, err := NodeForKey(ctx, client, stream)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := node.Do(ctx, "XINFO", "STREAM", stream).Result()
+	if err != nil {
+		return nil, err
+	}
+	switch reply.(type) {
+	case []any, map[any]any:
+		return fields(reply), nil
+	default:
+		return nil, fmt.Errorf("unexpected XINFO STREAM reply %T", reply)
+	}
+}
+
+// fields turns a reply listing fields into a map: a flat list of names and
+// values with RESP2, or a map with RESP3
+func fields(reply any) map[string]any {
+	values := map[string]any{}
+	switch reply := reply.(type) {
+	case []any:
+		for i := 0; i+1 < len(reply); i += 2 {
+			if name, ok := reply[i].(string); ok {
+				values[name] = reply[i+1]
+			}
+		}
+	case map[any]any:
+		for k, v := range reply {
+			if name, ok := k.(string); ok {
+				values[name] = v
+			}
+		}
+	}
+	return values
+}
+
+// SameSlot reports whether a Lua script may touch all of keys through client:
+// always unless client is a cluster client and they hash to several slots
+func SameSlot(client redis.UniversalClient, keys ...string) bool {
+	if _, ok := client.(*redis.ClusterClient); !ok || len(keys) == 0 {
+		return true
+	}
+	slot := Slot(keys[0])
+	for _, key := range keys[1:] {
+		if Slot(key) != slot {
+			return false
+		}
+	}
+	return true
+}
+
+// Slot returns the cluster hash slot of key, hashing only its hash tag, the
+// part between the first { and the next }, if it has a non-empty one
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % 16384)
+}
+
+// crc16 is the CRC16-CCITT (XModem) checksum Redis Cluster hashes keys with
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
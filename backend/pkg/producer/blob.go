@@ -0,0 +1,109 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// FieldBodyRef holds the reference of a body stored aside by WithBlobStore,
+// in place of the body field
+const FieldBodyRef = "body_ref"
+
+// ErrBlobNotFound is returned by BlobStore.Get for references to no blob
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores the bodies too large to be put in the stream, for the
+// claim-check pattern: the entry only carries a reference to its body, which
+// the worker fetches before running the handler and deletes once the job
+// completed. Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores data and returns a reference to it
+	Put(ctx context.Context, data []byte) (string, error)
+
+	// Get returns the data stored under ref, or ErrBlobNotFound
+	Get(ctx context.Context, ref string) ([]byte, error)
+
+	// Delete removes the data stored under ref, if any
+	Delete(ctx context.Context, ref string) error
+}
+
+// RedisBlobStore stores bodies in Redis string keys
+type RedisBlobStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisBlobStore creates a BlobStore keeping bodies in the <prefix><ID>
+// keys, such as "blob:" followed by a random ID, for ttl or until they are
+// deleted if ttl is zero. On Redis Cluster they can live on any node. Like
+// New, it takes a client from redisclient.Adapt.
+func NewRedisBlobStore(client redisclient.Client, prefix string, ttl time.Duration) *RedisBlobStore {
+	return &RedisBlobStore{client: redisx.Universal(client), prefix: prefix, ttl: ttl}
+}
+
+// Put implements BlobStore
+func (s *RedisBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	ref := s.prefix + id
+	if err := s.client.Set(ctx, ref, data, s.ttl).Err(); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// Get implements BlobStore
+func (s *RedisBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	data, err := s.client.Get(ctx, ref).Bytes()
+	if err == redis.Nil {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+// Delete implements BlobStore
+func (s *RedisBlobStore) Delete(ctx context.Context, ref string) error {
+	return s.client.Del(ctx, ref).Err()
+}
+
+// WithBlobStore stores bodies larger than limit bytes in store, putting only
+// a reference to them in the body_ref field of the entry. Bodies are stored
+// once compressed, if they are, and the worker must read from the same store.
+func WithBlobStore(store BlobStore, limit int) Option {
+	return func(o *options) {
+		o.blobStore = store
+		o.blobLimit = limit
+	}
+}
+
+// storeBody moves the body field of an entry to the blob store if it is too
+// large
+func storeBody(ctx context.Context, values map[string]any, o options) error {
+	body, ok := values[FieldBody].(string)
+	if !ok || o.blobStore == nil || len(body) <= o.blobLimit {
+		return nil
+	}
+	ref, err := o.blobStore.Put(ctx, []byte(body))
+	if err != nil {
+		return err
+	}
+	delete(values, FieldBody)
+	values[FieldBodyRef] = ref
+	return nil
+}
+
+// releaseBody deletes the body stored aside of an entry that couldn't be added
+func releaseBody(ctx context.Context, values map[string]any, o options) {
+	if ref, ok := values[FieldBodyRef].(string); ok {
+		o.blobStore.Delete(ctx, ref)
+	}
+}
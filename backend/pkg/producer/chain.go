@@ -0,0 +1,81 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FieldChain holds the steps left in the chain of a job, as a JSON array
+const FieldChain = "chain"
+
+// Step is a job of a chain, enqueued by the worker once the previous one
+// succeeded
+type Step struct {
+	Stream  string // defaults to the stream of the previous job
+	Type    string
+	Payload any // nil for the result of the previous job
+}
+
+// chainStep is a step as stored in the chain field
+type chainStep struct {
+	Stream string  `json:"stream,omitempty"`
+	Type   string  `json:"type,omitempty"`
+	Body   *string `json:"body,omitempty"`
+}
+
+// WithChain makes the job the first of a pipeline: once it succeeds the
+// worker enqueues the first step, carrying the others, and so on until the
+// last one, each only after its predecessor succeeded. The chain field isn't
+// compressed or encrypted along with the body.
+func WithChain(steps ...Step) Option {
+	return func(o *options) {
+		o.chain = steps
+	}
+}
+
+// encodeChain encodes the steps of a chain for the chain field
+func encodeChain(steps []Step) (string, error) {
+	chain := make([]chainStep, len(steps))
+	for i, step := range steps {
+		chain[i] = chainStep{Stream: step.Stream, Type: step.Type}
+		if step.Payload != nil {
+			body, err := encodeBody(step.Payload)
+			if err != nil {
+				return "", err
+			}
+			chain[i].Body = &body
+		}
+	}
+	encoded, err := json.Marshal(chain)
+	return string(encoded), err
+}
+
+// DecodeChain decodes the chain field of an entry, whose steps carry their
+// encoded bodies as string payloads
+func DecodeChain(s string) ([]Step, error) {
+	var chain []chainStep
+	if err := json.Unmarshal([]byte(s), &chain); err != nil {
+		return nil, err
+	}
+	steps := make([]Step, len(chain))
+	for i, step := range chain {
+		steps[i] = Step{Stream: step.Stream, Type: step.Type}
+		if step.Body != nil {
+			steps[i].Payload = *step.Body
+		}
+	}
+	return steps, nil
+}
+
+// chainField sets the chain field of an entry, if the job has one
+func chainField(values map[string]any, o options) error {
+	if len(o.chain) == 0 {
+		return nil
+	}
+	chain, err := encodeChain(o.chain)
+	if err != nil {
+		return fmt.Errorf("failed to encode chain: %w", err)
+	}
+	values[FieldChain] = chain
+	return nil
+}
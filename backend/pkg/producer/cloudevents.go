@@ -0,0 +1,105 @@
+package producer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// CloudEventsVersion is the version of the CloudEvents specification the
+// events are written in
+const CloudEventsVersion = "1.0"
+
+// Fields of the entries of jobs enqueued as CloudEvents
+const (
+	// FieldCloudEvent holds the whole event as JSON in structured mode
+	FieldCloudEvent = "ce"
+
+	// CloudEventPrefix prefixes the fields holding the attributes of the
+	// event in binary mode, such as ce_id and ce_type
+	CloudEventPrefix = "ce_"
+)
+
+// CloudEventMode selects how a job is written as a CloudEvent
+type CloudEventMode int
+
+const (
+	// CloudEventStructured writes the whole event, attributes and data, as
+	// JSON in the ce field
+	CloudEventStructured CloudEventMode = iota + 1
+
+	// CloudEventBinary writes each attribute in a ce_ field, such as
+	// ce_source, and the data in the body field
+	CloudEventBinary
+)
+
+// WithCloudEvent enqueues the job as a CloudEvents 1.0 event from source, for
+// other event-driven systems to read. The job ID becomes the id of the event
+// and the job type, which is required, its type. Bodies that are valid JSON
+// are sent as application/json data, protobuf ones as base64 data and others
+// as text/plain.
+func WithCloudEvent(mode CloudEventMode, source string) Option {
+	return func(o *options) {
+		o.cloudEventMode = mode
+		o.cloudEventSource = source
+	}
+}
+
+// cloudEvent replaces the id, type and body fields of values with the event
+// of the job
+func cloudEvent(values map[string]any, o options) error {
+	if o.jobType == "" {
+		return errors.New("CloudEvents need a job type")
+	}
+	if o.cloudEventSource == "" {
+		return errors.New("CloudEvents need a source")
+	}
+	body, _ := values[FieldBody].(string)
+	delete(values, FieldID)
+	delete(values, FieldType)
+	delete(values, FieldBody)
+
+	contentType, _ := values[FieldContentType].(string)
+	if contentType == "" {
+		contentType = "text/plain"
+		if json.Valid([]byte(body)) {
+			contentType = ContentTypeJSON
+		}
+	}
+	attributes := map[string]string{
+		"specversion":     CloudEventsVersion,
+		"id":              o.id,
+		"source":          o.cloudEventSource,
+		"type":            o.jobType,
+		"time":            time.Now().UTC().Format(time.RFC3339Nano),
+		"datacontenttype": contentType,
+	}
+
+	if o.cloudEventMode == CloudEventBinary {
+		for name, value := range attributes {
+			values[CloudEventPrefix+name] = value
+		}
+		values[FieldBody] = body
+		return nil
+	}
+
+	event := make(map[string]any, len(attributes)+1)
+	for name, value := range attributes {
+		event[name] = value
+	}
+	switch {
+	case contentType == ContentTypeJSON:
+		event["data"] = json.RawMessage(body)
+	case contentType == ContentTypeProtobuf:
+		event["data_base64"] = base64.StdEncoding.EncodeToString([]byte(body))
+	default:
+		event["data"] = body
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	values[FieldCloudEvent] = string(encoded)
+	return nil
+}
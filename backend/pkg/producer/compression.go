@@ -0,0 +1,44 @@
+package producer
+
+import "github.com/soham901/go-redis-stream-worker/internal/compression"
+
+// FieldContentEncoding names the compression of a compressed body
+const FieldContentEncoding = "content_encoding"
+
+// Compression is an algorithm compressing bodies
+type Compression string
+
+// Compressions
+const (
+	Gzip Compression = compression.Gzip
+	Zstd Compression = compression.Zstd
+)
+
+// WithCompression compresses bodies larger than threshold bytes with
+// algorithm, marking them with a content_encoding field so that the worker
+// decompresses them before running the handler. Bodies that don't shrink are
+// left as is, as are those of structured CloudEvents.
+func WithCompression(algorithm Compression, threshold int) Option {
+	return func(o *options) {
+		o.compression = algorithm
+		o.compressionThreshold = threshold
+	}
+}
+
+// compressBody compresses the body field of an entry if it is large enough
+func compressBody(values map[string]any, o options) error {
+	body, ok := values[FieldBody].(string)
+	if !ok || o.compression == "" || len(body) <= o.compressionThreshold {
+		return nil
+	}
+	compressed, err := compression.Compress(string(o.compression), []byte(body))
+	if err != nil {
+		return err
+	}
+	if len(compressed) >= len(body) {
+		return nil
+	}
+	values[FieldContentEncoding] = string(o.compression)
+	values[FieldBody] = string(compressed)
+	return nil
+}
@@ -0,0 +1,118 @@
+package producer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/soham901/go-redis-stream-worker/internal/encryption"
+)
+
+// Fields of encrypted bodies
+const (
+	// FieldEncryption names the cipher of an encrypted body
+	FieldEncryption = "encryption"
+
+	// FieldKeyID is the ID of the key the body was encrypted with
+	FieldKeyID = "key_id"
+)
+
+// EncryptionAESGCM marks bodies encrypted with AES-GCM
+const EncryptionAESGCM = "aes-gcm"
+
+// ErrUnknownKey is returned by Keyring.Key for key IDs it doesn't have
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring holds the AES keys bodies are encrypted with, by ID, so that keys
+// can be rotated: new bodies are encrypted with the current key while those
+// already in the stream are decrypted with the key they name. Keys are 16, 24
+// or 32 bytes long, for AES-128, AES-192 or AES-256. Implementations fetching
+// keys from a KMS should cache them, as they are asked for on every message,
+// and must be safe for concurrent use.
+type Keyring interface {
+	// CurrentKey returns the key to encrypt new bodies with and its ID
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, or ErrUnknownKey
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyring is a Keyring of fixed keys
+type StaticKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// ParseKeyring parses keys given as comma-separated <id>:<base64 key> pairs,
+// such as those of the ENCRYPTION_KEYS variable, the first being the current
+// key and the others kept to decrypt older bodies
+func ParseKeyring(s string) (*StaticKeyring, error) {
+	k := &StaticKeyring{keys: map[string][]byte{}}
+	for _, pair := range strings.Split(s, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key %q isn't <id>:<base64 key>", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s isn't base64: %w", id, err)
+		}
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("key %s is %d bytes long, not 16, 24 or 32", id, n)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("key %s is given twice", id)
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// CurrentKey implements Keyring
+func (k *StaticKeyring) CurrentKey(ctx context.Context) (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+// Key implements Keyring
+func (k *StaticKeyring) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// WithEncryption encrypts the body with the current key of keys using
+// AES-GCM, naming the key in the key_id field so that the worker decrypts it
+// with the same key. The body is bound to the job ID, so it can't be moved to
+// another job. Bodies of structured CloudEvents aren't encrypted.
+func WithEncryption(keys Keyring) Option {
+	return func(o *options) {
+		o.keyring = keys
+	}
+}
+
+// encryptBody encrypts the body field of an entry if asked to
+func encryptBody(ctx context.Context, values map[string]any, o options) error {
+	body, ok := values[FieldBody].(string)
+	if !ok || o.keyring == nil {
+		return nil
+	}
+	id, key, err := o.keyring.CurrentKey(ctx)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryption.Seal(key, []byte(body), []byte(o.id))
+	if err != nil {
+		return err
+	}
+	values[FieldEncryption] = EncryptionAESGCM
+	values[FieldKeyID] = id
+	values[FieldBody] = string(sealed)
+	return nil
+}
@@ -0,0 +1,37 @@
+package producer
+
+import "time"
+
+// FieldExpiresAt is the time after which the job is useless, set by
+// WithExpiresAt and WithTTL. Workers reading it later skip it with the expired
+// status.
+const FieldExpiresAt = "expires_at"
+
+// WithExpiresAt has workers skip the job instead of processing it if they read
+// it after t, e.g. for one-time passwords that are no use once they expired
+func WithExpiresAt(t time.Time) Option {
+	return func(o *options) {
+		o.expiresAt = t
+		o.ttl = 0
+	}
+}
+
+// WithTTL has workers skip the job instead of processing it if they read it
+// more than ttl after it was enqueued, or after it was due for EnqueueAt
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+		o.expiresAt = time.Time{}
+	}
+}
+
+// setExpiry sets the expiry of a job ready to run at from, if it has one
+func setExpiry(values map[string]any, o options, from time.Time) {
+	expiresAt := o.expiresAt
+	if o.ttl > 0 {
+		expiresAt = from.Add(o.ttl)
+	}
+	if !expiresAt.IsZero() {
+		values[FieldExpiresAt] = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+}
@@ -0,0 +1,201 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FieldGroup is the ID of the job group a job belongs to
+const FieldGroup = "job_group"
+
+// defaultGroupTTL is how long the state of a job group is kept by default
+const defaultGroupTTL = 7 * 24 * time.Hour
+
+// ErrGroupNotFound is returned for job groups that don't exist or expired
+var ErrGroupNotFound = errors.New("job group not found")
+
+// GroupKey returns the hash holding the state of job group id
+func GroupKey(id string) string {
+	return "group:" + id
+}
+
+// GroupResultsKey returns the hash holding the outcome of each finished job of
+// group id, by job ID
+func GroupResultsKey(id string) string {
+	return GroupKey(id) + ":results"
+}
+
+// GroupJob is a job of a group
+type GroupJob struct {
+	Payload any
+	Options []Option
+}
+
+// Group is a set of jobs processed independently, followed by a callback job
+// once all of them finished
+type Group struct {
+	ID   string // defaults to a random ID
+	Jobs []GroupJob
+
+	// Callback is enqueued once every job finished, to Stream or else the
+	// stream of the jobs, with the group ID as {"group": id} if it has no
+	// payload. With RequireAll it is only enqueued if all of them succeeded.
+	Callback   *Step
+	RequireAll bool
+
+	// TTL is how long the state of the group is kept, a week if zero
+	TTL time.Duration
+}
+
+// GroupStatus is the progress of a job group
+type GroupStatus struct {
+	ID        string
+	Total     int
+	Pending   int
+	Completed int
+	Failed    int
+	Results   map[string]GroupResult // of the finished jobs, by job ID
+}
+
+// GroupResult is the outcome of a finished job of a group
+type GroupResult struct {
+	Status string          `json:"status"` // completed or failed
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// EnqueueGroup adds the jobs of g to stream along with the state of the group,
+// in one transaction, and returns the group ID. opts apply to every job and
+// to the callback, before their own options. Workers record the outcome of
+// each job once it completed or failed for good, and the last one to finish
+// enqueues the callback and reports the group with the completed, partial or
+// failed status. On Redis Cluster the stream, the callback stream and the
+// group keys must hash to the same slot, e.g. with a group ID sharing the
+// hash tag of the stream.
+func (p *Producer) EnqueueGroup(ctx context.Context, stream string, g Group, opts ...Option) (string, error) {
+	if len(g.Jobs) == 0 {
+		return "", errors.New("job group has no jobs")
+	}
+	o := options{}
+	for _, opt := range slices.Concat(p.defaults, opts) {
+		opt(&o)
+	}
+	if g.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate group ID: %w", err)
+		}
+		g.ID = id
+	}
+	ttl := g.TTL
+	if ttl <= 0 {
+		ttl = defaultGroupTTL
+	}
+
+	state := map[string]any{
+		"total":      len(g.Jobs),
+		"pending":    len(g.Jobs),
+		"completed":  0,
+		"failed":     0,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if g.RequireAll {
+		state["require_all"] = "1"
+	}
+	if g.Callback != nil {
+		callback, err := p.groupCallback(ctx, stream, g, opts)
+		if err != nil {
+			return "", err
+		}
+		fields, err := json.Marshal(callback.Values)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode callback: %w", err)
+		}
+		state["callback"] = string(fields)
+		state["callback_stream"] = callback.Stream
+	}
+
+	entries := make([]*Entry, len(g.Jobs))
+	for i, job := range g.Jobs {
+		entry, err := p.Entry(ctx, stream, job.Payload, slices.Concat(opts, []Option{WithMetadata(FieldGroup, g.ID)}, job.Options)...)
+		if err != nil {
+			return "", err
+		}
+		entries[i] = entry
+	}
+
+	key := o.key(GroupKey(g.ID))
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, state)
+		pipe.Expire(ctx, key, ttl)
+		for _, entry := range entries {
+			pipe.XAdd(ctx, entry.xaddArgs())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add job group: %w", err)
+	}
+	return g.ID, nil
+}
+
+// groupCallback builds the entry of the callback of a group
+func (p *Producer) groupCallback(ctx context.Context, stream string, g Group, opts []Option) (*Entry, error) {
+	step := *g.Callback
+	if step.Stream != "" {
+		stream = step.Stream
+	}
+	payload := step.Payload
+	if payload == nil {
+		payload = map[string]string{"group": g.ID}
+	}
+	if step.Type != "" {
+		opts = append(slices.Clone(opts), WithType(step.Type))
+	}
+	callback, err := p.Entry(ctx, stream, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build callback: %w", err)
+	}
+	return callback, nil
+}
+
+// GroupStatus returns the progress of job group id, or ErrGroupNotFound
+func (p *Producer) GroupStatus(ctx context.Context, id string) (*GroupStatus, error) {
+	o := options{}
+	for _, opt := range p.defaults {
+		opt(&o)
+	}
+	var state, results *redis.MapStringStringCmd
+	_, err := p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		state = pipe.HGetAll(ctx, o.key(GroupKey(id)))
+		results = pipe.HGetAll(ctx, o.key(GroupResultsKey(id)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(state.Val()) == 0 {
+		return nil, ErrGroupNotFound
+	}
+
+	fields := state.Val()
+	status := &GroupStatus{ID: id, Results: make(map[string]GroupResult, len(results.Val()))}
+	status.Total, _ = strconv.Atoi(fields["total"])
+	status.Pending, _ = strconv.Atoi(fields["pending"])
+	status.Completed, _ = strconv.Atoi(fields["completed"])
+	status.Failed, _ = strconv.Atoi(fields["failed"])
+	for jobID, encoded := range results.Val() {
+		var result GroupResult
+		if err := json.Unmarshal([]byte(encoded), &result); err == nil {
+			status.Results[jobID] = result
+		}
+	}
+	return status, nil
+}
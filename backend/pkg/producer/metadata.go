@@ -0,0 +1,81 @@
+package producer
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fields letting a job be followed from the request that enqueued it to its
+// status updates
+const (
+	// FieldTraceID is the ID of the trace active when the job was enqueued
+	FieldTraceID = "trace_id"
+
+	// FieldCorrelationID is shared by the jobs of one request, and defaults
+	// to the job ID
+	FieldCorrelationID = "correlation_id"
+
+	// FieldProducer names the program that enqueued the job
+	FieldProducer = "producer"
+)
+
+// defaultProducerName is the producer field of jobs enqueued without
+// WithProducerName
+var defaultProducerName, _ = os.Hostname()
+
+// correlationKey is the context key of the correlation ID
+type correlationKey struct{}
+
+// ContextWithCorrelationID returns a copy of ctx carrying a correlation ID,
+// which jobs enqueued with it get unless given WithCorrelationID. Handlers
+// run with the correlation ID of their job, so the jobs they enqueue share it.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of ctx, or an empty
+// string if it has none
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// WithCorrelationID sets the correlation ID of the job, instead of the one of
+// the context or the job ID
+func WithCorrelationID(id string) Option {
+	return func(o *options) {
+		o.correlationID = id
+	}
+}
+
+// WithProducerName sets the producer field, which defaults to the host name
+func WithProducerName(name string) Option {
+	return func(o *options) {
+		o.producerName = name
+	}
+}
+
+// setMetadata sets the trace ID, correlation ID and producer fields of an
+// entry
+func setMetadata(ctx context.Context, values map[string]any, o options) {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		values[FieldTraceID] = sc.TraceID().String()
+	}
+	correlationID := o.correlationID
+	if correlationID == "" {
+		correlationID = CorrelationIDFromContext(ctx)
+	}
+	if correlationID == "" {
+		correlationID = o.id
+	}
+	values[FieldCorrelationID] = correlationID
+	producer := o.producerName
+	if producer == "" {
+		producer = defaultProducerName
+	}
+	if producer != "" {
+		values[FieldProducer] = producer
+	}
+}
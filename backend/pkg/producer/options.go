@@ -0,0 +1,148 @@
+package producer
+
+import (
+	"strings"
+	"time"
+)
+
+// Option configures a single Enqueue call, or every call of a Producer when
+// given to New
+type Option func(*options)
+
+// options holds the settings of an Enqueue call
+type options struct {
+	id       string
+	jobType  string
+	maxLen   int64
+	approx   bool
+	metadata map[string]string
+
+	idempotencyKey string
+	partitionKey   string
+	replyTo        string
+	priority       Priority
+	correlationID  string
+	producerName   string
+
+	uniqueKey string
+	uniqueTTL time.Duration
+
+	expiresAt time.Time
+	ttl       time.Duration
+
+	chain []Step
+
+	policy map[string]string
+
+	base64Body           bool
+	compression          Compression
+	compressionThreshold int
+
+	keyring Keyring
+
+	blobStore BlobStore
+	blobLimit int
+
+	cloudEventMode   CloudEventMode
+	cloudEventSource string
+
+	keyPrefix string
+}
+
+// WithKeyPrefix prepends prefix to the stream names given, as the worker does
+// with its KeyPrefix, unless they already start with it. It is meant for New,
+// so that every job goes to the streams of one environment.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// key returns name with the key prefix, unless it already has it
+func (o options) key(name string) string {
+	if strings.HasPrefix(name, o.keyPrefix) {
+		return name
+	}
+	return o.keyPrefix + name
+}
+
+// WithID sets the job ID reported in status updates, instead of a random one
+func WithID(id string) Option {
+	return func(o *options) {
+		o.id = id
+	}
+}
+
+// WithType sets the job type, which selects the handler the worker runs
+func WithType(jobType string) Option {
+	return func(o *options) {
+		o.jobType = jobType
+	}
+}
+
+// WithIdempotencyKey sets a key identifying the job across retried enqueues.
+// When the worker's DedupWindow is set, jobs with a key already processed
+// within the window are skipped.
+func WithIdempotencyKey(key string) Option {
+	return func(o *options) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithPartitionKey sets a key, such as the ID of the entity the job is about,
+// whose jobs a worker processes one at a time and in order
+func WithPartitionKey(key string) Option {
+	return func(o *options) {
+		o.partitionKey = key
+	}
+}
+
+// WithReplyTo asks the worker to add the outcome of the job to the stream
+// replyTo once it completes or fails for good, for AwaitReply to read. Request
+// sets it to a stream of its own.
+func WithReplyTo(replyTo string) Option {
+	return func(o *options) {
+		o.replyTo = replyTo
+	}
+}
+
+// WithUnique rejects the job if another job was enqueued on the same stream
+// with the same key within ttl, e.g. to debounce cache refreshes. Enqueue and
+// EnqueueAt then return a DuplicateJobError carrying the ID of that job
+// instead of adding this one. Unlike WithIdempotencyKey, this is checked when
+// enqueueing and the key is held for ttl whether the job was processed or
+// not, and forever if ttl is zero.
+func WithUnique(key string, ttl time.Duration) Option {
+	return func(o *options) {
+		o.uniqueKey = key
+		o.uniqueTTL = ttl
+	}
+}
+
+// WithMaxLen trims the stream to at most n entries when adding the job
+func WithMaxLen(n int64) Option {
+	return func(o *options) {
+		o.maxLen = n
+		o.approx = false
+	}
+}
+
+// WithApproxMaxLen trims the stream to about n entries when adding the job,
+// which is cheaper than an exact trim since Redis only removes whole nodes
+func WithApproxMaxLen(n int64) Option {
+	return func(o *options) {
+		o.maxLen = n
+		o.approx = true
+	}
+}
+
+// WithMetadata adds a field to the entry. The id, body and enqueued_at fields
+// can't be overridden this way.
+func WithMetadata(key, value string) Option {
+	return func(o *options) {
+		if o.metadata == nil {
+			o.metadata = map[string]string{}
+		}
+		o.metadata[key] = value
+	}
+}
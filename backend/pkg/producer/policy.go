@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"strconv"
+	"time"
+)
+
+// Fields overriding the retry and timeout policy the worker has for the type
+// of a job, set by WithMaxRetries, WithRetryDelay, WithRetryBackoff,
+// WithHandlerTimeout and WithDeadLetterStream
+const (
+	FieldMaxRetries       = "max_retries"
+	FieldRetryDelay       = "retry_delay_ms"
+	FieldRetryBackoff     = "retry_backoff"
+	FieldTimeout          = "timeout_ms"
+	FieldDeadLetterStream = "dead_letter_stream"
+)
+
+// WithMaxRetries has workers retry the job at most n times before
+// dead-lettering it, n being capped at their MESSAGE_MAX_RETRIES
+func WithMaxRetries(n int) Option {
+	return func(o *options) {
+		o.setPolicy(FieldMaxRetries, strconv.Itoa(max(n, 0)))
+	}
+}
+
+// WithRetryDelay sets the delay before the first retry of the job, which grows
+// with its retry backoff
+func WithRetryDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.setPolicy(FieldRetryDelay, strconv.FormatInt(d.Milliseconds(), 10))
+	}
+}
+
+// WithRetryBackoff sets how the delay between retries of the job grows:
+// exponential, linear or constant
+func WithRetryBackoff(backoff string) Option {
+	return func(o *options) {
+		o.setPolicy(FieldRetryBackoff, backoff)
+	}
+}
+
+// WithHandlerTimeout bounds each attempt at processing the job to d, which
+// workers cap at their MESSAGE_MAX_TIMEOUT
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.setPolicy(FieldTimeout, strconv.FormatInt(d.Milliseconds(), 10))
+	}
+}
+
+// WithDeadLetterStream has workers move the job to stream instead of their
+// dead-letter stream once it failed for good, if stream is one of their
+// MESSAGE_DLQ_STREAMS
+func WithDeadLetterStream(stream string) Option {
+	return func(o *options) {
+		o.setPolicy(FieldDeadLetterStream, stream)
+	}
+}
+
+// setPolicy records a policy field of the job
+func (o *options) setPolicy(field, value string) {
+	if o.policy == nil {
+		o.policy = map[string]string{}
+	}
+	o.policy[field] = value
+}
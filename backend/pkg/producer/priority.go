@@ -0,0 +1,38 @@
+package producer
+
+// Priority selects which of the streams behind a queue a job is added to
+type Priority int
+
+const (
+	// PriorityDefault adds the job to the stream itself
+	PriorityDefault Priority = iota
+
+	// PriorityHigh adds the job to the <stream>:high stream, drained before
+	// the others
+	PriorityHigh
+
+	// PriorityLow adds the job to the <stream>:low stream, drained after the
+	// others
+	PriorityLow
+)
+
+// WithPriority adds the job to the stream of priority behind the stream given,
+// for workers consuming it with Priorities set
+func WithPriority(priority Priority) Option {
+	return func(o *options) {
+		o.priority = priority
+	}
+}
+
+// PriorityStream returns the stream holding the jobs of priority behind
+// stream: <stream>:high, stream itself or <stream>:low
+func PriorityStream(stream string, priority Priority) string {
+	switch priority {
+	case PriorityHigh:
+		return stream + ":high"
+	case PriorityLow:
+		return stream + ":low"
+	default:
+		return stream
+	}
+}
@@ -0,0 +1,255 @@
+// Package producer enqueues jobs onto a Redis stream in the format the worker
+// package reads them.
+package producer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// Fields set on every entry, besides the metadata given with WithMetadata
+const (
+	FieldID         = "id"
+	FieldType       = "type"
+	FieldBody       = "body"
+	FieldEnqueuedAt = "enqueued_at"
+	FieldRunAt      = "run_at"
+
+	// FieldIdempotencyKey is set by WithIdempotencyKey
+	FieldIdempotencyKey = "idempotency_key"
+
+	// FieldPartitionKey is set by WithPartitionKey
+	FieldPartitionKey = "partition_key"
+
+	// FieldReplyTo is set by WithReplyTo
+	FieldReplyTo = "reply_to"
+)
+
+// Producer adds jobs to Redis streams
+type Producer struct {
+	client   redis.UniversalClient
+	defaults []Option
+}
+
+// New creates a Producer that writes to Redis using client, which must come
+// from redisclient.Adapt or worker.NewRedisClient. The options are applied to
+// every job before those given to each call, e.g. to always trim the stream
+// with WithApproxMaxLen.
+func New(client redisclient.Client, defaults ...Option) *Producer {
+	return &Producer{client: redisx.Universal(client), defaults: defaults}
+}
+
+// Enqueue adds a job with the given payload to stream and returns the ID of
+// the stream entry. Strings and byte slices are used as the body as is,
+// protobuf messages are encoded in their binary format and any other payload
+// is encoded as JSON. The trace context of ctx is added to the entry so the
+// worker continues the trace. Jobs enqueued WithUnique fail with a
+// DuplicateJobError while their key is held.
+func (p *Producer) Enqueue(ctx context.Context, stream string, payload any, opts ...Option) (string, error) {
+	values, o, err := newEntry(ctx, payload, slices.Concat(p.defaults, opts))
+	if err != nil {
+		return "", err
+	}
+	stream = PriorityStream(o.key(stream), o.priority)
+	if err := p.holdUnique(ctx, stream, o); err != nil {
+		releaseBody(ctx, values, o)
+		return "", err
+	}
+
+	entryID, err := p.client.XAdd(ctx, (&Entry{Stream: stream, MaxLen: o.maxLen, Approx: o.approx, Values: values}).xaddArgs()).Result()
+	if err != nil {
+		p.releaseUnique(ctx, stream, o)
+		releaseBody(ctx, values, o)
+		return "", fmt.Errorf("failed to add job to %s: %w", stream, err)
+	}
+	return entryID, nil
+}
+
+// Entry is the stream entry of a job, built by Producer.Entry
+type Entry struct {
+	Stream string
+	Values map[string]any
+
+	// MaxLen trims the stream to MaxLen entries when the entry is added, or
+	// to about as many if Approx is set. Zero leaves the stream as it is.
+	MaxLen int64
+	Approx bool
+}
+
+// Args returns the XADD command adding the entry, to run with the client of
+// the caller, e.g. pipe.Do(ctx, entry.Args()...) with go-redis
+func (e *Entry) Args() []any {
+	args := []any{"XADD", e.Stream}
+	if e.MaxLen > 0 {
+		trim := "="
+		if e.Approx {
+			trim = "~"
+		}
+		args = append(args, "MAXLEN", trim, e.MaxLen)
+	}
+	args = append(args, "*")
+	for _, field := range slices.Sorted(maps.Keys(e.Values)) {
+		args = append(args, field, e.Values[field])
+	}
+	return args
+}
+
+// xaddArgs returns the arguments of XAdd adding the entry
+func (e *Entry) xaddArgs() *redis.XAddArgs {
+	return &redis.XAddArgs{Stream: e.Stream, MaxLen: e.MaxLen, Approx: e.Approx, Values: e.Values}
+}
+
+// Entry builds the entry of a job like Enqueue does, without adding it, for
+// callers adding it with XADD in a transaction of their own. WithUnique isn't
+// checked, and bodies stored aside with WithBlobStore are left behind if the
+// entry is never added.
+func (p *Producer) Entry(ctx context.Context, stream string, payload any, opts ...Option) (*Entry, error) {
+	values, o, err := newEntry(ctx, payload, slices.Concat(p.defaults, opts))
+	if err != nil {
+		return nil, err
+	}
+	stream = PriorityStream(o.key(stream), o.priority)
+	return &Entry{Stream: stream, MaxLen: o.maxLen, Approx: o.approx, Values: values}, nil
+}
+
+// newEntry builds the fields of the entry for a job and returns them with the
+// applied options
+func newEntry(ctx context.Context, payload any, opts []Option) (map[string]any, options, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	body, err := encodeBody(payload)
+	if err != nil {
+		return nil, o, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	if o.id == "" {
+		if o.id, err = newID(); err != nil {
+			return nil, o, fmt.Errorf("failed to generate job ID: %w", err)
+		}
+	}
+
+	values := map[string]any{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier(values))
+	for k, v := range o.metadata {
+		values[k] = v
+	}
+	values[FieldID] = o.id
+	setMetadata(ctx, values, o)
+	if o.jobType != "" {
+		values[FieldType] = o.jobType
+	}
+	if o.idempotencyKey != "" {
+		values[FieldIdempotencyKey] = o.idempotencyKey
+	}
+	if o.partitionKey != "" {
+		values[FieldPartitionKey] = o.partitionKey
+	}
+	if o.replyTo != "" {
+		values[FieldReplyTo] = o.replyTo
+	}
+	for k, v := range o.policy {
+		values[k] = v
+	}
+	if err := chainField(values, o); err != nil {
+		return nil, o, err
+	}
+	values[FieldBody] = body
+	if m, ok := payload.(proto.Message); ok {
+		describeProto(values, m)
+	}
+	now := time.Now()
+	values[FieldEnqueuedAt] = now.UTC().Format(time.RFC3339Nano)
+	setExpiry(values, o, now)
+	if o.cloudEventMode != 0 {
+		if err := cloudEvent(values, o); err != nil {
+			return nil, o, err
+		}
+	}
+	if err := compressBody(values, o); err != nil {
+		return nil, o, fmt.Errorf("failed to compress body: %w", err)
+	}
+	if err := encryptBody(ctx, values, o); err != nil {
+		return nil, o, fmt.Errorf("failed to encrypt body: %w", err)
+	}
+	if err := storeBody(ctx, values, o); err != nil {
+		return nil, o, fmt.Errorf("failed to store body: %w", err)
+	}
+	if body, ok := values[FieldBody].(string); ok && o.base64Body {
+		values[FieldBodyEncoding] = BodyEncodingBase64
+		values[FieldBody] = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	return values, o, nil
+}
+
+// encodeBody turns a payload into the body field of an entry
+func encodeBody(payload any) (string, error) {
+	switch v := payload.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case nil:
+		return "", errors.New("payload is nil")
+	case proto.Message:
+		b, err := proto.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// newID returns a random job ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// carrier lets the trace context be written to the fields of an entry
+type carrier map[string]any
+
+// Get returns the value of a field, or an empty string if it is missing
+func (c carrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set sets a field
+func (c carrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the fields
+func (c carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,42 @@
+package producer
+
+import "google.golang.org/protobuf/proto"
+
+// Content types of bodies, in the content_type field
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Fields describing the encoding of the body
+const (
+	// FieldContentType is set to ContentTypeProtobuf for protobuf payloads.
+	// Bodies without one are JSON or plain text.
+	FieldContentType = "content_type"
+
+	// FieldProtoType is the full name of the message type of a protobuf
+	// body, such as example.v1.Order
+	FieldProtoType = "proto_type"
+
+	// FieldBodyEncoding is set to BodyEncodingBase64 by WithBase64Body
+	FieldBodyEncoding = "body_encoding"
+)
+
+// BodyEncodingBase64 marks bodies encoded in base64
+const BodyEncodingBase64 = "base64"
+
+// WithBase64Body encodes binary bodies, such as protobuf or compressed ones,
+// in base64 instead of storing their bytes as is, for consumers that can't
+// read binary fields. The worker decodes them before running the handler.
+func WithBase64Body() Option {
+	return func(o *options) {
+		o.base64Body = true
+	}
+}
+
+// describeProto sets the content type and message type of the protobuf body
+// of an entry
+func describeProto(values map[string]any, m proto.Message) {
+	values[FieldContentType] = ContentTypeProtobuf
+	values[FieldProtoType] = string(m.ProtoReflect().Descriptor().FullName())
+}
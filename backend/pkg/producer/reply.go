@@ -0,0 +1,106 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields of the entries the worker adds to reply streams
+const (
+	ReplyFieldID     = "id"
+	ReplyFieldStatus = "status"
+	ReplyFieldResult = "result"
+	ReplyFieldError  = "error"
+)
+
+// replyInfix separates the stream of a job from its ID in the name of the
+// reply stream of Request
+const replyInfix = ":reply:"
+
+// replyPollInterval is how long AwaitReply blocks per read, checking whether
+// its context is done in between
+const replyPollInterval = time.Second
+
+// Reply is the outcome of a job enqueued with Request
+type Reply struct {
+	ID     string
+	Status string          // "completed" or "failed"
+	Result json.RawMessage // the handler's result as JSON, if completed
+	Error  string          // why the job failed, if failed
+}
+
+// Request enqueues a job on stream like Enqueue, then waits for the worker to
+// publish its outcome to a reply stream, "<stream>:reply:<id>", until ctx is
+// done. Jobs that fail are returned with the failed status once the worker
+// gives up retrying them, not as an error. The reply stream is deleted
+// afterwards.
+func (p *Producer) Request(ctx context.Context, stream string, payload any, opts ...Option) (*Reply, error) {
+	o := options{}
+	for _, opt := range slices.Concat(p.defaults, opts) {
+		opt(&o)
+	}
+	if o.id == "" {
+		id, err := newID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate job ID: %w", err)
+		}
+		opts = append(opts, WithID(id))
+		o.id = id
+	}
+	stream = o.key(stream)
+	replyTo := ReplyStream(stream, o.id)
+	defer p.client.Del(context.WithoutCancel(ctx), replyTo)
+
+	if _, err := p.Enqueue(ctx, stream, payload, append(opts, WithReplyTo(replyTo))...); err != nil {
+		return nil, err
+	}
+	return p.AwaitReply(ctx, replyTo)
+}
+
+// ReplyStream returns the reply stream Request waits on for the job id
+// enqueued on stream, with its key prefix
+func ReplyStream(stream, id string) string {
+	return stream + replyInfix + id
+}
+
+// IsReplyStream reports whether stream is named like a reply stream of Request
+func IsReplyStream(stream string) bool {
+	return strings.Contains(stream, replyInfix)
+}
+
+// AwaitReply waits for the first reply added to replyTo, the stream given to
+// WithReplyTo, until ctx is done
+func (p *Producer) AwaitReply(ctx context.Context, replyTo string) (*Reply, error) {
+	for {
+		streams, err := p.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{replyTo, "0"},
+			Count:   1,
+			Block:   replyPollInterval,
+		}).Result()
+		switch {
+		case err == redis.Nil:
+			continue
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to read reply from %s: %w", replyTo, err)
+		}
+
+		values := streams[0].Messages[0].Values
+		reply := &Reply{}
+		reply.ID, _ = values[ReplyFieldID].(string)
+		reply.Status, _ = values[ReplyFieldStatus].(string)
+		reply.Error, _ = values[ReplyFieldError].(string)
+		if result, _ := values[ReplyFieldResult].(string); result != "" {
+			reply.Result = json.RawMessage(result)
+		}
+		return reply, nil
+	}
+}
@@ -0,0 +1,68 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ScheduledJob is how a delayed job is stored in the sorted set returned by
+// ScheduledKey, scored by the Unix time in milliseconds it is due at. The
+// worker's scheduler adds Fields to the stream once the job is due, trimming
+// it to MaxLen if set.
+type ScheduledJob struct {
+	Fields map[string]any `json:"fields"`
+	MaxLen int64          `json:"maxlen,omitempty"`
+	Approx bool           `json:"approx,omitempty"`
+}
+
+// ScheduledKey returns the sorted set holding the delayed jobs of stream
+func ScheduledKey(stream string) string {
+	return stream + ":scheduled"
+}
+
+// EnqueueAt schedules a job to be added to stream at runAt and returns its job
+// ID. It takes the same options as Enqueue, trimming the stream when the job
+// is added to it. Jobs are moved to the stream by a running worker, so they
+// can be up to its SCHEDULE_INTERVAL late.
+func (p *Producer) EnqueueAt(ctx context.Context, stream string, runAt time.Time, payload any, opts ...Option) (string, error) {
+	values, o, err := newEntry(ctx, payload, slices.Concat(p.defaults, opts))
+	if err != nil {
+		return "", err
+	}
+	values[FieldRunAt] = runAt.UTC().Format(time.RFC3339Nano)
+	setExpiry(values, o, runAt)
+	stream = PriorityStream(o.key(stream), o.priority)
+
+	member, err := json.Marshal(ScheduledJob{Fields: values, MaxLen: o.maxLen, Approx: o.approx})
+	if err != nil {
+		releaseBody(ctx, values, o)
+		return "", fmt.Errorf("failed to encode scheduled job: %w", err)
+	}
+	if err := p.holdUnique(ctx, stream, o); err != nil {
+		releaseBody(ctx, values, o)
+		return "", err
+	}
+
+	key := ScheduledKey(stream)
+	err = p.client.ZAdd(ctx, key, redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: string(member),
+	}).Err()
+	if err != nil {
+		p.releaseUnique(ctx, stream, o)
+		releaseBody(ctx, values, o)
+		return "", fmt.Errorf("failed to schedule job in %s: %w", key, err)
+	}
+	return o.id, nil
+}
+
+// EnqueueIn schedules a job to be added to stream after delay and returns its
+// job ID, like EnqueueAt
+func (p *Producer) EnqueueIn(ctx context.Context, stream string, delay time.Duration, payload any, opts ...Option) (string, error) {
+	return p.EnqueueAt(ctx, stream, time.Now().Add(delay), payload, opts...)
+}
@@ -0,0 +1,83 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrDuplicateJob is matched by the DuplicateJobError returned for jobs
+// enqueued with WithUnique while another job with the same key is held
+var ErrDuplicateJob = errors.New("duplicate job")
+
+// DuplicateJobError is returned by Enqueue and EnqueueAt instead of adding a
+// job whose unique key is held by an earlier job, JobID being the ID of that
+// job, so that callers can coalesce with it
+type DuplicateJobError struct {
+	Key   string
+	JobID string
+}
+
+// Error implements error
+func (e *DuplicateJobError) Error() string {
+	return fmt.Sprintf("duplicate job: key %q is held by job %s", e.Key, e.JobID)
+}
+
+// Is makes DuplicateJobError match ErrDuplicateJob
+func (e *DuplicateJobError) Is(target error) bool {
+	return target == ErrDuplicateJob
+}
+
+// UniqueKey returns the Redis key holding the unique key of the jobs of stream
+// set with WithUnique
+func UniqueKey(stream, key string) string {
+	return stream + ":unique:" + key
+}
+
+// holdUnique holds the unique key of a job for its ttl, or returns a
+// DuplicateJobError if an earlier job holds it
+func (p *Producer) holdUnique(ctx context.Context, stream string, o options) error {
+	if o.uniqueKey == "" {
+		return nil
+	}
+	key := UniqueKey(stream, o.uniqueKey)
+
+	// The key may expire between SETNX and GET, in which case try again
+	for range 3 {
+		held, err := p.client.SetNX(ctx, key, o.id, o.uniqueTTL).Result()
+		if err != nil {
+			return fmt.Errorf("failed to hold unique key %s: %w", key, err)
+		}
+		if held {
+			return nil
+		}
+		jobID, err := p.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read unique key %s: %w", key, err)
+		}
+		return &DuplicateJobError{Key: o.uniqueKey, JobID: jobID}
+	}
+	return fmt.Errorf("failed to hold unique key %s: it keeps expiring", key)
+}
+
+// releaseUnique releases the unique key of a job that couldn't be enqueued,
+// unless another job took it over
+func (p *Producer) releaseUnique(ctx context.Context, stream string, o options) {
+	if o.uniqueKey == "" {
+		return
+	}
+	releaseUniqueKey.Run(context.WithoutCancel(ctx), p.client, []string{UniqueKey(stream, o.uniqueKey)}, o.id)
+}
+
+// releaseUniqueKey deletes a unique key if it is still held by the job
+var releaseUniqueKey = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
@@ -0,0 +1,183 @@
+// Package redisclient is the connection to Redis that the worker and producer
+// packages take. Their APIs depend on the interfaces of this package rather
+// than on the go-redis client behind them, so that upgrading go-redis only
+// changes Adapt, and code that only reads or writes streams can depend on
+// StreamsClient and fake it in its own tests.
+package redisclient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// Message is a stream entry
+type Message struct {
+	ID     string
+	Values map[string]any
+}
+
+// StreamsClient is the subset of the Redis commands covering streams and
+// consumer groups. Errors are those of Redis, e.g. starting with BUSYGROUP
+// when creating a group that exists.
+type StreamsClient interface {
+	// XAdd adds an entry with an ID generated by Redis and returns the ID
+	XAdd(ctx context.Context, stream string, values map[string]any) (string, error)
+
+	// XReadGroup reads up to count entries of stream never delivered to the
+	// group, blocking for up to block while there are none, forever if block
+	// is zero and not at all if it is negative. It returns no entries when
+	// the block runs out.
+	XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error)
+
+	// XAck acknowledges entries and returns how many were pending
+	XAck(ctx context.Context, stream, group string, ids ...string) (int64, error)
+
+	// XAutoClaim transfers to consumer up to count entries pending for at
+	// least minIdle from start, returning them and the ID to continue from,
+	// "0-0" once the whole pending entries list was scanned
+	XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]Message, string, error)
+
+	// XGroupCreateMkStream creates a consumer group delivering the entries
+	// after start, creating the stream if needed
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) error
+
+	// XLen returns the number of entries of a stream
+	XLen(ctx context.Context, stream string) (int64, error)
+
+	// XRange returns the entries from start to stop, "-" and "+" standing for
+	// the first and last ones
+	XRange(ctx context.Context, stream, start, stop string) ([]Message, error)
+
+	// XDel deletes entries and returns how many existed
+	XDel(ctx context.Context, stream string, ids ...string) (int64, error)
+}
+
+// Client is a connection to a Redis server, Sentinel group or Cluster. The
+// worker and producer send commands beyond these, such as scripts and
+// transactions, so they only run on the clients of Adapt and
+// worker.NewRedisClient. Other implementations can stand in for them in code
+// of its own, e.g. fakes in tests.
+type Client interface {
+	StreamsClient
+
+	// Ping checks that Redis can be reached
+	Ping(ctx context.Context) error
+
+	// Close closes the connections of the client
+	Close() error
+}
+
+// Adapt returns the Client sending commands through client, a go-redis v9
+// client, cluster client or failover client. The worker and producer share
+// its connection pool, and closing either closes both.
+func Adapt(client redis.UniversalClient) Client {
+	return &adapter{client: client, conn: redisx.NewConn(client)}
+}
+
+// Tx is a MULTI/EXEC transaction of the worker, which a worker.TxStatusReporter
+// adds its writes to
+type Tx interface {
+	// Do queues a command, e.g. Do(ctx, "HSET", "job:1", "status", "done").
+	// Its reply is discarded, and its error is that of the transaction.
+	Do(ctx context.Context, args ...any)
+}
+
+// adapter implements Client with a go-redis client
+type adapter struct {
+	client redis.UniversalClient
+	conn   *redisx.Conn
+}
+
+// RedisConn returns the go-redis client, for the worker and producer
+func (a *adapter) RedisConn() *redisx.Conn {
+	return a.conn
+}
+
+// Ping implements Client
+func (a *adapter) Ping(ctx context.Context) error {
+	return a.client.Ping(ctx).Err()
+}
+
+// Close implements Client
+func (a *adapter) Close() error {
+	return a.client.Close()
+}
+
+// XAdd implements Client
+func (a *adapter) XAdd(ctx context.Context, stream string, values map[string]any) (string, error) {
+	return a.client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: values}).Result()
+}
+
+// XReadGroup implements Client
+func (a *adapter) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error) {
+	streams, err := a.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return messages(streams[0].Messages), nil
+}
+
+// XAck implements Client
+func (a *adapter) XAck(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	return a.client.XAck(ctx, stream, group, ids...).Result()
+}
+
+// XAutoClaim implements Client
+func (a *adapter) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]Message, string, error) {
+	claimed, next, err := a.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    start,
+		Count:    count,
+	}).Result()
+	return messages(claimed), next, err
+}
+
+// XGroupCreateMkStream implements Client
+func (a *adapter) XGroupCreateMkStream(ctx context.Context, stream, group, start string) error {
+	return a.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+}
+
+// XLen implements Client
+func (a *adapter) XLen(ctx context.Context, stream string) (int64, error) {
+	return a.client.XLen(ctx, stream).Result()
+}
+
+// XRange implements Client
+func (a *adapter) XRange(ctx context.Context, stream, start, stop string) ([]Message, error) {
+	entries, err := a.client.XRange(ctx, stream, start, stop).Result()
+	return messages(entries), err
+}
+
+// XDel implements Client
+func (a *adapter) XDel(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return a.client.XDel(ctx, stream, ids...).Result()
+}
+
+// messages converts go-redis stream messages
+func messages(entries []redis.XMessage) []Message {
+	if entries == nil {
+		return nil
+	}
+	converted := make([]Message, len(entries))
+	for i, entry := range entries {
+		converted[i] = Message{ID: entry.ID, Values: entry.Values}
+	}
+	return converted
+}
@@ -0,0 +1,68 @@
+package redisclient_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+func TestAdapt(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redisclient.Adapt(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := client.XGroupCreateMkStream(ctx, "jobs", "workers", "0"); err != nil {
+		t.Fatalf("XGroupCreateMkStream: %v", err)
+	}
+	if err := client.XGroupCreateMkStream(ctx, "jobs", "workers", "0"); err == nil || !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		t.Errorf("creating the group again = %v, want BUSYGROUP", err)
+	}
+
+	first, err := client.XAdd(ctx, "jobs", map[string]any{"body": "a"})
+	if err != nil {
+		t.Fatalf("XAdd: %v", err)
+	}
+	second, _ := client.XAdd(ctx, "jobs", map[string]any{"body": "b"})
+	if n, _ := client.XLen(ctx, "jobs"); n != 2 {
+		t.Errorf("XLen = %d, want 2", n)
+	}
+
+	read, err := client.XReadGroup(ctx, "jobs", "workers", "c1", 1, -1)
+	if err != nil || len(read) != 1 || read[0].ID != first || read[0].Values["body"] != "a" {
+		t.Fatalf("XReadGroup = %v, %v, want the first entry", read, err)
+	}
+	claimed, next, err := client.XAutoClaim(ctx, "jobs", "workers", "c2", 0, "0", 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != first || next != "0-0" {
+		t.Errorf("XAutoClaim = %v, %s, %v, want the first entry", claimed, next, err)
+	}
+	if n, err := client.XAck(ctx, "jobs", "workers", first); n != 1 || err != nil {
+		t.Errorf("XAck = %d, %v, want 1", n, err)
+	}
+
+	read, err = client.XReadGroup(ctx, "jobs", "workers", "c1", 10, -1)
+	if err != nil || len(read) != 1 || read[0].ID != second {
+		t.Fatalf("XReadGroup = %v, %v, want the second entry", read, err)
+	}
+	read, err = client.XReadGroup(ctx, "jobs", "workers", "c1", 10, 10*time.Millisecond)
+	if err != nil || len(read) != 0 {
+		t.Errorf("XReadGroup of a drained stream = %v, %v, want nothing", read, err)
+	}
+
+	if n, err := client.XDel(ctx, "jobs", first, "0-1"); n != 1 || err != nil {
+		t.Errorf("XDel = %d, %v, want 1", n, err)
+	}
+	entries, err := client.XRange(ctx, "jobs", "-", "+")
+	if err != nil || len(entries) != 1 || entries[0].ID != second {
+		t.Errorf("XRange = %v, %v, want the second entry", entries, err)
+	}
+}
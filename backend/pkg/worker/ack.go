@@ -0,0 +1,262 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// AckPolicy decides when messages are acknowledged, and so their delivery
+// semantics
+type AckPolicy string
+
+const (
+	// AckOnSuccess acknowledges messages once processed successfully. Failed
+	// messages stay pending and are retried, then dead-lettered (at-least-once).
+	AckOnSuccess AckPolicy = "on_success"
+
+	// AckAlways acknowledges messages once processed, whether or not the
+	// handler failed. Failed messages are dead-lettered without retries.
+	AckAlways AckPolicy = "always"
+
+	// AckBeforeProcessing acknowledges messages before running the handler, so
+	// a message is never processed twice but is lost if the worker crashes
+	// while processing it (at-most-once). Failed messages are dead-lettered.
+	AckBeforeProcessing AckPolicy = "before_processing"
+)
+
+// semantics returns the delivery semantics of the policy, as reported by the
+// delivery semantics metric
+func (p AckPolicy) semantics() string {
+	if p == AckBeforeProcessing {
+		return "at_most_once"
+	}
+	return "at_least_once"
+}
+
+// parseAckPolicy validates an ACK_POLICY value
+func parseAckPolicy(s string) (AckPolicy, error) {
+	switch policy := AckPolicy(s); policy {
+	case AckOnSuccess, AckAlways, AckBeforeProcessing:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown policy %q, expected on_success, always or before_processing", s)
+	}
+}
+
+// acknowledgeMessage acknowledges a message in the stream, with the next batch
+// if acks are batched
+func (c *consumer) acknowledgeMessage(messageID string) {
+	c.config.chaosAckDelay()
+	if c.acks != nil && c.acks.add(&c.groupMember, messageID) {
+		return
+	}
+	c.acknowledgeNow(messageID)
+}
+
+// acknowledgeNow acknowledges a message in the stream right away. It returns
+// false if it failed, or if the message wasn't pending for the group anymore.
+func (c *consumer) acknowledgeNow(messageID string) bool {
+	acked, err := c.client.XAck(context.Background(), c.stream, c.group, messageID).Result()
+	if isFailoverError(err) && c.waitForFailover(context.Background(), err) {
+		// Retry once the new master accepts writes
+		acked, err = c.client.XAck(context.Background(), c.stream, c.group, messageID).Result()
+	}
+	if err != nil {
+		c.logger.Error("Error acknowledging message", "entry_id", messageID, "error", err)
+		return false
+	}
+	c.metrics.acked.Add(float64(acked))
+	c.logger.Debug("Acknowledged message", "entry_id", messageID)
+	return acked > 0
+}
+
+// ackAndAdd acknowledges the entry ARGV[2] of the stream KEYS[1] in the group
+// ARGV[1] and, only if it was still pending, adds an entry to each of the
+// streams KEYS[2..]. Each entry is described in ARGV from index 3 by its
+// MAXLEN, 0 for none, "~" or "=" for the trimming, its ID, its number of
+// fields and its fields and values. It returns the number of entries
+// acknowledged, 0 or 1.
+var ackAndAdd = redis.NewScript(`
+if redis.call("XACK", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+local i = 3
+for k = 2, #KEYS do
+	local args = {"XADD", KEYS[k]}
+	local maxlen, trim, id, fields = tonumber(ARGV[i]), ARGV[i + 1], ARGV[i + 2], tonumber(ARGV[i + 3])
+	i = i + 4
+	if maxlen > 0 then
+		table.insert(args, "MAXLEN")
+		table.insert(args, trim)
+		table.insert(args, maxlen)
+	end
+	table.insert(args, id)
+	for j = i, i + 2 * fields - 1 do
+		table.insert(args, ARGV[j])
+	end
+	i = i + 2 * fields
+	redis.call(unpack(args))
+end
+return 1
+`)
+
+// ackAndAddArgs returns the keys and arguments of ackAndAdd for entries
+func (c *consumer) ackAndAddArgs(entryID string, entries []*redis.XAddArgs) ([]string, []any, error) {
+	keys := []string{c.stream}
+	args := []any{c.group, entryID}
+	for _, entry := range entries {
+		fields, err := entryFields(entry.Values)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid entry of %s: %w", entry.Stream, err)
+		}
+		trim, id := "=", entry.ID
+		if entry.Approx {
+			trim = "~"
+		}
+		if id == "" {
+			id = "*"
+		}
+		keys = append(keys, entry.Stream)
+		args = append(args, entry.MaxLen, trim, id, len(fields)/2)
+		args = append(args, fields...)
+	}
+	return keys, args, nil
+}
+
+// entryFields flattens the values of an entry, in the forms XAddArgs accepts,
+// into fields and values
+func entryFields(values any) ([]any, error) {
+	switch values := values.(type) {
+	case map[string]any:
+		fields := make([]any, 0, 2*len(values))
+		for field, value := range values {
+			fields = append(fields, field, value)
+		}
+		return fields, nil
+	case map[string]string:
+		fields := make([]any, 0, 2*len(values))
+		for field, value := range values {
+			fields = append(fields, field, value)
+		}
+		return fields, nil
+	case []string:
+		fields := make([]any, len(values))
+		for i, value := range values {
+			fields[i] = value
+		}
+		return fields, nil
+	case []any:
+		return values, nil
+	}
+	return nil, fmt.Errorf("unsupported values %T", values)
+}
+
+// acknowledgeWith acknowledges a message and adds entries, its completion
+// event to the outbox stream and the jobs to run next, but only if the
+// message was still pending, so that a message acknowledged meanwhile, e.g.
+// by the consumer that reclaimed it, doesn't add them twice. The ack and the
+// entries go through the ackAndAdd script inside a single MULTI/EXEC, along
+// with a status update that isn't nil, written with a TxStatusReporter that
+// statusInAck accepted whether or not the message was pending. On a cluster
+// where the streams hash to several slots, which a script can't touch, the
+// message is acknowledged first and the entries added after, which a crash
+// in between loses. A message acked before processing, with AckBeforeProcessing,
+// already belongs to this consumer, so its entries are added without
+// acknowledging it again. It reports whether the message was acknowledged and
+// the entries added.
+func (c *consumer) acknowledgeWith(entryID string, entries []*redis.XAddArgs, statusUpdate *StatusUpdate, acked bool) bool {
+	c.config.chaosAckDelay()
+	ctx := context.Background()
+	keys, args, err := c.ackAndAddArgs(entryID, entries)
+	if err != nil {
+		c.logger.Error("Error acknowledging message with its entries", "entry_id", entryID, "error", err)
+		return false
+	}
+	scripted := redisx.SameSlot(c.client, keys...)
+
+	var n int64 // entries acknowledged
+	if acked {
+		scripted, n = false, 1
+	}
+	ack := func() error {
+		if !scripted && n == 0 {
+			var err error
+			if n, err = c.client.XAck(ctx, c.stream, c.group, entryID).Result(); err != nil {
+				return err
+			}
+		}
+		var script *redis.Cmd
+		_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			switch {
+			case scripted:
+				script = ackAndAdd.Eval(ctx, pipe, keys, args...)
+			case n > 0:
+				for _, entry := range entries {
+					pipe.XAdd(ctx, entry)
+				}
+			}
+			if statusUpdate != nil {
+				reporter := c.statusReporter.(TxStatusReporter)
+				if err := reporter.ReportStatusTx(ctx, redisx.NewTx(pipe), *statusUpdate); err != nil {
+					c.logger.Warn("Failed to update status to "+statusUpdate.Status, "message_id", statusUpdate.ID, "error", err)
+				}
+			}
+			return nil
+		})
+		if err == nil && script != nil {
+			n, err = script.Int64()
+		}
+		return err
+	}
+
+	err = ack()
+	if isFailoverError(err) && c.waitForFailover(ctx, err) {
+		// Retry once the new master accepts writes
+		err = ack()
+	}
+	switch {
+	case err != nil:
+		c.logger.Error("Error acknowledging message with its entries", "entry_id", entryID, "error", err)
+	case n == 0:
+		c.logger.Warn("Message already acknowledged, not adding its entries", "entry_id", entryID, "entries", len(entries))
+	case acked:
+		c.logger.Debug("Added entries of message acknowledged before processing", "entry_id", entryID, "entries", len(entries))
+	default:
+		c.metrics.acked.Add(float64(n))
+		c.logger.Debug("Acknowledged message", "entry_id", entryID, "entries", len(entries))
+	}
+	return err == nil && n > 0
+}
+
+// outboxEntry builds the completion event of a message for the outbox stream
+func (c *consumer) outboxEntry(entryID, messageID string, result any) (*redis.XAddArgs, error) {
+	values, err := outboxEvent(entryID, messageID, c.stream, c.name, result)
+	if err != nil {
+		return nil, err
+	}
+	return &redis.XAddArgs{Stream: c.config.key(c.config.OutboxStream), Values: values}, nil
+}
+
+// outboxEvent builds the fields of the completion event written to the outbox
+func outboxEvent(entryID, messageID, stream, consumer string, result any) (map[string]any, error) {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling result: %w", err)
+	}
+
+	return map[string]any{
+		"id":           messageID,
+		"entry_id":     entryID,
+		"stream":       stream,
+		"consumer":     consumer,
+		"status":       "completed",
+		"result":       string(resultJSON),
+		"completed_at": time.Now().UnixMilli(),
+	}, nil
+}
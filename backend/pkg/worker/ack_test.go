@@ -0,0 +1,87 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+func TestAckOnSuccessRetries(t *testing.T) {
+	h := workertest.New(t)
+	var calls atomic.Int32
+	w := h.Worker()
+	w.Handle("flaky", func(ctx context.Context, msg worker.Message) (any, error) {
+		if calls.Add(1) < 3 {
+			return nil, errors.New("flaky")
+		}
+		return "ok", nil
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("flaky"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler called %d times, want 3", n)
+	}
+	if update, _ := h.Statuses.Last("1"); update.Status != "completed" || update.Attempt != 3 {
+		t.Errorf("last status %s at attempt %d, want completed at attempt 3", update.Status, update.Attempt)
+	}
+	if dead := h.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got %d dead letters, want none", len(dead))
+	}
+}
+
+func TestAckAlwaysDeadLettersWithoutRetries(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.AckPolicy = worker.AckAlways
+	var calls atomic.Int32
+	w := h.Worker()
+	w.Handle("broken", func(ctx context.Context, msg worker.Message) (any, error) {
+		calls.Add(1)
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+	if dead := h.DeadLetters(); len(dead) != 1 {
+		t.Errorf("got %d dead letters, want 1", len(dead))
+	}
+}
+
+func TestAckBeforeProcessing(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.AckPolicy = worker.AckBeforeProcessing
+	h.Config.OutboxStream = "events"
+	var pending atomic.Int64
+	w := h.Worker()
+	w.Handle("first", func(ctx context.Context, msg worker.Message) (any, error) {
+		// Acknowledging it again finds it no longer pending
+		n, err := h.Client.XAck(ctx, msg.Stream, h.Config.GroupName, msg.EntryID)
+		pending.Add(n)
+		return "done", err
+	})
+	w.Handle("second", func(ctx context.Context, msg worker.Message) (any, error) {
+		return "done", nil
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("first"), producer.WithID("1"), producer.WithChain(producer.Step{Type: "second"}))
+	// The outbox events of both steps are added after their acks
+	waitFor(t, "the outbox events", func() bool { return streamLen(t, h, "events") == 2 })
+
+	if n := pending.Load(); n != 0 {
+		t.Errorf("message was still pending while handled")
+	}
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ackBatchCloseTimeout bounds the final flush of the ack batcher on shutdown
+const ackBatchCloseTimeout = 10 * time.Second
+
+// ackGroup is a stream and consumer group acknowledged together
+type ackGroup struct {
+	stream string
+	group  string
+}
+
+// ackEntry is an entry waiting to be acknowledged
+type ackEntry struct {
+	ackGroup
+	id string
+}
+
+// ackBatch holds the entries of a group waiting to be acknowledged
+type ackBatch struct {
+	member *groupMember
+	ids    []string
+}
+
+// ackBatcher acknowledges the entries of all streams in batches, every
+// AckBatchInterval or once AckBatchSize entries are waiting, with one XACK
+// per stream and group sent in a single pipeline, so that consumers don't
+// wait for a round trip per message
+type ackBatcher struct {
+	client   redis.UniversalClient
+	logger   Logger
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	batches map[ackGroup]*ackBatch
+	waiting map[ackEntry]struct{} // queued or being flushed
+	count   int                   // entries queued
+	closed  bool
+	full    chan struct{} // signalled when size entries are queued
+	stop    chan struct{} // closed by close
+	done    chan struct{} // closed once the last batch was flushed
+}
+
+// newAckBatcher creates the ack batcher of a worker, or returns nil if
+// batching is disabled
+func newAckBatcher(config *Config, client redis.UniversalClient, logger Logger) *ackBatcher {
+	if config.AckBatchSize <= 1 {
+		return nil
+	}
+	return &ackBatcher{
+		client:   client,
+		logger:   logger,
+		size:     config.AckBatchSize,
+		interval: config.AckBatchInterval,
+		batches:  map[ackGroup]*ackBatch{},
+		waiting:  map[ackEntry]struct{}{},
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// add queues an entry of a member's group for the next batch. It returns false
+// once the batcher is closed.
+func (b *ackBatcher) add(m *groupMember, id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	key := ackGroup{stream: m.stream, group: m.group}
+	batch := b.batches[key]
+	if batch == nil {
+		batch = &ackBatch{member: m}
+		b.batches[key] = batch
+	}
+	batch.ids = append(batch.ids, id)
+	b.waiting[ackEntry{key, id}] = struct{}{}
+	b.count++
+	if b.count >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// has reports whether an entry of a member's group is waiting to be
+// acknowledged, so that it isn't retried meanwhile
+func (b *ackBatcher) has(m *groupMember, id string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.waiting[ackEntry{ackGroup{stream: m.stream, group: m.group}, id}]
+	return ok
+}
+
+// run flushes batches until the batcher is closed, then flushes what is left
+func (b *ackBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(max(b.interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			b.flush()
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		b.flush()
+	}
+}
+
+// close stops accepting entries and waits for the queued ones to be
+// acknowledged
+func (b *ackBatcher) close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	close(b.stop)
+
+	select {
+	case <-b.done:
+	case <-time.After(ackBatchCloseTimeout):
+		b.logger.Error("Timed out acknowledging the last messages")
+	}
+}
+
+// flush acknowledges every queued entry in one pipeline. Entries of a group
+// whose XACK fails stay pending, to be retried.
+func (b *ackBatcher) flush() {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = map[ackGroup]*ackBatch{}
+	b.count = 0
+	b.mu.Unlock()
+	if len(batches) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	acked := make(map[ackGroup]*redis.IntCmd, len(batches))
+	send := func() error {
+		_, err := b.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for key, batch := range batches {
+				acked[key] = pipe.XAck(ctx, key.stream, key.group, batch.ids...)
+			}
+			return nil
+		})
+		return err
+	}
+	if err := send(); isFailoverError(err) {
+		for _, batch := range batches {
+			// Retry once the new master accepts writes, the errors of the
+			// commands telling how it went
+			if batch.member.waitForFailover(ctx, err) {
+				send()
+			}
+			break
+		}
+	}
+
+	for key, batch := range batches {
+		m := batch.member
+		if err := acked[key].Err(); err != nil {
+			m.logger.Error("Error acknowledging messages", "entries", len(batch.ids), "error", err)
+		} else {
+			m.metrics.acked.Add(float64(acked[key].Val()))
+			m.logger.Debug("Acknowledged messages", "entries", len(batch.ids))
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, batch := range batches {
+		for _, id := range batch.ids {
+			delete(b.waiting, ackEntry{key, id})
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package worker
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// adminDefaultCount and adminMaxCount bound the entries listed or replayed
+	// by a single admin request
+	adminDefaultCount = 100
+	adminMaxCount     = 1000
+
+	// adminTimeout bounds the Redis calls of an admin request
+	adminTimeout = 10 * time.Second
+)
+
+// AdminHandler returns the handler serving the admin API and the dashboard, for
+// embedders that mount it on their own server instead of setting AdminAddr.
+// Every request but the one for the dashboard page must carry AdminToken as a
+// bearer token. Requests about a stream take a stream query parameter naming
+// it, defaulting to the first stream the worker consumes.
+//
+//	GET  /admin/dashboard               dashboard page
+//	GET  /admin/overview                stream and group state shown by the dashboard
+//	GET  /admin/stats                   worker counters and streams
+//	POST /admin/streams                 start consuming a stream, {"name": "", "group": ""}
+//	DELETE /admin/streams/{name}        stop consuming a stream
+//	GET  /admin/pending                 pending entries per consumer
+//	GET  /admin/pending/entries         pending entries, ?consumer=&start=&count=
+//	POST /admin/messages/{id}/ack       acknowledge an entry
+//	POST /admin/messages/{id}/requeue   add an entry to the stream again and acknowledge it
+//	GET  /admin/dlq                     dead-lettered entries, ?start=&count=
+//	POST /admin/dlq/{id}/replay         move a dead-lettered entry back to the stream, {"rewrite": [...]}
+//	POST /admin/dlq/replay              move the oldest dead-lettered entries back, ?count=, {"rewrite": [...]}
+//	POST /admin/pause                   stop the workers of the group from reading messages
+//	POST /admin/resume                  let them read messages again
+//	GET  /admin/jobs/{id}               state of a job, with the job store enabled
+//	GET  /admin/jobs                    jobs with a status, ?status=&offset=&count=
+//	POST /admin/jobs/{id}/cancel        cancel a queued or running job
+func (w *Worker) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/overview", w.handleAdminOverview)
+	mux.HandleFunc("GET /admin/stats", w.handleAdminStats)
+	mux.HandleFunc("POST /admin/streams", w.handleAdminAddStream)
+	mux.HandleFunc("DELETE /admin/streams/{name}", w.handleAdminRemoveStream)
+	mux.HandleFunc("GET /admin/pending", w.handleAdminPending)
+	mux.HandleFunc("GET /admin/pending/entries", w.handleAdminPendingEntries)
+	mux.HandleFunc("POST /admin/messages/{id}/ack", w.handleAdminAck)
+	mux.HandleFunc("POST /admin/messages/{id}/requeue", w.handleAdminRequeue)
+	mux.HandleFunc("GET /admin/dlq", w.handleAdminDeadLetters)
+	mux.HandleFunc("POST /admin/dlq/{id}/replay", w.handleAdminReplay)
+	mux.HandleFunc("POST /admin/dlq/replay", w.handleAdminReplayAll)
+	mux.HandleFunc("POST /admin/pause", w.handleAdminPause)
+	mux.HandleFunc("POST /admin/resume", w.handleAdminResume)
+	mux.HandleFunc("GET /admin/jobs/{id}", w.handleAdminJob)
+	mux.HandleFunc("GET /admin/jobs", w.handleAdminJobs)
+	mux.HandleFunc("POST /admin/jobs/{id}/cancel", w.handleAdminCancel)
+
+	root := http.NewServeMux()
+	root.HandleFunc("GET /admin/dashboard", w.handleDashboard)
+	root.Handle("/", w.requireAdminToken(mux))
+	return root
+}
+
+// requireAdminToken rejects requests without the admin bearer token
+func (w *Worker) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || w.config.AdminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(w.config.AdminToken)) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(rw, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// handleAdminStats returns the worker's counters and the streams it consumes
+func (w *Worker) handleAdminStats(rw http.ResponseWriter, r *http.Request) {
+	streams := w.live.list()
+	if streams == nil {
+		streams = []StreamConfig{}
+		for _, sub := range w.streams() {
+			streams = append(streams, sub.stream)
+		}
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]any{
+		"streams": streams,
+		"stats":   w.Stats(),
+	})
+}
+
+// handleAdminAddStream starts consuming the stream named in the request body
+func (w *Worker) handleAdminAddStream(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	var stream StreamConfig
+	if err := json.NewDecoder(r.Body).Decode(&stream); err != nil {
+		writeAdminError(rw, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+	if stream.Name == "" {
+		writeAdminError(rw, http.StatusBadRequest, errors.New("stream name is required"))
+		return
+	}
+	stream.Weight = 0
+	if stream.Group == "" {
+		stream.Group = w.config.GroupName
+	}
+	if err := w.AddStream(ctx, stream); err != nil {
+		writeAdminStreamError(rw, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusCreated, stream)
+}
+
+// handleAdminRemoveStream stops consuming a stream
+func (w *Worker) handleAdminRemoveStream(rw http.ResponseWriter, r *http.Request) {
+	if err := w.RemoveStream(r.PathValue("name")); err != nil {
+		writeAdminStreamError(rw, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]string{"removed": r.PathValue("name")})
+}
+
+// handleAdminPending returns the number of pending entries per consumer
+func (w *Worker) handleAdminPending(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	summary, err := i.Pending(ctx)
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, summary)
+}
+
+// handleAdminPendingEntries lists pending entries, optionally of one consumer
+func (w *Worker) handleAdminPendingEntries(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	count, err := adminCount(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	query := r.URL.Query()
+	entries, err := i.PendingEntries(ctx, query.Get("consumer"), query.Get("start"), count)
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"entries": entries})
+}
+
+// handleAdminAck acknowledges an entry, removing it from the pending list
+func (w *Worker) handleAdminAck(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	acked, err := i.Ack(ctx, r.PathValue("id"))
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	if acked == 0 {
+		writeAdminError(rw, http.StatusNotFound, errors.New("entry is not pending"))
+		return
+	}
+	w.metrics.acked.WithLabelValues(i.config.StreamName, i.config.GroupName).Inc()
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"acked": acked})
+}
+
+// handleAdminRequeue adds a copy of an entry to the end of the stream and
+// acknowledges the original, so it is delivered again as a new message
+func (w *Worker) handleAdminRequeue(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	newID, err := i.Requeue(ctx, id)
+	if err != nil {
+		writeAdminEntryError(rw, err)
+		return
+	}
+	w.logger.Info("Requeued message through the admin API", "entry_id", id, "new_entry_id", newID)
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"id": newID})
+}
+
+// handleAdminDeadLetters lists entries of the dead-letter stream
+func (w *Worker) handleAdminDeadLetters(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	count, err := adminCount(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := i.DeadLetters(ctx, r.URL.Query().Get("start"), count)
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"entries": entries})
+}
+
+// handleAdminReplay moves a dead-lettered entry back to the stream
+func (w *Worker) handleAdminReplay(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	transforms, err := adminRewrites(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	id := r.PathValue("id")
+	newID, err := i.ReplayDeadLetter(ctx, id, transforms...)
+	if err != nil {
+		writeAdminEntryError(rw, err)
+		return
+	}
+	w.logger.Info("Replayed dead-lettered message", "dlq_entry_id", id, "entry_id", newID)
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"id": newID})
+}
+
+// handleAdminReplayAll moves the oldest dead-lettered entries back to the stream
+func (w *Worker) handleAdminReplayAll(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+
+	count, err := adminCount(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	transforms, err := adminRewrites(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	replayed, err := i.ReplayDeadLetters(ctx, count, transforms...)
+	if replayed > 0 {
+		w.logger.Info("Replayed dead-lettered messages", "count", replayed)
+	}
+	if err != nil {
+		writeAdminJSON(rw, http.StatusInternalServerError, map[string]any{"replayed": replayed, "error": err.Error()})
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"replayed": replayed})
+}
+
+// adminRewrites parses the rewrites of the dead-lettered entries to replay
+// given by the optional body, {"rewrite": [".body.version = 2"]}
+func adminRewrites(r *http.Request) ([]ReplayTransform, error) {
+	var body struct {
+		Rewrite []string `json:"rewrite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid body: %w", err)
+	}
+	return ParseRewrites(body.Rewrite)
+}
+
+// handleAdminPause pauses the group, on every worker
+func (w *Worker) handleAdminPause(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+	if err := i.Pause(ctx); err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	w.logger.Info("Paused consumer group", "stream", i.config.StreamName, "group", i.config.GroupName)
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"paused": true})
+}
+
+// handleAdminResume resumes the group, on every worker
+func (w *Worker) handleAdminResume(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+	if err := i.Resume(ctx); err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	w.logger.Info("Resumed consumer group", "stream", i.config.StreamName, "group", i.config.GroupName)
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"paused": false})
+}
+
+// handleAdminJob returns the state of a job
+func (w *Worker) handleAdminJob(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	jobs, ok := w.adminJobStore(rw)
+	if !ok {
+		return
+	}
+	job, err := jobs.Get(ctx, r.PathValue("id"))
+	if errors.Is(err, ErrJobNotFound) {
+		writeAdminError(rw, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, job)
+}
+
+// handleAdminCancel cancels a job, whichever stream it is in and whether it is
+// running or not
+func (w *Worker) handleAdminCancel(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	id := r.PathValue("id")
+	if err := newInspector(w.client, w.config).Cancel(ctx, id); err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusAccepted, map[string]any{"cancelled": id})
+}
+
+// handleAdminJobs lists the jobs with a status, most recently updated first
+func (w *Worker) handleAdminJobs(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	jobs, ok := w.adminJobStore(rw)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		writeAdminError(rw, http.StatusBadRequest, errors.New("status is required"))
+		return
+	}
+	count, err := adminCount(r)
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err)
+		return
+	}
+	var offset int64
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			writeAdminError(rw, http.StatusBadRequest, errors.New("offset must be a non-negative integer"))
+			return
+		}
+	}
+	list, err := jobs.List(ctx, status, offset, count)
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, map[string]any{"jobs": list})
+}
+
+// adminJobStore returns the job store, or writes an error and returns false
+// if it is disabled
+func (w *Worker) adminJobStore(rw http.ResponseWriter) (*JobStore, bool) {
+	jobs := w.jobStore()
+	if jobs == nil {
+		writeAdminError(rw, http.StatusNotFound, errors.New("job store is disabled"))
+		return nil, false
+	}
+	return jobs, true
+}
+
+// adminInspector returns an Inspector for the stream named by the stream query
+// parameter, or the first one the worker consumes. It writes an error and
+// returns false if the worker doesn't consume that stream.
+func (w *Worker) adminInspector(rw http.ResponseWriter, r *http.Request) (*Inspector, bool) {
+	stream := r.URL.Query().Get("stream")
+	config := w.streamConfig(stream)
+	if config == nil {
+		writeAdminError(rw, http.StatusNotFound, errors.New("unknown stream "+stream))
+		return nil, false
+	}
+	return newInspector(w.client, config), true
+}
+
+// adminCount parses the count query parameter
+func adminCount(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("count")
+	if v == "" {
+		return adminDefaultCount, nil
+	}
+	count, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || count <= 0 || count > adminMaxCount {
+		return 0, errors.New("count must be between 1 and " + strconv.Itoa(adminMaxCount))
+	}
+	return count, nil
+}
+
+// writeAdminJSON writes v as the JSON response
+func writeAdminJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// writeAdminError writes err as a JSON error response
+func writeAdminError(rw http.ResponseWriter, status int, err error) {
+	writeAdminJSON(rw, status, map[string]string{"error": err.Error()})
+}
+
+// writeAdminStreamError writes the error of adding or removing a stream
+func writeAdminStreamError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotRunning):
+		writeAdminError(rw, http.StatusServiceUnavailable, err)
+	case errors.Is(err, ErrStreamConsumed):
+		writeAdminError(rw, http.StatusConflict, err)
+	case errors.Is(err, ErrStreamNotConsumed):
+		writeAdminError(rw, http.StatusNotFound, err)
+	default:
+		writeAdminError(rw, http.StatusInternalServerError, err)
+	}
+}
+
+// writeAdminEntryError writes the error of looking up an entry
+func writeAdminEntryError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, ErrEntryNotFound) {
+		writeAdminError(rw, http.StatusNotFound, err)
+		return
+	}
+	writeAdminError(rw, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,399 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Archive backends selectable with ArchiveBackend
+const (
+	ArchiveBackendPostgres = "postgres"
+	ArchiveBackendNone     = "none"
+)
+
+// archiveTimeout bounds each batch written by the archive batcher
+const archiveTimeout = 10 * time.Second
+
+// archiveCloseTimeout bounds the final flush of the archive batcher on
+// shutdown
+const archiveCloseTimeout = 10 * time.Second
+
+// postgresArchiveRows caps the rows of one INSERT, to stay below the 65535
+// parameters Postgres accepts per statement
+const postgresArchiveRows = 1000
+
+// JobRecord is what is archived of a job that completed or failed for good
+type JobRecord struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type,omitempty"`
+	Stream     string    `json:"stream"`
+	EntryID    string    `json:"entry_id"`
+	Status     string    `json:"status"`           // completed, compensated or failed
+	Payload    string    `json:"payload"`          // body of the message, decoded
+	Result     any       `json:"result,omitempty"` // of the handler, if the job completed
+	Error      string    `json:"error,omitempty"`  // of the last attempt, if the job failed
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueued_at"`         // or due, if scheduled
+	StartedAt  time.Time `json:"started_at,omitzero"` // of the last attempt, zero if the handler didn't run
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Archiver keeps the records of finished jobs, so that their history
+// survives the trimming of the stream, e.g. for auditing and analytics.
+// Archive is called from a single goroutine with batches of records, and
+// returns an error if the batch wasn't archived, to be retried with later
+// records. A record may be archived twice if the worker crashes before
+// acknowledging its job.
+type Archiver interface {
+	Archive(ctx context.Context, records []JobRecord) error
+}
+
+// WithArchiver archives finished jobs with archiver instead of the backend
+// selected by ArchiveBackend
+func WithArchiver(archiver Archiver) Option {
+	return func(w *Worker) {
+		w.archiver = archiver
+	}
+}
+
+// newArchiver creates the archiver selected by ArchiveBackend, or returns nil
+// if archiving is disabled. The Postgres table is created if needed.
+func newArchiver(ctx context.Context, config *Config) (Archiver, error) {
+	switch config.ArchiveBackend {
+	case "", ArchiveBackendNone:
+		return nil, nil
+	case ArchiveBackendPostgres:
+		dsn := config.ArchivePostgresDSN
+		if dsn == "" {
+			dsn = config.StatusPostgresDSN
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive database: %w", err)
+		}
+		a, err := NewPostgresArchiver(db, config.ArchiveTable)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.CreateTable(ctx); err != nil {
+			return nil, fmt.Errorf("error creating archive table: %w", err)
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown archive backend %q", config.ArchiveBackend)
+}
+
+// archiveBatcher hands the records of finished jobs to the archiver in the
+// background, up to size at once every interval or as soon as size are
+// waiting, so that consumers never wait for it. Batches that fail are retried
+// with the next ones. Records are dropped once queueSize are waiting, and
+// those still waiting when a worker crashes are lost.
+type archiveBatcher struct {
+	archiver  Archiver
+	logger    Logger
+	records   *prometheus.CounterVec // by outcome
+	size      int
+	queueSize int
+	interval  time.Duration
+
+	mu       sync.Mutex
+	pending  []JobRecord
+	failing  bool // the last batch failed
+	dropping bool // records were dropped since the last batch written
+	closed   bool
+	full     chan struct{} // signalled when size records are pending
+	stop     chan struct{} // closed by close
+	done     chan struct{} // closed once the last batch was written
+}
+
+// newArchiveBatcher creates the batcher of archiver, or returns nil if
+// archiver is nil
+func newArchiveBatcher(config *Config, archiver Archiver, logger Logger, records *prometheus.CounterVec) *archiveBatcher {
+	if archiver == nil {
+		return nil
+	}
+	return &archiveBatcher{
+		archiver:  archiver,
+		logger:    logger,
+		records:   records,
+		size:      max(config.ArchiveBatchSize, 1),
+		queueSize: max(config.ArchiveQueueSize, config.ArchiveBatchSize, 1),
+		interval:  config.ArchiveBatchInterval,
+		full:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// add queues a record for the next batch, dropping it if the queue is full or
+// the batcher closed
+func (b *archiveBatcher) add(record JobRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.pending) >= b.queueSize {
+		b.records.WithLabelValues("dropped").Inc()
+		if !b.dropping {
+			b.logger.Warn("Archive queue full, dropping job records", "queued", len(b.pending))
+			b.dropping = true
+		}
+		return
+	}
+	b.pending = append(b.pending, record)
+	if len(b.pending) >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run writes batches until the batcher is closed, then writes what is left
+func (b *archiveBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(max(b.interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			for b.flush(true) {
+			}
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		b.flush(false)
+	}
+}
+
+// close stops accepting records and waits for the pending ones to be written
+func (b *archiveBatcher) close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	close(b.stop)
+
+	select {
+	case <-b.done:
+	case <-time.After(archiveCloseTimeout):
+		b.logger.Error("Timed out archiving the last job records")
+	}
+}
+
+// flush writes up to size pending records, reporting whether there were any.
+// A batch that fails goes back to the front of the queue, unless final, in
+// which case it is dropped.
+func (b *archiveBatcher) flush(final bool) bool {
+	b.mu.Lock()
+	n := min(len(b.pending), b.size)
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	b.mu.Unlock()
+	if n == 0 {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	err := b.archiver.Archive(ctx, batch)
+	cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.records.WithLabelValues("archived").Add(float64(n))
+		b.dropping = false
+		if b.failing {
+			b.logger.Info("Archiving job records again")
+			b.failing = false
+		}
+		return true
+	}
+	if !b.failing || final {
+		b.logger.Error("Error archiving job records", "records", n, "error", err)
+		b.failing = true
+	}
+	if final {
+		b.records.WithLabelValues("dropped").Add(float64(n))
+		return true
+	}
+	// Keep the oldest records, dropping the newest that no longer fit
+	b.pending = append(batch, b.pending...)
+	if dropped := len(b.pending) - b.queueSize; dropped > 0 {
+		b.pending = b.pending[:b.queueSize]
+		b.records.WithLabelValues("dropped").Add(float64(dropped))
+	}
+	return true
+}
+
+// archive queues the record of a job that completed or failed for good, if
+// archiving is enabled
+func (c *consumer) archive(msg Message, status string, result any, err error, attempt int) {
+	if c.archiver == nil {
+		return
+	}
+	record := JobRecord{
+		ID:         msg.ID,
+		Type:       msg.Type,
+		Stream:     msg.Stream,
+		EntryID:    msg.EntryID,
+		Status:     status,
+		Payload:    msg.Body,
+		Result:     result,
+		Attempts:   attempt,
+		EnqueuedAt: queuedAt(msg.Values, msg.EntryID),
+		StartedAt:  msg.started,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	c.archiver.add(record)
+}
+
+// PostgresArchiver inserts job records into a Postgres table, which
+// CreateTable creates. Records are keyed by stream and entry ID, a record
+// archived again replacing the earlier one. Payloads that are JSON go in the
+// payload column, and others in payload_raw.
+type PostgresArchiver struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresArchiver creates an archiver writing to table, "job_archive" if
+// empty, through db, which must use a Postgres driver such as
+// github.com/lib/pq
+func NewPostgresArchiver(db *sql.DB, table string) (*PostgresArchiver, error) {
+	if table == "" {
+		table = "job_archive"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid archive table name %q", table)
+	}
+	return &PostgresArchiver{db: db, table: table}, nil
+}
+
+// CreateTable creates the archive table and its indexes if they don't exist
+func (a *PostgresArchiver) CreateTable(ctx context.Context) error {
+	_, err := a.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+a.table+` (
+	stream      text NOT NULL,
+	entry_id    text NOT NULL,
+	id          text NOT NULL,
+	type        text NOT NULL,
+	status      text NOT NULL,
+	payload     jsonb,
+	payload_raw bytea,
+	result      jsonb,
+	error       text,
+	attempts    integer NOT NULL,
+	enqueued_at timestamptz NOT NULL,
+	started_at  timestamptz,
+	finished_at timestamptz NOT NULL,
+	PRIMARY KEY (stream, entry_id)
+)`)
+	if err != nil {
+		return err
+	}
+	// Index names can't be qualified with the schema, which they take from
+	// the table
+	name := a.table[strings.LastIndex(a.table, ".")+1:]
+	_, err = a.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+name+`_id_idx ON `+a.table+` (id)`)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+name+`_finished_at_idx ON `+a.table+` (finished_at)`)
+	return err
+}
+
+// Archive implements Archiver, inserting the records in as few statements as
+// possible
+func (a *PostgresArchiver) Archive(ctx context.Context, records []JobRecord) error {
+	for start := 0; start < len(records); start += postgresArchiveRows {
+		if err := a.insert(ctx, records[start:min(start+postgresArchiveRows, len(records))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert inserts records with a single statement, which can't update a row
+// twice, so that only the last record of an entry is kept
+func (a *PostgresArchiver) insert(ctx context.Context, records []JobRecord) error {
+	last := make(map[[2]string]int, len(records))
+	for i, record := range records {
+		last[[2]string{record.Stream, record.EntryID}] = i
+	}
+	if len(last) < len(records) {
+		unique := make([]JobRecord, 0, len(last))
+		for i, record := range records {
+			if last[[2]string{record.Stream, record.EntryID}] == i {
+				unique = append(unique, record)
+			}
+		}
+		records = unique
+	}
+
+	const columns = 13
+	var query strings.Builder
+	query.WriteString(`INSERT INTO ` + a.table + ` (stream, entry_id, id, type, status, payload, payload_raw, result, error,
+	attempts, enqueued_at, started_at, finished_at)
+VALUES `)
+	args := make([]any, 0, len(records)*columns)
+	for i, record := range records {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range columns {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("$" + strconv.Itoa(i*columns+j+1))
+		}
+		query.WriteString(")")
+
+		var payload, payloadRaw any
+		if json.Valid([]byte(record.Payload)) {
+			payload = record.Payload
+		} else {
+			payloadRaw = []byte(record.Payload)
+		}
+		var result any
+		if record.Result != nil {
+			data, err := json.Marshal(record.Result)
+			if err != nil {
+				return fmt.Errorf("error marshaling result of job %s: %w", record.ID, err)
+			}
+			result = string(data)
+		}
+		var errorText, startedAt any
+		if record.Error != "" {
+			errorText = record.Error
+		}
+		if !record.StartedAt.IsZero() {
+			startedAt = record.StartedAt
+		}
+		args = append(args, record.Stream, record.EntryID, record.ID, record.Type, record.Status, payload, payloadRaw,
+			result, errorText, record.Attempts, record.EnqueuedAt, startedAt, record.FinishedAt)
+	}
+	query.WriteString(`
+ON CONFLICT (stream, entry_id) DO UPDATE SET id = EXCLUDED.id, type = EXCLUDED.type, status = EXCLUDED.status,
+	payload = EXCLUDED.payload, payload_raw = EXCLUDED.payload_raw, result = EXCLUDED.result, error = EXCLUDED.error,
+	attempts = EXCLUDED.attempts, enqueued_at = EXCLUDED.enqueued_at, started_at = EXCLUDED.started_at,
+	finished_at = EXCLUDED.finished_at`)
+
+	if _, err := a.db.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("error archiving job records: %w", err)
+	}
+	return nil
+}
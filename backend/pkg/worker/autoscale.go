@@ -0,0 +1,310 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+const (
+	// scaleDownErrorRate is the share of failed handler runs in an interval
+	// above which the autoscaler halves the concurrency
+	scaleDownErrorRate = 0.2
+
+	// scaleDownLatency is how many times slower than usual handlers may get
+	// before the autoscaler reduces the concurrency
+	scaleDownLatency = 2
+)
+
+// autoscaler adapts how many of a set of consumers process messages at once
+// between MinWorkerCount and MaxWorkerCount. All MaxWorkerCount consumers are
+// started, and each holds one of limit slots while it takes and processes a
+// delivery. Without autoscaling the limit stays at WorkerCount, until Reload
+// changes it. A nil autoscaler lets every consumer run.
+type autoscaler struct {
+	auto   bool
+	logger Logger
+
+	// spawn starts consumer id of the set, limited by s, and started counts
+	// the consumers started
+	spawn   func(s *autoscaler, id int)
+	started int
+
+	mu       sync.Mutex
+	queues   []*queue
+	min, max int
+	limit    int
+	running  int
+	changed  chan struct{} // closed when a slot may have become free
+
+	// Handler runs, failures and time spent in handlers since the last
+	// decision
+	runs     atomic.Int64
+	failures atomic.Int64
+	busy     atomic.Int64
+
+	latency time.Duration // usual handler latency, learnt over time
+	backlog int64         // backlog at the last decision
+}
+
+// newAutoscaler creates the autoscaler of the consumers of queues, started
+// with spawn, and starts them
+func (w *Worker) newAutoscaler(queues []*queue, spawn func(s *autoscaler, id int)) *autoscaler {
+	s := &autoscaler{
+		auto:    w.config.autoscaling(),
+		queues:  slices.Clone(queues),
+		logger:  withFields(w.logger, "component", "autoscaler"),
+		spawn:   spawn,
+		changed: make(chan struct{}),
+	}
+	s.bounds(w.config)
+	s.limit = min(max(w.config.WorkerCount, s.min), s.max)
+	if s.auto {
+		s.report(s.limit)
+	}
+	w.reload.addScaler(s)
+
+	s.started = w.config.consumerCount()
+	for id := 0; id < s.started; id++ {
+		spawn(s, id)
+	}
+	return s
+}
+
+// bounds sets the range of the limit from config, s.mu being held if the
+// consumers were started: MinWorkerCount and MaxWorkerCount when autoscaling,
+// or WorkerCount
+func (s *autoscaler) bounds(config *Config) {
+	if !s.auto {
+		s.min, s.max = config.WorkerCount, config.WorkerCount
+		return
+	}
+	s.min = max(1, config.MinWorkerCount)
+	s.max = max(s.min, config.MaxWorkerCount)
+}
+
+// resize applies the worker counts of a reloaded configuration, keeping the
+// limit within the new bounds and starting consumers if more of them may run
+// at once than were started. Consumers over the limit stop taking messages
+// after the one they are processing or waiting for.
+func (s *autoscaler) resize(config *Config) {
+	s.mu.Lock()
+	from, to := s.limit, s.limit
+	s.bounds(config)
+	if s.auto {
+		to = min(max(s.limit, s.min), s.max)
+	} else {
+		to = config.WorkerCount
+	}
+	s.limit = to
+	s.notify()
+	started := s.started
+	s.started = max(s.started, s.max)
+	s.mu.Unlock()
+
+	for id := started; id < s.started; id++ {
+		s.spawn(s, id)
+	}
+	if from != to {
+		if s.auto {
+			s.report(to)
+		}
+		s.logger.Info("Resizing consumers", "from", from, "to", to)
+	}
+}
+
+// acquire waits for a free slot and takes it. It returns false if ctx is done
+// first.
+func (s *autoscaler) acquire(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	for {
+		s.mu.Lock()
+		if s.running < s.limit {
+			s.running++
+			s.mu.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// release frees a slot taken with acquire
+func (s *autoscaler) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.notify()
+}
+
+// observe records a handler run for the next decision
+func (s *autoscaler) observe(duration time.Duration, failed bool) {
+	if s == nil {
+		return
+	}
+	s.runs.Add(1)
+	s.busy.Add(int64(duration))
+	if failed {
+		s.failures.Add(1)
+	}
+}
+
+// notify wakes up the consumers waiting for a slot, s.mu being held
+func (s *autoscaler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// run adjusts the concurrency every AutoscaleInterval until ctx is done
+func (s *autoscaler) run(ctx context.Context, interval time.Duration) {
+	if s == nil || !s.auto || interval <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.logger.Info("Autoscaling consumers", "min", s.min, "max", s.max, "initial", s.limit)
+	s.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.scale(ctx, interval)
+	}
+}
+
+// scale makes one scaling decision from what happened during the last
+// interval. Failing handlers or latency rising above its usual level mean a
+// downstream dependency is struggling, so the concurrency is reduced. Otherwise
+// it grows while messages keep piling up and shrinks while consumers are idle.
+func (s *autoscaler) scale(ctx context.Context, interval time.Duration) {
+	runs, failures := s.runs.Swap(0), s.failures.Swap(0)
+	busy := time.Duration(s.busy.Swap(0))
+	backlog := s.measureBacklog(ctx)
+
+	var latency time.Duration
+	var errorRate float64
+	if runs > 0 {
+		latency = busy / time.Duration(runs)
+		errorRate = float64(failures) / float64(runs)
+	}
+	// Average number of consumers running a handler over the interval
+	utilization := float64(busy) / float64(interval)
+
+	s.mu.Lock()
+	limit := s.limit
+	target, reason := limit, ""
+	switch {
+	case runs > 0 && errorRate > scaleDownErrorRate:
+		target, reason = limit/2, "error rate"
+	case runs > 0 && s.latency > 0 && latency > s.latency*scaleDownLatency:
+		target, reason = limit-1, "latency"
+	case backlog > 0 && (backlog > s.backlog || utilization >= 0.9*float64(limit)):
+		target, reason = limit+max(1, limit/4), "lag"
+	case backlog == 0 && utilization < float64(limit)/2:
+		target, reason = limit-1, "idle"
+	}
+	target = min(max(target, s.min), s.max)
+	if target != limit {
+		s.limit = target
+		s.notify()
+	}
+	s.mu.Unlock()
+
+	// Learn the usual latency, following slow changes but not sudden spikes
+	if runs > 0 {
+		if s.latency == 0 || latency < s.latency {
+			s.latency = latency
+		} else {
+			s.latency += (latency - s.latency) / 10
+		}
+	}
+	s.backlog = backlog
+
+	if target != limit {
+		s.report(target)
+		s.logger.Info("Scaling consumers", "from", limit, "to", target, "reason", reason,
+			"backlog", backlog, "latency", latency, "error_rate", errorRate, "utilization", utilization)
+	}
+}
+
+// addQueue adds a queue started after the consumers, such as a tenant's, to
+// those they take messages from
+func (s *autoscaler) addQueue(q *queue) {
+	s.mu.Lock()
+	s.queues = append(s.queues, q)
+	limit := s.limit
+	s.mu.Unlock()
+	if s.auto {
+		q.member.metrics.concurrency.Set(float64(limit))
+	}
+}
+
+// removeQueue removes a queue whose reader stopped
+func (s *autoscaler) removeQueue(q *queue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues = slices.DeleteFunc(s.queues, func(other *queue) bool {
+		return other == q
+	})
+}
+
+// currentQueues returns the queues the consumers take messages from
+func (s *autoscaler) currentQueues() []*queue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.queues)
+}
+
+// measureBacklog returns how many messages are waiting: those not delivered to
+// the groups yet, when Redis reports the lag of the groups, and those read but
+// not taken by a consumer yet
+func (s *autoscaler) measureBacklog(ctx context.Context) int64 {
+	var backlog int64
+	for _, q := range s.currentQueues() {
+		backlog += int64(len(q.deliveries))
+		for _, partition := range q.partitions {
+			backlog += int64(len(partition))
+		}
+
+		groups, err := redisx.XInfo(ctx, q.member.client, "GROUPS", q.member.stream)
+		if err != nil {
+			if ctx.Err() == nil {
+				q.member.logger.Warn("Failed to read the lag of the group", "error", err)
+			}
+			continue
+		}
+		for _, group := range groups {
+			if group["name"] == q.member.group {
+				lag, _ := group["lag"].(int64)
+				backlog += lag
+			}
+		}
+	}
+	return backlog
+}
+
+// report sets the concurrency gauge of every stream to limit
+func (s *autoscaler) report(limit int) {
+	for _, q := range s.currentQueues() {
+		q.member.metrics.concurrency.Set(float64(limit))
+	}
+}
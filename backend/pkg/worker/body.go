@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/soham901/go-redis-stream-worker/internal/compression"
+	"github.com/soham901/go-redis-stream-worker/internal/encryption"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// decodeBody returns the body of an entry: its body field, or the blob its
+// body_ref field refers to, decoded from base64 when its body_encoding field
+// says so, decrypted when it has an encryption field and then decompressed
+// when it has a content_encoding field, recording the compression ratio.
+// Bodies that can't be decoded, are missing from the blob store or encrypted
+// with an unknown key fail with ErrInvalidPayload.
+func (c *consumer) decodeBody(ctx context.Context, values map[string]any) (string, error) {
+	body, _ := values[producer.FieldBody].(string)
+	if ref, ok := values[producer.FieldBodyRef].(string); ok {
+		blob, err := c.blobs.Get(ctx, ref)
+		if errors.Is(err, producer.ErrBlobNotFound) {
+			return "", fmt.Errorf("%w: body %s not found", ErrInvalidPayload, ref)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch body %s: %w", ref, err)
+		}
+		body = string(blob)
+	}
+	switch encoding, _ := values[producer.FieldBodyEncoding].(string); encoding {
+	case "":
+	case producer.BodyEncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid base64 body: %v", ErrInvalidPayload, err)
+		}
+		body = string(decoded)
+	default:
+		return "", fmt.Errorf("%w: unknown body encoding %q", ErrInvalidPayload, encoding)
+	}
+
+	if _, ok := values[producer.FieldEncryption]; ok {
+		decrypted, err := c.decrypt(ctx, values, body)
+		if err != nil {
+			return "", err
+		}
+		body = decrypted
+	}
+
+	encoding, _ := values[producer.FieldContentEncoding].(string)
+	if encoding == "" {
+		return body, nil
+	}
+	decompressed, err := compression.Decompress(encoding, []byte(body), int64(c.config.MaxDecompressedSize))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decompress body: %v", ErrInvalidPayload, err)
+	}
+	if len(body) > 0 {
+		c.metrics.compressionRatio.Observe(float64(len(decompressed)) / float64(len(body)))
+	}
+	return string(decompressed), nil
+}
+
+// decrypt decrypts an encrypted body with the key named by the key_id field
+// of its entry
+func (c *consumer) decrypt(ctx context.Context, values map[string]any, body string) (string, error) {
+	if cipher, _ := values[producer.FieldEncryption].(string); cipher != producer.EncryptionAESGCM {
+		return "", fmt.Errorf("%w: unknown encryption %q", ErrInvalidPayload, cipher)
+	}
+	if c.keyring == nil {
+		return "", fmt.Errorf("%w: body is encrypted but no encryption keys are set", ErrInvalidPayload)
+	}
+	keyID, _ := values[producer.FieldKeyID].(string)
+	key, err := c.keyring.Key(ctx, keyID)
+	if errors.Is(err, producer.ErrUnknownKey) {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get encryption key %q: %w", keyID, err)
+	}
+	jobID, _ := values[producer.FieldID].(string)
+	decrypted, err := encryption.Open(key, []byte(body), []byte(jobID))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decrypt body: %v", ErrInvalidPayload, err)
+	}
+	return string(decrypted), nil
+}
+
+// deleteBody deletes the blob of a completed entry whose body was stored aside
+func (c *consumer) deleteBody(values map[string]any) {
+	ref, ok := values[producer.FieldBodyRef].(string)
+	if !ok {
+		return
+	}
+	if err := c.blobs.Delete(context.Background(), ref); err != nil {
+		c.logger.Warn("Failed to delete the body of a completed message", "body_ref", ref, "error", err)
+	}
+}
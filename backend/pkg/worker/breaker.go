@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned by status updates rejected without calling the
+// API because its circuit breaker is open
+var ErrBreakerOpen = errors.New("status API circuit breaker is open")
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls go through
+	breakerOpen                         // calls are rejected until the cooldown elapses
+	breakerHalfOpen                     // a single probe call decides whether to close again
+)
+
+// breaker is a circuit breaker around the status API. It opens after threshold
+// consecutive failures and rejects calls for cooldown, then lets one probe
+// through. Calls made while the probe is in flight wait for its outcome rather
+// than being rejected, so updates aren't dropped when the API is back.
+type breaker struct {
+	threshold int // zero disables the breaker
+	cooldown  time.Duration
+	logger    Logger
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probe    chan struct{} // closed when the half-open probe finishes
+}
+
+// newBreaker creates a closed breaker
+func newBreaker(threshold int, cooldown time.Duration, logger Logger) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, logger: logger}
+}
+
+// call runs fn unless the breaker rejects it, recording its outcome
+func (b *breaker) call(ctx context.Context, fn func() error) error {
+	if b.threshold <= 0 {
+		return fn()
+	}
+	if err := b.allow(ctx); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// allow returns nil if a call may proceed. In the half-open state the first
+// caller becomes the probe and the others wait for it to finish.
+func (b *breaker) allow(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		switch b.state {
+		case breakerClosed:
+			b.mu.Unlock()
+			return nil
+		case breakerOpen:
+			if time.Since(b.openedAt) < b.cooldown {
+				b.mu.Unlock()
+				return ErrBreakerOpen
+			}
+			b.state = breakerHalfOpen
+			b.probe = make(chan struct{})
+			b.mu.Unlock()
+			return nil
+		}
+		probe := b.probe
+		b.mu.Unlock()
+
+		select {
+		case <-probe:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// record updates the state with the outcome of an allowed call
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.state == breakerHalfOpen {
+			b.logger.Info("Status API circuit breaker closed")
+			close(b.probe)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		close(b.probe)
+	case b.state == breakerClosed && b.failures >= b.threshold:
+	default:
+		return
+	}
+	b.logger.Warn("Status API circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+}
+
+// rejecting reports whether calls are currently rejected, and for how much
+// longer at most
+func (b *breaker) rejecting() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return false, 0
+	}
+	remaining := b.cooldown - time.Since(b.openedAt)
+	return remaining > 0, remaining
+}
+
+// waitForStatusAPI blocks while the status API circuit breaker is open, if
+// StatusBreakerPause is set, so messages aren't processed without reporting
+// their status. It returns false if ctx is done.
+func (r *reader) waitForStatusAPI(ctx context.Context) bool {
+	if !r.config.StatusBreakerPause {
+		return true
+	}
+	open, remaining := r.statusBreaker.rejecting()
+	if !open {
+		return true
+	}
+
+	r.logger.Warn("Status API unavailable, pausing reads", "for", remaining)
+	for open {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(remaining):
+		}
+		open, remaining = r.statusBreaker.rejecting()
+	}
+	r.logger.Info("Probing status API, resuming reads")
+	return true
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cancelPrefix prefixes the key marking a job as cancelled and the pub/sub
+// channel telling workers to cancel it, both followed by the job ID
+const cancelPrefix = "cancel:"
+
+// ErrJobCancelled is the cause of the context of a handler whose job was
+// cancelled
+var ErrJobCancelled = errors.New("job cancelled")
+
+// Cancel cancels a job: workers running its handler cancel its context, and
+// workers reading it later skip it, until CancelTTL has elapsed. Either way
+// the job is acknowledged and reported with the cancelled status. Publishing
+// on the cancel:<id> channel, after KeyPrefix, has the same effect.
+func (i *Inspector) Cancel(ctx context.Context, id string) error {
+	key := i.config.key(cancelPrefix + id)
+	if err := i.client.Set(ctx, key, time.Now().UTC().Format(time.RFC3339), i.config.CancelTTL).Err(); err != nil {
+		return err
+	}
+	return i.client.Publish(ctx, key, "").Err()
+}
+
+// cancelRegistry tracks the running handlers of a worker, to cancel them
+type cancelRegistry struct {
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+}
+
+// newCancelRegistry creates an empty registry
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{running: make(map[string]context.CancelCauseFunc)}
+}
+
+// add registers the running handler of job id, returning its context, which
+// is cancelled with ErrJobCancelled when the job is, and a function to call
+// once it returned
+func (r *cancelRegistry) add(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.mu.Lock()
+	r.running[id] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.running, id)
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel cancels the handler of job id, reporting whether it was running
+func (r *cancelRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.running[id]
+	if ok {
+		cancel(ErrJobCancelled)
+	}
+	return ok
+}
+
+// runCancel cancels the handlers of the jobs published on the cancel:<id>
+// channels until ctx is done. Jobs cancelled by publishing on the channel
+// alone are also marked, so that workers skip them if they are still queued.
+func (w *Worker) runCancel(ctx context.Context, cancels *cancelRegistry) {
+	prefix := w.config.key(cancelPrefix)
+	pubsub := w.client.PSubscribe(ctx, prefix+"*")
+	defer pubsub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return
+			}
+			id := strings.TrimPrefix(msg.Channel, prefix)
+			if err := w.client.SetNX(ctx, msg.Channel, time.Now().UTC().Format(time.RFC3339), w.config.CancelTTL).Err(); err != nil && ctx.Err() == nil {
+				w.logger.Warn("Failed to mark job as cancelled", "message_id", id, "error", err)
+			}
+			if cancels.cancel(id) {
+				w.logger.Info("Cancelling running job", "message_id", id)
+			}
+		}
+	}
+}
+
+// isCancelled reports whether a job was cancelled before it was processed. If
+// Redis can't be reached, the job is processed.
+func (c *consumer) isCancelled(messageID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+	n, err := c.client.Exists(ctx, c.config.key(cancelPrefix+messageID)).Result()
+	if err != nil && err != redis.Nil {
+		c.logger.Warn("Failed to check whether the job is cancelled, processing it", "message_id", messageID, "error", err)
+		return false
+	}
+	return n > 0
+}
+
+// skipCancelled acknowledges a cancelled job, unless it already was, without
+// processing it further, compensating its saga, and reports it with the
+// cancelled status
+func (c *consumer) skipCancelled(ctx context.Context, message redis.XMessage, messageID string, attempt int, acked bool) {
+	c.metrics.cancelled.Inc()
+	c.logger.Info("Skipping cancelled message", "message_id", messageID, "entry_id", message.ID, "attempt", attempt)
+	c.releaseIdempotencyKey(message)
+	if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "cancelled", Attempt: attempt}); err != nil {
+		c.logger.Warn("Failed to update status to cancelled", "message_id", messageID, "error", err)
+	}
+	c.reply(message, messageID, nil, ErrJobCancelled)
+	c.finishGroupJob(ctx, message.Values, messageID, nil, ErrJobCancelled)
+	c.acknowledgeCompensating(message, acked)
+}
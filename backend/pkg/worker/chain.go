@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// NextJob is a job enqueued once the job whose handler returned it succeeded
+type NextJob struct {
+	Stream  string // defaults to the stream of the current job
+	Type    string
+	Payload any
+	Options []producer.Option
+}
+
+// continuation is the result of a handler returning jobs to enqueue next
+type continuation struct {
+	result any
+	jobs   []NextJob
+}
+
+// Next returns a handler result reported as result, whose jobs are enqueued
+// once the current job succeeded, in the transaction acknowledging it and
+// after its status was reported. The jobs share the correlation ID of the
+// current one.
+func Next(result any, jobs ...NextJob) any {
+	return &continuation{result: result, jobs: jobs}
+}
+
+// nextEntries unwraps the result of the handler of msg, returning the entries
+// of the jobs to enqueue next: those it returned with Next, then the next step
+// of the chain the job was enqueued with, which gets the result as its payload
+// if it has none. Compensation jobs are followed by the compensation of the
+// step before theirs instead.
+func (c *consumer) nextEntries(ctx context.Context, msg Message, r *route, result any) (any, []*redis.XAddArgs, error) {
+	values := msg.Values
+	if isCompensation(values) {
+		steps, err := c.sagaSteps(ctx, values)
+		if err != nil {
+			return result, nil, err
+		}
+		entry, err := c.compensation(ctx, steps)
+		if err != nil || entry == nil {
+			return result, nil, err
+		}
+		return result, []*redis.XAddArgs{entry}, nil
+	}
+
+	var jobs []NextJob
+	if next, ok := result.(*continuation); ok {
+		result, jobs = next.result, next.jobs
+	}
+
+	if chain, ok := values[producer.FieldChain].(string); ok && chain != "" {
+		steps, err := producer.DecodeChain(chain)
+		if err != nil {
+			return result, nil, fmt.Errorf("invalid chain: %w", err)
+		}
+		if len(steps) > 0 {
+			step := steps[0]
+			payload := step.Payload
+			if payload == nil {
+				encoded, err := json.Marshal(result)
+				if err != nil {
+					return result, nil, fmt.Errorf("failed to encode result for the next step: %w", err)
+				}
+				payload = string(encoded)
+			}
+			job := NextJob{Stream: step.Stream, Type: step.Type, Payload: payload}
+			if len(steps) > 1 {
+				job.Options = []producer.Option{producer.WithChain(steps[1:]...)}
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	if len(jobs) == 0 {
+		return result, nil, nil
+	}
+	saga, err := c.sagaField(ctx, msg, r, result)
+	if err != nil {
+		return result, nil, err
+	}
+
+	p := producer.New(redisclient.Adapt(c.client))
+	entries := make([]*redis.XAddArgs, 0, len(jobs))
+	for _, job := range jobs {
+		stream := c.stream
+		if job.Stream != "" {
+			stream = c.config.key(job.Stream)
+		}
+		var opts []producer.Option
+		if c.keyring != nil {
+			opts = append(opts, producer.WithEncryption(c.keyring))
+		}
+		if job.Type != "" {
+			opts = append(opts, producer.WithType(job.Type))
+		}
+		if saga != "" {
+			opts = append(opts, producer.WithMetadata(SagaField, saga))
+		}
+		entry, err := p.Entry(ctx, stream, job.Payload, append(opts, job.Options...)...)
+		if err != nil {
+			return result, nil, fmt.Errorf("failed to build next job: %w", err)
+		}
+		if err := c.sealSaga(ctx, entry.Values); err != nil {
+			return result, nil, fmt.Errorf("failed to encrypt the saga of the next job: %w", err)
+		}
+		entries = append(entries, xaddArgs(entry))
+	}
+	return result, entries, nil
+}
+
+// xaddArgs returns the arguments of XAdd adding an entry built by the producer
+func xaddArgs(entry *producer.Entry) *redis.XAddArgs {
+	return &redis.XAddArgs{Stream: entry.Stream, MaxLen: entry.MaxLen, Approx: entry.Approx, Values: entry.Values}
+}
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrChaos fails the handlers, status updates and Redis commands failed on
+// purpose in chaos mode
+var ErrChaos = errors.New("chaos")
+
+// chaos reports whether to inject a fault with probability rate, always false
+// unless chaos mode is on
+func (c *Config) chaos(rate float64) bool {
+	return c.Chaos && rate > 0 && rand.Float64() < rate
+}
+
+// chaosAckDelay sleeps before an ack with probability ChaosAckDelayRate, for
+// up to ChaosAckDelay, so that the message may be reclaimed meanwhile
+func (c *Config) chaosAckDelay() {
+	if c.ChaosAckDelay > 0 && c.chaos(c.ChaosAckDelayRate) {
+		time.Sleep(rand.N(c.ChaosAckDelay))
+	}
+}
+
+// chaosHook fails Redis commands with probability rate as if the connection
+// dropped, which the worker handles like a failover
+type chaosHook struct {
+	rate float64
+}
+
+// errChaosDisconnect is the error of the Redis commands failed by chaosHook
+var errChaosDisconnect = fmt.Errorf("%w: simulated Redis disconnect: %w", ErrChaos, io.EOF)
+
+// DialHook implements redis.Hook
+func (h chaosHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook
+func (h chaosHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if rand.Float64() < h.rate {
+			cmd.SetErr(errChaosDisconnect)
+			return errChaosDisconnect
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook
+func (h chaosHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if rand.Float64() < h.rate {
+			for _, cmd := range cmds {
+				cmd.SetErr(errChaosDisconnect)
+			}
+			return errChaosDisconnect
+		}
+		return next(ctx, cmds)
+	}
+}
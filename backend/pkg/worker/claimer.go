@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// claimBatchSize is the COUNT passed to each XAUTOCLAIM call
+const claimBatchSize = 100
+
+// runClaimer periodically reclaims entries that have been pending longer than
+// ClaimMinIdle, e.g. because the consumer that read them crashed, and assigns
+// them to the reader, which dispatches them like any other retry. Only the
+// leader reclaims if the workers elect one.
+func (w *Worker) runClaimer(ctx context.Context, r *reader) {
+	if r.config.ClaimInterval <= 0 {
+		return
+	}
+	maxDelay := r.config.MaxDelay
+	for _, route := range r.router.routes {
+		if route.retry != nil && route.retry.MaxDelay > maxDelay {
+			maxDelay = route.retry.MaxDelay
+		}
+	}
+	if r.config.ClaimMinIdle <= maxDelay {
+		r.logger.Warn("CLAIM_MIN_IDLE should exceed the largest retry delay, or messages waiting for a retry may be reclaimed",
+			"claim_min_idle", r.config.ClaimMinIdle, "retry_max_delay", maxDelay)
+	}
+	if r.config.HeartbeatInterval >= r.config.ClaimMinIdle {
+		r.logger.Warn("HEARTBEAT_INTERVAL should be well below CLAIM_MIN_IDLE, or long running handlers may lose their messages",
+			"heartbeat_interval", r.config.HeartbeatInterval, "claim_min_idle", r.config.ClaimMinIdle)
+	}
+
+	ticker := time.NewTicker(r.config.ClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !w.leader.leads() {
+			continue
+		}
+
+		total := 0
+		start := "0-0"
+		for {
+			ids, cursor, err := autoClaim(ctx, r, start, r.config.ClaimMinIdle)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("Error reclaiming pending messages", "error", err)
+				}
+				break
+			}
+			total += len(ids)
+			if cursor == "0-0" {
+				break
+			}
+			start = cursor
+		}
+
+		if total > 0 {
+			w.reclaimed.Add(int64(total))
+			r.metrics.reclaimed.Add(float64(total))
+			r.logger.Info("Reclaimed stale pending messages", "count", total)
+		}
+	}
+}
+
+// autoClaim transfers up to claimBatchSize entries idle for at least minIdle to
+// the reader, starting at start. It returns the claimed IDs and the cursor for
+// the next call, which is "0-0" once the whole pending list was scanned.
+// JUSTID keeps the delivery count unchanged; the reader's retry counts it.
+func autoClaim(ctx context.Context, r *reader, start string, minIdle time.Duration) ([]string, string, error) {
+	// Sent as a raw command since Redis 7 replies with an extra element
+	reply, err := r.client.Do(ctx, "XAUTOCLAIM", r.stream, r.group, r.name,
+		minIdle.Milliseconds(), start, "COUNT", claimBatchSize, "JUSTID").Slice()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) < 2 {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM reply of length %d", len(reply))
+	}
+
+	cursor, _ := reply[0].(string)
+	entries, _ := reply[1].([]any)
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if id, ok := entry.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, cursor, nil
+}
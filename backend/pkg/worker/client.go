@@ -0,0 +1,11 @@
+package worker
+
+import "github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+
+// StreamsClient is the subset of the Redis client covering the stream
+// commands, for code that only needs those to depend on, and fake, instead of
+// a whole redisclient.Client. CreateConsumerGroup takes one, while the worker
+// and Inspector also need scripts, transactions, pub/sub and plain keys, so
+// tests of the processing loop run them against miniredis with the
+// workertest package instead.
+type StreamsClient = redisclient.StreamsClient
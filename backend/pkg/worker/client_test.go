@@ -0,0 +1,75 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// fakeStreams is a StreamsClient whose groups exist once created
+type fakeStreams struct {
+	groups map[string]string // start ID by stream and group
+	err    error             // returned by XGroupCreateMkStream if set
+}
+
+func (f *fakeStreams) XAdd(ctx context.Context, stream string, values map[string]any) (string, error) {
+	return "1-0", nil
+}
+
+func (f *fakeStreams) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]redisclient.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeStreams) XAck(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStreams) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]redisclient.Message, string, error) {
+	return nil, "0-0", nil
+}
+
+func (f *fakeStreams) XGroupCreateMkStream(ctx context.Context, stream, group, start string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.groups[stream+"/"+group]; ok {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+	f.groups[stream+"/"+group] = start
+	return nil
+}
+
+func (f *fakeStreams) XLen(ctx context.Context, stream string) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStreams) XRange(ctx context.Context, stream, start, stop string) ([]redisclient.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeStreams) XDel(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return 0, nil
+}
+
+func TestCreateConsumerGroupWithFake(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeStreams{groups: map[string]string{}}
+	if err := worker.CreateConsumerGroupAt(ctx, fake, "jobs", "workers", "$"); err != nil {
+		t.Fatalf("CreateConsumerGroupAt: %v", err)
+	}
+	if err := worker.CreateConsumerGroup(ctx, fake, "jobs", "workers"); err != nil {
+		t.Errorf("CreateConsumerGroup of an existing group: %v", err)
+	}
+	if start := fake.groups["jobs/workers"]; start != "$" {
+		t.Errorf("group starts at %q, want $", start)
+	}
+
+	fake.err = errors.New("READONLY You can't write against a read only replica")
+	if err := worker.CreateConsumerGroup(ctx, fake, "other", "workers"); !errors.Is(err, fake.err) {
+		t.Errorf("CreateConsumerGroup = %v, want %v", err, fake.err)
+	}
+}
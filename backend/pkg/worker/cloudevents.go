@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// CloudEvent holds the attributes of a message read as a CloudEvents event
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            string
+	DataContentType string
+	Extensions      map[string]string // other attributes, by name
+}
+
+// CloudEvent returns the attributes of the event the message was read from
+// when CloudEvents is enabled, or false if it isn't an event
+func (m Message) CloudEvent() (CloudEvent, bool) {
+	prefix := producer.CloudEventPrefix
+	if _, ok := m.Values[prefix+"specversion"].(string); !ok {
+		return CloudEvent{}, false
+	}
+	event := CloudEvent{Extensions: map[string]string{}}
+	for field, value := range m.Values {
+		name, ok := strings.CutPrefix(field, prefix)
+		if !ok {
+			continue
+		}
+		s, _ := value.(string)
+		switch name {
+		case "specversion":
+			event.SpecVersion = s
+		case "id":
+			event.ID = s
+		case "source":
+			event.Source = s
+		case "type":
+			event.Type = s
+		case "subject":
+			event.Subject = s
+		case "time":
+			event.Time = s
+		case "datacontenttype":
+			event.DataContentType = s
+		default:
+			event.Extensions[name] = s
+		}
+	}
+	return event, true
+}
+
+// decodeCloudEvent returns the fields of an entry holding a CloudEvent with
+// the id, type and body fields taken from the event, and the attributes of a
+// structured event in ce_ fields as in binary mode. Other entries are returned
+// as is.
+func decodeCloudEvent(values map[string]any) (map[string]any, error) {
+	prefix := producer.CloudEventPrefix
+	if structured, ok := values[producer.FieldCloudEvent].(string); ok {
+		return decodeStructuredCloudEvent(values, structured)
+	}
+	if _, ok := values[prefix+"specversion"]; !ok {
+		return values, nil
+	}
+
+	attributes := map[string]string{}
+	for field, value := range values {
+		if name, ok := strings.CutPrefix(field, prefix); ok {
+			attributes[name], _ = value.(string)
+		}
+	}
+	if err := checkCloudEvent(attributes); err != nil {
+		return nil, err
+	}
+	decoded := maps.Clone(values)
+	decoded[producer.FieldID] = attributes["id"]
+	decoded[producer.FieldType] = attributes["type"]
+	return decoded, nil
+}
+
+// decodeStructuredCloudEvent decodes the JSON event of the ce field
+func decodeStructuredCloudEvent(values map[string]any, structured string) (map[string]any, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(structured), &event); err != nil {
+		return nil, fmt.Errorf("%w: invalid CloudEvent: %v", ErrInvalidPayload, err)
+	}
+
+	decoded := maps.Clone(values)
+	attributes := map[string]string{}
+	for name, raw := range event {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			s = string(raw)
+		}
+		attributes[name] = s
+		decoded[producer.CloudEventPrefix+name] = s
+	}
+	if err := checkCloudEvent(attributes); err != nil {
+		return nil, err
+	}
+
+	body, err := cloudEventData(event, attributes["datacontenttype"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CloudEvent data: %v", ErrInvalidPayload, err)
+	}
+	decoded[producer.FieldID] = attributes["id"]
+	decoded[producer.FieldType] = attributes["type"]
+	decoded[producer.FieldBody] = body
+	return decoded, nil
+}
+
+// cloudEventData returns the data of a structured event as a body: JSON data
+// as is, strings of other content types unquoted and data_base64 decoded
+func cloudEventData(event map[string]json.RawMessage, contentType string) (string, error) {
+	if encoded, ok := event["data_base64"]; ok {
+		var s string
+		if err := json.Unmarshal(encoded, &s); err != nil {
+			return "", err
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	data, ok := event["data"]
+	if !ok {
+		return "", nil
+	}
+	if !isJSONContentType(contentType) {
+		var s string
+		if json.Unmarshal(data, &s) == nil {
+			return s, nil
+		}
+	}
+	return string(data), nil
+}
+
+// isJSONContentType reports whether data of contentType is JSON, which it is
+// when the content type is missing
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "" || mediaType == "application/json" || mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// checkCloudEvent returns an error if the attributes of an event lack a
+// required one or are of another version
+func checkCloudEvent(attributes map[string]string) error {
+	for _, name := range []string{"specversion", "id", "source", "type"} {
+		if attributes[name] == "" {
+			return fmt.Errorf("%w: CloudEvent has no %s", ErrInvalidPayload, name)
+		}
+	}
+	if version := attributes["specversion"]; version != producer.CloudEventsVersion {
+		return fmt.Errorf("%w: unsupported CloudEvents version %q", ErrInvalidPayload, version)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package worker_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// contentEncoding returns the content_encoding field of an entry
+func contentEncoding(t *testing.T, h *workertest.Harness, entryID string) any {
+	t.Helper()
+	entries, err := h.Client.XRange(context.Background(), h.Config.StreamName, entryID, entryID)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("XRANGE %s: %v", entryID, err)
+	}
+	return entries[0].Values[producer.FieldContentEncoding]
+}
+
+func TestCompressedBody(t *testing.T) {
+	large := `"` + strings.Repeat("row,", 4096) + `"`
+	for _, algorithm := range []producer.Compression{producer.Gzip, producer.Zstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			h := workertest.New(t)
+			keys := keyring(t, "a")
+			bodies := runDecrypting(h, keys)
+
+			entryID := h.Enqueue(large, producer.WithType("email"), producer.WithID("large"),
+				producer.WithCompression(algorithm, 1024), producer.WithEncryption(keys))
+			if body := receive(t, bodies); body != large {
+				t.Errorf("handler got a body of %d bytes, want %d", len(body), len(large))
+			}
+			if encoding := contentEncoding(t, h, entryID); encoding != string(algorithm) {
+				t.Errorf("large body has content encoding %v, want %s", encoding, algorithm)
+			}
+
+			entryID = h.Enqueue(`"small"`, producer.WithType("email"), producer.WithID("small"),
+				producer.WithCompression(algorithm, 1024), producer.WithEncryption(keys))
+			if body := receive(t, bodies); body != `"small"` {
+				t.Errorf("handler got body %s", body)
+			}
+			if encoding := contentEncoding(t, h, entryID); encoding != nil {
+				t.Errorf("small body has content encoding %v, want none", encoding)
+			}
+		})
+	}
+}
+
+func TestCompressedBodyTooLarge(t *testing.T) {
+	for _, algorithm := range []producer.Compression{producer.Gzip, producer.Zstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			h := workertest.New(t)
+			h.Config.MaxDecompressedSize = 1024
+			w := h.Worker()
+			w.Handle("email", func(ctx context.Context, msg worker.Message) (any, error) {
+				t.Error("handler ran for a body decompressing beyond the limit")
+				return nil, nil
+			})
+			h.Run(w)
+
+			h.Enqueue(`"`+strings.Repeat("0", 1<<20)+`"`, producer.WithType("email"), producer.WithID("1"),
+				producer.WithCompression(algorithm, 1024))
+			h.WaitIdle(5 * time.Second)
+
+			dead := h.DeadLetters()
+			if len(dead) != 1 {
+				t.Fatalf("got %d dead letters, want 1", len(dead))
+			}
+			if cause, _ := dead[0].Values["dlq_error"].(string); !strings.Contains(cause, "failed to decompress body") {
+				t.Errorf("dead-lettered with %q, want failing to decompress", cause)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// concurrencyRetryDelay is how long a handler waits before trying to take
+	// a slot of its type again while all are taken across workers
+	concurrencyRetryDelay = 50 * time.Millisecond
+
+	// concurrencyErrorDelay is how long a handler waits before trying again
+	// when Redis couldn't be reached
+	concurrencyErrorDelay = time.Second
+
+	// concurrencyTimeout bounds each call taking, renewing or releasing a slot
+	concurrencyTimeout = 5 * time.Second
+)
+
+// takeSlot adds the slot ARGV[1] to the semaphore KEYS[1], expiring ARGV[3]
+// milliseconds from now, if it holds fewer than ARGV[2] slots once the expired
+// ones are dropped. It returns 1 if the slot was taken, 0 if all are taken.
+var takeSlot = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// renewSlot extends the slot ARGV[1] of the semaphore KEYS[1] to expire
+// ARGV[2] milliseconds from now, returning 0 if it expired already
+var renewSlot = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local expiry = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not expiry or tonumber(expiry) <= now then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// WithMaxConcurrency runs at most n handlers of the type at once in the
+// worker process, across all of its consumers and streams, e.g. to protect a
+// downstream that only takes a few requests at a time. Other messages of the
+// type wait in their consumer for a slot.
+func WithMaxConcurrency(n int) HandlerOption {
+	return func(r *route) {
+		r.slots = nil
+		if n > 0 {
+			r.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithGlobalConcurrency runs at most n handlers of the type at once across
+// all the workers sharing the Redis server, each holding a slot of the
+// semaphore in the concurrency:<type> key while its message is processed.
+// Messages of the type wait in their consumer for a slot. Slots are renewed
+// every third of ConcurrencySlotTTL, so those of a worker that died are freed
+// after ConcurrencySlotTTL.
+func WithGlobalConcurrency(n int) HandlerOption {
+	return func(r *route) {
+		r.globalSlots = max(n, 0)
+	}
+}
+
+// concurrencyKey returns the key holding the slots of the handlers of a type
+// running across workers
+func (c *Config) concurrencyKey(jobType string) string {
+	return c.key("concurrency:" + jobType)
+}
+
+// waitForTurn waits for the rate limit of the route and for a free slot of
+// its type, if they are limited. It returns the function releasing the slot
+// once the message is done, or false if ctx is done first.
+func (c *consumer) waitForTurn(ctx context.Context, r *route, jobType string) (func(), bool) {
+	if !r.limiter.wait(ctx) {
+		return nil, false
+	}
+
+	if r.slots != nil {
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	releaseLocal := func() {
+		if r.slots != nil {
+			<-r.slots
+		}
+	}
+	if r.globalSlots == 0 {
+		return releaseLocal, true
+	}
+
+	slot := &slot{c: c, key: c.config.concurrencyKey(jobType), token: c.name + ":" + rand.Text(), jobType: jobType}
+	ttl := c.config.ConcurrencySlotTTL
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, concurrencyTimeout)
+		taken, err := takeSlot.Run(queryCtx, c.client, []string{slot.key}, slot.token, r.globalSlots, ttl.Milliseconds()).Int()
+		cancel()
+		delay := concurrencyRetryDelay
+		switch {
+		case err != nil && ctx.Err() == nil:
+			c.logger.Warn("Failed to take a concurrency slot", "type", jobType, "error", err)
+			delay = concurrencyErrorDelay
+		case taken == 1:
+			slot.done = make(chan struct{})
+			slot.stopped = make(chan struct{})
+			go slot.renew(ttl)
+			return func() {
+				slot.release()
+				releaseLocal()
+			}, true
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			releaseLocal()
+			return nil, false
+		}
+	}
+}
+
+// slot is a slot of the semaphore of a type held across workers
+type slot struct {
+	c       *consumer
+	key     string
+	token   string
+	jobType string
+
+	done    chan struct{} // closed to stop renewing
+	stopped chan struct{} // closed once renewing stopped
+}
+
+// renew extends the slot every third of ttl until it is released
+func (s *slot) renew(ttl time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), concurrencyTimeout)
+		renewed, err := renewSlot.Run(ctx, s.c.client, []string{s.key}, s.token, ttl.Milliseconds()).Int()
+		cancel()
+		switch {
+		case err != nil:
+			s.c.logger.Warn("Failed to renew concurrency slot", "type", s.jobType, "error", err)
+		case renewed == 0:
+			// Another worker may have taken it, so the limit can be exceeded
+			// until this handler returns
+			s.c.logger.Warn("Concurrency slot expired while the handler ran", "type", s.jobType)
+			return
+		}
+	}
+}
+
+// release stops renewing the slot and frees it for other workers
+func (s *slot) release() {
+	close(s.done)
+	<-s.stopped
+	ctx, cancel := context.WithTimeout(context.Background(), concurrencyTimeout)
+	defer cancel()
+	if err := s.c.client.ZRem(ctx, s.key, s.token).Err(); err != nil {
+		s.c.logger.Warn("Failed to release concurrency slot", "type", s.jobType, "error", err)
+	}
+}
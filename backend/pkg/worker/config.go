@@ -0,0 +1,995 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// Config holds all worker configuration
+type Config struct {
+	// RedisURL (redis:// or rediss://) takes precedence over RedisHost and RedisPort
+	RedisURL  string
+	RedisHost string
+	RedisPort string
+
+	// Sentinel addresses and master name, used instead of RedisHost and
+	// RedisPort when set
+	RedisSentinelAddrs []string
+	RedisMasterName    string
+
+	// Cluster seed addresses, used instead of all of the above when set
+	RedisClusterAddrs []string
+
+	// Credentials, Username being the ACL user on Redis 6 and later
+	RedisUsername string
+	RedisPassword string
+
+	// TLS for Redis connections, with optional client certificate and CA
+	RedisTLSEnabled  bool
+	RedisTLSCertFile string
+	RedisTLSKeyFile  string
+	RedisTLSCAFile   string
+
+	// RedisDedicatedReaders gives the reader of each stream a connection of
+	// its own, named with CLIENT SETNAME, instead of holding one of the shared
+	// pool while XREADGROUP blocks
+	RedisDedicatedReaders bool
+
+	// Reads failing with a Redis error are tried again after a backoff
+	// doubling from ReadErrorBackoff up to ReadErrorMaxBackoff, jittered
+	ReadErrorBackoff    time.Duration
+	ReadErrorMaxBackoff time.Duration
+
+	// KeyPrefix is prepended to every key and channel the worker uses, such
+	// as "prod:", so that environments can share one Redis
+	KeyPrefix string
+
+	ApiURL         string
+	WorkerCount    int
+	BatchSize      int
+	StreamName     string
+	GroupName      string
+	ProcessingTime time.Duration
+	FailoverGrace  time.Duration
+	ShutdownGrace  time.Duration
+	OutboxStream   string
+	AckPolicy      AckPolicy
+
+	// Each read asks XREADGROUP for up to ReadCount messages, BatchSize if
+	// zero, blocking for up to ReadBlock while there are none. With ReadBlock
+	// zero the reader polls instead, waiting IdlePollDelay after a read that
+	// found nothing, doubled with every such read in a row up to
+	// IdlePollMaxDelay. ReadProfile is the profile they were preset with.
+	ReadBlock        time.Duration
+	ReadCount        int
+	IdlePollDelay    time.Duration
+	IdlePollMaxDelay time.Duration
+	ReadProfile      ReadProfile
+
+	// Requests to the status API share one client, which keeps up to
+	// HTTPMaxIdleConns idle connections, HTTPMaxIdleConnsPerHost per host,
+	// alive for HTTPIdleConnTimeout, opens at most HTTPMaxConnsPerHost per host
+	// unless zero, and negotiates HTTP/2 with TLS servers unless HTTP2Enabled
+	// is false. HTTPTimeout bounds each request and HTTPDialTimeout each new
+	// connection.
+	HTTPTimeout             time.Duration
+	HTTPDialTimeout         time.Duration
+	HTTPMaxIdleConns        int
+	HTTPMaxIdleConnsPerHost int
+	HTTPMaxConnsPerHost     int
+	HTTPIdleConnTimeout     time.Duration
+	HTTP2Enabled            bool
+
+	// Requests to the status API are authenticated with HTTPBearerToken as a
+	// bearer token, HTTPAPIKey in the HTTPAPIKeyHeader header, "X-API-Key" by
+	// default, and HTTPBasicUser and HTTPBasicPassword, those that are set.
+	// The bearer token and basic authentication both use the Authorization
+	// header, so only one of them can be set.
+	// For mutual TLS, the client presents the certificate in HTTPTLSCertFile
+	// and HTTPTLSKeyFile, and HTTPTLSCAFile replaces the system roots.
+	HTTPBearerToken   string
+	HTTPAPIKey        string
+	HTTPAPIKeyHeader  string
+	HTTPBasicUser     string
+	HTTPBasicPassword string
+	HTTPTLSCertFile   string
+	HTTPTLSKeyFile    string
+	HTTPTLSCAFile     string
+
+	// Requests to the status API that fail, are rate limited or answered with
+	// a 5xx status are retried up to HTTPMaxRetries times, waiting as long as
+	// a Retry-After header asks, or HTTPRetryBaseDelay doubled for every retry
+	// up to HTTPRetryMaxDelay. Updates the API wants retried later than that
+	// go to the status outbox.
+	HTTPMaxRetries     int
+	HTTPRetryBaseDelay time.Duration
+	HTTPRetryMaxDelay  time.Duration
+
+	// HTTPSigningSecret, if set, signs the body of every request to the status
+	// API with HMAC-SHA256, for the API to check it comes from the worker
+	HTTPSigningSecret string
+
+	// GroupStartID is where consumer groups created by the worker start: "0"
+	// to process the entries already in the stream, "$" for new entries only,
+	// or an entry ID to process the entries after it
+	GroupStartID string
+
+	// ConsumerName is the template of the name the worker reads each stream's
+	// group under, made unique per process with {hostname}, the pod name in
+	// Kubernetes, or {pid}. {stream} and {group} are also expanded.
+	ConsumerName string
+
+	// With MaxWorkerCount set, the number of consumers processing messages at
+	// once adapts between MinWorkerCount and MaxWorkerCount, starting at
+	// WorkerCount and reconsidered every AutoscaleInterval, growing with the
+	// stream's lag and shrinking when handlers slow down or fail
+	MinWorkerCount    int
+	MaxWorkerCount    int
+	AutoscaleInterval time.Duration
+
+	// Streams consumed by the worker, each through its own consumer group and
+	// with WorkerCount consumers. When empty the worker consumes StreamName
+	// through GroupName.
+	//
+	// If any stream has a weight, or StrictPriority is set, the streams share
+	// one pool of WorkerCount consumers instead. Each consumer takes the next
+	// message from a stream picked in proportion to the weights, or from the
+	// stream with the highest weight that has messages with StrictPriority.
+	Streams        []StreamConfig
+	StrictPriority bool
+
+	// AtMostOnceStreams are processed with AckBeforeProcessing whatever
+	// AckPolicy is, unless their StreamConfig has an ack policy
+	AtMostOnceStreams []string
+
+	// Priorities consumes each stream as three, <stream>:high, the stream
+	// itself and <stream>:low, where producer.WithPriority adds jobs, drained
+	// in that order as with StrictPriority. With PriorityAging set, a stream
+	// of a strict pool that has messages waiting but wasn't served for that
+	// long gets the next message taken, so that it doesn't starve.
+	Priorities    bool
+	PriorityAging time.Duration
+
+	// StreamPattern, such as "jobs:*", makes the worker also consume every
+	// stream matching the glob pattern, looked for every
+	// StreamDiscoveryInterval, each through GroupName with its own WorkerCount
+	// consumers like a stream of Streams. Streams deleted are no longer read.
+	StreamPattern           string
+	StreamDiscoveryInterval time.Duration
+
+	// TenantStreamPattern, such as "jobs:*", makes the worker also consume
+	// the stream of every tenant, named by the pattern with the tenant in
+	// place of the star, through GroupName. Tenant streams are looked for
+	// every TenantDiscoveryInterval and share one pool of WorkerCount
+	// consumers taking their messages in turn, at most TenantConcurrency of
+	// each tenant at once when it is positive.
+	TenantStreamPattern     string
+	TenantDiscoveryInterval time.Duration
+	TenantConcurrency       int
+
+	// DedupWindow is how long an idempotency key set with
+	// producer.WithIdempotencyKey is remembered once its job is processed, so
+	// that later jobs with the same key are skipped. Zero disables it.
+	DedupWindow time.Duration
+
+	// ReplyTTL is how long the reply streams of jobs enqueued with
+	// producer.Request or producer.WithReplyTo are kept after the worker adds
+	// the outcome of the job, zero keeping them until the producer deletes
+	// them
+	ReplyTTL time.Duration
+
+	// CancelTTL is how long a job cancelled with Inspector.Cancel or the
+	// cancel:<id> channel is remembered, so that it is skipped if it is read
+	// after being cancelled
+	CancelTTL time.Duration
+
+	// RateLimit caps how many messages per second the worker reads and retries
+	// across all its streams, allowing bursts of up to RateBurst messages,
+	// which defaults to one second's worth. Zero disables it.
+	RateLimit float64
+	RateBurst int
+
+	// HandlerTimeout fails attempts whose handler runs for longer, zero
+	// disabling it. A timeout set with WithTimeout takes precedence.
+	HandlerTimeout time.Duration
+
+	// Retry policy for messages whose handler returns an error, unless their
+	// type has its own or they set theirs
+	MaxRetries   int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	RetryBackoff Backoff
+
+	// Producers can override the policy of a single message, within bounds:
+	// at most MessageMaxRetries retries, MessageMaxRetryDelay before the first
+	// one and MessageMaxTimeout for each attempt, and only to dead-letter it
+	// to one of MessageDeadLetterStreams
+	MessageMaxRetries        int
+	MessageMaxRetryDelay     time.Duration
+	MessageMaxTimeout        time.Duration
+	MessageDeadLetterStreams []string
+
+	// Messages that exhaust their retries are moved to DeadLetterStream, which
+	// defaults to "<StreamName>:dlq", or dropped if DeadLetterEnabled is false.
+	// A worker consuming several streams must leave DeadLetterStream empty, so
+	// that each stream has its own and dead letters are replayed to it.
+	DeadLetterEnabled bool
+	DeadLetterStream  string
+
+	// Every DeadLetterCheckInterval, the dead-letter stream is measured for
+	// the metrics, trimmed to about DeadLetterMaxLen entries and of those
+	// older than DeadLetterMaxAge, zero disabling either, and an alert is
+	// raised when it reaches DeadLetterAlertThreshold entries, posted to
+	// DeadLetterAlertWebhook if set
+	DeadLetterCheckInterval  time.Duration
+	DeadLetterMaxLen         int
+	DeadLetterMaxAge         time.Duration
+	DeadLetterAlertThreshold int
+	DeadLetterAlertWebhook   string
+
+	// SchemaDir holds a <type>.json JSON Schema for each message type whose
+	// bodies are validated before their handler runs, unless the handler was
+	// registered WithSchema
+	SchemaDir string
+
+	// CloudEvents reads entries holding a CloudEvents 1.0 event, structured
+	// as JSON in their ce field or binary in ce_ fields, the event's id being
+	// the job ID and its type the job type. Other entries are read as usual.
+	CloudEvents bool
+
+	// EncryptionKeys decrypt the bodies producers encrypted with
+	// producer.WithEncryption, as comma-separated <id>:<base64 key> pairs
+	EncryptionKeys string
+
+	// Compressed bodies decompressing to more than MaxDecompressedSize bytes
+	// are dead-lettered instead of being decompressed in full
+	MaxDecompressedSize int
+
+	// Every ClaimInterval, entries pending for longer than ClaimMinIdle are
+	// reclaimed from their consumers with XAUTOCLAIM. ClaimMinIdle should exceed
+	// MaxDelay so messages waiting for a retry are left alone.
+	ClaimInterval time.Duration
+	ClaimMinIdle  time.Duration
+
+	// RecoverPending has the reader of each stream process again the entries
+	// a previous run of its consumer left pending, reading them from ID 0 as
+	// soon as it starts, instead of once their retry delay has elapsed
+	RecoverPending bool
+
+	// HeartbeatInterval is how often the entry of a running handler is claimed
+	// again to reset its idle time, so that handlers running for longer than
+	// ClaimMinIdle keep their message. It should be well below ClaimMinIdle,
+	// zero disabling it.
+	HeartbeatInterval time.Duration
+
+	// ConcurrencySlotTTL is how long a slot of a type limited with
+	// WithGlobalConcurrency stays taken after the worker holding it stopped
+	// renewing it, e.g. because it died
+	ConcurrencySlotTTL time.Duration
+
+	// ProgressInterval is the minimum time between the progress updates of a
+	// handler, those reported sooner being dropped
+	ProgressInterval time.Duration
+
+	// Every ConsumerCleanupInterval, consumers of the group idle for longer
+	// than ConsumerMaxIdle and without pending entries are deleted, zero
+	// disabling it
+	ConsumerCleanupInterval time.Duration
+	ConsumerMaxIdle         time.Duration
+
+	// Every TrimInterval, one of the workers trims the stream to about
+	// StreamMaxLen entries and removes the entries older than StreamMaxAge,
+	// zero disabling either. Entries not processed by every consumer group
+	// yet are kept, unless TrimUnprocessed is set.
+	TrimInterval    time.Duration
+	StreamMaxLen    int
+	StreamMaxAge    time.Duration
+	TrimUnprocessed bool
+
+	// Every LagInterval, the lag, last delivered ID and pending entries of the
+	// consumer group and the entries added to the stream are read with XINFO,
+	// exported as metrics and logged at debug level, zero disabling it
+	LagInterval time.Duration
+
+	// Every ScheduleInterval, delayed jobs that are due are moved from the
+	// "<StreamName>:scheduled" sorted set to the stream, zero disabling it
+	ScheduleInterval time.Duration
+
+	// Recurring jobs, along with those in the CronKey hash, which defaults to
+	// "<StreamName>:cron"
+	CronJobs []CronJob
+	CronKey  string
+
+	// With LeaderElection, the workers elect a leader holding the LeaderKey
+	// lease, "<StreamName>:leader" by default, for LeaderLeaseTTL, and only
+	// the leader trims, reclaims stale messages, fires cron jobs and deletes
+	// idle consumers. Another worker takes over once the lease expires.
+	LeaderElection bool
+	LeaderKey      string
+	LeaderLeaseTTL time.Duration
+
+	// StatusBackend is where status updates are reported: "http" to post them
+	// to ApiURL, "redis-hash" to keep each job's latest status in the hash
+	// StatusKeyPrefix+id, expiring StatusTTL after its last update unless
+	// zero, "redis-stream" to add them to StatusStream, which defaults to
+	// "<StreamName>:status", trimmed to about StatusStreamMaxLen entries,
+	// "postgres" to keep them in StatusTable of the StatusPostgresDSN
+	// database, "grpc" to stream them to the StatusService at StatusGRPCAddr,
+	// over TLS if StatusGRPCTLS is set, or "none". WithStatusReporter takes
+	// precedence.
+	StatusBackend      string
+	StatusKeyPrefix    string
+	StatusTTL          time.Duration
+	StatusStream       string
+	StatusStreamMaxLen int
+	StatusPostgresDSN  string
+	StatusTable        string
+	StatusGRPCAddr     string
+	StatusGRPCTLS      bool
+
+	// The status API circuit breaker opens after StatusBreakerThreshold
+	// consecutive failed updates, zero disabling it, and lets a probe through
+	// after StatusBreakerCooldown. StatusBreakerPause stops reading while it
+	// is open instead of processing messages without status updates.
+	StatusBreakerThreshold int
+	StatusBreakerCooldown  time.Duration
+	StatusBreakerPause     bool
+
+	// Status updates that can't be delivered are queued in a Redis list,
+	// StatusOutboxKey defaulting to "<StreamName>:status-outbox", and retried
+	// in order until the API accepts them, unless StatusOutboxEnabled is false
+	StatusOutboxEnabled bool
+	StatusOutboxKey     string
+
+	// With JobStoreEnabled, the worker keeps the state of every job in the
+	// hash JobKeyPrefix+id, indexed by status, expiring JobTTL after its last
+	// update unless zero, and serves it from the admin API
+	JobStoreEnabled bool
+	JobKeyPrefix    string
+	JobTTL          time.Duration
+
+	// StatusBatchSize, when above one, makes consumers hand status updates to
+	// a batcher that reports up to that many at once every
+	// StatusBatchInterval, or as soon as StatusBatchSize are waiting. Only
+	// the HTTP backend supports batches.
+	StatusBatchSize     int
+	StatusBatchInterval time.Duration
+
+	// AckBatchSize, when above one, acknowledges processed messages in
+	// batches, with one pipelined XACK per stream every AckBatchInterval, or as
+	// soon as AckBatchSize are waiting. Messages acknowledged along with other
+	// writes, or before processing, are acknowledged right away.
+	AckBatchSize     int
+	AckBatchInterval time.Duration
+
+	// ArchiveBackend is where the records of jobs that completed or failed for
+	// good are archived: "postgres" to insert them into ArchiveTable of the
+	// ArchivePostgresDSN database, which defaults to StatusPostgresDSN, or
+	// "none". Records are written in the background, up to ArchiveBatchSize
+	// at once every ArchiveBatchInterval, and dropped once ArchiveQueueSize
+	// are waiting. WithArchiver takes precedence.
+	ArchiveBackend       string
+	ArchivePostgresDSN   string
+	ArchiveTable         string
+	ArchiveBatchSize     int
+	ArchiveBatchInterval time.Duration
+	ArchiveQueueSize     int
+
+	// HTTPAddr is the listen address of the embedded HTTP server exposing
+	// /metrics, /healthz, /readyz and /scaling, or empty to not start it
+	HTTPAddr string
+
+	// AdminAddr is the listen address of the admin API, or empty to not start
+	// it. Requests must carry AdminToken as a bearer token.
+	AdminAddr  string
+	AdminToken string
+
+	MaintenanceWindows  []MaintenanceWindow
+	MaintenanceLocation *time.Location
+
+	// DownstreamHealthURL is probed every DownstreamCheckInterval along with
+	// the checks added with WithDownstreamCheck, reading being paused while it
+	// doesn't answer with a 2xx status, or empty to not probe it
+	DownstreamHealthURL     string
+	DownstreamCheckInterval time.Duration
+
+	// Level and format ("text" or "json") of the logger created by NewLogger
+	LogLevel  slog.Level
+	LogFormat string
+
+	// ConfigFile is the file the configuration was loaded from, if any, and
+	// ConfigWatchInterval how often cmd/worker checks it for changes to
+	// reload, zero to only reload on SIGHUP
+	ConfigFile          string
+	ConfigWatchInterval time.Duration
+
+	// level of the loggers created by NewLogger, changed by Reload
+	logLevel *slog.LevelVar
+
+	// Chaos turns on fault injection, to check how retries, dead-lettering
+	// and reclaiming cope with failures before production, and must never be
+	// set in production. Each rate is the probability between 0 and 1 of
+	// failing a handler, delaying an ack by up to ChaosAckDelay, failing a
+	// status update and failing a Redis command as if the connection dropped.
+	Chaos                bool
+	ChaosHandlerFailRate float64
+	ChaosAckDelayRate    float64
+	ChaosAckDelay        time.Duration
+	ChaosStatusFailRate  float64
+	ChaosRedisFailRate   float64
+}
+
+// DefaultConfig returns a configuration with the default value for every field
+func DefaultConfig() *Config {
+	return &Config{
+		RedisHost:               "localhost",
+		RedisPort:               "6379",
+		RedisMasterName:         "mymaster",
+		ApiURL:                  "http://localhost:3000",
+		HTTPTimeout:             5 * time.Second,
+		HTTPDialTimeout:         5 * time.Second,
+		HTTPMaxIdleConns:        100,
+		HTTPMaxIdleConnsPerHost: 32,
+		HTTPIdleConnTimeout:     90 * time.Second,
+		HTTP2Enabled:            true,
+		HTTPAPIKeyHeader:        "X-API-Key",
+		HTTPMaxRetries:          2,
+		HTTPRetryBaseDelay:      200 * time.Millisecond,
+		HTTPRetryMaxDelay:       5 * time.Second,
+		WorkerCount:             5,
+		MinWorkerCount:          1,
+		AutoscaleInterval:       10 * time.Second,
+		StreamDiscoveryInterval: 10 * time.Second,
+		TenantDiscoveryInterval: 10 * time.Second,
+		BatchSize:               10,
+		ReadBlock:               5 * time.Second,
+		IdlePollDelay:           100 * time.Millisecond,
+		IdlePollMaxDelay:        time.Second,
+		StreamName:              "mystream",
+		GroupName:               "mygroup",
+		GroupStartID:            "0",
+		ConsumerName:            defaultConsumerName,
+		ProcessingTime:          2 * time.Second,
+		FailoverGrace:           30 * time.Second,
+		ShutdownGrace:           10 * time.Second,
+		AckPolicy:               AckOnSuccess,
+		MaxRetries:              3,
+		BaseDelay:               time.Second,
+		MaxDelay:                time.Minute,
+		RetryBackoff:            BackoffExponential,
+		MessageMaxRetries:       25,
+		MessageMaxRetryDelay:    time.Hour,
+		MessageMaxTimeout:       time.Hour,
+		DeadLetterEnabled:       true,
+		DeadLetterCheckInterval: time.Minute,
+		ClaimInterval:           30 * time.Second,
+		ClaimMinIdle:            5 * time.Minute,
+		RecoverPending:          true,
+		HeartbeatInterval:       time.Minute,
+		ConcurrencySlotTTL:      30 * time.Second,
+		ReplyTTL:                5 * time.Minute,
+		CancelTTL:               24 * time.Hour,
+		ProgressInterval:        time.Second,
+		ConsumerCleanupInterval: time.Hour,
+		ConsumerMaxIdle:         24 * time.Hour,
+		TrimInterval:            time.Minute,
+		LeaderLeaseTTL:          15 * time.Second,
+		LagInterval:             15 * time.Second,
+		ScheduleInterval:        time.Second,
+		StatusBackend:           StatusBackendHTTP,
+		StatusKeyPrefix:         "job:",
+		StatusTable:             "job_status",
+		StatusBreakerThreshold:  5,
+		StatusBreakerCooldown:   30 * time.Second,
+		StatusOutboxEnabled:     true,
+		JobKeyPrefix:            "job:",
+		JobTTL:                  24 * time.Hour,
+		StatusBatchInterval:     100 * time.Millisecond,
+		AckBatchInterval:        50 * time.Millisecond,
+		ArchiveBackend:          ArchiveBackendNone,
+		ArchiveTable:            "job_archive",
+		ArchiveBatchSize:        100,
+		MaxDecompressedSize:     64 << 20,
+		ArchiveBatchInterval:    time.Second,
+		ArchiveQueueSize:        10000,
+		ReadErrorBackoff:        100 * time.Millisecond,
+		ReadErrorMaxBackoff:     10 * time.Second,
+		MaintenanceLocation:     time.UTC,
+		DownstreamCheckInterval: 10 * time.Second,
+		LogLevel:                slog.LevelInfo,
+		LogFormat:               "text",
+	}
+}
+
+// autoscaling reports whether the number of consumers adapts to the load
+func (c *Config) autoscaling() bool {
+	return c.MaxWorkerCount > 0
+}
+
+// consumerCount returns how many consumers are started for each stream, or
+// for the pool: WorkerCount, or MaxWorkerCount when autoscaling
+func (c *Config) consumerCount() int {
+	if c.autoscaling() {
+		return max(c.MaxWorkerCount, c.MinWorkerCount, 1)
+	}
+	return c.WorkerCount
+}
+
+// strictPriority reports whether pooled streams are drained in order of weight
+func (c *Config) strictPriority() bool {
+	return c.StrictPriority || c.Priorities
+}
+
+// consumesSeveral reports whether the configuration makes the worker consume
+// more than one stream
+func (c *Config) consumesSeveral() bool {
+	return len(c.Streams) > 1 || c.Priorities || c.StreamPattern != "" || c.TenantStreamPattern != ""
+}
+
+// key returns name with KeyPrefix, unless it is empty or already has it
+func (c *Config) key(name string) string {
+	if name == "" || strings.HasPrefix(name, c.KeyPrefix) {
+		return name
+	}
+	return c.KeyPrefix + name
+}
+
+// RedisAddr returns the host:port address of the Redis server
+func (c *Config) RedisAddr() string {
+	return fmt.Sprintf("%s:%s", c.RedisHost, c.RedisPort)
+}
+
+// Redacted returns a copy of the configuration with secrets masked, for logging
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.RedisPassword != "" {
+		redacted.RedisPassword = "*****"
+	}
+	if redacted.AdminToken != "" {
+		redacted.AdminToken = "*****"
+	}
+	if redacted.EncryptionKeys != "" {
+		redacted.EncryptionKeys = "*****"
+	}
+	if redacted.HTTPBearerToken != "" {
+		redacted.HTTPBearerToken = "*****"
+	}
+	if redacted.HTTPAPIKey != "" {
+		redacted.HTTPAPIKey = "*****"
+	}
+	if redacted.HTTPBasicPassword != "" {
+		redacted.HTTPBasicPassword = "*****"
+	}
+	if redacted.HTTPSigningSecret != "" {
+		redacted.HTTPSigningSecret = "*****"
+	}
+	if u, err := url.Parse(redacted.RedisURL); err == nil {
+		redacted.RedisURL = u.Redacted()
+	}
+	redacted.StatusPostgresDSN = redactDSN(redacted.StatusPostgresDSN)
+	redacted.ArchivePostgresDSN = redactDSN(redacted.ArchivePostgresDSN)
+	return &redacted
+}
+
+// redactDSN masks the password of a Postgres connection string. URLs keep
+// everything else, while key=value strings are masked entirely since their
+// password can't be told apart reliably.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "*****"
+	}
+	if query := u.Query(); query.Has("password") {
+		query.Set("password", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// LoadConfig loads configuration from environment variables and the YAML or
+// TOML file named by CONFIG_FILE, environment variables taking precedence,
+// falling back to DefaultConfig for anything that is not set
+func LoadConfig() (*Config, error) {
+	return LoadConfigWithOverrides(nil)
+}
+
+// LoadConfigWithOverrides loads configuration like LoadConfig, the non-empty
+// values of overrides, keyed by environment variable, taking precedence over
+// the environment. cmd/worker passes its command-line flags this way.
+func LoadConfigWithOverrides(overrides map[string]string) (*Config, error) {
+	s, err := loadSettings(overrides)
+	if err != nil {
+		return nil, err
+	}
+	return loadConfig(s)
+}
+
+// ConfigKey is a setting LoadConfig reads, by its environment variable
+type ConfigKey struct {
+	Name string
+	Bool bool // true for boolean settings
+}
+
+// ConfigKeys returns the settings LoadConfig reads, in the order it reads them
+func ConfigKeys() []ConfigKey {
+	s := newSettings(nil, func(string) string { return "" })
+	s.get("CONFIG_FILE")
+	_, _ = loadConfig(s)
+	keys := make([]ConfigKey, len(s.keys))
+	for i, key := range s.keys {
+		keys[i] = ConfigKey{Name: key, Bool: s.bools[key]}
+	}
+	return keys
+}
+
+// loadConfig loads the configuration from s
+func loadConfig(s *settings) (*Config, error) {
+	config := DefaultConfig()
+	config.ConfigFile = s.file
+
+	// The variables of the settings a read profile presets override it
+	if v := s.get("READ_PROFILE"); v != "" {
+		if err := config.ApplyReadProfile(ReadProfile(v)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("READ_PROFILE"), err)
+		}
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"WORKER_COUNT", &config.WorkerCount},
+		{"MIN_WORKER_COUNT", &config.MinWorkerCount},
+		{"MAX_WORKER_COUNT", &config.MaxWorkerCount},
+		{"BATCH_SIZE", &config.BatchSize},
+		{"READ_COUNT", &config.ReadCount},
+		{"MAX_RETRIES", &config.MaxRetries},
+		{"MESSAGE_MAX_RETRIES", &config.MessageMaxRetries},
+		{"STATUS_BREAKER_THRESHOLD", &config.StatusBreakerThreshold},
+		{"STATUS_BATCH_SIZE", &config.StatusBatchSize},
+		{"ACK_BATCH_SIZE", &config.AckBatchSize},
+		{"ARCHIVE_BATCH_SIZE", &config.ArchiveBatchSize},
+		{"ARCHIVE_QUEUE_SIZE", &config.ArchiveQueueSize},
+		{"MAX_DECOMPRESSED_SIZE", &config.MaxDecompressedSize},
+		{"STREAM_MAXLEN", &config.StreamMaxLen},
+		{"DLQ_MAXLEN", &config.DeadLetterMaxLen},
+		{"DLQ_ALERT_THRESHOLD", &config.DeadLetterAlertThreshold},
+		{"STATUS_STREAM_MAXLEN", &config.StatusStreamMaxLen},
+		{"HTTP_MAX_IDLE_CONNS", &config.HTTPMaxIdleConns},
+		{"HTTP_MAX_IDLE_CONNS_PER_HOST", &config.HTTPMaxIdleConnsPerHost},
+		{"HTTP_MAX_CONNS_PER_HOST", &config.HTTPMaxConnsPerHost},
+		{"HTTP_MAX_RETRIES", &config.HTTPMaxRetries},
+		{"RATE_BURST", &config.RateBurst},
+		{"TENANT_CONCURRENCY", &config.TenantConcurrency},
+	}
+	for _, v := range ints {
+		if err := s.setInt(v.dst, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxWorkerCount > 0 && config.MinWorkerCount > config.MaxWorkerCount {
+		return nil, fmt.Errorf("MIN_WORKER_COUNT %d exceeds MAX_WORKER_COUNT %d", config.MinWorkerCount, config.MaxWorkerCount)
+	}
+	if err := s.setFloat(&config.RateLimit, "RATE_LIMIT"); err != nil {
+		return nil, err
+	}
+	rates := []struct {
+		key string
+		dst *float64
+	}{
+		{"CHAOS_HANDLER_FAIL_RATE", &config.ChaosHandlerFailRate},
+		{"CHAOS_ACK_DELAY_RATE", &config.ChaosAckDelayRate},
+		{"CHAOS_STATUS_FAIL_RATE", &config.ChaosStatusFailRate},
+		{"CHAOS_REDIS_FAIL_RATE", &config.ChaosRedisFailRate},
+	}
+	for _, v := range rates {
+		if err := s.setFloat(v.dst, v.key); err != nil {
+			return nil, err
+		}
+		if *v.dst < 0 || *v.dst > 1 {
+			return nil, fmt.Errorf("%s %v is not between 0 and 1", s.name(v.key), *v.dst)
+		}
+	}
+
+	// Durations are given in milliseconds
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"PROCESSING_TIME", &config.ProcessingTime},
+		{"FAILOVER_GRACE", &config.FailoverGrace},
+		{"READ_BLOCK", &config.ReadBlock},
+		{"IDLE_POLL_DELAY", &config.IdlePollDelay},
+		{"IDLE_POLL_MAX_DELAY", &config.IdlePollMaxDelay},
+		{"READ_ERROR_BACKOFF", &config.ReadErrorBackoff},
+		{"READ_ERROR_MAX_BACKOFF", &config.ReadErrorMaxBackoff},
+		{"SHUTDOWN_GRACE", &config.ShutdownGrace},
+		{"HANDLER_TIMEOUT", &config.HandlerTimeout},
+		{"AUTOSCALE_INTERVAL", &config.AutoscaleInterval},
+		{"PRIORITY_AGING", &config.PriorityAging},
+		{"STREAM_DISCOVERY_INTERVAL", &config.StreamDiscoveryInterval},
+		{"TENANT_DISCOVERY_INTERVAL", &config.TenantDiscoveryInterval},
+		{"DEDUP_WINDOW", &config.DedupWindow},
+		{"REPLY_TTL", &config.ReplyTTL},
+		{"CANCEL_TTL", &config.CancelTTL},
+		{"JOB_TTL", &config.JobTTL},
+		{"RETRY_BASE_DELAY", &config.BaseDelay},
+		{"RETRY_MAX_DELAY", &config.MaxDelay},
+		{"MESSAGE_MAX_RETRY_DELAY", &config.MessageMaxRetryDelay},
+		{"MESSAGE_MAX_TIMEOUT", &config.MessageMaxTimeout},
+		{"CLAIM_INTERVAL", &config.ClaimInterval},
+		{"CLAIM_MIN_IDLE", &config.ClaimMinIdle},
+		{"HEARTBEAT_INTERVAL", &config.HeartbeatInterval},
+		{"CONCURRENCY_SLOT_TTL", &config.ConcurrencySlotTTL},
+		{"DOWNSTREAM_CHECK_INTERVAL", &config.DownstreamCheckInterval},
+		{"PROGRESS_INTERVAL", &config.ProgressInterval},
+		{"CONSUMER_CLEANUP_INTERVAL", &config.ConsumerCleanupInterval},
+		{"CONSUMER_MAX_IDLE", &config.ConsumerMaxIdle},
+		{"SCHEDULE_INTERVAL", &config.ScheduleInterval},
+		{"TRIM_INTERVAL", &config.TrimInterval},
+		{"DLQ_CHECK_INTERVAL", &config.DeadLetterCheckInterval},
+		{"LEADER_LEASE_TTL", &config.LeaderLeaseTTL},
+		{"LAG_INTERVAL", &config.LagInterval},
+		{"CHAOS_ACK_DELAY", &config.ChaosAckDelay},
+		{"CONFIG_WATCH_INTERVAL", &config.ConfigWatchInterval},
+		{"STREAM_MAX_AGE", &config.StreamMaxAge},
+		{"DLQ_MAX_AGE", &config.DeadLetterMaxAge},
+		{"STATUS_BREAKER_COOLDOWN", &config.StatusBreakerCooldown},
+		{"STATUS_BATCH_INTERVAL", &config.StatusBatchInterval},
+		{"ACK_BATCH_INTERVAL", &config.AckBatchInterval},
+		{"ARCHIVE_BATCH_INTERVAL", &config.ArchiveBatchInterval},
+		{"STATUS_TTL", &config.StatusTTL},
+		{"HTTP_TIMEOUT", &config.HTTPTimeout},
+		{"HTTP_DIAL_TIMEOUT", &config.HTTPDialTimeout},
+		{"HTTP_IDLE_CONN_TIMEOUT", &config.HTTPIdleConnTimeout},
+		{"HTTP_RETRY_BASE_DELAY", &config.HTTPRetryBaseDelay},
+		{"HTTP_RETRY_MAX_DELAY", &config.HTTPRetryMaxDelay},
+	}
+	for _, v := range durations {
+		if err := s.setDuration(v.dst, v.key); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse maintenance windows, evaluated in UTC unless a time zone is given
+	maintenanceWindows, err := parseMaintenanceWindows(s.get("MAINTENANCE_WINDOWS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", s.name("MAINTENANCE_WINDOWS"), err)
+	}
+	config.MaintenanceWindows = maintenanceWindows
+	if tz := s.get("MAINTENANCE_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("MAINTENANCE_TIMEZONE"), err)
+		}
+		config.MaintenanceLocation = loc
+	}
+
+	// Log level is one of debug, info, warn or error
+	if v := s.get("LOG_LEVEL"); v != "" {
+		if err := config.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("LOG_LEVEL"), err)
+		}
+	}
+	if v := s.get("LOG_FORMAT"); v != "" {
+		format, err := parseLogFormat(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("LOG_FORMAT"), err)
+		}
+		config.LogFormat = format
+	}
+
+	if v := s.get("RETRY_BACKOFF"); v != "" {
+		backoff, err := parseBackoff(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("RETRY_BACKOFF"), err)
+		}
+		config.RetryBackoff = backoff
+	}
+
+	if v := s.get("ACK_POLICY"); v != "" {
+		policy, err := parseAckPolicy(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("ACK_POLICY"), err)
+		}
+		config.AckPolicy = policy
+	}
+	s.setList(&config.AtMostOnceStreams, "AT_MOST_ONCE_STREAMS")
+	s.setList(&config.MessageDeadLetterStreams, "MESSAGE_DLQ_STREAMS")
+
+	// Streams are given as a comma separated list of stream[:group][=weight]
+	streams, err := parseStreams(s.get("STREAMS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", s.name("STREAMS"), err)
+	}
+	config.Streams = streams
+
+	s.setString(&config.StreamPattern, "STREAM_PATTERN")
+	s.setString(&config.TenantStreamPattern, "TENANT_STREAM_PATTERN")
+	if p := config.TenantStreamPattern; p != "" && strings.Count(p, "*") != 1 {
+		return nil, fmt.Errorf("invalid %s %q, must have one * standing for the tenant", s.name("TENANT_STREAM_PATTERN"), p)
+	}
+
+	// Cron jobs are given as a JSON array
+	cronJobs, err := parseCronJobs(s.get("CRON_JOBS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", s.name("CRON_JOBS"), err)
+	}
+	config.CronJobs = cronJobs
+
+	bools := []struct {
+		key string
+		dst *bool
+	}{
+		{"DLQ_ENABLED", &config.DeadLetterEnabled},
+		{"CLOUDEVENTS", &config.CloudEvents},
+		{"RECOVER_PENDING", &config.RecoverPending},
+		{"HTTP2_ENABLED", &config.HTTP2Enabled},
+		{"REDIS_TLS_ENABLED", &config.RedisTLSEnabled},
+		{"REDIS_DEDICATED_READERS", &config.RedisDedicatedReaders},
+		{"STATUS_BREAKER_PAUSE", &config.StatusBreakerPause},
+		{"STATUS_OUTBOX_ENABLED", &config.StatusOutboxEnabled},
+		{"STATUS_GRPC_TLS", &config.StatusGRPCTLS},
+		{"JOB_STORE_ENABLED", &config.JobStoreEnabled},
+		{"STRICT_PRIORITY", &config.StrictPriority},
+		{"PRIORITIES", &config.Priorities},
+		{"TRIM_UNPROCESSED", &config.TrimUnprocessed},
+		{"LEADER_ELECTION", &config.LeaderElection},
+		{"CHAOS_ENABLED", &config.Chaos},
+	}
+	for _, v := range bools {
+		if err := s.setBool(v.dst, v.key); err != nil {
+			return nil, err
+		}
+	}
+	s.setString(&config.DeadLetterStream, "DLQ_STREAM")
+	if config.DeadLetterStream != "" && config.consumesSeveral() {
+		return nil, fmt.Errorf("invalid %s %q, can't be shared by several streams, each of which uses <stream>:dlq", s.name("DLQ_STREAM"), config.DeadLetterStream)
+	}
+	s.setString(&config.DeadLetterAlertWebhook, "DLQ_ALERT_WEBHOOK")
+	s.setString(&config.SchemaDir, "SCHEMA_DIR")
+	s.setString(&config.EncryptionKeys, "ENCRYPTION_KEYS")
+	if config.EncryptionKeys != "" {
+		if _, err := producer.ParseKeyring(config.EncryptionKeys); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name("ENCRYPTION_KEYS"), err)
+		}
+	}
+	s.setString(&config.StatusOutboxKey, "STATUS_OUTBOX_KEY")
+	s.setString(&config.StatusBackend, "STATUS_BACKEND")
+	s.setString(&config.StatusKeyPrefix, "STATUS_KEY_PREFIX")
+	s.setString(&config.StatusStream, "STATUS_STREAM")
+	s.setString(&config.StatusPostgresDSN, "STATUS_POSTGRES_DSN")
+	s.setString(&config.StatusTable, "STATUS_TABLE")
+	s.setString(&config.StatusGRPCAddr, "STATUS_GRPC_ADDR")
+	s.setString(&config.ArchiveBackend, "ARCHIVE_BACKEND")
+	s.setString(&config.ArchivePostgresDSN, "ARCHIVE_POSTGRES_DSN")
+	s.setString(&config.ArchiveTable, "ARCHIVE_TABLE")
+	s.setString(&config.JobKeyPrefix, "JOB_KEY_PREFIX")
+	s.setString(&config.CronKey, "CRON_KEY")
+	s.setString(&config.LeaderKey, "LEADER_KEY")
+	s.setString(&config.DownstreamHealthURL, "DOWNSTREAM_HEALTH_URL")
+	if config.LeaderElection && config.LeaderLeaseTTL <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("LEADER_LEASE_TTL"), config.LeaderLeaseTTL)
+	}
+	if config.MessageMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid %s %v, must not be negative", s.name("MESSAGE_MAX_RETRIES"), config.MessageMaxRetries)
+	}
+	if config.MessageMaxRetryDelay <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("MESSAGE_MAX_RETRY_DELAY"), config.MessageMaxRetryDelay)
+	}
+	if config.MessageMaxTimeout <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("MESSAGE_MAX_TIMEOUT"), config.MessageMaxTimeout)
+	}
+	if config.MaxDecompressedSize <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("MAX_DECOMPRESSED_SIZE"), config.MaxDecompressedSize)
+	}
+	if config.ReadBlock < 0 {
+		return nil, fmt.Errorf("invalid %s %v, must not be negative", s.name("READ_BLOCK"), config.ReadBlock)
+	}
+	if config.ReadBlock == 0 && config.IdlePollDelay <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive when %s is 0", s.name("IDLE_POLL_DELAY"), config.IdlePollDelay, s.name("READ_BLOCK"))
+	}
+	if config.IdlePollMaxDelay < config.IdlePollDelay {
+		return nil, fmt.Errorf("invalid %s %v, must be at least %s", s.name("IDLE_POLL_MAX_DELAY"), config.IdlePollMaxDelay, s.name("IDLE_POLL_DELAY"))
+	}
+	if config.ReadErrorBackoff <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("READ_ERROR_BACKOFF"), config.ReadErrorBackoff)
+	}
+	if config.ReadErrorMaxBackoff < config.ReadErrorBackoff {
+		return nil, fmt.Errorf("invalid %s %v, must be at least %s", s.name("READ_ERROR_MAX_BACKOFF"), config.ReadErrorMaxBackoff, s.name("READ_ERROR_BACKOFF"))
+	}
+	if config.DownstreamCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("DOWNSTREAM_CHECK_INTERVAL"), config.DownstreamCheckInterval)
+	}
+	if config.ConcurrencySlotTTL <= 0 {
+		return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("CONCURRENCY_SLOT_TTL"), config.ConcurrencySlotTTL)
+	}
+	if config.ArchiveBackend != "" && config.ArchiveBackend != ArchiveBackendNone {
+		if config.ArchiveBatchSize <= 0 {
+			return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("ARCHIVE_BATCH_SIZE"), config.ArchiveBatchSize)
+		}
+		if config.ArchiveBatchInterval <= 0 {
+			return nil, fmt.Errorf("invalid %s %v, must be positive", s.name("ARCHIVE_BATCH_INTERVAL"), config.ArchiveBatchInterval)
+		}
+		if config.ArchiveQueueSize < config.ArchiveBatchSize {
+			return nil, fmt.Errorf("invalid %s %v, must be at least %s", s.name("ARCHIVE_QUEUE_SIZE"), config.ArchiveQueueSize, s.name("ARCHIVE_BATCH_SIZE"))
+		}
+	}
+
+	// Outbox is disabled unless a stream name is given
+	config.OutboxStream = s.get("OUTBOX_STREAM")
+
+	// Override string values that are set
+	s.setString(&config.StreamName, "STREAM_NAME")
+	s.setString(&config.GroupName, "GROUP_NAME")
+	s.setString(&config.ConsumerName, "CONSUMER_NAME")
+	s.setString(&config.GroupStartID, "GROUP_START_ID")
+	if !validStartID(config.GroupStartID) {
+		return nil, fmt.Errorf("invalid %s %q, must be 0, $ or an entry ID", s.name("GROUP_START_ID"), config.GroupStartID)
+	}
+	s.setString(&config.RedisURL, "REDIS_URL")
+	s.setString(&config.RedisHost, "REDIS_HOST")
+	s.setString(&config.RedisPort, "REDIS_PORT")
+	s.setList(&config.RedisSentinelAddrs, "REDIS_SENTINEL_ADDRS")
+	s.setString(&config.RedisMasterName, "REDIS_MASTER_NAME")
+	s.setList(&config.RedisClusterAddrs, "REDIS_CLUSTER_ADDRS")
+	s.setString(&config.RedisUsername, "REDIS_USERNAME")
+	s.setString(&config.RedisPassword, "REDIS_PASSWORD")
+	s.setString(&config.RedisTLSCertFile, "REDIS_TLS_CERT")
+	s.setString(&config.RedisTLSKeyFile, "REDIS_TLS_KEY")
+	s.setString(&config.RedisTLSCAFile, "REDIS_TLS_CA")
+	s.setString(&config.KeyPrefix, "KEY_PREFIX")
+	s.setString(&config.HTTPBearerToken, "HTTP_BEARER_TOKEN")
+	s.setString(&config.HTTPAPIKey, "HTTP_API_KEY")
+	s.setString(&config.HTTPAPIKeyHeader, "HTTP_API_KEY_HEADER")
+	s.setString(&config.HTTPBasicUser, "HTTP_BASIC_USER")
+	s.setString(&config.HTTPBasicPassword, "HTTP_BASIC_PASSWORD")
+	if config.HTTPBearerToken != "" && (config.HTTPBasicUser != "" || config.HTTPBasicPassword != "") {
+		return nil, fmt.Errorf("%s can't be combined with %s or %s, both use the Authorization header",
+			s.name("HTTP_BEARER_TOKEN"), s.name("HTTP_BASIC_USER"), s.name("HTTP_BASIC_PASSWORD"))
+	}
+	s.setString(&config.HTTPTLSCertFile, "HTTP_TLS_CERT")
+	s.setString(&config.HTTPTLSKeyFile, "HTTP_TLS_KEY")
+	s.setString(&config.HTTPTLSCAFile, "HTTP_TLS_CA")
+	s.setString(&config.HTTPSigningSecret, "HTTP_SIGNING_SECRET")
+	s.setString(&config.ApiURL, "API_URL")
+	s.setString(&config.HTTPAddr, "HTTP_ADDR")
+	s.setString(&config.AdminAddr, "ADMIN_ADDR")
+	s.setString(&config.AdminToken, "ADMIN_TOKEN")
+	if config.AdminAddr != "" && config.AdminToken == "" {
+		return nil, fmt.Errorf("ADMIN_TOKEN is required when ADMIN_ADDR is set")
+	}
+	if err := s.unknown(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// validStartID reports whether id is a position a consumer group can start at:
+// "$" or an entry ID, with or without its sequence number
+func validStartID(id string) bool {
+	if id == "$" {
+		return true
+	}
+	ms, seq, hasSeq := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	if hasSeq {
+		if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
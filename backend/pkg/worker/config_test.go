@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig loads a configuration from env alone, ignoring the
+// environment of the test process
+func loadTestConfig(env map[string]string) (*Config, error) {
+	return loadConfig(newSettings(env, func(string) string { return "" }))
+}
+
+func TestRedactedMasksSecrets(t *testing.T) {
+	config, err := loadTestConfig(map[string]string{
+		"REDIS_PASSWORD":      "redis-secret",
+		"ADMIN_ADDR":          ":9090",
+		"ADMIN_TOKEN":         "admin-secret",
+		"HTTP_BEARER_TOKEN":   "bearer-secret",
+		"HTTP_API_KEY":        "api-key-secret",
+		"HTTP_SIGNING_SECRET": "signing-secret",
+	})
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	basic := *config
+	basic.HTTPBearerToken = ""
+	basic.HTTPBasicUser = "user"
+	basic.HTTPBasicPassword = "basic-secret"
+
+	for _, c := range []*Config{config, &basic} {
+		logged := fmt.Sprintf("%+v", c.Redacted())
+		for _, secret := range []string{"redis-secret", "admin-secret", "bearer-secret", "api-key-secret", "basic-secret", "signing-secret"} {
+			if strings.Contains(logged, secret) {
+				t.Errorf("redacted configuration shows %s: %s", secret, logged)
+			}
+		}
+	}
+	if config.HTTPBearerToken != "bearer-secret" {
+		t.Errorf("Redacted changed the configuration")
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"", ""},
+		{"postgres://user:secret@db:5432/jobs?sslmode=disable", "postgres://user:xxxxx@db:5432/jobs?sslmode=disable"},
+		{"postgresql://user@db/jobs?password=secret", "postgresql://user@db/jobs?password=xxxxx"},
+		{"host=db user=user password=secret dbname=jobs", "*****"},
+	}
+	for _, tt := range tests {
+		if got := redactDSN(tt.dsn); got != tt.want {
+			t.Errorf("redactDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+
+	config := DefaultConfig()
+	config.StatusPostgresDSN = "postgres://user:secret@db/jobs"
+	config.ArchivePostgresDSN = "host=archive password=secret"
+	if logged := fmt.Sprintf("%+v", config.Redacted()); strings.Contains(logged, "secret") {
+		t.Errorf("redacted configuration shows a DSN password: %s", logged)
+	}
+}
+
+func TestConfigChangesRedactSecrets(t *testing.T) {
+	old := DefaultConfig()
+	old.HTTPAPIKey = "old-key"
+	new := *old
+	new.HTTPAPIKey = "new-key"
+
+	changes := configChanges(old, &new)
+	if len(changes) != 1 || changes[0].field != "HTTPAPIKey" {
+		t.Fatalf("changes %+v, want HTTPAPIKey", changes)
+	}
+	if changes[0].from != "*****" || changes[0].to != "*****" {
+		t.Errorf("change logged as %v -> %v, want masked", changes[0].from, changes[0].to)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "bearer token and basic user",
+			env:  map[string]string{"HTTP_BEARER_TOKEN": "t", "HTTP_BASIC_USER": "u"},
+			want: "HTTP_BEARER_TOKEN can't be combined",
+		},
+		{
+			name: "bearer token and basic password",
+			env:  map[string]string{"HTTP_BEARER_TOKEN": "t", "HTTP_BASIC_PASSWORD": "p"},
+			want: "HTTP_BEARER_TOKEN can't be combined",
+		},
+		{
+			name: "integer",
+			env:  map[string]string{"WORKER_COUNT": "many"},
+			want: "invalid WORKER_COUNT",
+		},
+		{
+			name: "boolean",
+			env:  map[string]string{"DLQ_ENABLED": "maybe"},
+			want: "invalid DLQ_ENABLED",
+		},
+		{
+			name: "duration",
+			env:  map[string]string{"READ_BLOCK": "5s"},
+			want: "invalid READ_BLOCK",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(tt.env)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	env := map[string]string{"STREAM_NAME": "from-env", "WORKER_COUNT": "3", "READ_BLOCK": "250"}
+	overrides := map[string]string{"STREAM_NAME": "from-flag", "DLQ_ENABLED": "false"}
+	config, err := loadConfig(newSettings(overrides, func(key string) string { return env[key] }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StreamName != "from-flag" {
+		t.Errorf("StreamName = %s, want the override", config.StreamName)
+	}
+	if config.WorkerCount != 3 || config.ReadBlock != 250*time.Millisecond {
+		t.Errorf("WorkerCount = %d, ReadBlock = %s, want those of the environment", config.WorkerCount, config.ReadBlock)
+	}
+	if config.DeadLetterEnabled {
+		t.Error("DeadLetterEnabled = true, want the override")
+	}
+}
@@ -0,0 +1,339 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// settings are where LoadConfig reads the configuration from: overrides such
+// as command-line flags, then environment variables, then the CONFIG_FILE
+// file. The file uses the names of the environment variables in lower case,
+// nested by any of their underscore separated prefixes, so that
+// redis.tls.enabled, redis_tls.enabled and redis_tls_enabled all set
+// REDIS_TLS_ENABLED.
+type settings struct {
+	overrides map[string]string
+	env       func(key string) string
+	file      string
+	values    map[string]string // file values by environment variable
+	fields    map[string]string // field names in the file by environment variable
+
+	// keys read, in order, and those read as booleans
+	keys  []string
+	used  map[string]bool
+	bools map[string]bool
+}
+
+// newSettings creates settings without a file
+func newSettings(overrides map[string]string, env func(key string) string) *settings {
+	return &settings{
+		overrides: overrides,
+		env:       env,
+		values:    map[string]string{},
+		fields:    map[string]string{},
+		used:      map[string]bool{},
+		bools:     map[string]bool{},
+	}
+}
+
+// loadSettings reads the file named by CONFIG_FILE, if set
+func loadSettings(overrides map[string]string) (*settings, error) {
+	s := newSettings(overrides, os.Getenv)
+	s.file = s.get("CONFIG_FILE")
+	if s.file == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CONFIG_FILE: %w", err)
+	}
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(s.file)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported CONFIG_FILE extension %q, must be .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", s.file, err)
+	}
+	if err := s.flatten("", "", tree); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", s.file, err)
+	}
+	return s, nil
+}
+
+// flatten stores the values of the file under the environment variables they
+// set. Lists of values are joined with commas, except for streams, whose items
+// may be tables, and cron jobs, which are kept as JSON.
+func (s *settings) flatten(key, field string, value any) error {
+	if tables, ok := value.([]map[string]any); ok {
+		items := make([]any, len(tables))
+		for i, table := range tables {
+			items[i] = table
+		}
+		value = items
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		for name, child := range value {
+			childKey := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+			childField := name
+			if key != "" {
+				childKey, childField = key+"_"+childKey, field+"."+name
+			}
+			if err := s.flatten(childKey, childField, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		if key == "CRON_JOBS" {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			return s.set(key, field, string(encoded))
+		}
+		items := make([]string, len(value))
+		for i, item := range value {
+			var err error
+			if key == "STREAMS" {
+				items[i], err = streamSpec(item)
+			} else {
+				items[i], err = scalar(item)
+			}
+			if err != nil {
+				return fmt.Errorf("%s[%d]: %w", field, i, err)
+			}
+		}
+		return s.set(key, field, strings.Join(items, ","))
+	default:
+		v, err := scalar(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		return s.set(key, field, v)
+	}
+}
+
+// set stores the value of a field, which no other field may set
+func (s *settings) set(key, field, value string) error {
+	if other, ok := s.fields[key]; ok {
+		return fmt.Errorf("%s and %s both set %s", other, field, key)
+	}
+	s.values[key], s.fields[key] = value, field
+	return nil
+}
+
+// scalar formats a single value of the file
+func scalar(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unexpected %T value", value)
+	}
+}
+
+// streamSpec formats an item of the streams list, either a STREAMS item such
+// as "emails:mailers=2" or a table with name, group and weight fields
+func streamSpec(item any) (string, error) {
+	table, ok := item.(map[string]any)
+	if !ok {
+		return scalar(item)
+	}
+	var name, group string
+	var weight int64
+	for field, value := range table {
+		var ok bool
+		switch field {
+		case "name":
+			name, ok = value.(string)
+		case "group":
+			group, ok = value.(string)
+		case "weight":
+			switch n := value.(type) {
+			case int:
+				weight, ok = int64(n), true
+			case int64:
+				weight, ok = n, true
+			}
+		default:
+			return "", fmt.Errorf("unknown field %s", field)
+		}
+		if !ok {
+			return "", fmt.Errorf("%s: unexpected %T value", field, value)
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	spec := name
+	if group != "" {
+		spec += ":" + group
+	}
+	if weight != 0 {
+		spec += "=" + strconv.FormatInt(weight, 10)
+	}
+	return spec, nil
+}
+
+// get returns the override of key, or else the value of the environment
+// variable key, or else of the field of the file setting it
+func (s *settings) get(key string) string {
+	if !s.used[key] {
+		s.keys = append(s.keys, key)
+		s.used[key] = true
+	}
+	if v := s.overrides[key]; v != "" {
+		return v
+	}
+	if v := s.env(key); v != "" {
+		return v
+	}
+	return s.values[key]
+}
+
+// fromFile reports whether the value of key comes from the file
+func (s *settings) fromFile(key string) bool {
+	_, ok := s.fields[key]
+	return ok && s.overrides[key] == "" && s.env(key) == ""
+}
+
+// name names the setting of key in errors: the field of the file when the
+// value comes from it, the environment variable otherwise
+func (s *settings) name(key string) string {
+	if s.fromFile(key) {
+		return fmt.Sprintf("%s in %s", s.fields[key], s.file)
+	}
+	return key
+}
+
+// unknown returns an error naming the fields of the file that don't match
+// any setting, to catch typos
+func (s *settings) unknown() error {
+	var fields []string
+	for key, field := range s.fields {
+		if !s.used[key] {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Strings(fields)
+	return fmt.Errorf("unknown field %s in %s", strings.Join(fields, ", "), s.file)
+}
+
+// setString overwrites dst with the value of key if it is set
+func (s *settings) setString(dst *string, key string) {
+	if v := s.get(key); v != "" {
+		*dst = v
+	}
+}
+
+// setList overwrites dst with the comma separated values of key if it is set,
+// ignoring empty items
+func (s *settings) setList(dst *[]string, key string) {
+	v := s.get(key)
+	if v == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+// setInt overwrites dst with the integer value of key if it is set
+func (s *settings) setInt(dst *int, key string) error {
+	v := s.get(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", s.name(key), err)
+	}
+	*dst = n
+	return nil
+}
+
+// setFloat overwrites dst with the decimal value of key if it is set
+func (s *settings) setFloat(dst *float64, key string) error {
+	v := s.get(key)
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", s.name(key), err)
+	}
+	*dst = f
+	return nil
+}
+
+// setDuration overwrites dst with the value of key, interpreted as
+// milliseconds, if it is set. The file may also give durations such as "30s".
+func (s *settings) setDuration(dst *time.Duration, key string) error {
+	v := s.get(key)
+	if v == "" {
+		return nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err == nil {
+		*dst = time.Duration(ms) * time.Millisecond
+		return nil
+	}
+	if s.fromFile(key) {
+		d, durationErr := time.ParseDuration(v)
+		if durationErr == nil {
+			*dst = d
+			return nil
+		}
+		err = durationErr
+	}
+	return fmt.Errorf("invalid %s: %w", s.name(key), err)
+}
+
+// setBool overwrites dst with the boolean value of key if it is set
+func (s *settings) setBool(dst *bool, key string) error {
+	s.bools[key] = true
+	v := s.get(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", s.name(key), err)
+	}
+	*dst = b
+	return nil
+}
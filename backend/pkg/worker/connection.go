@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisState is how well the worker reaches Redis, as seen by its reads
+type RedisState int
+
+const (
+	// RedisConnected is the state while reads succeed
+	RedisConnected RedisState = iota
+	// RedisDegraded is the state while reads fail but Redis answers PING,
+	// e.g. out of memory or missing the consumer group
+	RedisDegraded
+	// RedisDown is the state while Redis doesn't answer at all
+	RedisDown
+)
+
+// redisStates are the values of the state label of the Redis state gauge
+var redisStates = []RedisState{RedisConnected, RedisDegraded, RedisDown}
+
+// String returns the name of the state, as in logs and metrics
+func (s RedisState) String() string {
+	switch s {
+	case RedisDegraded:
+		return "degraded"
+	case RedisDown:
+		return "down"
+	default:
+		return "connected"
+	}
+}
+
+// connectionState tracks the state of Redis from the reads of every stream,
+// the worst state of any stream being that of the worker
+type connectionState struct {
+	logger Logger
+	gauge  *prometheus.GaugeVec
+
+	mu      sync.Mutex
+	state   RedisState
+	since   time.Time             // when state was entered
+	lastErr error                 // of the last failed read
+	failing map[string]RedisState // by stream whose last read failed
+}
+
+// newConnectionState creates the connection state of a worker, which starts
+// connected
+func newConnectionState(logger Logger, gauge *prometheus.GaugeVec) *connectionState {
+	c := &connectionState{
+		logger:  withFields(logger, "component", "redis"),
+		gauge:   gauge,
+		since:   time.Now(),
+		failing: map[string]RedisState{},
+	}
+	c.report()
+	return c
+}
+
+// get returns the state, since when it holds and the error of the last
+// failed read
+func (c *connectionState) get() (RedisState, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state, c.since, c.lastErr
+}
+
+// succeeded records a read of stream that succeeded
+func (c *connectionState) succeeded(stream string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.failing[stream]; !ok {
+		return
+	}
+	delete(c.failing, stream)
+	c.update()
+}
+
+// failed records a read of stream that failed with err, pinging Redis through
+// client to tell a degraded Redis from one that is down
+func (c *connectionState) failed(ctx context.Context, client redis.UniversalClient, stream string, err error) {
+	state := RedisDegraded
+	pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	if client.Ping(pingCtx).Err() != nil {
+		state = RedisDown
+	}
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+	c.failing[stream] = state
+	c.update()
+}
+
+// update sets the state to the worst state of the streams, logging and
+// reporting changes. c.mu must be held.
+func (c *connectionState) update() {
+	state := RedisConnected
+	for _, s := range c.failing {
+		state = max(state, s)
+	}
+	if state == c.state {
+		return
+	}
+
+	previous, since := c.state, c.since
+	c.state, c.since = state, time.Now()
+	switch {
+	case state == RedisConnected:
+		c.logger.Info("Redis reachable again, reads resumed", "outage", time.Since(since).Round(time.Millisecond))
+		c.lastErr = nil
+	case previous == RedisConnected:
+		c.logger.Error("Redis reads failing, backing off", "state", state, "error", c.lastErr)
+	default:
+		c.logger.Warn("Redis state changed", "state", state, "previous", previous, "error", c.lastErr)
+	}
+	c.report()
+}
+
+// report sets the Redis state gauge. c.mu must be held, or c not yet shared.
+func (c *connectionState) report() {
+	for _, s := range redisStates {
+		value := 0.0
+		if s == c.state {
+			value = 1
+		}
+		c.gauge.WithLabelValues(s.String()).Set(value)
+	}
+}
+
+// readBackoff returns how long to wait before reading again after the given
+// number of consecutive failed reads: ReadErrorBackoff doubled with every
+// failure up to ReadErrorMaxBackoff, the upper half jittered so that workers
+// don't all come back at once
+func (c *Config) readBackoff(failures int) time.Duration {
+	delay := c.ReadErrorBackoff
+	for i := 1; i < failures && delay < c.ReadErrorMaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, c.ReadErrorMaxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
+
+// RedisState returns the state of Redis as seen by the reads of the worker,
+// since when it holds, and the error of the last failed read while it isn't
+// connected
+func (w *Worker) RedisState() (RedisState, time.Time, error) {
+	return w.connection.get()
+}
@@ -0,0 +1,494 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// handlerTimeoutGrace is how long a handler may take to return once its
+// timeout expired before it is left running in the background
+const handlerTimeoutGrace = time.Second
+
+// delivery is a message handed from the reader to a consumer, attempt being
+// 1 for its first delivery
+type delivery struct {
+	message redis.XMessage
+	attempt int
+	readAt  time.Time
+}
+
+// consumer is one of the goroutines processing the messages fetched by a
+// Worker's reader
+type consumer struct {
+	groupMember
+	id         int
+	active     *atomic.Int64
+	deliveries <-chan delivery
+	partition  <-chan delivery // messages of the consumer's partition
+	inFlight   *sync.Map
+	scaler     *autoscaler // nil without autoscaling
+}
+
+// run processes deliveries until ctx is done or the reader stops, passing
+// handlerCtx to the handler. Deliveries not yet started at shutdown stay
+// pending in the group.
+func (c *consumer) run(ctx, handlerCtx context.Context) {
+	c.logger.Info("Starting worker")
+	c.active.Add(1)
+	c.metrics.activeWorkers.Inc()
+	defer func() {
+		c.active.Add(-1)
+		c.metrics.activeWorkers.Dec()
+		c.logger.Info("Worker shutting down")
+	}()
+
+	// Hold a slot while taking and processing a delivery when autoscaling
+	for c.scaler.acquire(ctx) {
+		d, ok := c.next(ctx)
+		if !ok {
+			c.scaler.release()
+			return
+		}
+		c.process(ctx, handlerCtx, d)
+		c.inFlight.Delete(d.message.ID)
+		c.scaler.release()
+	}
+}
+
+// next returns the next delivery of the consumer's partition or the shared
+// channel, or false once ctx is done or the reader stopped
+func (c *consumer) next(ctx context.Context) (delivery, bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return delivery{}, false
+		case d, ok := <-c.partition:
+			if !ok {
+				c.partition = nil
+				continue
+			}
+			return d, true
+		case d, ok := <-c.deliveries:
+			return d, ok
+		}
+	}
+}
+
+// processMessage handles a single delivery of a message from the stream,
+// running the handler registered for its type. ctx is passed to the handler.
+func (c *consumer) processMessage(ctx context.Context, d delivery) {
+	message, attempt := d.message, d.attempt
+
+	// Status updates only carry the span, not the handler context, so they
+	// still go through when handlers are cancelled
+	ctx, span := c.startMessageSpan(ctx, d)
+	defer span.End()
+	spanCtx := trace.ContextWithSpan(context.Background(), span)
+
+	// Take the job of an entry holding a CloudEvent from the event, leaving
+	// the entry as is for retries and the dead-letter stream
+	values := message.Values
+	if c.config.CloudEvents {
+		var err error
+		if values, err = decodeCloudEvent(values); err != nil {
+			c.logger.Error("Invalid message", "entry_id", message.ID, "error", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			c.deadLetter(message, attempt, err, false)
+			return
+		}
+	}
+
+	messageID, ok := values["id"].(string)
+	if !ok {
+		err := errors.New("invalid message ID format")
+		c.logger.Error("Invalid message", "entry_id", message.ID, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		// Retrying can't fix the message, so dead-letter it right away
+		c.deadLetter(message, attempt, err, false)
+		return
+	}
+
+	span.SetAttributes(attribute.String("messaging.redis.job_id", messageID))
+	messageType, _ := values[TypeField].(string)
+
+	// Carry the correlation ID of the producer to the handler, the logs and
+	// the status updates
+	metadata := messageMetadata(values)
+	ctx = withMetadata(ctx, metadata)
+	spanCtx = withMetadata(spanCtx, metadata)
+	if metadata.CorrelationID != "" {
+		span.SetAttributes(attribute.String("messaging.message.conversation_id", metadata.CorrelationID))
+	}
+	logger := withFields(c.logger, append([]any{"message_id", messageID, "entry_id", message.ID, "attempt", attempt},
+		metadata.logFields()...)...)
+	messageBody, err := c.decodeBody(ctx, values)
+	if err != nil && !errors.Is(err, ErrInvalidPayload) {
+		// The blob store is unavailable, read the message again later
+		logger.Warn("Failed to read the body, leaving the message pending", "error", err)
+		return
+	}
+	if err != nil {
+		logger.Error("Invalid message", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.deadLetter(message, attempt, err, false)
+		return
+	}
+	logger.Info("Processing message")
+	// Never log the plaintext of encrypted bodies
+	if _, encrypted := values[producer.FieldEncryption]; encrypted {
+		logger.Debug("Message body", "body_size", len(messageBody), "encrypted", true)
+	} else {
+		logger.Debug("Message body", "body", messageBody)
+	}
+
+	// Skip jobs already processed under the same idempotency key
+	if c.isDuplicate(message) {
+		c.skipDuplicate(spanCtx, message, messageID, attempt)
+		return
+	}
+
+	// Skip jobs cancelled before being read, registering the job first so
+	// that it can't be cancelled unnoticed in between
+	ctx, done := c.cancels.add(ctx, messageID)
+	defer done()
+	if c.isCancelled(messageID) {
+		c.skipCancelled(spanCtx, message, messageID, attempt, false)
+		return
+	}
+
+	// Wait for the rate limit of the type and for a slot to run it, if they
+	// are limited
+	route := c.router.lookup(messageType)
+	if route != nil {
+		release, ok := c.waitForTurn(ctx, route, messageType)
+		if !ok {
+			if errors.Is(context.Cause(ctx), ErrJobCancelled) {
+				c.skipCancelled(spanCtx, message, messageID, attempt, false)
+				return
+			}
+			logger.Warn("Message interrupted by shutdown while rate or concurrency limited, leaving it pending")
+			return
+		}
+		defer release()
+	}
+
+	// Skip jobs read after their expiry, for which it's too late
+	if deadline := expiresAt(values, message.ID, route); !deadline.IsZero() && time.Now().After(deadline) {
+		c.skipExpired(spanCtx, message, messageID, attempt, deadline)
+		return
+	}
+
+	// At-most-once delivery, the message won't be seen again whatever happens.
+	// One that couldn't be acknowledged stays pending to be tried again, and
+	// one another consumer took over is left to it.
+	if c.config.AckPolicy == AckBeforeProcessing {
+		c.config.chaosAckDelay()
+		if !c.acknowledgeNow(message.ID) {
+			logger.Warn("Message not acknowledged before processing, skipping it")
+			return
+		}
+	}
+
+	msg := Message{
+		ID:       messageID,
+		Type:     messageType,
+		EntryID:  message.ID,
+		Stream:   c.stream,
+		Consumer: c.name,
+		Body:     messageBody,
+		Values:   values,
+	}
+	policy := c.router.messagePolicy(route, values)
+	if route == nil {
+		err := fmt.Errorf("%w %q", ErrUnknownType, messageType)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.metrics.failed.Inc()
+		c.failed(spanCtx, msg, err, attempt)
+		c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+		return
+	}
+
+	// Compensation jobs of a saga run the compensation handler of their type
+	compensating := isCompensation(values)
+	if compensating {
+		if route.compensate == nil {
+			err := fmt.Errorf("%w %q", ErrNoCompensation, messageType)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			c.metrics.failed.Inc()
+			c.failed(spanCtx, msg, err, attempt)
+			c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+			return
+		}
+		compensation := *route
+		compensation.handler = route.compensate
+		route = &compensation
+		stepValues, err := c.compensationValues(spanCtx, values)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			c.metrics.failed.Inc()
+			c.failed(spanCtx, msg, err, attempt)
+			c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+			return
+		}
+		msg.Values = stepValues
+	}
+
+	// Reject bodies that don't match the schema of their type
+	if err := c.router.validate(messageType, route, messageBody); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.metrics.failed.Inc()
+		c.failed(spanCtx, msg, err, attempt)
+		c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+		return
+	}
+
+	// Update status to 'processing'
+	if err := c.updateStatus(spanCtx, StatusUpdate{ID: messageID, Status: "processing", Attempt: attempt}); err != nil {
+		logger.Warn("Failed to update status to processing", "error", err)
+		// Continue processing despite update failure
+	}
+
+	// Time spent in the stream before the first attempt, retries excluded
+	queued := queuedAt(values, message.ID)
+	metricType := c.router.metricType(messageType)
+	if attempt == 1 {
+		c.metrics.queueWait.WithLabelValues(metricType).Observe(max(time.Since(queued), 0).Seconds())
+	}
+
+	// Keep the message from being reclaimed while the handler runs
+	stopHeartbeat := c.startHeartbeat(message.ID)
+	progress := c.newProgressReporter(spanCtx, messageID, attempt)
+	msg.progress = progress
+	locks := c.newHandlerLocks(messageID)
+	msg.locks = locks
+	start := time.Now()
+	msg.started = start
+	result, err := c.runHandler(ctx, route, msg)
+	duration := time.Since(start)
+	progress.stop()
+	locks.stop()
+	stopHeartbeat()
+	c.metrics.duration.Observe(duration.Seconds())
+	c.scaler.observe(duration, err != nil && ctx.Err() == nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if errors.Is(err, ErrHandlerTimeout) {
+		c.metrics.timeouts.Inc()
+	}
+	if err != nil && errors.Is(context.Cause(ctx), ErrJobCancelled) {
+		c.skipCancelled(spanCtx, message, messageID, attempt, c.config.AckPolicy == AckBeforeProcessing)
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		// Cancelled at the end of the shutdown grace period, leave it pending for reclaim
+		if c.config.AckPolicy == AckBeforeProcessing {
+			logger.Warn("Message interrupted by shutdown after being acknowledged", "duration", duration, "error", err)
+			c.metrics.atMostOnceLost.Inc()
+		} else {
+			logger.Warn("Message interrupted by shutdown, leaving it pending", "duration", duration, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		c.metrics.failed.Inc()
+		logger.Warn("Failed to process message", "duration", duration, "error", err)
+		c.failed(spanCtx, msg, err, attempt)
+		c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+		return
+	}
+
+	// Build the jobs to run next before reporting the job, so that one that
+	// can't be enqueued fails it
+	result, next, err := c.nextEntries(spanCtx, msg, route, result)
+	if err != nil {
+		err = Fatal(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.metrics.failed.Inc()
+		logger.Error("Failed to enqueue the next jobs", "error", err)
+		c.failed(spanCtx, msg, err, attempt)
+		c.handleFailure(spanCtx, message, msg, attempt, policy, err)
+		return
+	}
+	// Build the completion event for the outbox stream too before any of the
+	// job's side effects, so that one that can't be built leaves it pending
+	// without having been reported, replied to or archived
+	if c.config.OutboxStream != "" {
+		event, err := c.outboxEntry(message.ID, messageID, result)
+		if err != nil {
+			logger.Error("Error building outbox event", "error", err)
+			return
+		}
+		next = append(next, event)
+	}
+	c.metrics.processed.Inc()
+	logger.Info("Processed message", "duration", duration)
+	c.succeeded(spanCtx, msg, result)
+
+	// Update status to 'completed' with result, or 'compensated' for the
+	// compensation of a step, when acknowledging the message if the status
+	// backend is in Redis
+	status := "completed"
+	if compensating {
+		status = "compensated"
+	}
+	update := StatusUpdate{ID: messageID, Status: status, Result: result, Attempt: attempt}
+	var statusInAck *StatusUpdate
+	if c.statusInAck(update) {
+		update = c.recordStatus(spanCtx, update)
+		statusInAck = &update
+	} else if err := c.updateStatus(spanCtx, update); err != nil {
+		logger.Warn("Failed to update status to "+status, "error", err)
+	}
+	c.reply(message, messageID, result, nil)
+	c.finishGroupJob(spanCtx, values, messageID, result, nil)
+	c.archive(msg, status, result, nil, attempt)
+
+	// Acknowledge the message, emitting the completion event and enqueueing
+	// the next jobs in the same transaction
+	switch {
+	case len(next) > 0 || statusInAck != nil:
+		c.acknowledgeWith(message.ID, next, statusInAck, c.config.AckPolicy == AckBeforeProcessing)
+	case c.config.AckPolicy != AckBeforeProcessing:
+		c.acknowledgeMessage(message.ID)
+	}
+	c.deleteBody(values)
+	c.metrics.endToEnd.WithLabelValues(metricType).Observe(max(time.Since(queued), 0).Seconds())
+}
+
+// runHandler runs the handler of a route, within its timeout if it has one.
+// A handler still running when the timeout expires fails with
+// ErrHandlerTimeout. If it doesn't return within handlerTimeoutGrace of its
+// context being cancelled it is left running in the background, so that it
+// doesn't block the consumer.
+func (c *consumer) runHandler(ctx context.Context, route *route, msg Message) (any, error) {
+	timeout := c.router.timeout(route, msg.Values)
+	if timeout <= 0 {
+		return c.callHandler(ctx, route.handler, msg)
+	}
+
+	handlerCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result any
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := c.callHandler(handlerCtx, route.handler, msg)
+		done <- outcome{result, err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-handlerCtx.Done():
+		if ctx.Err() != nil {
+			// Cancelled by shutdown, wait for the handler like without a timeout
+			o = <-done
+			break
+		}
+		select {
+		case o = <-done:
+		case <-time.After(handlerTimeoutGrace):
+			c.logger.Warn("Handler ignored its timeout, leaving it running in the background",
+				"message_id", msg.ID, "entry_id", msg.EntryID, "timeout", timeout)
+			return nil, fmt.Errorf("%w after %s", ErrHandlerTimeout, timeout)
+		}
+	}
+	if o.err != nil && ctx.Err() == nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, timeout, o.err)
+	}
+	return o.result, o.err
+}
+
+// callHandler calls handler, recovering from a panic to fail the attempt with
+// ErrHandlerPanic instead of crashing the process
+func (c *consumer) callHandler(ctx context.Context, handler Handler, msg Message) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.metrics.panics.Inc()
+			c.logger.Error("Handler panicked", "message_id", msg.ID, "entry_id", msg.EntryID,
+				"panic", p, "stack", string(debug.Stack()))
+			result, err = nil, fmt.Errorf("%w: %v", ErrHandlerPanic, p)
+		}
+	}()
+	if c.config.chaos(c.config.ChaosHandlerFailRate) {
+		return nil, fmt.Errorf("%w: simulated handler failure", ErrChaos)
+	}
+	return handler(ctx, msg)
+}
+
+// handleFailure leaves a failed message pending so it is retried after a
+// backoff, or gives up and dead-letters it once the policy's retries are
+// exhausted, the error is permanent or the ack policy doesn't allow retries.
+// Errors wrapping SkipRetry drop the message instead.
+func (c *consumer) handleFailure(ctx context.Context, message redis.XMessage, msg Message, attempt int, policy RetryPolicy, err error) {
+	messageID := msg.ID
+	if c.config.AckPolicy == AckBeforeProcessing {
+		c.metrics.atMostOnceLost.Inc()
+	}
+	if errors.Is(err, SkipRetry) {
+		c.dropMessage(ctx, message, msg, attempt, err)
+		return
+	}
+	if c.config.AckPolicy == AckOnSuccess && attempt <= policy.MaxRetries && !isPermanent(err) {
+		policy.after = retryDelay(err)
+		// Let the reader know when the message is due without reading it again
+		c.router.scheduleRetry(message.ID, policy)
+		c.logger.Info("Retrying message after backoff", "message_id", messageID, "entry_id", message.ID,
+			"attempt", attempt, "delay", policy.delay(message.ID, attempt))
+		if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "retrying", Result: err.Error(), Attempt: attempt}); err != nil {
+			c.logger.Warn("Failed to update status to retrying", "message_id", messageID, "error", err)
+		}
+		return
+	}
+
+	c.logger.Error("Giving up on message", "message_id", messageID, "entry_id", message.ID,
+		"attempts", attempt, "error", err)
+	c.releaseIdempotencyKey(message)
+	if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "failed", Result: err.Error(), Attempt: attempt}); err != nil {
+		c.logger.Warn("Failed to update status to failed", "message_id", messageID, "error", err)
+	}
+	c.reply(message, messageID, nil, err)
+	c.archive(msg, "failed", nil, err, attempt)
+	c.deadLetter(message, attempt, err, c.config.AckPolicy == AckBeforeProcessing)
+}
+
+// dropMessage gives up on a message whose handler returned SkipRetry,
+// acknowledging it without dead-lettering it and compensating its saga
+func (c *consumer) dropMessage(ctx context.Context, message redis.XMessage, msg Message, attempt int, err error) {
+	messageID := msg.ID
+	c.logger.Info("Dropping message without retrying it", "message_id", messageID, "entry_id", message.ID,
+		"attempts", attempt, "error", err)
+	c.releaseIdempotencyKey(message)
+	if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "failed", Result: err.Error(), Attempt: attempt}); err != nil {
+		c.logger.Warn("Failed to update status to failed", "message_id", messageID, "error", err)
+	}
+	c.reply(message, messageID, nil, err)
+	c.finishGroupJob(ctx, message.Values, messageID, nil, err)
+	c.archive(msg, "failed", nil, err, attempt)
+	c.acknowledgeCompensating(message, c.config.AckPolicy == AckBeforeProcessing)
+}
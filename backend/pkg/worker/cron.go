@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+const (
+	// cronCheckInterval is how often the cron scheduler looks for due jobs
+	cronCheckInterval = time.Second
+
+	// cronLockTTL is how long the lock claiming a job's tick is kept, which
+	// must exceed the clock skew between workers
+	cronLockTTL = time.Hour
+)
+
+// CronJob is a job added to the stream on a recurring schedule
+type CronJob struct {
+	Name string `json:"name"`           // unique name, part of the job IDs
+	Spec string `json:"spec"`           // 5 field cron expression or a descriptor such as @hourly
+	Type string `json:"type,omitempty"` // job type selecting the handler
+	Body string `json:"body,omitempty"` // body of the job
+
+	// Stream the job is added to, defaulting to the first stream the worker
+	// consumes
+	Stream string `json:"stream,omitempty"`
+}
+
+// cronKey returns the Redis hash of cron jobs, defaulting to "<stream>:cron"
+func (c *Config) cronKey() string {
+	if c.CronKey != "" {
+		return c.key(c.CronKey)
+	}
+	return c.key(c.StreamName + ":cron")
+}
+
+// parseCronJobs parses a JSON array of cron jobs such as
+// [{"name": "cleanup", "spec": "*/5 * * * *", "type": "cleanup"}]
+func parseCronJobs(s string) ([]CronJob, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var jobs []CronJob
+	if err := json.Unmarshal([]byte(s), &jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if err := job.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+// validate checks that the job has a name and a valid schedule
+func (j CronJob) validate() error {
+	if j.Name == "" {
+		return errors.New("cron job without a name")
+	}
+	if _, err := cron.ParseStandard(j.Spec); err != nil {
+		return fmt.Errorf("cron job %s: %w", j.Name, err)
+	}
+	return nil
+}
+
+// cronScheduler adds the jobs defined in CronJobs and in the cron hash to the
+// stream when their schedule fires. Every worker runs one, and a lock per job
+// and tick makes sure only one of them adds the job. With leader election only
+// the leader adds jobs, the others following the schedules to take over.
+type cronScheduler struct {
+	w         *Worker
+	config    *Config // of the first stream, whose cron hash is read
+	producer  *producer.Producer
+	schedules map[string]cron.Schedule // parsed specs, nil for invalid ones
+	invalid   map[string]bool          // hash values already reported as invalid
+	next      map[CronJob]time.Time    // next run of each job
+}
+
+// runCron runs the cron scheduler until ctx is done
+func (w *Worker) runCron(ctx context.Context) {
+	s := &cronScheduler{
+		w:         w,
+		config:    w.streamConfig(""),
+		producer:  producer.New(redisclient.Adapt(w.client)),
+		schedules: map[string]cron.Schedule{},
+		invalid:   map[string]bool{},
+		next:      map[CronJob]time.Time{},
+	}
+
+	ticker := time.NewTicker(cronCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.check(ctx, time.Now())
+	}
+}
+
+// check adds the jobs whose next run is due at now. Runs missed while no
+// worker was running are skipped.
+func (s *cronScheduler) check(ctx context.Context, now time.Time) {
+	next := make(map[CronJob]time.Time, len(s.next))
+	for _, job := range s.jobs(ctx) {
+		schedule := s.schedule(job)
+		if schedule == nil {
+			continue
+		}
+		fireAt, ok := s.next[job]
+		if !ok {
+			// New or changed job
+			fireAt = nextRun(schedule, now)
+		}
+		if !fireAt.After(now) {
+			s.fire(ctx, job, fireAt)
+			fireAt = nextRun(schedule, now)
+		}
+		next[job] = fireAt
+	}
+	s.next = next
+}
+
+// nextRun returns the first run of schedule after t. Fixed intervals such as
+// @every 5m are aligned to the Unix epoch rather than to when the worker
+// started, so all workers agree on their runs.
+func nextRun(schedule cron.Schedule, t time.Time) time.Time {
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		return t.Truncate(every.Delay).Add(every.Delay)
+	}
+	return schedule.Next(t)
+}
+
+// jobs returns the jobs from the configuration and the cron hash, the latter
+// taking precedence for jobs with the same name. The hash maps job names to
+// JSON objects like the ones in CRON_JOBS, and is read on every check so jobs
+// can be changed at runtime.
+func (s *cronScheduler) jobs(ctx context.Context) []CronJob {
+	jobs := map[string]CronJob{}
+	for _, job := range s.config.CronJobs {
+		jobs[job.Name] = job
+	}
+
+	key := s.config.cronKey()
+	defs, err := s.w.client.HGetAll(ctx, key).Result()
+	if err != nil && ctx.Err() == nil {
+		s.w.logger.Error("Error reading cron jobs", "key", key, "error", err)
+	}
+	for name, def := range defs {
+		var job CronJob
+		if err := json.Unmarshal([]byte(def), &job); err != nil {
+			if !s.invalid[def] {
+				s.invalid[def] = true
+				s.w.logger.Error("Invalid cron job definition", "key", key, "name", name, "error", err)
+			}
+			continue
+		}
+		job.Name = name
+		jobs[name] = job
+	}
+
+	list := make([]CronJob, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	return list
+}
+
+// schedule returns the parsed schedule of a job, or nil if its spec is invalid
+func (s *cronScheduler) schedule(job CronJob) cron.Schedule {
+	schedule, ok := s.schedules[job.Spec]
+	if !ok {
+		var err error
+		if schedule, err = cron.ParseStandard(job.Spec); err != nil {
+			s.w.logger.Error("Invalid cron spec", "name", job.Name, "spec", job.Spec, "error", err)
+		}
+		s.schedules[job.Spec] = schedule
+	}
+	return schedule
+}
+
+// fire adds the job for the tick at fireAt, unless another worker already did
+// or another worker leads
+func (s *cronScheduler) fire(ctx context.Context, job CronJob, fireAt time.Time) {
+	if !s.w.leader.leads() {
+		return
+	}
+	tick := strconv.FormatInt(fireAt.Unix(), 10)
+	lock := s.config.cronKey() + ":lock:" + job.Name + ":" + tick
+	stream := s.config.key(job.Stream)
+	if stream == "" {
+		stream = s.config.StreamName
+	}
+	acquired, err := s.w.client.SetNX(ctx, lock, stream, cronLockTTL).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			s.w.logger.Error("Error locking cron job", "name", job.Name, "error", err)
+		}
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	opts := []producer.Option{
+		producer.WithID("cron:" + job.Name + ":" + tick),
+		producer.WithMetadata("cron_job", job.Name),
+	}
+	if job.Type != "" {
+		opts = append(opts, producer.WithType(job.Type))
+	}
+	entryID, err := s.producer.Enqueue(ctx, stream, job.Body, opts...)
+	if err != nil {
+		s.w.logger.Error("Error adding cron job", "name", job.Name, "error", err)
+		return
+	}
+	s.w.logger.Info("Added cron job", "name", job.Name, "stream", stream, "entry_id", entryID, "scheduled_at", fireAt)
+}
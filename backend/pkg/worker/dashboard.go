@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+)
+
+// dashboardFailures is how many dead-lettered entries the dashboard shows
+const dashboardFailures = 20
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// overview is the state shown by the dashboard
+type overview struct {
+	*Overview
+	Stats Stats `json:"stats"`
+}
+
+// handleDashboard serves the dashboard page. The page itself is public; it
+// asks for the admin token and sends it with its requests to /admin/overview.
+func (w *Worker) handleDashboard(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	rw.Write(dashboardPage)
+}
+
+// handleAdminOverview returns the state shown by the dashboard
+func (w *Worker) handleAdminOverview(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	i, ok := w.adminInspector(rw, r)
+	if !ok {
+		return
+	}
+	o, err := i.Overview(ctx, dashboardFailures)
+	if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, overview{Overview: o, Stats: w.Stats()})
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// DeadLetterPrefix prefixes the metadata fields the worker adds to dead-lettered
+// entries, next to the original entry fields
+const DeadLetterPrefix = "dlq_"
+
+// deadLetterStream returns the dead-letter stream, defaulting to "<stream>:dlq"
+func (c *Config) deadLetterStream() string {
+	if c.DeadLetterStream != "" {
+		return c.key(c.DeadLetterStream)
+	}
+	return c.key(c.StreamName + ":dlq")
+}
+
+// messageDeadLetterStream reports whether a message may set stream, with
+// KeyPrefix, as its dead-letter stream: whether it is one of
+// MessageDeadLetterStreams
+func (c *Config) messageDeadLetterStream(stream string) bool {
+	return slices.ContainsFunc(c.MessageDeadLetterStreams, func(name string) bool {
+		return c.key(name) == stream
+	})
+}
+
+// deadLetter moves a message that can't be processed to its dead-letter
+// stream, see router.deadLetterStream, and acknowledges it on the main stream
+// with acknowledgeWith, so that a message another consumer reclaimed and
+// acknowledged meanwhile isn't dead-lettered twice. With the dead-letter
+// stream disabled the message is only acknowledged, dropping it. acked is
+// whether it was acknowledged before processing.
+func (c *consumer) deadLetter(message redis.XMessage, attempt int, cause error, acked bool) {
+	messageID, _ := message.Values[producer.FieldID].(string)
+	c.finishGroupJob(context.Background(), message.Values, messageID, nil, cause)
+
+	if !c.config.DeadLetterEnabled {
+		c.logger.Warn("Dropping message", "entry_id", message.ID, "error", cause)
+		c.acknowledgeCompensating(message, acked)
+		return
+	}
+
+	values := make(map[string]any, len(message.Values)+6)
+	for k, v := range message.Values {
+		values[k] = v
+	}
+	values[DeadLetterPrefix+"error"] = cause.Error()
+	values[DeadLetterPrefix+"attempts"] = attempt
+	values[DeadLetterPrefix+"original_id"] = message.ID
+	values[DeadLetterPrefix+"original_stream"] = c.stream
+	values[DeadLetterPrefix+"consumer"] = c.name
+	values[DeadLetterPrefix+"failed_at"] = time.Now().UnixMilli()
+
+	stream := c.router.deadLetterStream(message.Values)
+	entries := []*redis.XAddArgs{{Stream: stream, Values: values}}
+	// Undo the completed steps of its saga, in the same transaction
+	if compensation := c.startCompensation(message.Values); compensation != nil {
+		entries = append(entries, compensation)
+	}
+	// On errors the message stays pending and is dead-lettered again on its
+	// next delivery
+	if c.acknowledgeWith(message.ID, entries, nil, acked) {
+		c.logger.Warn("Moved message to dead-letter stream", "entry_id", message.ID, "dlq_stream", stream, "error", cause)
+	}
+}
+
+// StripDeadLetterFields returns the original fields of a dead-lettered entry
+// without the metadata added by the worker
+func StripDeadLetterFields(values map[string]any) map[string]any {
+	original := make(map[string]any, len(values))
+	for k, v := range values {
+		if !strings.HasPrefix(k, DeadLetterPrefix) {
+			original[k] = v
+		}
+	}
+	return original
+}
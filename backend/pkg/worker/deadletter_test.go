@@ -0,0 +1,124 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// streamLen returns the number of entries of a stream of the harness
+func streamLen(t *testing.T, h *workertest.Harness, stream string) int64 {
+	t.Helper()
+	n, err := h.Client.XLen(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("XLEN %s: %v", stream, err)
+	}
+	return n
+}
+
+// waitFor waits for cond to hold, failing the test after 5 seconds. Entries
+// added after a message acknowledged before processing can come after the
+// consumer group looks idle.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeadLetterRouting(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	h.Config.MessageDeadLetterStreams = []string{"billing:dlq"}
+	w := h.Worker()
+	w.Handle("broken", func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("default"))
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("allowed"), producer.WithDeadLetterStream("billing:dlq"))
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("denied"), producer.WithDeadLetterStream("other:dlq"))
+	h.WaitIdle(5 * time.Second)
+
+	var ids []any
+	for _, entry := range h.DeadLetters() {
+		ids = append(ids, entry.Values[producer.FieldID])
+		if entry.Values["dlq_error"] != "broken" || entry.Values["dlq_original_stream"] != h.Config.StreamName {
+			t.Errorf("dead letter %s has fields %v", entry.ID, entry.Values)
+		}
+	}
+	if len(ids) != 2 || ids[0] != "default" || ids[1] != "denied" {
+		t.Errorf("default dead-letter stream has jobs %v, want default and denied", ids)
+	}
+	if n := streamLen(t, h, "billing:dlq"); n != 1 {
+		t.Errorf("billing:dlq has %d entries, want 1", n)
+	}
+	if n := streamLen(t, h, "other:dlq"); n != 0 {
+		t.Errorf("other:dlq has %d entries, want none", n)
+	}
+}
+
+func TestDeadLetterSkipsAcknowledgedMessage(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	w := h.Worker()
+	w.Handle("reclaimed", func(ctx context.Context, msg worker.Message) (any, error) {
+		// Another consumer reclaimed the message and acknowledged it meanwhile
+		if _, err := h.Client.XAck(ctx, msg.Stream, h.Config.GroupName, msg.EntryID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("reclaimed"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	if dead := h.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got %d dead letters for a message acknowledged meanwhile, want none", len(dead))
+	}
+}
+
+func TestDeadLetterDisabled(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	h.Config.DeadLetterEnabled = false
+	w := h.Worker()
+	w.Handle("broken", func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	if dead := h.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got %d dead letters with the dead-letter stream disabled", len(dead))
+	}
+	if update, _ := h.Statuses.Last("1"); update.Status != "failed" {
+		t.Errorf("last status %s, want failed", update.Status)
+	}
+}
+
+func TestDeadLetterAckedBeforeProcessing(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.AckPolicy = worker.AckBeforeProcessing
+	w := h.Worker()
+	w.Handle("broken", func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("broken"), producer.WithID("1"))
+	waitFor(t, "the dead letter", func() bool { return len(h.DeadLetters()) == 1 })
+}
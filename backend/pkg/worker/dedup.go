@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// dedupTimeout bounds the Redis calls checking and releasing idempotency keys
+const dedupTimeout = 5 * time.Second
+
+// holdIdempotencyKey sets KEYS[1] to the entry ID ARGV[1] for ARGV[2]
+// milliseconds unless it is set to another entry's ID. It returns 1 if the
+// entry holds the key, a retry of the entry finding it already set, or 0 if
+// another entry with the same idempotency key was processed.
+var holdIdempotencyKey = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return 0
+end
+if not holder then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+end
+return 1
+`)
+
+// dropIdempotencyKey deletes KEYS[1] if it is held by the entry ARGV[1]
+var dropIdempotencyKey = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+// dedupKey returns the Redis key recording that a message with the
+// idempotency key was processed
+func (c *consumer) dedupKey(key string) string {
+	return c.stream + ":dedup:" + key
+}
+
+// isDuplicate reports whether another entry with the message's idempotency
+// key was processed within DedupWindow, recording that this one is processed
+// otherwise. Messages without a key are never duplicates, and neither are
+// those that can't be checked because Redis failed.
+func (c *consumer) isDuplicate(message redis.XMessage) bool {
+	key, _ := message.Values[producer.FieldIdempotencyKey].(string)
+	if c.config.DedupWindow <= 0 || key == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+
+	held, err := holdIdempotencyKey.Run(ctx, c.client, []string{c.dedupKey(key)},
+		message.ID, c.config.DedupWindow.Milliseconds()).Int()
+	if err != nil {
+		c.logger.Warn("Failed to check idempotency key, processing the message", "entry_id", message.ID,
+			"idempotency_key", key, "error", err)
+		return false
+	}
+	return held == 0
+}
+
+// releaseIdempotencyKey forgets that the message was processed once it was
+// given up on, so that a new job with the same idempotency key, or a replay
+// from the dead-letter stream, is processed
+func (c *consumer) releaseIdempotencyKey(message redis.XMessage) {
+	key, _ := message.Values[producer.FieldIdempotencyKey].(string)
+	if c.config.DedupWindow <= 0 || key == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+
+	if err := dropIdempotencyKey.Run(ctx, c.client, []string{c.dedupKey(key)}, message.ID).Err(); err != nil {
+		c.logger.Warn("Failed to release idempotency key", "entry_id", message.ID, "idempotency_key", key, "error", err)
+	}
+}
+
+// skipDuplicate acknowledges a duplicate message without processing it and
+// reports it with the duplicate status
+func (c *consumer) skipDuplicate(ctx context.Context, message redis.XMessage, messageID string, attempt int) {
+	c.metrics.duplicates.Inc()
+	c.logger.Info("Skipping duplicate message", "message_id", messageID, "entry_id", message.ID,
+		"idempotency_key", message.Values[producer.FieldIdempotencyKey])
+	if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "duplicate", Attempt: attempt}); err != nil {
+		c.logger.Warn("Failed to update status to duplicate", "message_id", messageID, "error", err)
+	}
+	if c.config.AckPolicy != AckBeforeProcessing {
+		c.acknowledgeMessage(message.ID)
+	}
+}
@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// streamDiscovery finds the streams matching a pattern to consume them as they
+// appear and stop once they are deleted
+type streamDiscovery struct {
+	pattern  string
+	interval time.Duration
+	logger   Logger
+
+	// accept reports whether a stream matching the pattern is to be consumed
+	accept func(stream string) bool
+
+	// start starts consuming a new stream, and returns what stops it
+	start func(stream string) (stop func())
+}
+
+// runDiscovery scans for the streams of d every interval until ctx is done,
+// creating the consumer group of new ones and starting them, and stopping the
+// streams it started that were deleted. Streams the worker already consumes
+// are left alone, and so are those removed with RemoveStream until they are
+// deleted. The streams the worker writes to, such as dead-letter streams, are
+// never consumed.
+func (w *Worker) runDiscovery(ctx context.Context, d streamDiscovery) {
+	started := map[string]bool{}
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		names, err := redisx.ScanStreams(ctx, w.client, d.pattern)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("Failed to scan for streams", "error", err)
+		}
+		if err == nil {
+			own := w.ownStreams()
+			found := map[string]bool{}
+			for _, stream := range names {
+				if own[stream] || derivedStream(stream) || !d.accept(stream) {
+					continue
+				}
+				found[stream] = true
+				if started[stream] || w.live.consumed(stream) {
+					continue
+				}
+				if err := CreateConsumerGroupAt(ctx, redisclient.Adapt(w.client), stream, w.config.GroupName, w.config.GroupStartID); err != nil {
+					d.logger.Error("Failed to create consumer group", "stream", stream, "error", err)
+					continue
+				}
+				d.logger.Info("Stream found", "stream", stream)
+				stop := d.start(stream)
+				if !w.live.add(stream, StreamConfig{Name: stream, Group: w.config.GroupName}, stop) {
+					// Shutting down, or added with AddStream meanwhile
+					stop()
+					continue
+				}
+				started[stream] = true
+			}
+			for stream := range started {
+				if found[stream] {
+					continue
+				}
+				delete(started, stream)
+				if stop, ok := w.live.remove(stream); ok {
+					d.logger.Info("Stream deleted, stopping its reader", "stream", stream)
+					stop()
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// derivedStream reports whether stream is named like one the worker derives
+// from the name of another to write to: a dead-letter stream,
+// "<stream>:dlq", a status stream, "<stream>:status", or a reply stream of
+// producer.Request, "<stream>:reply:<id>". They are recognised by name so that
+// they are still left alone once the stream they belong to is deleted, or
+// after a restart.
+func derivedStream(stream string) bool {
+	return strings.HasSuffix(stream, ":dlq") || strings.HasSuffix(stream, ":status") || producer.IsReplyStream(stream)
+}
+
+// ownStreams returns the streams the worker is configured to write to rather
+// than consume, whatever their names: DeadLetterStream, the dead-letter
+// streams of its handlers and those messages may set, and its status and
+// outbox streams
+func (w *Worker) ownStreams() map[string]bool {
+	own := map[string]bool{w.config.statusStream(): true}
+	if w.config.DeadLetterStream != "" {
+		own[w.config.key(w.config.DeadLetterStream)] = true
+	}
+	for _, sub := range w.streams() {
+		for _, r := range sub.handlers {
+			if r.deadLetterStream != "" {
+				own[w.config.key(r.deadLetterStream)] = true
+			}
+		}
+	}
+	for _, r := range w.handlers {
+		if r.deadLetterStream != "" {
+			own[w.config.key(r.deadLetterStream)] = true
+		}
+	}
+	for _, name := range w.config.MessageDeadLetterStreams {
+		own[w.config.key(name)] = true
+	}
+	if w.config.OutboxStream != "" {
+		own[w.config.key(w.config.OutboxStream)] = true
+	}
+	return own
+}
+
+// startPatternStreams starts the goroutine discovering the streams matching
+// StreamPattern, each consumed like a stream of Streams by its own reader and
+// consumers, which consume starts
+func (w *Worker) startPatternStreams(ctx context.Context, wg *sync.WaitGroup, consume func(stream string) (stop func())) {
+	pattern := w.config.key(w.config.StreamPattern)
+	logger := withFields(w.logger, "component", "discovery", "pattern", pattern)
+	logger.Info("Discovering streams", "interval", w.config.StreamDiscoveryInterval)
+	tenants := w.config.key(w.config.TenantStreamPattern)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runDiscovery(ctx, streamDiscovery{
+			pattern:  pattern,
+			interval: w.config.StreamDiscoveryInterval,
+			logger:   logger,
+			accept: func(stream string) bool {
+				// Tenant streams are left to the tenant pool
+				return tenants == "" || tenantName(tenants, stream) == ""
+			},
+			start: consume,
+		})
+	}()
+}
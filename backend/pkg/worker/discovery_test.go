@@ -0,0 +1,64 @@
+package worker_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+func TestDiscoveryExcludesOwnStreams(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.StreamName = "jobs:default"
+	h.Config.StreamPattern = "jobs:*"
+	h.Config.StreamDiscoveryInterval = 10 * time.Millisecond
+	h.Config.OutboxStream = "jobs:events"
+	h.Config.MessageDeadLetterStreams = []string{"jobs:billing-failures"}
+
+	// Left by an earlier run: the dead-letter stream of a stream deleted since,
+	// a reply stream of producer.Request, a status stream and the configured
+	// streams the worker writes to
+	streams := []string{
+		"jobs:gone:dlq",
+		producer.ReplyStream("jobs:orders", "42"),
+		"jobs:orders:status",
+		"jobs:events",
+		"jobs:billing-failures",
+		"jobs:orders",
+	}
+	for _, stream := range streams {
+		if _, err := h.Producer.Enqueue(context.Background(), stream, "{}", producer.WithType("record")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var consumed []string
+	w := h.Worker()
+	w.Handle("record", func(ctx context.Context, msg worker.Message) (any, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		consumed = append(consumed, msg.Stream)
+		return nil, nil
+	})
+	h.Run(w)
+
+	waitFor(t, "jobs:orders to be consumed", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Contains(consumed, "jobs:orders")
+	})
+	// Leave a few more scans to pick the others up
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(consumed) != 1 {
+		t.Errorf("consumed %v, want only jobs:orders", consumed)
+	}
+}
@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// deadLetterAlertTimeout bounds posting a dead-letter alert to the webhook
+const deadLetterAlertTimeout = 10 * time.Second
+
+// deadLetterAlertClient posts the dead-letter alerts
+var deadLetterAlertClient = &http.Client{Timeout: deadLetterAlertTimeout}
+
+// DeadLetterAlert is raised when the dead-letter stream of a stream reaches
+// DeadLetterAlertThreshold entries
+type DeadLetterAlert struct {
+	Stream           string  `json:"stream"`
+	DeadLetterStream string  `json:"dead_letter_stream"`
+	Length           int64   `json:"length"`
+	Threshold        int     `json:"threshold"`
+	GrowthRate       float64 `json:"growth_per_second"` // over the last check
+}
+
+// DeadLetterAlertHook is called when a dead-letter alert is raised
+type DeadLetterAlertHook func(ctx context.Context, alert DeadLetterAlert)
+
+// OnDeadLetterAlert adds a hook called when a dead-letter stream reaches
+// DeadLetterAlertThreshold entries, e.g. to page someone, along with the
+// DeadLetterAlertWebhook. It must be called before Run.
+func (w *Worker) OnDeadLetterAlert(hook DeadLetterAlertHook) {
+	w.hooks.deadLetterAlert = append(w.hooks.deadLetterAlert, hook)
+}
+
+// runDeadLetterMonitor measures the member's dead-letter stream every
+// DeadLetterCheckInterval for the metrics. On the leader if the workers elect
+// one, it also trims the stream to DeadLetterMaxLen entries and of those older
+// than DeadLetterMaxAge, and raises an alert when it reaches
+// DeadLetterAlertThreshold entries. The <dlq>:alerted key records the alert
+// until the stream shrinks below the threshold, so that it is raised once
+// however many workers check the stream.
+func (w *Worker) runDeadLetterMonitor(ctx context.Context, m groupMember) {
+	if !m.config.DeadLetterEnabled || m.config.DeadLetterCheckInterval <= 0 {
+		return
+	}
+	stream := m.config.deadLetterStream()
+	var (
+		last     int64 // length after the last check
+		lastTime time.Time
+	)
+	ticker := time.NewTicker(m.config.DeadLetterCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		length, err := m.client.XLen(ctx, stream).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				m.logger.Warn("Failed to measure the dead-letter stream", "dead_letter_stream", stream, "error", err)
+			}
+		} else {
+			now := time.Now()
+			growth := 0.0
+			if !lastTime.IsZero() {
+				growth = max(float64(length-last), 0) / now.Sub(lastTime).Seconds()
+			}
+			m.metrics.deadLetters.Set(float64(length))
+			m.metrics.deadLetterGrowth.Set(growth)
+
+			if w.leader.leads() {
+				w.alertDeadLetters(ctx, m, stream, length, growth)
+				length -= trimDeadLetters(ctx, m, stream)
+			}
+			last, lastTime = length, now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trimDeadLetters applies the retention of the dead-letter stream and returns
+// how many entries it removed
+func trimDeadLetters(ctx context.Context, m groupMember, stream string) int64 {
+	var removed int64
+	if m.config.DeadLetterMaxLen > 0 {
+		n, err := m.client.XTrimMaxLenApprox(ctx, stream, int64(m.config.DeadLetterMaxLen), 0).Result()
+		if err != nil && ctx.Err() == nil {
+			m.logger.Error("Error trimming the dead-letter stream", "dead_letter_stream", stream, "error", err)
+		}
+		removed += n
+	}
+	if m.config.DeadLetterMaxAge > 0 {
+		cutoff := fmt.Sprintf("%d-0", time.Now().Add(-m.config.DeadLetterMaxAge).UnixMilli())
+		n, err := m.client.XTrimMinIDApprox(ctx, stream, cutoff, 0).Result()
+		if err != nil && ctx.Err() == nil {
+			m.logger.Error("Error trimming the dead-letter stream", "dead_letter_stream", stream, "error", err)
+		}
+		removed += n
+	}
+	if removed > 0 {
+		m.logger.Info("Trimmed dead-letter stream", "dead_letter_stream", stream, "removed", removed)
+	}
+	return removed
+}
+
+// alertDeadLetters raises the alert of the dead-letter stream if it reached
+// the threshold and no worker raised it yet, or clears it once the stream is
+// back below
+func (w *Worker) alertDeadLetters(ctx context.Context, m groupMember, stream string, length int64, growth float64) {
+	threshold := m.config.DeadLetterAlertThreshold
+	if threshold <= 0 {
+		return
+	}
+	key := stream + ":alerted"
+	if length < int64(threshold) {
+		if err := m.client.Del(ctx, key).Err(); err != nil && ctx.Err() == nil {
+			m.logger.Warn("Failed to clear the dead-letter alert", "dead_letter_stream", stream, "error", err)
+		}
+		return
+	}
+	raised, err := m.client.SetNX(ctx, key, time.Now().UTC().Format(time.RFC3339), 0).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Warn("Failed to record the dead-letter alert", "dead_letter_stream", stream, "error", err)
+		}
+		return
+	}
+	if !raised {
+		return
+	}
+
+	alert := DeadLetterAlert{
+		Stream:           m.stream,
+		DeadLetterStream: stream,
+		Length:           length,
+		Threshold:        threshold,
+		GrowthRate:       growth,
+	}
+	m.logger.Warn("Dead-letter stream reached the alert threshold", "dead_letter_stream", stream,
+		"length", length, "threshold", threshold, "growth_per_second", growth)
+	for _, hook := range w.hooks.deadLetterAlert {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					m.logger.Error("Hook panicked", "panic", p, "stack", string(debug.Stack()))
+				}
+			}()
+			hook(ctx, alert)
+		}()
+	}
+	if m.config.DeadLetterAlertWebhook == "" {
+		return
+	}
+	if err := postDeadLetterAlert(ctx, m.config.DeadLetterAlertWebhook, alert); err != nil {
+		m.logger.Error("Failed to post the dead-letter alert, raising it again on the next check", "dead_letter_stream", stream, "error", err)
+		m.client.Del(ctx, key)
+	}
+}
+
+// postDeadLetterAlert posts alert to a webhook as JSON, with a text field for
+// Slack and compatible incoming webhooks
+func postDeadLetterAlert(ctx context.Context, url string, alert DeadLetterAlert) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+		DeadLetterAlert
+	}{
+		Text: fmt.Sprintf("Dead-letter stream %s of %s has %d entries, over the threshold of %d (%.2f new per second)",
+			alert.DeadLetterStream, alert.Stream, alert.Length, alert.Threshold, alert.GrowthRate),
+		DeadLetterAlert: alert,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadLetterAlertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := deadLetterAlertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
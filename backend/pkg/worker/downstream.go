@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// downstreamCheckTimeout bounds each run of a downstream check
+const downstreamCheckTimeout = 5 * time.Second
+
+// DownstreamCheck returns an error if a dependency of the handlers, such as an
+// API they call, is unhealthy. It may probe the dependency or report the state
+// of a circuit breaker around it.
+type DownstreamCheck func(ctx context.Context) error
+
+// downstreamCheck is a named DownstreamCheck
+type downstreamCheck struct {
+	name  string
+	check DownstreamCheck
+}
+
+// WithDownstreamCheck stops the worker from reading messages while check
+// fails, run every DownstreamCheckInterval, so that messages bound to fail
+// stay in the stream instead of churning through retries. name identifies the
+// check in logs and metrics.
+func WithDownstreamCheck(name string, check DownstreamCheck) Option {
+	return func(w *Worker) {
+		w.downstreamChecks = append(w.downstreamChecks, downstreamCheck{name: name, check: check})
+	}
+}
+
+// httpCheck returns a DownstreamCheck expecting a 2xx response to a GET of url
+func httpCheck(client *http.Client, url string) DownstreamCheck {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// checks returns the downstream checks of the worker: those added with
+// WithDownstreamCheck, and a probe of DownstreamHealthURL if it is set
+func (w *Worker) checks() []downstreamCheck {
+	checks := slices.Clone(w.downstreamChecks)
+	if w.config.DownstreamHealthURL != "" {
+		client := &http.Client{Timeout: downstreamCheckTimeout}
+		checks = append(checks, downstreamCheck{name: "http", check: httpCheck(client, w.config.DownstreamHealthURL)})
+	}
+	return checks
+}
+
+// downstreamGate runs the downstream checks of a worker, gating the reads of
+// every stream while one of them fails
+type downstreamGate struct {
+	w         *Worker
+	checks    []downstreamCheck
+	unhealthy *pauseState
+	logger    Logger
+	failing   map[string]bool // by check name
+}
+
+// newDownstreamGate creates the gate of the downstream checks of a worker
+func (w *Worker) newDownstreamGate(checks []downstreamCheck, unhealthy *pauseState) *downstreamGate {
+	return &downstreamGate{
+		w:         w,
+		checks:    checks,
+		unhealthy: unhealthy,
+		logger:    withFields(w.logger, "component", "downstream-checks"),
+		failing:   make(map[string]bool, len(checks)),
+	}
+}
+
+// check runs every check once, pausing or resuming reads with their outcome
+func (g *downstreamGate) check(ctx context.Context) {
+	for _, c := range g.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, downstreamCheckTimeout)
+		err := c.check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		healthy := 1.0
+		if err != nil {
+			healthy = 0
+			if !g.failing[c.name] {
+				g.logger.Warn("Downstream unhealthy", "check", c.name, "error", err)
+			}
+		} else if g.failing[c.name] {
+			g.logger.Info("Downstream healthy again", "check", c.name)
+		}
+		g.failing[c.name] = err != nil
+		g.w.metrics.downstreamHealthy.WithLabelValues(c.name).Set(healthy)
+	}
+
+	down := false
+	for _, failed := range g.failing {
+		down = down || failed
+	}
+	if g.unhealthy.set(down) {
+		if down {
+			g.logger.Warn("Pausing reads until downstream checks pass")
+		} else {
+			g.logger.Info("Downstream checks pass, resuming reads")
+		}
+	}
+}
+
+// run runs the checks every DownstreamCheckInterval until ctx is done
+func (g *downstreamGate) run(ctx context.Context) {
+	ticker := time.NewTicker(g.w.config.DownstreamCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		g.check(ctx)
+	}
+}
@@ -0,0 +1,130 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// keyring parses a keyring of 32 byte keys filled with the byte of each ID,
+// the first being the current one
+func keyring(t *testing.T, ids ...string) *producer.StaticKeyring {
+	t.Helper()
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		pairs[i] = id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32)))
+	}
+	keys, err := producer.ParseKeyring(strings.Join(pairs, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// runDecrypting runs a worker with keys sending the bodies of email jobs to
+// the returned channel
+func runDecrypting(h *workertest.Harness, keys producer.Keyring) <-chan string {
+	bodies := make(chan string, 1)
+	w := h.Worker(worker.WithKeyring(keys))
+	w.Handle("email", func(ctx context.Context, msg worker.Message) (any, error) {
+		bodies <- msg.Body
+		return nil, nil
+	})
+	h.Run(w)
+	return bodies
+}
+
+// receive returns the next body, failing the test after 5 seconds
+func receive(t *testing.T, bodies <-chan string) string {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the handler")
+		return ""
+	}
+}
+
+func TestEncryptedBody(t *testing.T) {
+	h := workertest.New(t)
+	keys := keyring(t, "a")
+	bodies := runDecrypting(h, keys)
+
+	entryID := h.Enqueue(`{"to":"a@example.com"}`, producer.WithType("email"), producer.WithID("1"), producer.WithEncryption(keys))
+	if body := receive(t, bodies); body != `{"to":"a@example.com"}` {
+		t.Errorf("handler got body %s", body)
+	}
+
+	entries, err := h.Client.XRange(context.Background(), h.Config.StreamName, entryID, entryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := entries[0].Values
+	if body, _ := values[producer.FieldBody].(string); strings.Contains(body, "example.com") {
+		t.Errorf("body stored in plaintext")
+	}
+	if values[producer.FieldEncryption] != producer.EncryptionAESGCM || values[producer.FieldKeyID] != "a" {
+		t.Errorf("got encryption fields %v and %v", values[producer.FieldEncryption], values[producer.FieldKeyID])
+	}
+}
+
+func TestEncryptedBodyRotatedKey(t *testing.T) {
+	h := workertest.New(t)
+	bodies := runDecrypting(h, keyring(t, "b", "a"))
+
+	h.Enqueue(`"old"`, producer.WithType("email"), producer.WithID("1"), producer.WithEncryption(keyring(t, "a")))
+	if body := receive(t, bodies); body != `"old"` {
+		t.Errorf("handler got body %s", body)
+	}
+}
+
+func TestEncryptedBodyRejected(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		keys  producer.Keyring
+		moved bool
+		want  string
+	}{
+		{name: "unknown key", keys: keyring(t, "b"), want: "unknown encryption key"},
+		{name: "moved to another job", keys: keyring(t, "a"), moved: true, want: "failed to decrypt body"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := workertest.New(t)
+			bodies := runDecrypting(h, tt.keys)
+
+			entry, err := h.Producer.Entry(context.Background(), h.Config.StreamName, `"secret"`,
+				producer.WithType("email"), producer.WithID("1"), producer.WithEncryption(keyring(t, "a")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.moved {
+				// The body is bound to job 1
+				entry.Values[producer.FieldID] = "2"
+			}
+			if _, err := h.Client.XAdd(context.Background(), entry.Stream, entry.Values); err != nil {
+				t.Fatal(err)
+			}
+			h.WaitIdle(5 * time.Second)
+
+			select {
+			case body := <-bodies:
+				t.Errorf("handler got body %s", body)
+			default:
+			}
+			dead := h.DeadLetters()
+			if len(dead) != 1 {
+				t.Fatalf("got %d dead letters, want 1", len(dead))
+			}
+			if cause, _ := dead[0].Values["dlq_error"].(string); !strings.Contains(cause, worker.ErrInvalidPayload.Error()) || !strings.Contains(cause, tt.want) {
+				t.Errorf("dead-lettered with %q, want an invalid payload: %s", cause, tt.want)
+			}
+		})
+	}
+}
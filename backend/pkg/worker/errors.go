@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"errors"
+	"time"
+)
+
+// SkipRetry is returned by handlers, or wrapped in the error they return, to
+// drop a message that failed without retrying or dead-lettering it. The job
+// is acknowledged and reported as failed.
+var SkipRetry = errors.New("skip retry")
+
+// fatalError fails a message without retrying it
+type fatalError struct {
+	err error
+}
+
+// Fatal marks err as one retrying can't fix, so that the message is
+// dead-lettered right away like one with an invalid payload
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err: err}
+}
+
+// Error implements error
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the marked error
+func (e *fatalError) Unwrap() error {
+	return e.err
+}
+
+// retryableError fails a message to retry it after a delay of its own
+type retryableError struct {
+	err   error
+	delay time.Duration
+}
+
+// Retryable marks err as one to retry after delay instead of the backoff of
+// the retry policy, e.g. when the failing service said when to come back. The
+// message is retried even if err wraps an error that is otherwise permanent,
+// as long as the policy has retries left. A zero delay keeps the backoff.
+func Retryable(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err, delay: delay}
+}
+
+// Error implements error
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the marked error
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// retryDelay returns the delay err asks to be retried after with Retryable,
+// zero if none
+func retryDelay(err error) time.Duration {
+	var r *retryableError
+	if errors.As(err, &r) {
+		return r.delay
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// ErrJobExpired is the error replied for jobs skipped because they were read
+// after their expiry
+var ErrJobExpired = errors.New("job expired")
+
+// WithTTL skips messages of the type read more than ttl after they were
+// enqueued, or due if scheduled, instead of running the handler. Messages
+// whose producer set an earlier expiry with producer.WithTTL or
+// producer.WithExpiresAt expire at that time instead.
+func WithTTL(ttl time.Duration) HandlerOption {
+	return func(r *route) {
+		r.ttl = ttl
+	}
+}
+
+// expiresAt returns when the message of an entry routed to r expires, or the
+// zero time if it doesn't
+func expiresAt(values map[string]any, entryID string, r *route) time.Time {
+	var deadline time.Time
+	if s, ok := values[producer.FieldExpiresAt].(string); ok {
+		deadline, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	if r != nil && r.ttl > 0 {
+		if t := queuedAt(values, entryID).Add(r.ttl); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// skipExpired acknowledges an expired job without processing it, compensating
+// its saga, and reports it with the expired status
+func (c *consumer) skipExpired(ctx context.Context, message redis.XMessage, messageID string, attempt int, deadline time.Time) {
+	c.metrics.expired.Inc()
+	c.logger.Info("Skipping expired message", "message_id", messageID, "entry_id", message.ID, "attempt", attempt,
+		"expired_for", time.Since(deadline))
+	c.releaseIdempotencyKey(message)
+	if err := c.updateStatus(ctx, StatusUpdate{ID: messageID, Status: "expired", Attempt: attempt}); err != nil {
+		c.logger.Warn("Failed to update status to expired", "message_id", messageID, "error", err)
+	}
+	c.reply(message, messageID, nil, ErrJobExpired)
+	c.finishGroupJob(ctx, message.Values, messageID, nil, ErrJobExpired)
+	c.acknowledgeCompensating(message, false)
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// failoverPollInterval is how often Redis is probed while waiting for a new master
+const failoverPollInterval = 250 * time.Millisecond
+
+// isFailoverError reports whether err is one of the transient errors Redis
+// returns while a replica is being promoted to master
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// The old master went away or is refusing connections
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	// The node we are connected to was demoted or is still loading its
+	// dataset, or the cluster is electing a new master for the slot
+	msg := err.Error()
+	return strings.HasPrefix(msg, "READONLY ") ||
+		strings.HasPrefix(msg, "LOADING ") ||
+		strings.HasPrefix(msg, "MASTERDOWN ") ||
+		strings.HasPrefix(msg, "CLUSTERDOWN ") ||
+		strings.HasPrefix(msg, "TRYAGAIN ") ||
+		strings.Contains(msg, "connection refused")
+}
+
+// waitForFailover polls Redis on a short interval until a writable master
+// answers again. It returns false if the grace window elapses or ctx is done,
+// in which case the caller should fall back to its regular error handling.
+func (m *groupMember) waitForFailover(ctx context.Context, cause error) bool {
+	if m.config.FailoverGrace <= 0 {
+		return false
+	}
+
+	m.logger.Warn("Redis failover detected, waiting for a new master", "error", cause, "grace", m.config.FailoverGrace)
+
+	deadline := time.Now().Add(m.config.FailoverGrace)
+	ticker := time.NewTicker(failoverPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		if time.Now().After(deadline) {
+			m.logger.Error("Redis failover grace window elapsed", "grace", m.config.FailoverGrace)
+			return false
+		}
+
+		// Pick up the new slot owner once the cluster has promoted a replica
+		if cluster, ok := m.client.(*redis.ClusterClient); ok {
+			cluster.ReloadState(ctx)
+		}
+
+		// PING also succeeds against a replica, so check the role of the node
+		// serving the stream
+		node, err := redisx.NodeForKey(ctx, m.client, m.stream)
+		if err != nil {
+			continue
+		}
+		role, err := node.Do(ctx, "ROLE").Slice()
+		if err != nil || len(role) == 0 || role[0] != "master" {
+			continue
+		}
+
+		m.logger.Info("Redis master is available again, resuming")
+		return true
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// groupTimeout bounds the Redis calls recording the outcome of a job of a group
+const groupTimeout = 5 * time.Second
+
+// finishGroupJob records the outcome ARGV[3] of the job ARGV[1] in the results
+// KEYS[2] of the group KEYS[1], counting it as ARGV[2], completed or failed,
+// unless it was already recorded. The last job of the group to finish adds the
+// callback to the stream KEYS[3], unless the group requires all of its jobs to
+// succeed and one failed, and gets the number of completed and failed jobs.
+// Others get nil.
+var finishGroupJob = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+if redis.call("HSETNX", KEYS[2], ARGV[1], ARGV[3]) == 0 then
+	return false
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+redis.call("HINCRBY", KEYS[1], ARGV[2], 1)
+if redis.call("HINCRBY", KEYS[1], "pending", -1) > 0 then
+	return false
+end
+
+local completed = tonumber(redis.call("HGET", KEYS[1], "completed"))
+local failed = tonumber(redis.call("HGET", KEYS[1], "failed"))
+local callback = redis.call("HGET", KEYS[1], "callback")
+if callback and not (failed > 0 and redis.call("HGET", KEYS[1], "require_all") == "1") then
+	local args = {}
+	for field, value in pairs(cjson.decode(callback)) do
+		table.insert(args, field)
+		table.insert(args, value)
+	end
+	redis.call("XADD", KEYS[3], "*", unpack(args))
+end
+return {completed, failed}
+`)
+
+// finishGroupJob records the outcome of a job belonging to a group, before it
+// is acknowledged so that a redelivery records it if the worker stops in
+// between, and reports the group once all of its jobs finished. handlerErr is
+// nil for jobs that completed.
+func (c *consumer) finishGroupJob(ctx context.Context, values map[string]any, messageID string, result any, handlerErr error) {
+	groupID, _ := values[producer.FieldGroup].(string)
+	if groupID == "" {
+		return
+	}
+	outcome := producer.GroupResult{Status: "completed"}
+	if handlerErr != nil {
+		outcome = producer.GroupResult{Status: "failed", Error: handlerErr.Error()}
+	} else if encoded, err := json.Marshal(result); err == nil {
+		outcome.Result = encoded
+	}
+	encoded, err := json.Marshal(outcome)
+	if err != nil {
+		c.logger.Warn("Failed to encode job group result", "message_id", messageID, "group", groupID, "error", err)
+		return
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, groupTimeout)
+	defer cancel()
+	key := c.config.key(producer.GroupKey(groupID))
+	callbackStream, err := c.client.HGet(queryCtx, key, "callback_stream").Result()
+	if err != nil && err != redis.Nil {
+		c.logger.Warn("Failed to record job group result", "message_id", messageID, "group", groupID, "error", err)
+		return
+	}
+	if callbackStream == "" {
+		callbackStream = c.stream
+	}
+	counts, err := finishGroupJob.Run(queryCtx, c.client,
+		[]string{key, c.config.key(producer.GroupResultsKey(groupID)), callbackStream},
+		messageID, outcome.Status, string(encoded)).Int64Slice()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		c.logger.Warn("Failed to record job group result", "message_id", messageID, "group", groupID, "error", err)
+		return
+	}
+
+	completed, failed := counts[0], counts[1]
+	status := "completed"
+	switch {
+	case completed == 0:
+		status = "failed"
+	case failed > 0:
+		status = "partial"
+	}
+	c.logger.Info("Job group finished", "group", groupID, "status", status, "completed", completed, "failed", failed)
+	summary := map[string]int64{"total": completed + failed, "completed": completed, "failed": failed}
+	if err := c.updateStatus(ctx, StatusUpdate{ID: groupID, Status: status, Result: summary}); err != nil {
+		c.logger.Warn("Failed to update job group status", "group", groupID, "error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// runImports runs a worker importing rows, failing the row "bad", and
+// counting the callbacks of the import groups
+func runImports(t *testing.T, h *workertest.Harness) *atomic.Int32 {
+	t.Helper()
+	h.Config.MaxRetries = 0
+	var callbacks atomic.Int32
+	w := h.Worker()
+	w.Handle("import", func(ctx context.Context, msg worker.Message) (any, error) {
+		if msg.Body == `"bad"` {
+			return nil, errors.New("bad row")
+		}
+		return msg.Body, nil
+	})
+	w.Handle("imported", func(ctx context.Context, msg worker.Message) (any, error) {
+		if msg.Body != `{"group":"g1"}` {
+			t.Errorf("callback got body %s", msg.Body)
+		}
+		callbacks.Add(1)
+		return nil, nil
+	})
+	h.Run(w)
+	return &callbacks
+}
+
+// enqueueImport enqueues the group g1 importing rows
+func enqueueImport(t *testing.T, h *workertest.Harness, requireAll bool, rows ...string) {
+	t.Helper()
+	g := producer.Group{ID: "g1", Callback: &producer.Step{Type: "imported"}, RequireAll: requireAll}
+	for _, row := range rows {
+		g.Jobs = append(g.Jobs, producer.GroupJob{Payload: row})
+	}
+	if _, err := h.Producer.EnqueueGroup(context.Background(), h.Config.StreamName, g, producer.WithType("import")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobGroupCallback(t *testing.T) {
+	h := workertest.New(t)
+	callbacks := runImports(t, h)
+
+	enqueueImport(t, h, false, `"a"`, `"bad"`, `"c"`)
+	waitFor(t, "the callback", func() bool { return callbacks.Load() == 1 })
+	h.WaitIdle(5 * time.Second)
+
+	status, err := h.Producer.GroupStatus(context.Background(), "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Total != 3 || status.Pending != 0 || status.Completed != 2 || status.Failed != 1 || len(status.Results) != 3 {
+		t.Errorf("got group status %+v, want 2 of 3 jobs completed and 1 failed", status)
+	}
+	if update, _ := h.Statuses.Last("g1"); update.Status != "partial" {
+		t.Errorf("last status of the group %s, want partial", update.Status)
+	}
+	if n := callbacks.Load(); n != 1 {
+		t.Errorf("callback ran %d times, want once", n)
+	}
+}
+
+func TestJobGroupRequireAll(t *testing.T) {
+	h := workertest.New(t)
+	callbacks := runImports(t, h)
+
+	enqueueImport(t, h, true, `"a"`, `"bad"`)
+	waitFor(t, "the group to finish", func() bool {
+		update, _ := h.Statuses.Last("g1")
+		return update.Status == "partial"
+	})
+	h.WaitIdle(5 * time.Second)
+
+	if n := callbacks.Load(); n != 0 {
+		t.Errorf("callback ran %d times with a failed job, want never", n)
+	}
+}
+
+func TestJobGroupCompleted(t *testing.T) {
+	h := workertest.New(t)
+	callbacks := runImports(t, h)
+
+	enqueueImport(t, h, true, `"a"`, `"b"`)
+	waitFor(t, "the callback", func() bool { return callbacks.Load() == 1 })
+
+	if update, _ := h.Statuses.Last("g1"); update.Status != "completed" {
+		t.Errorf("last status of the group %s, want completed", update.Status)
+	}
+}
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/statuspb"
+)
+
+// errStatusStreamClosed fails the updates waiting for an ack when the status
+// stream breaks
+var errStatusStreamClosed = errors.New("status stream closed")
+
+// GRPCStatusReporter pushes updates over a single StreamStatuses stream of
+// the StatusService defined in proto/status/v1/status.proto, instead of one
+// request per update. Updates are sent in order and each call waits for the
+// ack of its update. Acks with a code other than 200 fail like HTTP status
+// codes, those with a 4xx code not being retried. A broken stream fails the
+// updates waiting for their ack and is opened again by the next update.
+type GRPCStatusReporter struct {
+	client statuspb.StatusServiceClient
+	md     metadata.MD
+
+	sendMu sync.Mutex // serializes Send, which may block on flow control
+
+	mu      sync.Mutex
+	stream  statuspb.StatusService_StreamStatusesClient
+	cancel  context.CancelFunc
+	seq     uint64
+	pending map[uint64]chan *statuspb.StatusAck
+}
+
+// NewGRPCStatusReporter creates a reporter sending updates through conn,
+// with md as the metadata of the stream, for credentials
+func NewGRPCStatusReporter(conn grpc.ClientConnInterface, md metadata.MD) *GRPCStatusReporter {
+	return &GRPCStatusReporter{client: statuspb.NewStatusServiceClient(conn), md: md}
+}
+
+// dialStatusGRPC connects to the gRPC status service at StatusGRPCAddr, with
+// TLS and the credentials of the HTTP backend if StatusGRPCTLS is set
+func dialStatusGRPC(config *Config) (*GRPCStatusReporter, error) {
+	creds := insecure.NewCredentials()
+	if config.StatusGRPCTLS {
+		tlsConfig, err := config.httpTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(config.StatusGRPCAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the status service: %w", err)
+	}
+
+	md := metadata.MD{}
+	for name, values := range config.httpHeader() {
+		md.Append(name, values...)
+	}
+	return NewGRPCStatusReporter(conn, md), nil
+}
+
+// ReportStatus implements StatusReporter
+func (r *GRPCStatusReporter) ReportStatus(ctx context.Context, update StatusUpdate) error {
+	result, err := json.Marshal(update.Result)
+	if err != nil {
+		return fmt.Errorf("error marshaling status result: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	msg := &statuspb.StatusUpdate{
+		Id:         update.ID,
+		Status:     update.Status,
+		ResultJson: result,
+		Attempt:    int32(update.Attempt),
+
+		Progress:        int32(update.Progress),
+		ProgressMessage: update.ProgressMessage,
+
+		CorrelationId: update.CorrelationID,
+		TraceId:       update.TraceID,
+	}
+	acked, err := r.send(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("error updating status: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.pending, msg.Seq)
+		r.mu.Unlock()
+		return fmt.Errorf("error updating status: %w", ctx.Err())
+	case ack := <-acked:
+		switch {
+		case ack == nil:
+			return fmt.Errorf("error updating status: %w", errStatusStreamClosed)
+		case ack.Code != http.StatusOK:
+			return &statusCodeError{code: int(ack.Code)}
+		}
+		return nil
+	}
+}
+
+// send sends an update on the stream, opening it if needed, and returns the
+// channel its ack is delivered on, nil if the stream breaks first
+func (r *GRPCStatusReporter) send(ctx context.Context, update *statuspb.StatusUpdate) (<-chan *statuspb.StatusAck, error) {
+	r.mu.Lock()
+	if r.stream == nil {
+		// The stream outlives the update opening it
+		streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		if len(r.md) > 0 {
+			streamCtx = metadata.NewOutgoingContext(streamCtx, r.md)
+		}
+		stream, err := r.client.StreamStatuses(streamCtx)
+		if err != nil {
+			r.mu.Unlock()
+			cancel()
+			return nil, err
+		}
+		r.stream, r.cancel = stream, cancel
+		r.pending = make(map[uint64]chan *statuspb.StatusAck)
+		go r.receive(stream)
+	}
+
+	r.seq++
+	update.Seq = r.seq
+	acked := make(chan *statuspb.StatusAck, 1)
+	r.pending[update.Seq] = acked
+	stream := r.stream
+	r.mu.Unlock()
+
+	r.sendMu.Lock()
+	err := stream.Send(update)
+	r.sendMu.Unlock()
+	if err != nil {
+		r.mu.Lock()
+		r.reset(stream)
+		r.mu.Unlock()
+		return nil, err
+	}
+	return acked, nil
+}
+
+// receive delivers the acks of stream until it breaks
+func (r *GRPCStatusReporter) receive(stream statuspb.StatusService_StreamStatusesClient) {
+	for {
+		ack, err := stream.Recv()
+		r.mu.Lock()
+		if err != nil {
+			r.reset(stream)
+			r.mu.Unlock()
+			return
+		}
+		if acked, ok := r.pending[ack.Seq]; ok && r.stream == stream {
+			delete(r.pending, ack.Seq)
+			acked <- ack
+		}
+		r.mu.Unlock()
+	}
+}
+
+// reset drops stream if it is still the current one, failing the updates
+// waiting for their ack, r.mu being held
+func (r *GRPCStatusReporter) reset(stream statuspb.StatusService_StreamStatusesClient) {
+	if r.stream != stream {
+		return
+	}
+	r.cancel()
+	for _, acked := range r.pending {
+		close(acked)
+	}
+	r.stream, r.cancel, r.pending = nil, nil, nil
+}
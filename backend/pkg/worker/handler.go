@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Message is a stream entry handed to a Handler
+type Message struct {
+	ID       string         // job ID from the entry's id field or CloudEvent, used for status updates
+	Type     string         // job type from the entry's type field or CloudEvent, if any
+	EntryID  string         // Redis stream entry ID
+	Stream   string         // stream the entry was read from
+	Consumer string         // consumer name that received the entry
+	Body     string         // entry's body field, or the data of its CloudEvent
+	Values   map[string]any // all entry fields
+
+	progress *progressReporter // nil outside of the worker
+	locks    *handlerLocks     // nil outside of the worker
+	started  time.Time         // when the handler was called, zero before
+}
+
+// Handler processes a message. The returned result is sent with the completed
+// status update; a non-nil error marks the message as failed.
+type Handler func(ctx context.Context, msg Message) (any, error)
+
+// SimulatedHandler returns a handler that sleeps for d and returns a result
+// string, which is what the worker does when no handler is registered
+func SimulatedHandler(d time.Duration) Handler {
+	return func(ctx context.Context, msg Message) (any, error) {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return fmt.Sprintf("Processed result for message %s by %s", msg.Body, msg.Consumer), nil
+	}
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// readinessTimeout bounds the Redis checks made by the readiness probe
+const readinessTimeout = 2 * time.Second
+
+// handleHealthz reports that the process is up
+func (w *Worker) handleHealthz(rw http.ResponseWriter, r *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintln(rw, "ok")
+}
+
+// handleReadyz reports whether the worker can process messages: Redis is
+// reachable, the consumer groups exist and consumers are running. A worker
+// whose reads fail while Redis answers is ready but reported as degraded.
+func (w *Worker) handleReadyz(rw http.ResponseWriter, r *http.Request) {
+	if err := w.Ready(r.Context()); err != nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(rw, "not ready: %v\n", err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	if state, since, err := w.connection.get(); state == RedisDegraded {
+		fmt.Fprintf(rw, "degraded since %s: %v\n", since.Format(time.RFC3339), err)
+		return
+	}
+	fmt.Fprintln(rw, "ok")
+}
+
+// Ready returns nil if Redis is reachable, the consumer groups exist and at
+// least one consumer is running, or an error describing the failed check.
+// Redis counts as unreachable while the reads find it down, even if it answers
+// the PING of the check.
+func (w *Worker) Ready(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	if err := w.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis unreachable: %w", err)
+	}
+	if state, since, err := w.connection.get(); state == RedisDown {
+		return fmt.Errorf("redis down since %s: %w", since.Format(time.RFC3339), err)
+	}
+
+	for _, sub := range w.streams() {
+		stream, group := w.config.key(sub.stream.Name), sub.stream.Group
+		exists, err := groupExists(ctx, w.client, stream, group)
+		if err != nil {
+			return fmt.Errorf("error checking consumer group: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("consumer group %s does not exist on %s", group, stream)
+		}
+	}
+
+	if w.active.Load() == 0 {
+		return fmt.Errorf("no workers running")
+	}
+	return nil
+}
+
+// groupExists reports whether the consumer group exists on the stream
+func groupExists(ctx context.Context, client redis.UniversalClient, stream, group string) (bool, error) {
+	info, err := groupInfo(ctx, client, stream, group)
+	return info != nil, err
+}
+
+// groupInfo returns the fields XINFO GROUPS reports for the consumer group, or
+// nil if it doesn't exist
+func groupInfo(ctx context.Context, client redis.UniversalClient, stream, group string) (map[string]any, error) {
+	groups, err := redisx.XInfo(ctx, client, "GROUPS", stream)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range groups {
+		if info["name"] == group {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// heartbeatTimeout bounds each call extending the lease of an entry
+const heartbeatTimeout = 5 * time.Second
+
+// extendLease resets the idle time of the entry ARGV[3] of the group ARGV[1]
+// on the stream KEYS[1] if it is still pending on the consumer ARGV[2], without
+// bumping its delivery count. It returns 1 if the lease was extended and 0 if
+// the entry was acknowledged or another consumer claimed it.
+var extendLease = redis.NewScript(`
+local pending = redis.call("XPENDING", KEYS[1], ARGV[1], ARGV[3], ARGV[3], 1, ARGV[2])
+if #pending == 0 then
+	return 0
+end
+redis.call("XCLAIM", KEYS[1], ARGV[1], ARGV[2], 0, ARGV[3], "JUSTID")
+return 1
+`)
+
+// startHeartbeat extends the lease of a pending entry every HeartbeatInterval
+// while its handler runs, so that the claimers of other workers don't take it
+// over from a long running handler. The returned function stops it.
+func (c *consumer) startHeartbeat(entryID string) func() {
+	if c.config.HeartbeatInterval <= 0 || c.config.AckPolicy == AckBeforeProcessing {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.config.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+			extended, err := extendLease.Run(ctx, c.client, []string{c.stream}, c.group, c.name, entryID).Int()
+			cancel()
+			switch {
+			case err != nil:
+				c.logger.Warn("Failed to extend the lease of the message", "entry_id", entryID, "error", err)
+			case extended == 0:
+				c.logger.Warn("Message is no longer pending on this consumer, stopping its heartbeat", "entry_id", entryID)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// FailureHook is called after every failed attempt at processing a message,
+// attempt being 1 for its first delivery
+type FailureHook func(ctx context.Context, msg Message, err error, attempt int)
+
+// SuccessHook is called after a message was processed, with the result of its
+// handler
+type SuccessHook func(ctx context.Context, msg Message, result any)
+
+// hooks holds the hooks added with OnFailure, OnSuccess and OnDeadLetterAlert
+type hooks struct {
+	failure         []FailureHook
+	success         []SuccessHook
+	deadLetterAlert []DeadLetterAlertHook
+}
+
+// OnFailure adds a hook called whenever a handler fails, e.g. to report the
+// error to an error tracker or run a compensating action, before the message
+// is retried or dead-lettered. Attempts interrupted by shutdown or
+// cancellation aren't failures. It must be called before Run.
+func (w *Worker) OnFailure(hook FailureHook) {
+	w.hooks.failure = append(w.hooks.failure, hook)
+}
+
+// OnSuccess adds a hook called whenever a handler succeeds, before the
+// message is acknowledged. It must be called before Run.
+func (w *Worker) OnSuccess(hook SuccessHook) {
+	w.hooks.success = append(w.hooks.success, hook)
+}
+
+// failed calls the failure hooks. Hooks run on the consumer, which waits for
+// them, and a hook that panics is logged and skipped.
+func (c *consumer) failed(ctx context.Context, msg Message, err error, attempt int) {
+	for _, hook := range c.hooks.failure {
+		c.callHook(msg, func() { hook(ctx, msg, err, attempt) })
+	}
+}
+
+// succeeded calls the success hooks, like failed
+func (c *consumer) succeeded(ctx context.Context, msg Message, result any) {
+	for _, hook := range c.hooks.success {
+		c.callHook(msg, func() { hook(ctx, msg, result) })
+	}
+}
+
+// callHook calls a hook, recovering from a panic
+func (c *consumer) callHook(msg Message, call func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.logger.Error("Hook panicked", "message_id", msg.ID, "entry_id", msg.EntryID,
+				"panic", p, "stack", string(debug.Stack()))
+		}
+	}()
+	call()
+}
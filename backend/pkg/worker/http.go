@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpShutdownTimeout is how long the embedded HTTP server may take to shut down
+const httpShutdownTimeout = 5 * time.Second
+
+// HTTPHandler returns the handler serving the worker's HTTP endpoints, for
+// embedders that mount it on their own server instead of setting HTTPAddr
+func (w *Worker) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(w.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", w.handleHealthz)
+	mux.HandleFunc("/readyz", w.handleReadyz)
+	mux.HandleFunc("/scaling", w.handleScaling)
+	return mux
+}
+
+// serveHTTP runs an embedded HTTP server serving handler on addr until ctx is
+// done, name describing it in logs
+func (w *Worker) serveHTTP(ctx context.Context, addr string, handler http.Handler, name string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			w.logger.Error("Error shutting down HTTP server", "server", name, "error", err)
+		}
+	}()
+
+	w.logger.Info("Serving "+name, "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		w.logger.Error("HTTP server stopped", "server", name, "error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultHTTPClient is shared by the HTTP status reporters created without a
+// client, so that they keep their connections alive
+var defaultHTTPClient = &http.Client{Timeout: statusTimeout}
+
+// newHTTPClient creates the client shared by all requests to the status API,
+// pooling its connections with the transport settings of config
+func newHTTPClient(config *Config) (*http.Client, error) {
+	tlsConfig, err := config.httpTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.HTTPDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     config.HTTP2Enabled,
+		MaxIdleConns:          config.HTTPMaxIdleConns,
+		MaxIdleConnsPerHost:   config.HTTPMaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.HTTPMaxConnsPerHost,
+		IdleConnTimeout:       config.HTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if !config.HTTP2Enabled {
+		// A non-nil empty map disables HTTP/2 over TLS
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{Transport: transport, Timeout: config.HTTPTimeout}, nil
+}
+
+// httpTLSConfig builds the TLS configuration of the status API client, or
+// returns nil to use the defaults when no certificate or CA is configured
+func (c *Config) httpTLSConfig() (*tls.Config, error) {
+	if c.HTTPTLSCertFile == "" && c.HTTPTLSKeyFile == "" && c.HTTPTLSCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	// Client certificate for APIs that require mutual TLS
+	if c.HTTPTLSCertFile != "" || c.HTTPTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.HTTPTLSCertFile, c.HTTPTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading API client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Custom CA, otherwise the system roots are used
+	if c.HTTPTLSCAFile != "" {
+		pem, err := os.ReadFile(c.HTTPTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading API CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in API CA file %s", c.HTTPTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// httpHeader returns the headers authenticating requests to the status API
+func (c *Config) httpHeader() http.Header {
+	header := http.Header{}
+	if c.HTTPBearerToken != "" {
+		header.Set("Authorization", "Bearer "+c.HTTPBearerToken)
+	}
+	if c.HTTPAPIKey != "" {
+		name := c.HTTPAPIKeyHeader
+		if name == "" {
+			name = "X-API-Key"
+		}
+		header.Set(name, c.HTTPAPIKey)
+	}
+	return header
+}
+
+// Headers of signed requests to the status API
+const (
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// signRequest signs a request whose body is body with secret. The
+// X-Signature-Timestamp header is the Unix time in seconds, and X-Signature
+// is "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and
+// the body. Receivers recompute it and reject old timestamps to stop replays.
+func signRequest(req *http.Request, secret, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,313 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// ErrEntryNotFound is returned when an operation names a missing stream entry
+var ErrEntryNotFound = errors.New("entry not found")
+
+// Inspector queries and repairs the stream, consumer group and dead-letter
+// stream of a configuration without processing messages. It backs the admin
+// API and streamctl.
+type Inspector struct {
+	client redis.UniversalClient
+	config *Config
+}
+
+// NewInspector creates an Inspector for the stream and group of config, the
+// stream name getting KeyPrefix. Like New, it takes a client from
+// NewRedisClient or redisclient.Adapt.
+func NewInspector(client redisclient.Client, config *Config) *Inspector {
+	return newInspector(redisx.Universal(client), config)
+}
+
+// newInspector implements NewInspector
+func newInspector(client redis.UniversalClient, config *Config) *Inspector {
+	if stream := config.key(config.StreamName); stream != config.StreamName {
+		prefixed := *config
+		prefixed.StreamName = stream
+		config = &prefixed
+	}
+	return &Inspector{client: client, config: config}
+}
+
+// Entry is a stream entry
+type Entry struct {
+	ID     string         `json:"id"`
+	Values map[string]any `json:"values"`
+}
+
+// PendingSummary is the state of the group's pending entries list
+type PendingSummary struct {
+	Count     int64            `json:"count"`
+	Lower     string           `json:"lower"`     // smallest pending entry ID
+	Higher    string           `json:"higher"`    // largest pending entry ID
+	Consumers map[string]int64 `json:"consumers"` // pending entries per consumer
+}
+
+// PendingEntry is an entry of the group's pending entries list
+type PendingEntry struct {
+	ID         string `json:"id"`
+	Consumer   string `json:"consumer"`
+	IdleMillis int64  `json:"idle_ms"`
+	Deliveries int64  `json:"deliveries"`
+}
+
+// ConsumerInfo describes a consumer of the group
+type ConsumerInfo struct {
+	Name       string `json:"name"`
+	Pending    int64  `json:"pending"`
+	IdleMillis int64  `json:"idle_ms"`
+}
+
+// Overview is the state of the stream, its consumer group and its dead-letter
+// stream
+type Overview struct {
+	Stream          string         `json:"stream"`
+	Group           string         `json:"group"`
+	Time            time.Time      `json:"time"`
+	Length          int64          `json:"length"`
+	Lag             *int64         `json:"lag"` // nil when Redis can't tell, before Redis 7 or after deletions
+	LastDeliveredID string         `json:"last_delivered_id"`
+	Pending         int64          `json:"pending"`
+	Paused          bool           `json:"paused"`
+	Consumers       []ConsumerInfo `json:"consumers"`
+	DeadLetters     int64          `json:"dead_letters"`
+	RecentFailures  []Entry        `json:"recent_failures"` // newest dead-lettered entries first
+}
+
+// Overview returns the state of the queue, including up to failures of the
+// most recent dead-lettered entries
+func (i *Inspector) Overview(ctx context.Context, failures int64) (*Overview, error) {
+	stream, group := i.config.StreamName, i.config.GroupName
+	o := &Overview{
+		Stream:         stream,
+		Group:          group,
+		Time:           time.Now(),
+		Consumers:      []ConsumerInfo{},
+		RecentFailures: []Entry{},
+	}
+
+	var err error
+	if o.Length, err = i.client.XLen(ctx, stream).Result(); err != nil {
+		return nil, err
+	}
+
+	if o.Paused, err = i.Paused(ctx); err != nil {
+		return nil, err
+	}
+
+	info, err := groupInfo(ctx, i.client, stream, group)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		if lag, ok := info["lag"].(int64); ok {
+			o.Lag = &lag
+		}
+		o.LastDeliveredID, _ = info["last-delivered-id"].(string)
+		o.Pending, _ = info["pending"].(int64)
+
+		consumers, err := redisx.XInfo(ctx, i.client, "CONSUMERS", stream, group)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range consumers {
+			name, _ := c["name"].(string)
+			pending, _ := c["pending"].(int64)
+			idle, _ := c["idle"].(int64)
+			o.Consumers = append(o.Consumers, ConsumerInfo{Name: name, Pending: pending, IdleMillis: idle})
+		}
+	}
+
+	if i.config.DeadLetterEnabled {
+		deadLetters := i.config.deadLetterStream()
+		if o.DeadLetters, err = i.client.XLen(ctx, deadLetters).Result(); err != nil {
+			return nil, err
+		}
+		messages, err := i.client.XRevRangeN(ctx, deadLetters, "+", "-", failures).Result()
+		if err != nil {
+			return nil, err
+		}
+		o.RecentFailures = entries(messages)
+	}
+
+	return o, nil
+}
+
+// Pending returns the number of pending entries per consumer
+func (i *Inspector) Pending(ctx context.Context) (*PendingSummary, error) {
+	summary, err := i.client.XPending(ctx, i.config.StreamName, i.config.GroupName).Result()
+	if err != nil {
+		return nil, err
+	}
+	consumers := summary.Consumers
+	if consumers == nil {
+		consumers = map[string]int64{}
+	}
+	return &PendingSummary{Count: summary.Count, Lower: summary.Lower, Higher: summary.Higher, Consumers: consumers}, nil
+}
+
+// PendingEntries lists up to count pending entries from start, of one consumer
+// or of all of them if consumer is empty
+func (i *Inspector) PendingEntries(ctx context.Context, consumer, start string, count int64) ([]PendingEntry, error) {
+	if start == "" {
+		start = "-"
+	}
+	pending, err := i.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   i.config.StreamName,
+		Group:    i.config.GroupName,
+		Start:    start,
+		End:      "+",
+		Count:    count,
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]PendingEntry, 0, len(pending))
+	for _, p := range pending {
+		list = append(list, PendingEntry{
+			ID:         p.ID,
+			Consumer:   p.Consumer,
+			IdleMillis: p.Idle.Milliseconds(),
+			Deliveries: p.RetryCount,
+		})
+	}
+	return list, nil
+}
+
+// Ack acknowledges entries, removing them from the pending entries list, and
+// returns how many were pending
+func (i *Inspector) Ack(ctx context.Context, ids ...string) (int64, error) {
+	return i.client.XAck(ctx, i.config.StreamName, i.config.GroupName, ids...).Result()
+}
+
+// Requeue adds a copy of an entry to the end of the stream and acknowledges the
+// original in one transaction, so it is delivered again as a new message. It
+// returns the ID of the new entry.
+func (i *Inspector) Requeue(ctx context.Context, id string) (string, error) {
+	values, err := i.entryValues(ctx, i.config.StreamName, id)
+	if err != nil {
+		return "", err
+	}
+
+	var added *redis.StringCmd
+	_, err = i.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.XAdd(ctx, &redis.XAddArgs{Stream: i.config.StreamName, Values: values})
+		pipe.XAck(ctx, i.config.StreamName, i.config.GroupName, id)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return added.Val(), nil
+}
+
+// DeadLetters lists up to count entries of the dead-letter stream from start
+func (i *Inspector) DeadLetters(ctx context.Context, start string, count int64) ([]Entry, error) {
+	if start == "" {
+		start = "-"
+	}
+	messages, err := i.client.XRangeN(ctx, i.config.deadLetterStream(), start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	return entries(messages), nil
+}
+
+// ReplayDeadLetter adds the original fields of a dead-lettered entry to the
+// stream, rewritten by transforms in turn, and deletes it from the dead-letter
+// stream in one transaction. It returns the ID of the new entry.
+func (i *Inspector) ReplayDeadLetter(ctx context.Context, id string, transforms ...ReplayTransform) (string, error) {
+	values, err := i.entryValues(ctx, i.config.deadLetterStream(), id)
+	if err != nil {
+		return "", err
+	}
+	return i.replay(ctx, id, values, transforms)
+}
+
+// ReplayDeadLetters replays the count oldest dead-lettered entries, rewritten
+// by transforms, and returns how many were replayed, which is less than count
+// on errors
+func (i *Inspector) ReplayDeadLetters(ctx context.Context, count int64, transforms ...ReplayTransform) (int, error) {
+	messages, err := i.client.XRangeN(ctx, i.config.deadLetterStream(), "-", "+", count).Result()
+	if err != nil {
+		return 0, err
+	}
+	for n, message := range messages {
+		if _, err := i.replay(ctx, message.ID, message.Values, transforms); err != nil {
+			return n, err
+		}
+	}
+	return len(messages), nil
+}
+
+// replay moves the dead-lettered entry id with values back to the stream,
+// rewritten by transforms
+func (i *Inspector) replay(ctx context.Context, id string, values map[string]any, transforms []ReplayTransform) (string, error) {
+	values, err := applyTransforms(StripDeadLetterFields(values), transforms)
+	if err != nil {
+		return "", fmt.Errorf("failed to transform %s: %w", id, err)
+	}
+	var added *redis.StringCmd
+	_, err = i.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.XAdd(ctx, &redis.XAddArgs{Stream: i.config.StreamName, Values: values})
+		pipe.XDel(ctx, i.config.deadLetterStream(), id)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return added.Val(), nil
+}
+
+// Trim removes the oldest entries of the stream beyond maxLen, or only whole
+// nodes of them if approx is set, and returns how many were removed. Entries
+// still pending in the group are removed too and can't be processed anymore.
+func (i *Inspector) Trim(ctx context.Context, maxLen int64, approx bool) (int64, error) {
+	if approx {
+		return i.client.XTrimMaxLenApprox(ctx, i.config.StreamName, maxLen, 0).Result()
+	}
+	return i.client.XTrimMaxLen(ctx, i.config.StreamName, maxLen).Result()
+}
+
+// TrimBefore removes the entries of the stream with an ID lower than minID,
+// like Trim
+func (i *Inspector) TrimBefore(ctx context.Context, minID string, approx bool) (int64, error) {
+	if approx {
+		return i.client.XTrimMinIDApprox(ctx, i.config.StreamName, minID, 0).Result()
+	}
+	return i.client.XTrimMinID(ctx, i.config.StreamName, minID).Result()
+}
+
+// entryValues returns the fields of the entry id of stream
+func (i *Inspector) entryValues(ctx context.Context, stream, id string) (map[string]any, error) {
+	messages, err := i.client.XRange(ctx, stream, id, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return messages[0].Values, nil
+}
+
+// entries converts stream messages to entries
+func entries(messages []redis.XMessage) []Entry {
+	list := make([]Entry, 0, len(messages))
+	for _, message := range messages {
+		list = append(list, Entry{ID: message.ID, Values: message.Values})
+	}
+	return list
+}
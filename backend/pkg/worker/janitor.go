@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// deregisterTimeout bounds the Redis calls deleting the worker's consumers on
+// shutdown
+const deregisterTimeout = 5 * time.Second
+
+// deleteIdleConsumer deletes the consumer ARGV[2] of the group ARGV[1] on the
+// stream KEYS[1] if it has no pending entries, atomically so that no entry it
+// reads meanwhile is dropped from the group. It returns 1 if it was deleted or
+// didn't exist and -1 if it has pending entries.
+var deleteIdleConsumer = redis.NewScript(`
+local pending = redis.call("XPENDING", KEYS[1], ARGV[1], "-", "+", 1, ARGV[2])
+if #pending > 0 then
+	return -1
+end
+redis.call("XGROUP", "DELCONSUMER", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// runJanitor deletes the consumers of the member's group that have been idle
+// for longer than ConsumerMaxIdle and have no pending entries, every
+// ConsumerCleanupInterval, on the leader if the workers elect one. Entries
+// still pending on idle consumers are left to the claimer, and the consumer is
+// deleted on a later run once they are gone.
+func (w *Worker) runJanitor(ctx context.Context, m groupMember) {
+	if m.config.ConsumerCleanupInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.ConsumerCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !w.leader.leads() {
+			continue
+		}
+
+		consumers, err := redisx.XInfo(ctx, m.client, "CONSUMERS", m.stream, m.group)
+		if err != nil {
+			if ctx.Err() == nil {
+				m.logger.Error("Error listing consumers", "error", err)
+			}
+			continue
+		}
+		for _, c := range consumers {
+			name, _ := c["name"].(string)
+			idle, _ := c["idle"].(int64)
+			if name == "" || name == m.name || time.Duration(idle)*time.Millisecond < m.config.ConsumerMaxIdle {
+				continue
+			}
+			if pending, _ := c["pending"].(int64); pending > 0 {
+				continue
+			}
+			deleted, err := deleteIdleConsumer.Run(ctx, m.client, []string{m.stream}, m.group, name).Int64()
+			if err != nil {
+				if ctx.Err() == nil {
+					m.logger.Error("Error deleting idle consumer", "idle_consumer", name, "error", err)
+				}
+				continue
+			}
+			if deleted > 0 {
+				m.logger.Info("Deleted idle consumer", "idle_consumer", name, "idle", time.Duration(idle)*time.Millisecond)
+			}
+		}
+	}
+}
+
+// deregister deletes the member's consumer from its group on shutdown, unless
+// it still has pending entries, which are then reclaimed by other workers
+func (m groupMember) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+	defer cancel()
+
+	deleted, err := deleteIdleConsumer.Run(ctx, m.client, []string{m.stream}, m.group, m.name).Int64()
+	switch {
+	case err != nil:
+		m.logger.Warn("Failed to delete consumer from the group", "error", err)
+	case deleted < 0:
+		m.logger.Info("Keeping consumer in the group, it has pending entries")
+	default:
+		m.logger.Info("Deleted consumer from the group")
+	}
+}
@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// ErrJobNotFound is returned by JobStore.Get for jobs it has no state of,
+// never seen or expired
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is the state of a job kept by the JobStore
+type Job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Attempt    int             `json:"attempt"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	StartedAt  time.Time       `json:"started_at,omitzero"`
+	FinishedAt time.Time       `json:"finished_at,omitzero"`
+
+	// Progress last reported by the handler with Message.Progress
+	Progress        int    `json:"progress"`
+	ProgressMessage string `json:"progress_message,omitempty"`
+}
+
+// JobStore keeps the state of every job the worker processes in the hash
+// <prefix><id>, and indexes jobs by status in the sorted sets
+// <prefix>by-status:<status>, scored by the time of their last update, so
+// that clients can look jobs up from Redis or the admin API. Both expire ttl
+// after the last update of a job, unless ttl is zero.
+type JobStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewJobStore creates a store keeping jobs under prefix, "job:" if empty. Like
+// New, it takes a client from NewRedisClient or redisclient.Adapt.
+func NewJobStore(client redisclient.Client, prefix string, ttl time.Duration) *JobStore {
+	return newJobStore(redisx.Universal(client), prefix, ttl)
+}
+
+// newJobStore implements NewJobStore
+func newJobStore(client redis.UniversalClient, prefix string, ttl time.Duration) *JobStore {
+	if prefix == "" {
+		prefix = "job:"
+	}
+	return &JobStore{client: client, prefix: prefix, ttl: ttl}
+}
+
+// jobStore returns the job store of the worker, or nil if it is disabled
+func (w *Worker) jobStore() *JobStore {
+	if !w.config.JobStoreEnabled {
+		return nil
+	}
+	return newJobStore(w.client, w.config.key(w.config.JobKeyPrefix), w.config.JobTTL)
+}
+
+// key returns the key of the hash of a job
+func (s *JobStore) key(id string) string {
+	return s.prefix + id
+}
+
+// indexKey returns the key of the index of the jobs with a status
+func (s *JobStore) indexKey(status string) string {
+	return s.prefix + "by-status:" + status
+}
+
+// Record applies a status update to the state of its job
+func (s *JobStore) Record(ctx context.Context, update StatusUpdate) error {
+	result, err := json.Marshal(update.Result)
+	if err != nil {
+		return fmt.Errorf("error marshaling job result: %w", err)
+	}
+	key := s.key(update.ID)
+	now := time.Now().UTC()
+	stamp := now.Format(time.RFC3339Nano)
+
+	// Updates of a job come one at a time, so its previous status can be read
+	// before moving it to the index of the new one
+	previous, err := s.client.HGet(ctx, key, "status").Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("error reading job state: %w", err)
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" && previous != update.Status {
+			pipe.ZRem(ctx, s.indexKey(previous), update.ID)
+		}
+		pipe.HSetNX(ctx, key, "created_at", stamp)
+		values := map[string]any{
+			"status":     update.Status,
+			"result":     string(result),
+			"attempt":    update.Attempt,
+			"updated_at": stamp,
+		}
+		switch update.Status {
+		case "processing":
+			// A new attempt starts over, progress updates don't
+			if update.Progress == 0 && update.ProgressMessage == "" {
+				values["started_at"] = stamp
+			}
+			values["progress"] = update.Progress
+			values["progress_message"] = update.ProgressMessage
+		case "completed", "failed", "cancelled", "expired", "partial", "compensated":
+			values["finished_at"] = stamp
+		}
+		pipe.HSet(ctx, key, values)
+
+		index := s.indexKey(update.Status)
+		pipe.ZAdd(ctx, index, redis.Z{Score: float64(now.UnixMilli()), Member: update.ID})
+		if s.ttl > 0 {
+			pipe.Expire(ctx, key, s.ttl)
+			pipe.ZRemRangeByScore(ctx, index, "-inf", strconv.FormatInt(now.Add(-s.ttl).UnixMilli(), 10))
+			pipe.Expire(ctx, index, s.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error recording job state: %w", err)
+	}
+	return nil
+}
+
+// Get returns the state of a job, or ErrJobNotFound
+func (s *JobStore) Get(ctx context.Context, id string) (*Job, error) {
+	values, err := s.client.HGetAll(ctx, s.key(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrJobNotFound
+	}
+	return parseJob(id, values), nil
+}
+
+// List returns up to count jobs with a status, most recently updated first,
+// skipping the offset first ones
+func (s *JobStore) List(ctx context.Context, status string, offset, count int64) ([]Job, error) {
+	ids, err := s.client.ZRevRange(ctx, s.indexKey(status), offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, s.key(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	for i, cmd := range cmds {
+		// Skip jobs that expired, or changed status since the index was read
+		job := parseJob(ids[i], cmd.Val())
+		if len(cmd.Val()) == 0 || job.Status != status {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// parseJob turns the fields of the hash of a job into a Job
+func parseJob(id string, values map[string]string) *Job {
+	job := &Job{ID: id, Status: values["status"]}
+	if result := values["result"]; result != "" && result != "null" {
+		job.Result = json.RawMessage(result)
+	}
+	job.Attempt, _ = strconv.Atoi(values["attempt"])
+	job.Progress, _ = strconv.Atoi(values["progress"])
+	job.ProgressMessage = values["progress_message"]
+	job.CreatedAt, _ = time.Parse(time.RFC3339Nano, values["created_at"])
+	job.UpdatedAt, _ = time.Parse(time.RFC3339Nano, values["updated_at"])
+	job.StartedAt, _ = time.Parse(time.RFC3339Nano, values["started_at"])
+	job.FinishedAt, _ = time.Parse(time.RFC3339Nano, values["finished_at"])
+	return job
+}
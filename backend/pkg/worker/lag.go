@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+var (
+	groupLagDesc = prometheus.NewDesc("stream_worker_group_lag",
+		"Entries of the stream not delivered to the consumer group yet, as reported by Redis 7 (XINFO GROUPS).", streamLabels, nil)
+	groupEntriesReadDesc = prometheus.NewDesc("stream_worker_group_entries_read",
+		"Entries delivered to the consumer group since it was created, from Redis 7 (XINFO GROUPS).", streamLabels, nil)
+	groupLastDeliveredDesc = prometheus.NewDesc("stream_worker_group_last_delivered_timestamp_seconds",
+		"Time of the last entry delivered to the consumer group, from its ID (XINFO GROUPS).", streamLabels, nil)
+	streamEntriesAddedDesc = prometheus.NewDesc("stream_worker_stream_entries_added",
+		"Entries added to the stream since it was created, from Redis 7 (XINFO STREAM).", streamLabels, nil)
+)
+
+// groupLag is what XINFO told about a consumer group and its stream, the
+// fields only Redis 7 reports being nil before, and lag also when Redis can't
+// tell after entries were deleted
+type groupLag struct {
+	lag             *int64
+	entriesRead     *int64
+	entriesAdded    *int64
+	pending         int64
+	lastDeliveredID string
+}
+
+// lagMonitor holds the last reading of every stream the worker consumes, for
+// the metrics
+type lagMonitor struct {
+	mu       sync.Mutex
+	readings map[[2]string]groupLag
+}
+
+// newLagMonitor creates a monitor without readings
+func newLagMonitor() *lagMonitor {
+	return &lagMonitor{readings: make(map[[2]string]groupLag)}
+}
+
+// runLagMonitor reads the lag of the member's group every LagInterval
+func (w *Worker) runLagMonitor(ctx context.Context, m groupMember) {
+	if m.config.LagInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.LagInterval)
+	defer ticker.Stop()
+
+	for {
+		w.measureLag(ctx, m)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// measureLag reads the lag of the member's group and records it
+func (w *Worker) measureLag(ctx context.Context, m groupMember) {
+	info, err := groupInfo(ctx, m.client, m.stream, m.group)
+	if err == nil && info == nil {
+		// The group was deleted, it is created again on the next read
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Warn("Failed to read the lag of the group", "error", err)
+		}
+		return
+	}
+	stream, err := redisx.XInfoStream(ctx, m.client, m.stream)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Warn("Failed to read the stream info", "error", err)
+		}
+		return
+	}
+
+	var l groupLag
+	l.lag = optionalInt(info["lag"])
+	l.entriesRead = optionalInt(info["entries-read"])
+	l.entriesAdded = optionalInt(stream["entries-added"])
+	l.pending, _ = info["pending"].(int64)
+	l.lastDeliveredID, _ = info["last-delivered-id"].(string)
+
+	w.lags.mu.Lock()
+	w.lags.readings[[2]string{m.stream, m.group}] = l
+	w.lags.mu.Unlock()
+
+	m.logger.Debug("Consumer group lag", "lag", valueOrNil(l.lag), "pending", l.pending,
+		"last_delivered_id", l.lastDeliveredID, "entries_read", valueOrNil(l.entriesRead),
+		"entries_added", valueOrNil(l.entriesAdded))
+}
+
+// optionalInt returns v if it is an integer, nil otherwise, such as when
+// Redis replies with a null lag
+func optionalInt(v any) *int64 {
+	n, ok := v.(int64)
+	if !ok {
+		return nil
+	}
+	return &n
+}
+
+// valueOrNil returns *n, or nil for logging if n is nil
+func valueOrNil(n *int64) any {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// lagCollector reports the last readings of the lag monitor, leaving out what
+// Redis didn't report
+type lagCollector struct {
+	lags *lagMonitor
+}
+
+// Describe implements prometheus.Collector
+func (c *lagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- groupLagDesc
+	ch <- groupEntriesReadDesc
+	ch <- groupLastDeliveredDesc
+	ch <- streamEntriesAddedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *lagCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lags.mu.Lock()
+	defer c.lags.mu.Unlock()
+
+	for key, l := range c.lags.readings {
+		stream, group := key[0], key[1]
+		gauge := func(desc *prometheus.Desc, v *int64) {
+			if v != nil {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(*v), stream, group)
+			}
+		}
+		gauge(groupLagDesc, l.lag)
+		gauge(groupEntriesReadDesc, l.entriesRead)
+		gauge(streamEntriesAddedDesc, l.entriesAdded)
+		if ms, _ := splitID(l.lastDeliveredID); ms > 0 {
+			ch <- prometheus.MustNewConstMetric(groupLastDeliveredDesc, prometheus.GaugeValue, float64(ms)/1000, stream, group)
+		}
+	}
+}
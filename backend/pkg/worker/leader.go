@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderReleaseTimeout bounds handing the lease back on shutdown
+const leaderReleaseTimeout = 2 * time.Second
+
+// renewLease extends the lease KEYS[1] by ARGV[2] milliseconds if it is still
+// held by ARGV[1], returning 1, or 0 if another worker holds it
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLease deletes the lease KEYS[1] if it is still held by ARGV[1]
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// leaderKey returns the key of the lease held by the leader of the workers
+func (c *Config) leaderKey() string {
+	if c.LeaderKey != "" {
+		return c.key(c.LeaderKey)
+	}
+	return c.key(c.StreamName + ":leader")
+}
+
+// leaderElector elects one of the workers sharing a lease key to run the
+// maintenance tasks: trimming, reclaiming stale messages, firing cron jobs,
+// deleting idle consumers and looking after the dead-letter streams. The
+// leader holds the key for LeaderLeaseTTL and renews it every third of that,
+// while the others try to take it over as often, which they can once the
+// leader stopped renewing it. A nil elector leads, without election every
+// worker runs the tasks.
+type leaderElector struct {
+	client redis.UniversalClient
+	key    string
+	id     string
+	ttl    time.Duration
+	logger Logger
+	gauge  prometheus.Gauge
+
+	leading   atomic.Bool
+	renewedAt time.Time // when the lease was last taken or renewed
+}
+
+// newLeaderElector creates the elector of the worker, or nil without
+// LeaderElection
+func (w *Worker) newLeaderElector(config *Config) *leaderElector {
+	if !config.LeaderElection {
+		return nil
+	}
+	id := w.consumerName(config.StreamName, config.GroupName) + ":" + rand.Text()
+	return &leaderElector{
+		client: w.client,
+		key:    config.leaderKey(),
+		id:     id,
+		ttl:    config.LeaderLeaseTTL,
+		logger: withFields(w.logger, "component", "leader", "key", config.leaderKey(), "candidate", id),
+		gauge:  w.metrics.leader.WithLabelValues(config.StreamName, config.GroupName),
+	}
+}
+
+// leads reports whether the worker is the leader
+func (e *leaderElector) leads() bool {
+	return e == nil || e.leading.Load()
+}
+
+// run campaigns for the lease until ctx is done, then hands it back if held
+func (e *leaderElector) run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	e.logger.Info("Campaigning for leadership", "lease", e.ttl)
+	interval := max(e.ttl/3, time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx, interval)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign renews the lease if the worker leads, or else tries to take it
+func (e *leaderElector) campaign(ctx context.Context, interval time.Duration) {
+	if e.leading.Load() {
+		renewed, err := renewLease.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int64()
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			// Step down before the lease may expire, as another worker could
+			// then take it over
+			if time.Since(e.renewedAt)+interval >= e.ttl {
+				e.logger.Error("Failed to renew the leader lease, stepping down", "error", err)
+				e.setLeading(false)
+			} else {
+				e.logger.Warn("Failed to renew the leader lease", "error", err)
+			}
+		case renewed == 0:
+			e.logger.Warn("Lost the leader lease to another worker")
+			e.setLeading(false)
+		default:
+			e.renewedAt = time.Now()
+		}
+		return
+	}
+
+	acquired, err := e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			e.logger.Error("Failed to campaign for leadership", "error", err)
+		}
+		return
+	}
+	if acquired {
+		e.renewedAt = time.Now()
+		e.logger.Info("Elected leader, running the maintenance tasks")
+		e.setLeading(true)
+	}
+}
+
+// setLeading records whether the worker leads
+func (e *leaderElector) setLeading(leading bool) {
+	e.leading.Store(leading)
+	if leading {
+		e.gauge.Set(1)
+	} else {
+		e.gauge.Set(0)
+	}
+}
+
+// release hands the lease back on shutdown so that another worker takes over
+// without waiting for it to expire
+func (e *leaderElector) release() {
+	if !e.leading.Load() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), leaderReleaseTimeout)
+	defer cancel()
+	e.setLeading(false)
+	if err := releaseLease.Run(ctx, e.client, []string{e.key}, e.id).Err(); err != nil {
+		e.logger.Warn("Failed to release the leader lease", "error", err)
+		return
+	}
+	e.logger.Info("Released the leader lease")
+}
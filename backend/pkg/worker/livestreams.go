@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+var (
+	// ErrNotRunning is returned when changing a worker that isn't running
+	ErrNotRunning = errors.New("worker is not running")
+
+	// ErrStreamConsumed is returned by AddStream for a stream the worker
+	// already consumes
+	ErrStreamConsumed = errors.New("stream already consumed")
+
+	// ErrStreamNotConsumed is returned by RemoveStream for a stream the worker
+	// doesn't consume
+	ErrStreamNotConsumed = errors.New("stream not consumed")
+)
+
+// liveStreams are the streams a running worker consumes, whether it started
+// with them, found them or was told to add them, by key
+type liveStreams struct {
+	mu sync.Mutex
+
+	// start starts consuming a stream and returns what stops it, nil while
+	// Run isn't running
+	start   func(stream StreamConfig) (stop func())
+	streams map[string]liveStream
+}
+
+// liveStream is a stream consumed by a running worker
+type liveStream struct {
+	config StreamConfig
+	stop   func()
+}
+
+// run records that Run started, consuming streams with start
+func (l *liveStreams) run(start func(stream StreamConfig) (stop func())) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start = start
+	l.streams = map[string]liveStream{}
+}
+
+// close records that Run is shutting down, after which streams can no longer
+// be added or removed
+func (l *liveStreams) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start = nil
+	l.streams = nil
+}
+
+// add records a stream being consumed, unless it already is or Run isn't
+// running
+func (l *liveStreams) add(key string, config StreamConfig, stop func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams == nil {
+		return false
+	}
+	if _, ok := l.streams[key]; ok {
+		return false
+	}
+	l.streams[key] = liveStream{config: config, stop: stop}
+	return true
+}
+
+// consumed reports whether a stream is being consumed
+func (l *liveStreams) consumed(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.streams[key]
+	return ok
+}
+
+// remove forgets a stream and returns what stops it, or false if it isn't
+// being consumed
+func (l *liveStreams) remove(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.streams[key]
+	if !ok {
+		return nil, false
+	}
+	delete(l.streams, key)
+	return s.stop, true
+}
+
+// get returns a stream being consumed
+func (l *liveStreams) get(key string) (StreamConfig, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.streams[key]
+	return s.config, ok
+}
+
+// list returns the streams being consumed sorted by name, or nil if Run isn't
+// running
+func (l *liveStreams) list() []StreamConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams == nil {
+		return nil
+	}
+	list := make([]StreamConfig, 0, len(l.streams))
+	for _, s := range l.streams {
+		list = append(list, s.config)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// AddStream makes the running worker consume stream, through its group or
+// GroupName, creating the group at GroupStartID if needed. The stream gets its
+// own reader and consumers, like a stream of Streams without a weight, and
+// the handlers of its subscription if it has one. It returns
+// ErrStreamConsumed if the worker already consumes the stream, and an error
+// if DeadLetterStream is set, as it can't be shared by several streams.
+func (w *Worker) AddStream(ctx context.Context, stream StreamConfig) error {
+	if stream.Name == "" {
+		return errors.New("stream name is required")
+	}
+	if stream.Group == "" {
+		stream.Group = w.config.GroupName
+	}
+	if w.config.DeadLetterStream != "" {
+		return fmt.Errorf("dead-letter stream %s can't be shared with stream %s", w.config.DeadLetterStream, stream.Name)
+	}
+	key := w.config.key(stream.Name)
+
+	w.live.mu.Lock()
+	defer w.live.mu.Unlock()
+	if w.live.start == nil {
+		return ErrNotRunning
+	}
+	if _, ok := w.live.streams[key]; ok {
+		return fmt.Errorf("%w: %s", ErrStreamConsumed, stream.Name)
+	}
+	if err := CreateConsumerGroupAt(ctx, redisclient.Adapt(w.client), key, stream.Group, w.config.GroupStartID); err != nil {
+		return fmt.Errorf("failed to create consumer group %s on %s: %w", stream.Group, key, err)
+	}
+	w.live.streams[key] = liveStream{config: stream, stop: w.live.start(stream)}
+	w.logger.Info("Stream added", "stream", key, "group", stream.Group)
+	return nil
+}
+
+// RemoveStream makes the running worker stop reading stream. Its consumers
+// finish the messages they are processing, and those read but not started
+// are left pending in the group. It returns ErrStreamNotConsumed if the
+// worker doesn't consume the stream.
+func (w *Worker) RemoveStream(name string) error {
+	stop, ok := w.live.remove(w.config.key(name))
+	if !ok {
+		if w.live.list() == nil {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("%w: %s", ErrStreamNotConsumed, name)
+	}
+	stop()
+	w.logger.Info("Stream removed", "stream", w.config.key(name))
+	return nil
+}
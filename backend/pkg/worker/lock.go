@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// lockRetryDelay is how long Lock waits before trying a held lock again
+	lockRetryDelay = 50 * time.Millisecond
+
+	// lockTimeout bounds each call renewing or releasing a lock
+	lockTimeout = 5 * time.Second
+)
+
+// Lock takes the lock named key, shared by every worker using the same Redis,
+// waiting while another holder has it until ctx is done. Handlers use it to
+// serialize work on a shared resource, e.g. an account that jobs of several
+// streams update. The lock is held for ttl and renewed every third of that
+// until it is unlocked, so a handler can hold it for as long as it runs,
+// while the lock of a worker that died expires after ttl. Locks the handler
+// didn't unlock are released when it returns.
+//
+// This is a single Redis lock rather than Redlock over several servers: it
+// doesn't survive a failover losing the lock key, and a holder cut off from
+// Redis loses it after ttl. Handlers that must not run unguarded watch
+// Lost.
+func (m Message) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if m.locks == nil {
+		return nil, errors.New("locks are only available to handlers run by the worker")
+	}
+	return m.locks.lock(ctx, key, ttl)
+}
+
+// Lock is a lock taken by a handler with Message.Lock
+type Lock struct {
+	key   string
+	token string
+	ttl   time.Duration
+	set   *handlerLocks
+
+	acquiredAt time.Time
+	lost       chan struct{} // closed once the lock may be held by another
+	done       chan struct{} // closed to stop renewing
+	stopped    chan struct{} // closed once renewing stopped
+	unlock     sync.Once
+}
+
+// Lost returns a channel closed when the lock could no longer be renewed, as
+// another holder took it over or Redis couldn't be reached for too long
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stops renewing the lock and releases it, unless another holder took
+// it over meanwhile. Calls after the first return nil.
+func (l *Lock) Unlock() error {
+	var err error
+	l.unlock.Do(func() {
+		close(l.done)
+		<-l.stopped
+		l.set.forget(l)
+		l.set.c.metrics.lockHold.Observe(time.Since(l.acquiredAt).Seconds())
+
+		ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+		defer cancel()
+		if e := releaseLease.Run(ctx, l.set.c.client, []string{l.key}, l.token).Err(); e != nil {
+			err = fmt.Errorf("failed to release lock %s: %w", l.key, e)
+		}
+	})
+	return err
+}
+
+// renew extends the lock every third of its ttl until it is unlocked, closing
+// lost if it can't
+func (l *Lock) renew() {
+	defer close(l.stopped)
+	interval := max(l.ttl/3, time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewedAt := l.acquiredAt
+	logger := l.set.c.logger
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+		renewed, err := renewLease.Run(ctx, l.set.c.client, []string{l.key}, l.token, l.ttl.Milliseconds()).Int64()
+		cancel()
+		switch {
+		case err != nil:
+			if time.Since(renewedAt)+interval < l.ttl {
+				logger.Warn("Failed to renew lock", "lock", l.key, "error", err)
+				continue
+			}
+			logger.Error("Failed to renew lock before it expires, giving it up", "lock", l.key, "error", err)
+		case renewed == 0:
+			logger.Warn("Lock expired and was taken over by another holder", "lock", l.key)
+		default:
+			renewedAt = time.Now()
+			continue
+		}
+		close(l.lost)
+		return
+	}
+}
+
+// handlerLocks are the locks taken by one run of a handler
+type handlerLocks struct {
+	c         *consumer
+	messageID string
+
+	mu   sync.Mutex
+	held map[*Lock]struct{}
+	done bool
+}
+
+// newHandlerLocks creates the locks of a run of the handler of job id
+func (c *consumer) newHandlerLocks(id string) *handlerLocks {
+	return &handlerLocks{c: c, messageID: id, held: map[*Lock]struct{}{}}
+}
+
+// lock takes the lock named key, retrying every lockRetryDelay while another
+// holder has it
+func (s *handlerLocks) lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if key == "" {
+		return nil, errors.New("lock key is required")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid lock ttl %v, must be positive", ttl)
+	}
+	l := &Lock{
+		key:   s.c.config.key("lock:" + key),
+		token: s.c.name + ":" + rand.Text(),
+		ttl:   ttl,
+		set:   s,
+	}
+
+	contended := false
+	for {
+		acquired, err := s.c.client.SetNX(ctx, l.key, l.token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to take lock %s: %w", l.key, err)
+		}
+		if acquired {
+			break
+		}
+		if !contended {
+			contended = true
+			s.c.metrics.lockContentions.Inc()
+			s.c.logger.Debug("Waiting for lock", "lock", l.key, "message_id", s.messageID)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to take lock %s: %w", l.key, context.Cause(ctx))
+		case <-time.After(lockRetryDelay):
+		}
+	}
+
+	l.acquiredAt = time.Now()
+	l.lost = make(chan struct{})
+	l.done = make(chan struct{})
+	l.stopped = make(chan struct{})
+	go l.renew()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		// The handler returned while the lock was taken, e.g. from a goroutine
+		// it left behind
+		go l.Unlock()
+		return nil, errors.New("handler already returned")
+	}
+	s.held[l] = struct{}{}
+	return l, nil
+}
+
+// forget records that l was unlocked
+func (s *handlerLocks) forget(l *Lock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.held, l)
+}
+
+// stop releases the locks the handler didn't unlock once it returned
+func (s *handlerLocks) stop() {
+	s.mu.Lock()
+	s.done = true
+	held := make([]*Lock, 0, len(s.held))
+	for l := range s.held {
+		held = append(held, l)
+	}
+	s.mu.Unlock()
+
+	for _, l := range held {
+		s.c.logger.Warn("Handler returned without unlocking, releasing the lock", "lock", l.key, "message_id", s.messageID)
+		if err := l.Unlock(); err != nil {
+			s.c.logger.Warn("Failed to release lock", "lock", l.key, "message_id", s.messageID, "error", err)
+		}
+	}
+}
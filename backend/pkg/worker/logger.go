@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logger is the structured logger the worker writes to. Arguments after the
+// message are alternating keys and values, as with log/slog. *slog.Logger
+// implements it, and small adapters let zap, zerolog and others be plugged in.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewLogger creates a slog logger writing to out in the configured format and
+// at the configured level, which Worker.Reload changes if the worker runs with
+// config
+func NewLogger(out io.Writer, config *Config) *slog.Logger {
+	if config.logLevel == nil {
+		config.logLevel = new(slog.LevelVar)
+	}
+	config.logLevel.Set(config.LogLevel)
+	opts := &slog.HandlerOptions{Level: config.logLevel}
+	if config.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(out, opts))
+	}
+	return slog.New(slog.NewTextHandler(out, opts))
+}
+
+// withFields returns a logger adding args to every record logged through it
+func withFields(logger Logger, args ...any) Logger {
+	if l, ok := logger.(*slog.Logger); ok {
+		return l.With(args...)
+	}
+	return &fieldLogger{logger: logger, args: args}
+}
+
+// fieldLogger adds fields to the records of a Logger that isn't a slog logger
+type fieldLogger struct {
+	logger Logger
+	args   []any
+}
+
+func (l *fieldLogger) Debug(msg string, args ...any) { l.logger.Debug(msg, l.with(args)...) }
+func (l *fieldLogger) Info(msg string, args ...any)  { l.logger.Info(msg, l.with(args)...) }
+func (l *fieldLogger) Warn(msg string, args ...any)  { l.logger.Warn(msg, l.with(args)...) }
+func (l *fieldLogger) Error(msg string, args ...any) { l.logger.Error(msg, l.with(args)...) }
+
+// with prepends the logger's fields to args
+func (l *fieldLogger) with(args []any) []any {
+	return append(append(make([]any, 0, len(l.args)+len(args)), l.args...), args...)
+}
+
+// parseLogFormat validates a LOG_FORMAT value
+func parseLogFormat(s string) (string, error) {
+	switch format := strings.ToLower(s); format {
+	case "text", "json":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected text or json", s)
+	}
+}
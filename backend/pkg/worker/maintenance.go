@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceCheckInterval is how often a paused worker checks whether the
+// maintenance window has ended
+const maintenanceCheckInterval = 10 * time.Second
+
+// weekdays maps the day abbreviations accepted in MAINTENANCE_WINDOWS
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// MaintenanceWindow is a recurring daily time range during which workers stop
+// reading new messages. A window whose end is before its start runs past midnight.
+type MaintenanceWindow struct {
+	Days  [7]bool       // indexed by time.Weekday, the day the window starts on
+	Start time.Duration // offset from midnight
+	End   time.Duration // offset from midnight
+}
+
+// parseMaintenanceWindows parses a comma separated list of windows such as
+// "Sat 02:00-04:00, Mon-Fri 23:30-00:15, 12:00-12:30". Without a day part the
+// window applies to every day.
+func parseMaintenanceWindows(spec string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		window := MaintenanceWindow{}
+		timeRange := entry
+		if fields := strings.Fields(entry); len(fields) == 2 {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("window %q: %w", entry, err)
+			}
+			window.Days = days
+			timeRange = fields[1]
+		} else if len(fields) == 1 {
+			for i := range window.Days {
+				window.Days[i] = true
+			}
+		} else {
+			return nil, fmt.Errorf("window %q: expected \"[days] HH:MM-HH:MM\"", entry)
+		}
+
+		start, end, ok := strings.Cut(timeRange, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q: expected time range HH:MM-HH:MM", entry)
+		}
+		var err error
+		if window.Start, err = parseClock(start); err != nil {
+			return nil, fmt.Errorf("window %q: %w", entry, err)
+		}
+		if window.End, err = parseClock(end); err != nil {
+			return nil, fmt.Errorf("window %q: %w", entry, err)
+		}
+		if window.Start == window.End {
+			return nil, fmt.Errorf("window %q: start and end are equal", entry)
+		}
+
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseDays parses a single day ("Sat") or an inclusive day range ("Mon-Fri")
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	first, last, isRange := strings.Cut(strings.ToLower(s), "-")
+	from, ok := weekdays[first]
+	if !ok {
+		return days, fmt.Errorf("unknown day %q", first)
+	}
+	to := from
+	if isRange {
+		if to, ok = weekdays[last]; !ok {
+			return days, fmt.Errorf("unknown day %q", last)
+		}
+	}
+
+	for d := from; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == to {
+			break
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a HH:MM time of day into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window
+func (mw MaintenanceWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if mw.Start < mw.End {
+		return mw.Days[t.Weekday()] && offset >= mw.Start && offset < mw.End
+	}
+
+	// The window wraps past midnight, so the early part belongs to the previous day
+	yesterday := (t.Weekday() + 6) % 7
+	return (mw.Days[t.Weekday()] && offset >= mw.Start) || (mw.Days[yesterday] && offset < mw.End)
+}
+
+// inMaintenance reports whether t falls inside any of the configured windows
+func (c *Config) inMaintenance(t time.Time) bool {
+	t = t.In(c.MaintenanceLocation)
+	for _, window := range c.MaintenanceWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForMaintenance blocks while a maintenance window is active, logging when
+// the worker enters and leaves maintenance mode. The consumer group and any
+// pending messages are left untouched. It returns false if ctx is done.
+func (r *reader) waitForMaintenance(ctx context.Context) bool {
+	if !r.config.inMaintenance(time.Now()) {
+		return true
+	}
+
+	r.logger.Info("Entering maintenance mode, pausing reads")
+	for r.config.inMaintenance(time.Now()) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(maintenanceCheckInterval):
+		}
+	}
+	r.logger.Info("Leaving maintenance mode, resuming reads")
+	return true
+}
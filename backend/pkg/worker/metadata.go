@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// Metadata describes where a message comes from, as written by the producer,
+// so that a request can be followed through the jobs it enqueued
+type Metadata struct {
+	TraceID       string    // trace active when the job was enqueued
+	CorrelationID string    // shared by the jobs of one request
+	Producer      string    // program that enqueued the job
+	EnqueuedAt    time.Time // zero if unknown
+}
+
+// metadataKey is the context key of the metadata of a message
+type metadataKey struct{}
+
+// MetadataFromContext returns the metadata of the message a handler runs for,
+// which is empty outside of handlers. Jobs enqueued with the context of a
+// handler share its correlation ID.
+func MetadataFromContext(ctx context.Context) Metadata {
+	m, _ := ctx.Value(metadataKey{}).(Metadata)
+	return m
+}
+
+// messageMetadata reads the metadata fields of an entry
+func messageMetadata(values map[string]any) Metadata {
+	m := Metadata{}
+	m.TraceID, _ = values[producer.FieldTraceID].(string)
+	m.CorrelationID, _ = values[producer.FieldCorrelationID].(string)
+	m.Producer, _ = values[producer.FieldProducer].(string)
+	if enqueuedAt, ok := values[producer.FieldEnqueuedAt].(string); ok {
+		m.EnqueuedAt, _ = time.Parse(time.RFC3339Nano, enqueuedAt)
+	}
+	return m
+}
+
+// queuedAt returns when the message of an entry became ready to process: the
+// time it was due if it was scheduled, else when it was enqueued, falling back
+// to the time in the entry ID for producers that don't record it
+func queuedAt(values map[string]any, entryID string) time.Time {
+	for _, field := range []string{producer.FieldRunAt, producer.FieldEnqueuedAt} {
+		if s, ok := values[field].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t
+			}
+		}
+	}
+	ms, _ := splitID(entryID)
+	return time.UnixMilli(int64(ms))
+}
+
+// withMetadata returns a copy of ctx carrying m, and its correlation ID for
+// the producer
+func withMetadata(ctx context.Context, m Metadata) context.Context {
+	ctx = context.WithValue(ctx, metadataKey{}, m)
+	if m.CorrelationID != "" {
+		ctx = producer.ContextWithCorrelationID(ctx, m.CorrelationID)
+	}
+	return ctx
+}
+
+// logFields returns the fields identifying m in logs
+func (m Metadata) logFields() []any {
+	var fields []any
+	if m.CorrelationID != "" {
+		fields = append(fields, "correlation_id", m.CorrelationID)
+	}
+	if m.TraceID != "" {
+		fields = append(fields, "trace_id", m.TraceID)
+	}
+	return fields
+}
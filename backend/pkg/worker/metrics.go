@@ -0,0 +1,322 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+)
+
+// metricsQueryTimeout bounds the Redis queries made while Prometheus scrapes
+const metricsQueryTimeout = 2 * time.Second
+
+// streamLabels are the labels of the per-stream metrics
+var streamLabels = []string{"stream", "group"}
+
+// metrics holds the Prometheus collectors of a Worker. Each worker has its own
+// registry so several workers can be embedded in one process.
+type metrics struct {
+	registry *prometheus.Registry
+
+	processed        *prometheus.CounterVec
+	failed           *prometheus.CounterVec
+	acked            *prometheus.CounterVec
+	reclaimed        *prometheus.CounterVec
+	timeouts         *prometheus.CounterVec
+	panics           *prometheus.CounterVec
+	trimmed          *prometheus.CounterVec
+	duplicates       *prometheus.CounterVec
+	cancelled        *prometheus.CounterVec
+	expired          *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	activeWorkers    *prometheus.GaugeVec
+	concurrency      *prometheus.GaugeVec
+	paused           *prometheus.GaugeVec
+	leader           *prometheus.GaugeVec
+	lockContentions  *prometheus.CounterVec
+	lockHold         *prometheus.HistogramVec
+	deadLetters      *prometheus.GaugeVec
+	deadLetterGrowth *prometheus.GaugeVec
+	compressionRatio *prometheus.HistogramVec
+	queueWait        *prometheus.HistogramVec
+	endToEnd         *prometheus.HistogramVec
+	readErrors       *prometheus.CounterVec
+	atMostOnceLost   *prometheus.CounterVec
+	semantics        *prometheus.GaugeVec
+
+	downstreamHealthy *prometheus.GaugeVec   // by check
+	redisState        *prometheus.GaugeVec   // by state
+	archived          *prometheus.CounterVec // by outcome
+}
+
+// streamMetrics are the collectors of one stream and group
+type streamMetrics struct {
+	processed        prometheus.Counter
+	failed           prometheus.Counter
+	acked            prometheus.Counter
+	reclaimed        prometheus.Counter
+	timeouts         prometheus.Counter
+	panics           prometheus.Counter
+	trimmed          prometheus.Counter
+	duplicates       prometheus.Counter
+	cancelled        prometheus.Counter
+	expired          prometheus.Counter
+	duration         prometheus.Observer
+	activeWorkers    prometheus.Gauge
+	concurrency      prometheus.Gauge
+	paused           prometheus.Gauge
+	lockContentions  prometheus.Counter
+	lockHold         prometheus.Observer
+	deadLetters      prometheus.Gauge
+	deadLetterGrowth prometheus.Gauge
+	compressionRatio prometheus.Observer
+	queueWait        prometheus.ObserverVec // by type
+	endToEnd         prometheus.ObserverVec // by type
+	readErrors       prometheus.Counter
+	atMostOnceLost   prometheus.Counter
+}
+
+// newMetrics creates and registers the worker's collectors. The pending and
+// stream length gauges query Redis when they are scraped.
+func newMetrics(w *Worker) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_messages_processed_total",
+			Help: "Messages whose handler completed successfully.",
+		}, streamLabels),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_messages_failed_total",
+			Help: "Handler invocations that returned an error.",
+		}, streamLabels),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_messages_acked_total",
+			Help: "Messages acknowledged in the consumer group.",
+		}, streamLabels),
+		reclaimed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_messages_reclaimed_total",
+			Help: "Stale pending messages reclaimed with XAUTOCLAIM.",
+		}, streamLabels),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_handler_timeouts_total",
+			Help: "Handler invocations that ran past their timeout.",
+		}, streamLabels),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_handler_panics_total",
+			Help: "Handler invocations that panicked.",
+		}, streamLabels),
+		trimmed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_trimmed_entries_total",
+			Help: "Entries removed from the stream by the trimming policy.",
+		}, streamLabels),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_duplicates_total",
+			Help: "Messages skipped because their idempotency key was already processed.",
+		}, streamLabels),
+		cancelled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_cancelled_total",
+			Help: "Messages skipped or interrupted because their job was cancelled.",
+		}, streamLabels),
+		expired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_expired_total",
+			Help: "Messages skipped because they were read after their expiry.",
+		}, streamLabels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stream_worker_processing_duration_seconds",
+			Help:    "Time spent in the message handler.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 15),
+		}, streamLabels),
+		activeWorkers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_active_workers",
+			Help: "Consumers currently running.",
+		}, streamLabels),
+		concurrency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_concurrency",
+			Help: "Consumers allowed to process messages at once by the autoscaler.",
+		}, streamLabels),
+		paused: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_paused",
+			Help: "Whether the consumer group is paused, 1 or 0.",
+		}, streamLabels),
+		leader: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_leader",
+			Help: "Whether the worker is the elected leader running the maintenance tasks, 1 or 0.",
+		}, streamLabels),
+		lockContentions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_lock_contentions_total",
+			Help: "Handler locks that had to wait for another holder.",
+		}, streamLabels),
+		lockHold: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stream_worker_lock_hold_duration_seconds",
+			Help:    "Time handler locks were held.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 15),
+		}, streamLabels),
+		deadLetters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_dlq_length",
+			Help: "Entries in the dead-letter stream, measured every DLQ_CHECK_INTERVAL.",
+		}, streamLabels),
+		deadLetterGrowth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_dlq_growth_rate",
+			Help: "Entries added to the dead-letter stream per second since the previous check.",
+		}, streamLabels),
+		compressionRatio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stream_worker_compression_ratio",
+			Help:    "Size of compressed bodies once decompressed, divided by their compressed size.",
+			Buckets: []float64{1, 1.5, 2, 3, 5, 10, 20, 50, 100},
+		}, streamLabels),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stream_worker_queue_wait_seconds",
+			Help:    "Time messages waited between being enqueued, or due if scheduled, and their first attempt starting.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 18),
+		}, append(streamLabels, "type")),
+		endToEnd: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stream_worker_end_to_end_latency_seconds",
+			Help:    "Time between messages being enqueued, or due if scheduled, and being processed successfully, retries included.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 18),
+		}, append(streamLabels, "type")),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_read_errors_total",
+			Help: "Reads from the group that failed with a Redis error, each followed by a backoff.",
+		}, streamLabels),
+		atMostOnceLost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_at_most_once_lost_total",
+			Help: "Messages processed at most once that failed or were interrupted by shutdown after being acknowledged, and won't be tried again.",
+		}, streamLabels),
+		semantics: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_delivery_semantics",
+			Help: "1 for the delivery semantics the stream is processed with, at_least_once or at_most_once.",
+		}, append(streamLabels, "semantics")),
+		downstreamHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_downstream_healthy",
+			Help: "Whether the downstream check passed on its last run, 1 or 0. Reads stop while a check fails.",
+		}, []string{"check"}),
+		redisState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stream_worker_redis_state",
+			Help: "1 for the state of Redis as seen by the reads, connected, degraded or down, and 0 for the others.",
+		}, []string{"state"}),
+		archived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stream_worker_archived_records_total",
+			Help: "Records of finished jobs handed to the archiver, by outcome: archived, or dropped when the archive queue is full or the last batches fail on shutdown.",
+		}, []string{"outcome"}),
+	}
+
+	m.registry.MustRegister(
+		m.processed, m.failed, m.acked, m.reclaimed, m.timeouts, m.panics, m.trimmed, m.duplicates, m.cancelled, m.expired, m.duration, m.activeWorkers, m.concurrency, m.paused, m.leader, m.lockContentions, m.lockHold, m.deadLetters, m.deadLetterGrowth, m.compressionRatio, m.queueWait, m.endToEnd, m.readErrors, m.atMostOnceLost, m.semantics, m.downstreamHealthy, m.redisState, m.archived,
+		&queueCollector{w: w},
+		&lagCollector{lags: w.lags},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// forStream returns the collectors of a stream and group
+func (m *metrics) forStream(stream, group string) *streamMetrics {
+	labels := prometheus.Labels{"stream": stream, "group": group}
+	return &streamMetrics{
+		processed:        m.processed.WithLabelValues(stream, group),
+		failed:           m.failed.WithLabelValues(stream, group),
+		acked:            m.acked.WithLabelValues(stream, group),
+		reclaimed:        m.reclaimed.WithLabelValues(stream, group),
+		timeouts:         m.timeouts.WithLabelValues(stream, group),
+		panics:           m.panics.WithLabelValues(stream, group),
+		trimmed:          m.trimmed.WithLabelValues(stream, group),
+		duplicates:       m.duplicates.WithLabelValues(stream, group),
+		cancelled:        m.cancelled.WithLabelValues(stream, group),
+		expired:          m.expired.WithLabelValues(stream, group),
+		duration:         m.duration.WithLabelValues(stream, group),
+		activeWorkers:    m.activeWorkers.WithLabelValues(stream, group),
+		concurrency:      m.concurrency.WithLabelValues(stream, group),
+		paused:           m.paused.WithLabelValues(stream, group),
+		lockContentions:  m.lockContentions.WithLabelValues(stream, group),
+		lockHold:         m.lockHold.WithLabelValues(stream, group),
+		deadLetters:      m.deadLetters.WithLabelValues(stream, group),
+		deadLetterGrowth: m.deadLetterGrowth.WithLabelValues(stream, group),
+		compressionRatio: m.compressionRatio.WithLabelValues(stream, group),
+		queueWait:        m.queueWait.MustCurryWith(labels),
+		endToEnd:         m.endToEnd.MustCurryWith(labels),
+		readErrors:       m.readErrors.WithLabelValues(stream, group),
+		atMostOnceLost:   m.atMostOnceLost.WithLabelValues(stream, group),
+	}
+}
+
+var (
+	pendingDesc = prometheus.NewDesc("stream_worker_pending_messages",
+		"Entries in the consumer group's pending entries list (XPENDING).", streamLabels, nil)
+	lengthDesc = prometheus.NewDesc("stream_worker_stream_length",
+		"Number of entries in the stream (XLEN).", streamLabels, nil)
+	consumerPendingDesc = prometheus.NewDesc("stream_worker_consumer_pending_messages",
+		"Entries pending per consumer of the group, of any worker (XPENDING).", append(streamLabels, "consumer"), nil)
+	consumerInfoDesc = prometheus.NewDesc("stream_worker_consumer_info",
+		"Consumer name this worker reads the group under, always 1.", append(streamLabels, "consumer"), nil)
+	oldestPendingDesc = prometheus.NewDesc("stream_worker_oldest_pending_age_seconds",
+		"Age of the oldest entry in the consumer group's pending entries list, 0 when it is empty.", streamLabels, nil)
+)
+
+// queueCollector reports the pending entries, age of the oldest one and length
+// of every stream the worker consumes, the pending entries of each consumer and
+// the worker's own consumer names, querying Redis when it is scraped
+type queueCollector struct {
+	w *Worker
+}
+
+// Describe implements prometheus.Collector
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingDesc
+	ch <- oldestPendingDesc
+	ch <- lengthDesc
+	ch <- consumerPendingDesc
+	ch <- consumerInfoDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsQueryTimeout)
+	defer cancel()
+
+	for _, sub := range c.w.streams() {
+		stream, group := c.w.config.key(sub.stream.Name), sub.stream.Group
+		ch <- prometheus.MustNewConstMetric(consumerInfoDesc, prometheus.GaugeValue, 1, stream, group, c.w.consumerName(stream, group))
+		if summary, err := c.w.client.XPending(ctx, stream, group).Result(); err == nil {
+			ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(summary.Count), stream, group)
+			ch <- prometheus.MustNewConstMetric(oldestPendingDesc, prometheus.GaugeValue, pendingAge(summary), stream, group)
+			for consumer, n := range summary.Consumers {
+				ch <- prometheus.MustNewConstMetric(consumerPendingDesc, prometheus.GaugeValue, float64(n), stream, group, consumer)
+			}
+		}
+		if n, err := c.w.client.XLen(ctx, stream).Result(); err == nil {
+			ch <- prometheus.MustNewConstMetric(lengthDesc, prometheus.GaugeValue, float64(n), stream, group)
+		}
+	}
+}
+
+// pendingAge returns the age in seconds of the oldest pending entry, going by
+// the time in its ID
+func pendingAge(summary *redis.XPending) float64 {
+	if summary.Count == 0 {
+		return 0
+	}
+	ms, _ := splitID(summary.Lower)
+	return max(time.Since(time.UnixMilli(int64(ms))).Seconds(), 0)
+}
+
+// counterValue returns the sum of the counters a collector holds
+func counterValue(c prometheus.Collector) int64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var total float64
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return int64(total)
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// ErrUnauthorized fails messages rejected by Authorize. Retrying can't fix
+// them, so they are dead-lettered right away.
+var ErrUnauthorized = errors.New("message not authorized")
+
+// Middleware wraps a handler with behavior of its own, like net/http
+// middleware. It can inspect or change the message and context before calling
+// next, and the result and error after.
+type Middleware func(next Handler) Handler
+
+// Use adds middleware wrapping every handler of the worker, including the
+// fallback set with WithHandler. The first middleware added is the outermost.
+// It must be called before Run.
+func (w *Worker) Use(mw ...Middleware) {
+	w.middleware = append(w.middleware, mw...)
+}
+
+// Use adds middleware wrapping the handlers used for the stream's messages,
+// inside the middleware added with Worker.Use
+func (s *Subscription) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// WithMiddleware wraps the handler of a job type with mw, inside the middleware
+// added with Worker.Use and Subscription.Use
+func WithMiddleware(mw ...Middleware) HandlerOption {
+	return func(r *route) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// Chain combines middleware into one, the first being the outermost
+func Chain(mw ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// LogMessages logs every message handled, with its duration and error
+func LogMessages(logger Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (any, error) {
+			start := time.Now()
+			result, err := next(ctx, msg)
+			args := []any{"message_id", msg.ID, "type", msg.Type, "stream", msg.Stream,
+				"entry_id", msg.EntryID, "duration", time.Since(start)}
+			args = append(args, MetadataFromContext(ctx).logFields()...)
+			if err != nil {
+				logger.Warn("Handler failed", append(args, "error", err)...)
+			} else {
+				logger.Info("Handler succeeded", args...)
+			}
+			return result, err
+		}
+	}
+}
+
+// Observe calls observe with every message handled, its duration and error,
+// e.g. to record metrics of its own
+func Observe(observe func(msg Message, duration time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (any, error) {
+			start := time.Now()
+			result, err := next(ctx, msg)
+			observe(msg, time.Since(start), err)
+			return result, err
+		}
+	}
+}
+
+// Recover turns a panic in the handlers it wraps into an error wrapping
+// ErrHandlerPanic, logging the stack trace to logger, so that outer middleware
+// sees it as a failure. Handlers are always recovered by the worker, this only
+// matters to middleware.
+func Recover(logger Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (result any, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					logger.Error("Handler panicked", "message_id", msg.ID, "entry_id", msg.EntryID,
+						"panic", p, "stack", string(debug.Stack()))
+					result, err = nil, fmt.Errorf("%w: %v", ErrHandlerPanic, p)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout cancels the context of the handlers it wraps after d, failing them
+// with ErrHandlerTimeout if they return an error by then. Unlike WithTimeout
+// and HandlerTimeout it waits for them to return.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (any, error) {
+			handlerCtx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			result, err := next(handlerCtx, msg)
+			if err != nil && ctx.Err() == nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, d, err)
+			}
+			return result, err
+		}
+	}
+}
+
+// Authorize only calls the handlers it wraps for messages check accepts,
+// failing the others with ErrUnauthorized and the error check returned
+func Authorize(check func(ctx context.Context, msg Message) error) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (any, error) {
+			if err := check(ctx, msg); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// wrap returns the handler wrapped with the middleware of the worker, a
+// subscription and a route, in that order
+func wrap(handler Handler, middleware ...[]Middleware) Handler {
+	var all []Middleware
+	for _, mw := range middleware {
+		all = append(all, mw...)
+	}
+	if len(all) == 0 {
+		return handler
+	}
+	return Chain(all...)(handler)
+}
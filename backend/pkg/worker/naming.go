@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultConsumerName is the ConsumerName template used when none is set
+const defaultConsumerName = "{hostname}"
+
+// consumerName returns the name the worker reads stream through group under,
+// expanding the placeholders of ConsumerName: {hostname}, the pod name in
+// Kubernetes, {pid}, {stream} and {group}. It is the same for every call, so
+// pending entries can be traced back to the process owning them.
+func (w *Worker) consumerName(stream, group string) string {
+	template := w.config.ConsumerName
+	if template == "" {
+		template = defaultConsumerName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "worker"
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{stream}", stream,
+		"{group}", group,
+	).Replace(template)
+}
@@ -0,0 +1,77 @@
+package worker
+
+import "github.com/soham901/go-redis-stream-worker/pkg/producer"
+
+// Option configures a Worker
+type Option func(*Worker)
+
+// WithConfig replaces the default configuration
+func WithConfig(config *Config) Option {
+	return func(w *Worker) {
+		w.config = config
+	}
+}
+
+// WithLogger sets the logger used by the worker. Consumers add their own
+// fields, such as worker_id, to every record.
+func WithLogger(logger Logger) Option {
+	return func(w *Worker) {
+		w.logger = logger
+	}
+}
+
+// WithStream sets the stream and consumer group to read from
+func WithStream(stream, group string) Option {
+	return func(w *Worker) {
+		w.config.StreamName = stream
+		w.config.GroupName = group
+	}
+}
+
+// WithConcurrency sets the number of consumers reading from the group
+func WithConcurrency(n int) Option {
+	return func(w *Worker) {
+		w.config.WorkerCount = n
+	}
+}
+
+// WithAutoscaling adapts the number of consumers processing messages at once
+// between minCount and maxCount, starting at the configured WorkerCount
+func WithAutoscaling(minCount, maxCount int) Option {
+	return func(w *Worker) {
+		w.config.MinWorkerCount = minCount
+		w.config.MaxWorkerCount = maxCount
+	}
+}
+
+// WithStatusReporter reports status updates with reporter instead of the
+// backend selected by StatusBackend
+func WithStatusReporter(reporter StatusReporter) Option {
+	return func(w *Worker) {
+		w.statusReporter = reporter
+	}
+}
+
+// WithHandler registers the handler that processes messages
+func WithHandler(handler Handler) Option {
+	return func(w *Worker) {
+		w.handler = handler
+	}
+}
+
+// WithBlobStore reads the bodies that producers stored aside with
+// producer.WithBlobStore from store, instead of the Redis string keys of the
+// worker's client
+func WithBlobStore(store producer.BlobStore) Option {
+	return func(w *Worker) {
+		w.blobs = store
+	}
+}
+
+// WithKeyring decrypts encrypted bodies with the keys of keyring, such as one
+// fetching them from a KMS, instead of those of EncryptionKeys
+func WithKeyring(keyring producer.Keyring) Option {
+	return func(w *Worker) {
+		w.keyring = keyring
+	}
+}
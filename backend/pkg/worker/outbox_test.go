@@ -0,0 +1,56 @@
+package worker_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+func TestOutboxEvent(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.OutboxStream = "events"
+	w := h.Worker()
+	w.Handle("email", func(ctx context.Context, msg worker.Message) (any, error) {
+		return map[string]string{"sent": "yes"}, nil
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("email"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	events, err := h.Client.XRange(context.Background(), "events", "-", "+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Values["id"] != "1" || events[0].Values["result"] != `{"sent":"yes"}` {
+		t.Errorf("got outbox events %v, want the completion of job 1", events)
+	}
+}
+
+func TestOutboxEventUnencodable(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.OutboxStream = "events"
+	var calls atomic.Int32
+	w := h.Worker()
+	w.Handle("email", func(ctx context.Context, msg worker.Message) (any, error) {
+		calls.Add(1)
+		return func() {}, nil
+	})
+	h.Run(w)
+
+	h.Enqueue("{}", producer.WithType("email"), producer.WithID("1"), producer.WithReplyTo("replies"))
+	waitFor(t, "the handler", func() bool { return calls.Load() > 0 })
+	time.Sleep(100 * time.Millisecond)
+
+	if update, _ := h.Statuses.Last("1"); update.Status != "processing" {
+		t.Errorf("last status %s, want processing as the job can't be completed", update.Status)
+	}
+	if n := streamLen(t, h, "replies"); n != 0 {
+		t.Errorf("got %d replies for a job that can't be completed, want none", n)
+	}
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// partitionKey returns the partition key of a message set with
+// producer.WithPartitionKey, or "" if it has none
+func partitionKey(message redis.XMessage) string {
+	key, _ := message.Values[producer.FieldPartitionKey].(string)
+	return key
+}
+
+// partitionFor returns which of n consumers owns the partition key
+func partitionFor(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// process processes a delivery unless ctx is done. Messages with a partition
+// key that fail are retried here once their backoff elapsed, instead of by
+// the reader, so the later messages of their partition wait for them and are
+// processed in order.
+func (c *consumer) process(ctx, handlerCtx context.Context, d delivery) {
+	for ctx.Err() == nil {
+		c.processMessage(handlerCtx, d)
+
+		policy, retrying := c.router.retries.Load(d.message.ID)
+		if !retrying || partitionKey(d.message) == "" {
+			return
+		}
+
+		// Wait for the backoff, leaving the message to the reader if the worker
+		// shuts down meanwhile
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policy.(RetryPolicy).delay(d.message.ID, d.attempt)):
+		}
+		if !c.limiter.wait(ctx) {
+			return
+		}
+		if c.pause.isPaused() {
+			// Left to the reader, which retries it once the group is resumed
+			return
+		}
+
+		// Claiming the entry again bumps its delivery count like the reader's retries
+		messages, err := c.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.name,
+			Messages: []string{d.message.ID},
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("Error claiming message for retry", "entry_id", d.message.ID, "error", err)
+			}
+			return
+		}
+		c.router.retries.Delete(d.message.ID)
+		if len(messages) == 0 {
+			// Acknowledged or deleted meanwhile
+			return
+		}
+		d = delivery{message: messages[0], attempt: d.attempt + 1, readAt: time.Now()}
+	}
+}
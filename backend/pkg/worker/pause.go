@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pauseCheckInterval is how often workers read the paused flag of their
+// groups, in case they missed a control message
+const pauseCheckInterval = 30 * time.Second
+
+// Control messages published on the control channel of a group
+const (
+	controlPause  = "pause"
+	controlResume = "resume"
+)
+
+// pausedKey returns the key flagging the consumer group as paused
+func (c *Config) pausedKey() string {
+	return c.key(c.StreamName + ":" + c.GroupName + ":paused")
+}
+
+// controlChannel returns the pub/sub channel telling the workers of the
+// consumer group to pause or resume
+func (c *Config) controlChannel() string {
+	return c.key(c.StreamName + ":" + c.GroupName + ":control")
+}
+
+// Pause stops every worker of the group from reading new messages until Resume
+// is called. Workers finish the messages they already read. The flag is kept in
+// Redis, so workers started meanwhile start paused.
+func (i *Inspector) Pause(ctx context.Context) error {
+	if err := i.client.Set(ctx, i.config.pausedKey(), time.Now().UTC().Format(time.RFC3339), 0).Err(); err != nil {
+		return err
+	}
+	return i.client.Publish(ctx, i.config.controlChannel(), controlPause).Err()
+}
+
+// Resume lets the workers of the group read messages again after Pause
+func (i *Inspector) Resume(ctx context.Context) error {
+	if err := i.client.Del(ctx, i.config.pausedKey()).Err(); err != nil {
+		return err
+	}
+	return i.client.Publish(ctx, i.config.controlChannel(), controlResume).Err()
+}
+
+// Paused reports whether the group is paused
+func (i *Inspector) Paused(ctx context.Context) (bool, error) {
+	n, err := i.client.Exists(ctx, i.config.pausedKey()).Result()
+	return n > 0, err
+}
+
+// pauseState is whether a group is paused, as last read from Redis
+type pauseState struct {
+	mu      sync.Mutex
+	paused  bool
+	changed chan struct{} // closed when paused changes
+}
+
+// newPauseState creates the state of a group that isn't paused
+func newPauseState() *pauseState {
+	return &pauseState{changed: make(chan struct{})}
+}
+
+// isPaused reports whether the group is paused
+func (p *pauseState) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// set records whether the group is paused, reporting whether it changed
+func (p *pauseState) set(paused bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == paused {
+		return false
+	}
+	p.paused = paused
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return true
+}
+
+// wait blocks while the group is paused. It returns false if ctx is done
+// first.
+func (p *pauseState) wait(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		paused, changed := p.paused, p.changed
+		p.mu.Unlock()
+		if !paused {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// runControl keeps the member's pause state in sync with Redis, reading the
+// paused flag on every control message and every pauseCheckInterval, until
+// ctx is done
+func (w *Worker) runControl(ctx context.Context, m groupMember) {
+	pubsub := m.client.Subscribe(ctx, m.config.controlChannel())
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	ticker := time.NewTicker(pauseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		syncPause(ctx, m)
+		select {
+		case <-ctx.Done():
+			return
+		case <-messages:
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncPause reads the paused flag of the member's group into its pause state
+func syncPause(ctx context.Context, m groupMember) {
+	paused, err := newInspector(m.client, m.config).Paused(ctx)
+	switch {
+	case err != nil:
+		if ctx.Err() == nil {
+			m.logger.Error("Error reading whether the group is paused", "error", err)
+		}
+	case !m.pause.set(paused):
+	case paused:
+		m.metrics.paused.Set(1)
+		m.logger.Info("Consumption paused, finishing messages already read")
+	default:
+		m.metrics.paused.Set(0)
+		m.logger.Info("Consumption resumed")
+	}
+}
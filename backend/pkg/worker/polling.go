@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadProfile presets how the readers fetch messages, for latency or for
+// throughput
+type ReadProfile string
+
+const (
+	// ReadProfileLowLatency reads one message at a time, so that every
+	// message goes to the first idle consumer instead of waiting behind a
+	// batch, and blocks for 1s, so that shutdown is noticed quickly
+	ReadProfileLowLatency ReadProfile = "low-latency"
+
+	// ReadProfileThroughput reads up to 500 messages at a time, blocking for
+	// 5s, and acknowledges them in batches of 200, trading latency and a
+	// wider window for duplicates after a crash for fewer round trips
+	ReadProfileThroughput ReadProfile = "throughput"
+)
+
+// ApplyReadProfile sets the read settings of the profile: ReadBlock,
+// ReadCount and the batching of acks. LoadConfig applies READ_PROFILE before
+// the variables of those settings, which take precedence over it.
+func (c *Config) ApplyReadProfile(profile ReadProfile) error {
+	switch profile {
+	case "":
+	case ReadProfileLowLatency:
+		c.ReadBlock = time.Second
+		c.ReadCount = 1
+		c.AckBatchSize = 0
+	case ReadProfileThroughput:
+		c.ReadBlock = 5 * time.Second
+		c.ReadCount = 500
+		c.AckBatchSize = 200
+		c.AckBatchInterval = 50 * time.Millisecond
+	default:
+		return fmt.Errorf("unknown profile %q, expected low-latency or throughput", profile)
+	}
+	c.ReadProfile = profile
+	return nil
+}
+
+// readCount returns how many messages a read asks for at most
+func (c *Config) readCount() int {
+	if c.ReadCount > 0 {
+		return c.ReadCount
+	}
+	return c.BatchSize
+}
+
+// idlePollDelay returns how long a reader that doesn't block waits before
+// polling again after the given number of reads in a row that found nothing:
+// IdlePollDelay doubled with every such read up to IdlePollMaxDelay
+func (c *Config) idlePollDelay(idle int) time.Duration {
+	delay := c.IdlePollDelay
+	for i := 1; i < idle && delay < c.IdlePollMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, c.IdlePollMaxDelay)
+}
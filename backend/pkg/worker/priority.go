@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// prioritized reports whether several streams are consumed by one pool picking
+// them by priority instead of each having its own consumers
+func (w *Worker) prioritized(streams []*Subscription) bool {
+	if len(streams) < 2 {
+		return false
+	}
+	if w.config.strictPriority() {
+		return true
+	}
+	for _, sub := range streams {
+		if sub.stream.Weight != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// startPool starts WorkerCount consumers shared by queues, or MaxWorkerCount
+// of them with one autoscaler when autoscaling. Each takes its next
+// message from the queue with the highest weight that has one with
+// StrictPriority, or else from a queue picked in proportion to the weights, so
+// that no stream starves the others while all have messages. It returns the
+// autoscaler of the pool.
+func (w *Worker) startPool(ctx, handlerCtx context.Context, wg *sync.WaitGroup, queues []*queue) *autoscaler {
+	strict := w.config.strictPriority()
+	if strict {
+		queues = slices.Clone(queues)
+		slices.SortStableFunc(queues, func(a, b *queue) int {
+			return cmp.Compare(b.weight, a.weight)
+		})
+	}
+	now := time.Now().UnixNano()
+	for _, q := range queues {
+		q.served.Store(now)
+	}
+
+	scaler := w.newAutoscaler(queues, func(scaler *autoscaler, id int) {
+		if ctx.Err() != nil {
+			return
+		}
+		consumers := make([]*consumer, len(queues))
+		for j, q := range queues {
+			consumers[j] = w.newConsumer(q, id)
+			consumers[j].scaler = scaler
+		}
+		p := &poolConsumer{queues: queues, consumers: consumers, strict: strict, aging: w.config.PriorityAging, scaler: scaler}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(ctx, handlerCtx)
+		}()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scaler.run(ctx, w.config.AutoscaleInterval)
+	}()
+	return scaler
+}
+
+// poolConsumer processes the messages of several queues, holding a consumer
+// for each of them
+type poolConsumer struct {
+	queues    []*queue
+	consumers []*consumer
+	strict    bool
+	aging     time.Duration // zero for no aging
+	closed    []bool
+	scaler    *autoscaler
+}
+
+// run processes deliveries until ctx is done or the readers of all queues stop
+func (p *poolConsumer) run(ctx, handlerCtx context.Context) {
+	p.closed = make([]bool, len(p.queues))
+	logger := p.consumers[0].logger
+	logger.Info("Starting worker")
+	p.consumers[0].active.Add(1)
+	for _, c := range p.consumers {
+		c.metrics.activeWorkers.Inc()
+	}
+	defer func() {
+		p.consumers[0].active.Add(-1)
+		for _, c := range p.consumers {
+			c.metrics.activeWorkers.Dec()
+		}
+		logger.Info("Worker shutting down")
+	}()
+
+	for p.scaler.acquire(ctx) {
+		i, d, ok := p.next(ctx)
+		if !ok {
+			p.scaler.release()
+			return
+		}
+		p.queues[i].served.Store(time.Now().UnixNano())
+		c := p.consumers[i]
+		c.process(ctx, handlerCtx, d)
+		c.inFlight.Delete(d.message.ID)
+		p.scaler.release()
+	}
+}
+
+// next returns the next delivery and the index of its queue, trying the
+// queues in order of priority before waiting for any of them, and the
+// consumer's partition of each queue before its shared messages. It returns
+// false once ctx is done or all queues are closed.
+func (p *poolConsumer) next(ctx context.Context) (int, delivery, bool) {
+	for {
+		if ctx.Err() != nil {
+			return 0, delivery{}, false
+		}
+		for _, i := range p.order() {
+			if d, ok := p.receive(i, true); ok {
+				return i, d, true
+			}
+		}
+
+		// Nothing is ready: wait for the first message of any queue
+		cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+		var indexes []int
+		for i, q := range p.queues {
+			if !p.closed[i] {
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.consumers[i].partition)},
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.deliveries)})
+				indexes = append(indexes, i)
+			}
+		}
+		if len(indexes) == 0 {
+			return 0, delivery{}, false
+		}
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 0 {
+			return 0, delivery{}, false
+		}
+		i := indexes[(chosen-1)/2]
+		if !ok {
+			p.closeChannel(i, chosen%2 == 1)
+			continue
+		}
+		return i, value.Interface().(delivery), true
+	}
+}
+
+// receive returns the next delivery of queue i if one is ready, from the
+// consumer's partition first
+func (p *poolConsumer) receive(i int, partition bool) (delivery, bool) {
+	if p.closed[i] {
+		return delivery{}, false
+	}
+	ch := p.queues[i].deliveries
+	if partition {
+		ch = p.consumers[i].partition
+	}
+	select {
+	case d, ok := <-ch:
+		if ok {
+			return d, true
+		}
+		p.closeChannel(i, partition)
+	default:
+	}
+	if partition {
+		return p.receive(i, false)
+	}
+	return delivery{}, false
+}
+
+// closeChannel records that the partition or shared channel of queue i was
+// closed by its reader, the queue being closed with the latter
+func (p *poolConsumer) closeChannel(i int, partition bool) {
+	if partition {
+		p.consumers[i].partition = nil
+	} else {
+		p.closed[i] = true
+	}
+}
+
+// order returns the indexes of the open queues in the order they are tried:
+// by weight with StrictPriority, or else shuffled so that each comes first in
+// proportion to its weight
+func (p *poolConsumer) order() []int {
+	var order []int
+	total := 0
+	for i, q := range p.queues {
+		if !p.closed[i] {
+			order = append(order, i)
+			total += q.weight
+		}
+	}
+	if p.strict {
+		return p.age(order)
+	}
+
+	// Weighted sampling without replacement
+	for n := 0; n < len(order)-1; n++ {
+		pick := rand.IntN(total)
+		for k := n; k < len(order); k++ {
+			weight := p.queues[order[k]].weight
+			if pick < weight {
+				order[n], order[k] = order[k], order[n]
+				total -= weight
+				break
+			}
+			pick -= weight
+		}
+	}
+	return order
+}
+
+// age moves the queues that have messages waiting but weren't served for
+// longer than the aging period to the front of a strict order, the longest
+// waiting first, so that lower priority queues still get a message now and
+// then while higher ones are busy
+func (p *poolConsumer) age(order []int) []int {
+	if p.aging <= 0 {
+		return order
+	}
+	cutoff := time.Now().Add(-p.aging).UnixNano()
+	var starved []int
+	for _, i := range order {
+		q := p.queues[i]
+		waiting := len(q.deliveries) > 0 || len(p.consumers[i].partition) > 0
+		if waiting && q.served.Load() < cutoff {
+			starved = append(starved, i)
+		}
+	}
+	if len(starved) == 0 {
+		return order
+	}
+	slices.SortStableFunc(starved, func(a, b int) int {
+		return cmp.Compare(p.queues[a].served.Load(), p.queues[b].served.Load())
+	})
+	rest := slices.DeleteFunc(order, func(i int) bool {
+		return slices.Contains(starved, i)
+	})
+	return append(starved, rest...)
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Progress reports how far the handler got with the message, as a
+// percentage from 0 to 100 and an optional description of the current step.
+// It sends a processing status update carrying them to the job store and the
+// status backend, for UIs to show a progress bar. Calls within
+// ProgressInterval of the last update sent are dropped, except those reaching
+// 100, and calls after the handler returned are ignored.
+func (m Message) Progress(pct int, message string) {
+	if m.progress != nil {
+		m.progress.report(pct, message)
+	}
+}
+
+// progressReporter sends the progress updates of one handler run
+type progressReporter struct {
+	c        *consumer
+	ctx      context.Context // carries the message span
+	id       string
+	attempt  int
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	done bool
+}
+
+// newProgressReporter creates the progress reporter of a run of the handler
+// of job id
+func (c *consumer) newProgressReporter(ctx context.Context, id string, attempt int) *progressReporter {
+	return &progressReporter{c: c, ctx: ctx, id: id, attempt: attempt, interval: c.config.ProgressInterval}
+}
+
+// report sends a progress update unless it is throttled
+func (p *progressReporter) report(pct int, message string) {
+	pct = min(max(pct, 0), 100)
+
+	// Updates are sent with the lock held so they can't be reported after
+	// the final status of the job
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.done || (pct < 100 && now.Sub(p.last) < p.interval) {
+		return
+	}
+	p.last = now
+
+	update := StatusUpdate{ID: p.id, Status: "processing", Attempt: p.attempt, Progress: pct, ProgressMessage: message}
+	if err := p.c.updateStatus(p.ctx, update); err != nil {
+		p.c.logger.Warn("Failed to report progress", "message_id", p.id, "progress", pct, "error", err)
+	}
+}
+
+// stop ignores the progress reported from now on, once the handler returned
+func (p *progressReporter) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+}
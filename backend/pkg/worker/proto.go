@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"mime"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// RegisterProtoHandler registers fn for messages whose type field is jobType,
+// decoding the body into a T before calling it: protobuf bodies from their
+// binary format and others, such as those of producers sending JSON, with
+// protojson, so that both can share a stream. Bodies that can't be decoded,
+// or are protobuf messages of another type, fail with ErrInvalidPayload. It
+// must be called before Run.
+func RegisterProtoHandler[T proto.Message](w HandlerRegistry, jobType string, fn func(ctx context.Context, payload T) (any, error), opts ...HandlerOption) {
+	var zero T
+	messageType := zero.ProtoReflect().Type()
+	w.Handle(jobType, func(ctx context.Context, msg Message) (any, error) {
+		payload, err := decodeProto(msg, messageType)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, payload.(T))
+	}, opts...)
+}
+
+// Proto decodes a protobuf body into a message of the type named by its
+// proto_type field, looked up among the generated types linked into the
+// program. Bodies that aren't protobuf, or of an unknown type, fail with
+// ErrInvalidPayload.
+func (m Message) Proto() (proto.Message, error) {
+	if !m.isProto() {
+		return nil, fmt.Errorf("%w: body isn't protobuf", ErrInvalidPayload)
+	}
+	name, _ := m.Values[producer.FieldProtoType].(string)
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown protobuf type %q", ErrInvalidPayload, name)
+	}
+	return decodeProto(m, messageType)
+}
+
+// decodeProto decodes the body of m into a message of messageType, from the
+// protobuf binary format or JSON depending on its content type
+func decodeProto(m Message, messageType protoreflect.MessageType) (proto.Message, error) {
+	payload := messageType.New().Interface()
+	if !m.isProto() {
+		if err := protojson.Unmarshal([]byte(m.Body), payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		return payload, nil
+	}
+
+	want := messageType.Descriptor().FullName()
+	if name, _ := m.Values[producer.FieldProtoType].(string); name != "" && protoreflect.FullName(name) != want {
+		return nil, fmt.Errorf("%w: body is a %s, not a %s", ErrInvalidPayload, name, want)
+	}
+	if err := proto.Unmarshal([]byte(m.Body), payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return payload, nil
+}
+
+// isProto reports whether the body is in the protobuf binary format, going by
+// the content type of the entry or of its CloudEvent
+func (m Message) isProto() bool {
+	contentType, _ := m.Values[producer.FieldContentType].(string)
+	if contentType == "" {
+		contentType, _ = m.Values[producer.CloudEventPrefix+"datacontenttype"].(string)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == producer.ContentTypeProtobuf || mediaType == "application/protobuf"
+}
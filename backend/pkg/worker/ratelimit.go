@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled with rate tokens per second, holding
+// up to burst of them. A nil rateLimiter, or one without a positive rate,
+// doesn't limit anything.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a full bucket allowing rate messages per second, or
+// returns nil if rate isn't positive. burst defaults to a second's worth of
+// tokens.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// set changes the rate and burst of the bucket, as newRateLimiter takes them,
+// keeping the tokens it holds up to the new burst. A rate that isn't positive
+// disables the limit.
+func (l *rateLimiter) set(rate float64, burst int) {
+	if l == nil {
+		return
+	}
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 {
+		l.refill()
+		l.tokens = min(l.tokens, float64(burst))
+	} else {
+		// Start with a full bucket, like newRateLimiter
+		l.tokens = float64(burst)
+	}
+	l.rate, l.burst, l.last = rate, float64(burst), time.Now()
+}
+
+// take waits until a token is available and takes up to n of them, returning
+// how many it took, or 0 if ctx is done first
+func (l *rateLimiter) take(ctx context.Context, n int) int {
+	if l == nil {
+		return n
+	}
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return n
+		}
+		l.refill()
+		if l.tokens >= 1 {
+			taken := min(n, int(l.tokens))
+			l.tokens -= float64(taken)
+			l.mu.Unlock()
+			return taken
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(wait):
+		}
+	}
+}
+
+// wait takes a single token, waiting for one if needed. It returns false if
+// ctx is done first.
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	return l.take(ctx, 1) == 1
+}
+
+// putBack returns n tokens that were taken but not used
+func (l *rateLimiter) putBack(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens = min(l.burst, l.tokens+float64(n))
+}
+
+// refill adds the tokens accrued since the last refill, l.mu being held
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
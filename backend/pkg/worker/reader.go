@@ -0,0 +1,207 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reader fetches batches of messages from the stream and hands them to the
+// consumers. It is the only goroutine reading from the group, so every entry
+// in its pending list is either in inFlight or waiting for a retry.
+type reader struct {
+	groupMember
+	deliveries chan<- delivery
+	partitions []chan<- delivery
+	inFlight   *sync.Map
+
+	// reads is the dedicated connection XREADGROUP blocks on, with
+	// RedisDedicatedReaders, or nil to use the shared client
+	reads redis.UniversalClient
+
+	// failing is set while reads fail, holding off the retries
+	failing atomic.Bool
+}
+
+// run reads from the group until ctx is done, retrying failed messages
+// alongside with runRetries, then closes the deliveries and partition
+// channels
+func (r *reader) run(ctx context.Context) {
+	retryCtx, stopRetries := context.WithCancel(ctx)
+	var retries sync.WaitGroup
+	startRetries := sync.OnceFunc(func() {
+		retries.Add(1)
+		go func() {
+			defer retries.Done()
+			r.runRetries(retryCtx)
+		}()
+	})
+	defer func() {
+		stopRetries()
+		retries.Wait()
+		if r.reads != nil {
+			r.reads.Close()
+		}
+		close(r.deliveries)
+		for _, partition := range r.partitions {
+			close(partition)
+		}
+	}()
+
+	// Consecutive reads that failed with a Redis error, and that found no
+	// message while polling
+	failures, idle := 0, 0
+
+	// Whether the entries left pending by a previous run are still to be read
+	recovering := r.config.RecoverPending
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Stop reading new messages while a maintenance window is active
+		if !r.waitForMaintenance(ctx) {
+			return
+		}
+
+		// Or while an operator paused the group
+		if !r.pause.wait(ctx) {
+			return
+		}
+
+		// Or while the status API is down, if configured to
+		if !r.waitForStatusAPI(ctx) {
+			return
+		}
+
+		// Or while a downstream the handlers need is unhealthy
+		if !r.unhealthy.wait(ctx) {
+			return
+		}
+
+		// Process what a previous run left pending before any new message or
+		// retry
+		if recovering {
+			recovering = false
+			if !r.recoverPending(ctx) {
+				return
+			}
+			continue
+		}
+		startRetries()
+
+		block := r.config.ReadBlock
+		if block == 0 {
+			// Poll, without BLOCK
+			block = -1
+		}
+
+		// Take a token per message to read, waiting for the rate limit instead
+		// of reading messages that would have to wait for their turn
+		count := r.limiter.take(ctx, r.config.readCount())
+		if count == 0 {
+			continue
+		}
+
+		// Read a batch of new messages from the group
+		streams, err := r.readClient().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.group,
+			Consumer: r.name,
+			Streams:  []string{r.stream, ">"},
+			Count:    int64(count),
+			Block:    block,
+		}).Result()
+		read := 0
+		for _, stream := range streams {
+			read += len(stream.Messages)
+		}
+		r.limiter.putBack(count - read)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				// Shutting down
+				continue
+			}
+			if isFailoverError(err) && r.waitForFailover(ctx, err) {
+				// New master is up, read again right away
+				continue
+			}
+			if err == redis.Nil {
+				// Block timed out without new messages
+				failures = 0
+				r.failing.Store(false)
+				r.connection.succeeded(r.stream)
+				if r.config.ReadBlock == 0 {
+					// Or the poll found none, wait before the next one
+					idle++
+					select {
+					case <-time.After(r.config.idlePollDelay(idle)):
+					case <-ctx.Done():
+					}
+				}
+				continue
+			}
+
+			// Back off, logging the first failure and changes of the state
+			// of Redis rather than every attempt
+			failures++
+			r.failing.Store(true)
+			r.metrics.readErrors.Inc()
+			r.connection.failed(ctx, r.readClient(), r.stream, err)
+			delay := r.config.readBackoff(failures)
+			if failures == 1 {
+				r.logger.Warn("Error reading group", "error", err, "retry_in", delay)
+			} else {
+				r.logger.Debug("Error reading group", "error", err, "failures", failures, "retry_in", delay)
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		failures, idle = 0, 0
+		r.failing.Store(false)
+		r.connection.succeeded(r.stream)
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				if !r.dispatch(ctx, message, 1) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readClient returns the client XREADGROUP blocks on
+func (r *reader) readClient() redis.UniversalClient {
+	if r.reads != nil {
+		return r.reads
+	}
+	return r.client
+}
+
+// dispatch hands a message to the consumers, blocking while they are all busy
+// and the channel is full. Messages with a partition key go to the consumer
+// owning the partition. It returns false if ctx is done first, leaving the
+// message pending.
+func (r *reader) dispatch(ctx context.Context, message redis.XMessage, attempt int) bool {
+	deliveries := r.deliveries
+	if key := partitionKey(message); key != "" && len(r.partitions) > 0 {
+		deliveries = r.partitions[partitionFor(key, len(r.partitions))]
+	}
+
+	r.inFlight.Store(message.ID, struct{}{})
+	select {
+	case deliveries <- delivery{message: message, attempt: attempt, readAt: time.Now()}:
+		return true
+	case <-ctx.Done():
+		r.inFlight.Delete(message.ID)
+		return false
+	}
+}
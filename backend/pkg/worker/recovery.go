@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// recoverPending hands the entries a previous run of the consumer left in its
+// pending entries list to the consumers, reading them from ID 0 a batch at a
+// time before any new message is read. Entries deleted from the stream since
+// are acknowledged, nothing being left of them to process. Entries it fails
+// to read are left to retryPending. It returns false if ctx is done first.
+func (r *reader) recoverPending(ctx context.Context) bool {
+	start := "0"
+	recovered, deleted := 0, 0
+	for {
+		count := r.limiter.take(ctx, r.config.readCount())
+		if count == 0 {
+			return false
+		}
+		streams, err := r.readClient().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.group,
+			Consumer: r.name,
+			Streams:  []string{r.stream, start},
+			Count:    int64(count),
+			Block:    -1,
+		}).Result()
+		var messages []redis.XMessage
+		for _, stream := range streams {
+			messages = append(messages, stream.Messages...)
+		}
+		r.limiter.putBack(count - len(messages))
+		if err != nil && err != redis.Nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			r.logger.Error("Error recovering pending messages, leaving them to retries", "error", err)
+			break
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		attempts := r.deliveryCounts(ctx, messages)
+		var gone []string
+		for _, message := range messages {
+			start = message.ID
+			if len(message.Values) == 0 {
+				gone = append(gone, message.ID)
+				continue
+			}
+			if !r.dispatch(ctx, message, max(attempts[message.ID], 1)) {
+				return false
+			}
+			recovered++
+		}
+		if len(gone) > 0 {
+			if err := r.client.XAck(ctx, r.stream, r.group, gone...).Err(); err != nil {
+				r.logger.Error("Error acknowledging deleted pending messages", "entries", len(gone), "error", err)
+			} else {
+				deleted += len(gone)
+			}
+		}
+	}
+
+	if recovered > 0 || deleted > 0 {
+		r.logger.Info("Recovered pending messages of the previous run", "recovered", recovered, "deleted", deleted)
+	}
+	return true
+}
+
+// deliveryCounts returns how many times each of messages, just read from the
+// pending entries list, has been delivered, as the attempt to process it with.
+// Entries missing from the result are treated as a first attempt.
+func (r *reader) deliveryCounts(ctx context.Context, messages []redis.XMessage) map[string]int {
+	pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   r.stream,
+		Group:    r.group,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)),
+		Consumer: r.name,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		r.logger.Warn("Error reading delivery counts of pending messages", "error", err)
+	}
+	counts := make(map[string]int, len(pending))
+	for _, entry := range pending {
+		counts[entry.ID] = int(entry.RetryCount)
+	}
+	return counts
+}
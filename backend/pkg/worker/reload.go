@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// reloadable are the settings Reload applies to a running worker
+var reloadable = map[string]bool{
+	"WorkerCount":    true,
+	"MinWorkerCount": true,
+	"MaxWorkerCount": true,
+	"RateLimit":      true,
+	"RateBurst":      true,
+	"MaxRetries":     true,
+	"BaseDelay":      true,
+	"MaxDelay":       true,
+	"RetryBackoff":   true,
+	"LogLevel":       true,
+}
+
+// reloadState holds what Reload changes while Run runs
+type reloadState struct {
+	mu      sync.Mutex
+	config  *Config // configuration running, nil before Run
+	limiter *rateLimiter
+	scalers []*autoscaler
+
+	// worker-wide retry policy, read by the routers of all streams
+	retry atomic.Pointer[RetryPolicy]
+}
+
+// start records the configuration and rate limiter Run starts with
+func (s *reloadState) start(config *Config, limiter *rateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.limiter = limiter
+	policy := config.retryPolicy()
+	s.retry.Store(&policy)
+}
+
+// addScaler registers the concurrency limiter of a set of consumers
+func (s *reloadState) addScaler(scaler *autoscaler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scalers = append(s.scalers, scaler)
+}
+
+// removeScaler forgets the concurrency limiter of consumers that stopped
+func (s *reloadState) removeScaler(scaler *autoscaler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scalers = slices.DeleteFunc(s.scalers, func(other *autoscaler) bool {
+		return other == scaler
+	})
+}
+
+// Reload applies the tunables of config to the running worker: WorkerCount,
+// or MinWorkerCount and MaxWorkerCount when autoscaling, the rate limit, the
+// worker-wide retry policy and, with a logger created by NewLogger from the
+// configuration the worker was started with, the log level. Consumers are
+// started when more may run at once, and stop taking messages when fewer may.
+// Other settings only take effect after a restart, and are logged as such
+// when they differ, along with the settings that changed.
+func (w *Worker) Reload(config *Config) error {
+	if config.WorkerCount < 1 {
+		return fmt.Errorf("invalid WorkerCount %d, must be at least 1", config.WorkerCount)
+	}
+	if config.autoscaling() && config.MinWorkerCount > config.MaxWorkerCount {
+		return fmt.Errorf("MinWorkerCount %d exceeds MaxWorkerCount %d", config.MinWorkerCount, config.MaxWorkerCount)
+	}
+
+	w.reload.mu.Lock()
+	defer w.reload.mu.Unlock()
+	running := w.reload.config
+	if running == nil {
+		return ErrNotRunning
+	}
+
+	// The running configuration with the tunables of config
+	applied := *running
+	var changes, restart []string
+	for _, change := range configChanges(running, config) {
+		if !reloadable[change.field] {
+			restart = append(restart, change.field)
+			continue
+		}
+		// Switching autoscaling on or off changes how many consumers run
+		if running.autoscaling() != config.autoscaling() && (change.field == "WorkerCount" || change.field == "MinWorkerCount" || change.field == "MaxWorkerCount") {
+			restart = append(restart, change.field)
+			continue
+		}
+		reflect.ValueOf(&applied).Elem().FieldByName(change.field).Set(reflect.ValueOf(config).Elem().FieldByName(change.field))
+		changes = append(changes, change.String())
+	}
+
+	policy := applied.retryPolicy()
+	w.reload.retry.Store(&policy)
+	w.reload.limiter.set(applied.RateLimit, applied.RateBurst)
+	for _, scaler := range w.reload.scalers {
+		scaler.resize(&applied)
+	}
+	if w.config.logLevel != nil {
+		w.config.logLevel.Set(applied.LogLevel)
+	}
+	w.reload.config = &applied
+
+	if len(changes) == 0 {
+		w.logger.Info("Configuration reloaded, no changes to apply")
+	} else {
+		w.logger.Info("Configuration reloaded", "changes", changes)
+	}
+	if len(restart) > 0 {
+		w.logger.Warn("Changed settings only take effect after a restart", "settings", restart)
+	}
+	return nil
+}
+
+// configChange is a setting whose value differs between two configurations
+type configChange struct {
+	field    string
+	from, to any
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.field, c.from, c.to)
+}
+
+// configChanges returns the exported fields that differ between old and new,
+// with secrets masked
+func configChanges(old, new *Config) []configChange {
+	before, after := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	redactedBefore, redactedAfter := reflect.ValueOf(old.Redacted()).Elem(), reflect.ValueOf(new.Redacted()).Elem()
+	var changes []configChange
+	for i := 0; i < before.NumField(); i++ {
+		field := before.Type().Field(i)
+		if !field.IsExported() || reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			continue
+		}
+		changes = append(changes, configChange{
+			field: field.Name,
+			from:  redactedBefore.Field(i).Interface(),
+			to:    redactedAfter.Field(i).Interface(),
+		})
+	}
+	return changes
+}
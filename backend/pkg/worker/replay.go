@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// replayBatch is how many entries Replay copies per round trip
+const replayBatch = 100
+
+// ErrGroupExists is returned by ReplayIntoGroup when the group already exists
+var ErrGroupExists = errors.New("consumer group already exists")
+
+// Replay adds copies of the entries of the stream from start to end, entry
+// IDs or "-" and "+", to the end of the stream, so that the group processes
+// them again, e.g. after a bug in their handler was fixed. Up to count entries
+// are copied, all of them if count is 0, and entries added during the replay
+// aren't. The copies keep the job IDs of the originals, whose status they
+// update, but not their idempotency keys, so that deduplication doesn't skip
+// them. It returns how many entries were copied, which is less than asked on
+// errors, the entries copied before staying in the stream.
+func (i *Inspector) Replay(ctx context.Context, start, end string, count int64) (int64, error) {
+	stream := i.config.StreamName
+	if end == "+" || end == "" {
+		// Stop at the current last entry rather than running into the copies
+		last, err := i.client.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+		if err != nil {
+			return 0, err
+		}
+		if len(last) == 0 {
+			return 0, nil
+		}
+		end = last[0].ID
+	}
+	if start == "" {
+		start = "-"
+	}
+
+	var copied int64
+	for count <= 0 || copied < count {
+		batch := int64(replayBatch)
+		if count > 0 {
+			batch = min(batch, count-copied)
+		}
+		messages, err := i.client.XRangeN(ctx, stream, start, end, batch).Result()
+		if err != nil {
+			return copied, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		_, err = i.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, message := range messages {
+				values := make(map[string]any, len(message.Values))
+				for k, v := range message.Values {
+					if k != producer.FieldIdempotencyKey {
+						values[k] = v
+					}
+				}
+				pipe.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: values})
+			}
+			return nil
+		})
+		if err != nil {
+			return copied, err
+		}
+		copied += int64(len(messages))
+		start = "(" + messages[len(messages)-1].ID
+	}
+	return copied, nil
+}
+
+// ReplayIntoGroup creates group on the stream right before start, an entry ID,
+// so that workers reading the stream with it process the entries from start
+// on without copying them or disturbing the other groups. It returns the ID
+// the group was created at, or ErrGroupExists if it already exists.
+func (i *Inspector) ReplayIntoGroup(ctx context.Context, group, start string) (string, error) {
+	at := "0"
+	if start != "" && start != "-" {
+		var err error
+		if at, err = previousID(start); err != nil {
+			return "", err
+		}
+	}
+	err := i.client.XGroupCreateMkStream(ctx, i.config.StreamName, group, at).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return "", fmt.Errorf("%w: %s", ErrGroupExists, group)
+	}
+	if err != nil {
+		return "", err
+	}
+	return at, nil
+}
+
+// previousID returns the greatest entry ID lower than id, which may omit its
+// sequence number
+func previousID(id string) (string, error) {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid entry ID %q", id)
+	}
+	var seq uint64
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid entry ID %q", id)
+		}
+	}
+	switch {
+	case seq > 0:
+		return fmt.Sprintf("%d-%d", ms, seq-1), nil
+	case ms > 0:
+		return fmt.Sprintf("%d-%d", ms-1, uint64(math.MaxUint64)), nil
+	default:
+		return "0", nil
+	}
+}
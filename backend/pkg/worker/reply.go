@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// replyTimeout bounds the publication of a reply
+const replyTimeout = 5 * time.Second
+
+// reply adds the outcome of a job to the reply stream named in its reply_to
+// field, if any, for the producer waiting on it. The stream expires ReplyTTL
+// after the reply, so that replies nobody reads don't pile up. handlerErr is
+// nil if the job completed.
+func (c *consumer) reply(message redis.XMessage, messageID string, result any, handlerErr error) {
+	replyTo, _ := message.Values[producer.FieldReplyTo].(string)
+	if replyTo == "" {
+		return
+	}
+
+	values := map[string]any{producer.ReplyFieldID: messageID}
+	if handlerErr != nil {
+		values[producer.ReplyFieldStatus] = "failed"
+		values[producer.ReplyFieldError] = handlerErr.Error()
+	} else {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			c.logger.Warn("Failed to encode reply", "message_id", messageID, "error", err)
+			return
+		}
+		values[producer.ReplyFieldStatus] = "completed"
+		values[producer.ReplyFieldResult] = string(encoded)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: replyTo, Values: values})
+		if c.config.ReplyTTL > 0 {
+			pipe.Expire(ctx, replyTo, c.config.ReplyTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.Warn("Failed to publish reply", "message_id", messageID, "reply_to", replyTo, "error", err)
+	}
+}
@@ -0,0 +1,455 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// Status backends selectable with StatusBackend
+const (
+	StatusBackendHTTP        = "http"
+	StatusBackendRedisHash   = "redis-hash"
+	StatusBackendRedisStream = "redis-stream"
+	StatusBackendPostgres    = "postgres"
+	StatusBackendGRPC        = "grpc"
+	StatusBackendNone        = "none"
+)
+
+// statusTimeout bounds each status update to Redis or Postgres, and requests
+// of the default HTTP client
+const statusTimeout = 5 * time.Second
+
+// StatusReporter records the status updates of jobs, for clients to track
+// them. Updates of a job are reported in order. Reporters are called through
+// the status circuit breaker and outbox, so a failing reporter is retried
+// later.
+type StatusReporter interface {
+	ReportStatus(ctx context.Context, update StatusUpdate) error
+}
+
+// TxStatusReporter is a StatusReporter keeping updates in Redis, which can
+// queue the writes of an update in a MULTI/EXEC transaction instead. The
+// final update of a job that completed is then written in the transaction
+// acknowledging it, so that a job is never acknowledged without its result
+// or the other way around.
+type TxStatusReporter interface {
+	StatusReporter
+	ReportStatusTx(ctx context.Context, tx redisclient.Tx, update StatusUpdate) error
+}
+
+// newStatusReporter creates the reporter selected by StatusBackend, with
+// client for the Redis backends. The Postgres table is created if needed.
+func newStatusReporter(ctx context.Context, config *Config, client redis.UniversalClient) (StatusReporter, error) {
+	switch config.StatusBackend {
+	case "", StatusBackendHTTP:
+		client, err := newHTTPClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return &HTTPStatusReporter{
+			URL:      config.ApiURL + "/update-status",
+			Client:   client,
+			Header:   config.httpHeader(),
+			Username: config.HTTPBasicUser,
+			Password: config.HTTPBasicPassword,
+			Secret:   []byte(config.HTTPSigningSecret),
+			Retry: RetryPolicy{
+				MaxRetries: config.HTTPMaxRetries,
+				BaseDelay:  config.HTTPRetryBaseDelay,
+				MaxDelay:   config.HTTPRetryMaxDelay,
+			},
+		}, nil
+	case StatusBackendRedisHash:
+		return &RedisHashStatusReporter{Client: redisclient.Adapt(client), Prefix: config.key(config.StatusKeyPrefix), TTL: config.StatusTTL}, nil
+	case StatusBackendRedisStream:
+		return &RedisStreamStatusReporter{Client: redisclient.Adapt(client), Stream: config.statusStream(), MaxLen: int64(config.StatusStreamMaxLen)}, nil
+	case StatusBackendPostgres:
+		db, err := sql.Open("postgres", config.StatusPostgresDSN)
+		if err != nil {
+			return nil, fmt.Errorf("error opening status database: %w", err)
+		}
+		r, err := NewPostgresStatusReporter(db, config.StatusTable)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.CreateTable(ctx); err != nil {
+			return nil, fmt.Errorf("error creating status table: %w", err)
+		}
+		return r, nil
+	case StatusBackendGRPC:
+		return dialStatusGRPC(config)
+	case StatusBackendNone:
+		return NopStatusReporter{}, nil
+	}
+	return nil, fmt.Errorf("unknown status backend %q", config.StatusBackend)
+}
+
+// statusStream returns the stream the redis-stream backend adds updates to
+func (c *Config) statusStream() string {
+	if c.StatusStream != "" {
+		return c.key(c.StatusStream)
+	}
+	return c.key(c.StreamName + ":status")
+}
+
+// HTTPStatusReporter posts each update as JSON to URL, propagating the trace
+// context in the request headers. Requests are bounded by the timeout of
+// Client. Header is added to every request, and Username and Password, if
+// set, are sent with basic authentication. With a Secret, requests are signed
+// as described in signRequest. Updates answered with another status code
+// than 200 fail, and those rejected with a 4xx status are not retried.
+// Requests that fail, are rate limited or answered with a 5xx status are
+// retried up to Retry.MaxRetries times, after the delay asked for in a
+// Retry-After header or a backoff. If Retry-After asks for more than
+// Retry.MaxDelay, the update fails at once, to be retried from the outbox.
+type HTTPStatusReporter struct {
+	URL    string
+	Client *http.Client // defaults to a shared client with a 5 second timeout
+	Header http.Header
+
+	Username string
+	Password string
+	Secret   []byte
+
+	Retry RetryPolicy
+}
+
+// client returns the client requests are sent with
+func (r *HTTPStatusReporter) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return defaultHTTPClient
+}
+
+// newRequest creates a request posting body as JSON to url, with the trace
+// context and the credentials of r
+func (r *HTTPStatusReporter) newRequest(ctx context.Context, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	if len(r.Secret) > 0 {
+		signRequest(req, r.Secret, body, time.Now())
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, nil
+}
+
+// ReportStatus implements StatusReporter
+func (r *HTTPStatusReporter) ReportStatus(ctx context.Context, update StatusUpdate) error {
+	jsonData, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("error marshaling status update: %w", err)
+	}
+
+	resp, err := r.post(ctx, r.URL, jsonData)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// post posts body to url, retrying failed requests as set by Retry. It
+// returns the response if the API answered 200, and a *statusCodeError for
+// any other status code.
+func (r *HTTPStatusReporter) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := r.newRequest(ctx, url, body)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := r.client().Do(req)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			err = fmt.Errorf("error updating status: %w", err)
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		default:
+			resp.Body.Close()
+			err = &statusCodeError{code: resp.StatusCode}
+			if !retryableStatus(resp.StatusCode) {
+				return nil, err
+			}
+			wait = retryAfter(resp)
+		}
+
+		if attempt > r.Retry.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			wait = r.Retry.delay(string(body), attempt)
+		} else if wait > r.Retry.MaxDelay {
+			// Leave updates the API wants later to the outbox
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryableStatus reports whether a request answered with code may succeed
+// when sent again: rate limited or failed on the server side
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryAfter returns the delay the Retry-After header of a 429 or 503
+// response asks for, given in seconds or as a date, or 0 if there is none
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// RedisHashStatusReporter keeps the latest status of each job in the hash
+// <Prefix><id>, with the status, result (as JSON), attempt and updated_at
+// fields, expiring TTL after the last update unless TTL is zero. Client must
+// come from NewRedisClient or redisclient.Adapt.
+type RedisHashStatusReporter struct {
+	Client redisclient.Client
+	Prefix string // defaults to "job:"
+	TTL    time.Duration
+}
+
+// ReportStatus implements StatusReporter
+func (r *RedisHashStatusReporter) ReportStatus(ctx context.Context, update StatusUpdate) error {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	client := redisx.Universal(r.Client)
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return r.ReportStatusTx(ctx, redisx.NewTx(pipe), update)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating status: %w", err)
+	}
+	return nil
+}
+
+// ReportStatusTx implements TxStatusReporter
+func (r *RedisHashStatusReporter) ReportStatusTx(ctx context.Context, tx redisclient.Tx, update StatusUpdate) error {
+	values, err := statusValues(update)
+	if err != nil {
+		return err
+	}
+	prefix := r.Prefix
+	if prefix == "" {
+		prefix = "job:"
+	}
+	key := prefix + update.ID
+	args := []any{"HSET", key}
+	for _, field := range slices.Sorted(maps.Keys(values)) {
+		args = append(args, field, values[field])
+	}
+	tx.Do(ctx, args...)
+	if r.TTL > 0 {
+		tx.Do(ctx, "PEXPIRE", key, r.TTL.Milliseconds())
+	}
+	return nil
+}
+
+// RedisStreamStatusReporter adds every update to Stream, with the id, status,
+// result (as JSON), attempt and updated_at fields, trimming it to about MaxLen
+// entries unless MaxLen is zero. Client must come from NewRedisClient or
+// redisclient.Adapt.
+type RedisStreamStatusReporter struct {
+	Client redisclient.Client
+	Stream string
+	MaxLen int64
+}
+
+// ReportStatus implements StatusReporter
+func (r *RedisStreamStatusReporter) ReportStatus(ctx context.Context, update StatusUpdate) error {
+	entry, err := r.entry(update)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	if err := redisx.Universal(r.Client).Do(ctx, entry.Args()...).Err(); err != nil {
+		return fmt.Errorf("error updating status: %w", err)
+	}
+	return nil
+}
+
+// ReportStatusTx implements TxStatusReporter
+func (r *RedisStreamStatusReporter) ReportStatusTx(ctx context.Context, tx redisclient.Tx, update StatusUpdate) error {
+	entry, err := r.entry(update)
+	if err != nil {
+		return err
+	}
+	tx.Do(ctx, entry.Args()...)
+	return nil
+}
+
+// entry builds the entry of an update
+func (r *RedisStreamStatusReporter) entry(update StatusUpdate) (*producer.Entry, error) {
+	values, err := statusValues(update)
+	if err != nil {
+		return nil, err
+	}
+	values["id"] = update.ID
+	return &producer.Entry{Stream: r.Stream, Values: values, MaxLen: r.MaxLen, Approx: true}, nil
+}
+
+// statusValues returns the fields the Redis backends store for an update
+func statusValues(update StatusUpdate) (map[string]any, error) {
+	result, err := json.Marshal(update.Result)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling status result: %w", err)
+	}
+	values := map[string]any{
+		"status":     update.Status,
+		"result":     string(result),
+		"attempt":    update.Attempt,
+		"updated_at": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if update.Progress > 0 || update.ProgressMessage != "" {
+		values["progress"] = update.Progress
+		values["progress_message"] = update.ProgressMessage
+	}
+	if update.CorrelationID != "" {
+		values["correlation_id"] = update.CorrelationID
+	}
+	if update.TraceID != "" {
+		values["trace_id"] = update.TraceID
+	}
+	return values, nil
+}
+
+// tableName matches the table names PostgresStatusReporter accepts, optionally
+// qualified with a schema
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PostgresStatusReporter keeps the latest status of each job in a Postgres
+// table, which CreateTable creates
+type PostgresStatusReporter struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresStatusReporter creates a reporter writing to table, "job_status"
+// if empty, through db, which must use a Postgres driver such as
+// github.com/lib/pq
+func NewPostgresStatusReporter(db *sql.DB, table string) (*PostgresStatusReporter, error) {
+	if table == "" {
+		table = "job_status"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid status table name %q", table)
+	}
+	return &PostgresStatusReporter{db: db, table: table}, nil
+}
+
+// CreateTable creates the status table if it doesn't exist, and adds the
+// columns it lacks to a table created by an earlier version
+func (r *PostgresStatusReporter) CreateTable(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+r.table+` (
+	id               text PRIMARY KEY,
+	status           text NOT NULL,
+	result           jsonb,
+	attempt          integer NOT NULL,
+	progress         integer,
+	progress_message text,
+	correlation_id   text,
+	trace_id         text,
+	updated_at       timestamptz NOT NULL
+)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `ALTER TABLE `+r.table+`
+	ADD COLUMN IF NOT EXISTS progress integer,
+	ADD COLUMN IF NOT EXISTS progress_message text,
+	ADD COLUMN IF NOT EXISTS correlation_id text,
+	ADD COLUMN IF NOT EXISTS trace_id text`)
+	return err
+}
+
+// ReportStatus implements StatusReporter
+func (r *PostgresStatusReporter) ReportStatus(ctx context.Context, update StatusUpdate) error {
+	result, err := json.Marshal(update.Result)
+	if err != nil {
+		return fmt.Errorf("error marshaling status result: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	// Progress is only set on processing updates, and NULL on the others
+	var progress sql.NullInt64
+	if update.Progress != 0 || update.ProgressMessage != "" {
+		progress = sql.NullInt64{Int64: int64(update.Progress), Valid: true}
+	}
+
+	_, err = r.db.ExecContext(ctx, `INSERT INTO `+r.table+` (id, status, result, attempt, progress, progress_message,
+	correlation_id, trace_id, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, result = EXCLUDED.result,
+	attempt = EXCLUDED.attempt, progress = EXCLUDED.progress,
+	progress_message = EXCLUDED.progress_message, correlation_id = EXCLUDED.correlation_id,
+	trace_id = EXCLUDED.trace_id, updated_at = EXCLUDED.updated_at`,
+		update.ID, update.Status, string(result), update.Attempt, progress, nullString(update.ProgressMessage),
+		nullString(update.CorrelationID), nullString(update.TraceID))
+	if err != nil {
+		return fmt.Errorf("error updating status: %w", err)
+	}
+	return nil
+}
+
+// nullString returns s as a nullable column value, NULL if empty
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// NopStatusReporter drops every update, for deployments that don't track jobs
+type NopStatusReporter struct{}
+
+// ReportStatus implements StatusReporter
+func (NopStatusReporter) ReportStatus(context.Context, StatusUpdate) error {
+	return nil
+}
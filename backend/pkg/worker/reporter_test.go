@@ -0,0 +1,145 @@
+package worker_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// statusAPI is a status API recording the requests it is sent
+type statusAPI struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (a *statusAPI) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = append(a.requests, r)
+	a.bodies = append(a.bodies, body)
+}
+
+// received returns the requests received so far along with their bodies
+func (a *statusAPI) received() ([]*http.Request, [][]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*http.Request(nil), a.requests...), append([][]byte(nil), a.bodies...)
+}
+
+// runHTTPStatus processes a job with the status updates sent to a status API
+// configured by configure, returning the requests it received
+func runHTTPStatus(t *testing.T, configure func(*worker.Config)) ([]*http.Request, [][]byte) {
+	t.Helper()
+	api := &statusAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	h := workertest.New(t)
+	h.Config.StatusBackend = worker.StatusBackendHTTP
+	h.Config.ApiURL = server.URL
+	configure(h.Config)
+	w := h.Worker(worker.WithStatusReporter(nil))
+	w.Handle("noop", func(ctx context.Context, msg worker.Message) (any, error) {
+		return "ok", nil
+	})
+	h.Run(w)
+	h.Enqueue("{}", producer.WithType("noop"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		requests, bodies := api.received()
+		for _, body := range bodies {
+			var update worker.StatusUpdate
+			if json.Unmarshal(body, &update) == nil && update.Status == "completed" {
+				return requests, bodies
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status API got %d requests, none completing the job", len(requests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPStatusSigned(t *testing.T) {
+	requests, bodies := runHTTPStatus(t, func(c *worker.Config) {
+		c.HTTPSigningSecret = "secret"
+	})
+	for i, r := range requests {
+		timestamp := r.Header.Get("X-Signature-Timestamp")
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(bodies[i])
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Signature") != want {
+			t.Errorf("request %d signed %q, want %q", i, r.Header.Get("X-Signature"), want)
+		}
+		if seconds, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(seconds, 0)) > time.Minute {
+			t.Errorf("request %d has timestamp %q", i, timestamp)
+		}
+	}
+}
+
+func TestHTTPStatusCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*worker.Config)
+		check     func(*http.Request) string
+	}{
+		{
+			name: "bearer token and API key",
+			configure: func(c *worker.Config) {
+				c.HTTPBearerToken = "token"
+				c.HTTPAPIKey = "key"
+				c.HTTPAPIKeyHeader = "X-Key"
+			},
+			check: func(r *http.Request) string {
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					return "Authorization " + got
+				}
+				if got := r.Header.Get("X-Key"); got != "key" {
+					return "X-Key " + got
+				}
+				return ""
+			},
+		},
+		{
+			name: "basic authentication",
+			configure: func(c *worker.Config) {
+				c.HTTPBasicUser = "user"
+				c.HTTPBasicPassword = "password"
+			},
+			check: func(r *http.Request) string {
+				if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+					return "basic auth " + user + ":" + password
+				}
+				return ""
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, _ := runHTTPStatus(t, tt.configure)
+			for _, r := range requests {
+				if problem := tt.check(r); problem != "" {
+					t.Errorf("request to %s has %s", r.URL.Path, problem)
+				}
+			}
+		})
+	}
+}
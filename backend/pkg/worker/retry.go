@@ -0,0 +1,227 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// retryScanCount is how many of its pending entries the reader inspects per loop
+const retryScanCount = 100
+
+// RetryPolicy controls how often and how fast failed messages are retried
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt
+	BaseDelay  time.Duration // delay before the first retry
+	MaxDelay   time.Duration // cap of the delay
+	Backoff    Backoff       // how the delay grows, exponentially if empty
+
+	after time.Duration // delay of the next retry asked for with Retryable
+}
+
+// Backoff is how the delay between retries grows with each attempt
+type Backoff string
+
+const (
+	// BackoffExponential doubles the delay for every retry
+	BackoffExponential Backoff = "exponential"
+
+	// BackoffLinear adds BaseDelay to the delay for every retry
+	BackoffLinear Backoff = "linear"
+
+	// BackoffConstant waits BaseDelay before every retry
+	BackoffConstant Backoff = "constant"
+)
+
+// parseBackoff validates a RETRY_BACKOFF value
+func parseBackoff(s string) (Backoff, error) {
+	switch backoff := Backoff(s); backoff {
+	case BackoffExponential, BackoffLinear, BackoffConstant:
+		return backoff, nil
+	default:
+		return "", fmt.Errorf("unknown backoff %q, expected exponential, linear or constant", s)
+	}
+}
+
+// retryPolicy returns the worker-wide retry policy
+func (c *Config) retryPolicy() RetryPolicy {
+	return RetryPolicy{MaxRetries: c.MaxRetries, BaseDelay: c.BaseDelay, MaxDelay: c.MaxDelay, Backoff: c.RetryBackoff}
+}
+
+// delay returns how long a message should stay pending after its given
+// failed attempt before it is delivered again. The delay grows with every
+// attempt according to the backoff up to MaxDelay, and the upper half is
+// jittered. The jitter is derived from the entry ID so repeated checks of the
+// same entry agree on its due time. A delay asked for with Retryable is used
+// as is.
+func (p RetryPolicy) delay(entryID string, attempt int) time.Duration {
+	if p.after > 0 {
+		return p.after
+	}
+	delay := p.BaseDelay
+	switch p.Backoff {
+	case BackoffConstant:
+	case BackoffLinear:
+		delay = p.BaseDelay * time.Duration(max(attempt, 1))
+	default:
+		for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+			delay *= 2
+		}
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(entryID + ":" + strconv.Itoa(attempt)))
+	half := delay / 2
+	return half + time.Duration(h.Sum64()%uint64(half+1))
+}
+
+// retryInterval is how often the reader looks for pending entries to retry
+// when none is known to be due, e.g. those left by a previous run or
+// reclaimed from another consumer
+func (c *Config) retryInterval() time.Duration {
+	if c.ReadBlock > 0 {
+		return c.ReadBlock
+	}
+	return c.IdlePollMaxDelay
+}
+
+// runRetries retries the reader's failed messages as they come due until ctx
+// is done, woken up by the consumers scheduling a retry so that its backoff is
+// kept rather than waiting for a blocked read, and otherwise checking every
+// retryInterval. Retries wait while reads are held back or failing.
+func (r *reader) runRetries(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.router.retryScheduled:
+		case <-timer.C:
+		}
+		next := r.config.retryInterval()
+		if r.readsOpen() {
+			if due := r.retryPending(ctx); due > 0 {
+				next = min(next, due)
+			}
+		}
+		timer.Reset(max(next, time.Millisecond))
+	}
+}
+
+// readsOpen reports whether the reader may take messages: outside of
+// maintenance windows, while the group isn't paused, the status API breaker
+// and downstream checks don't hold reads back and reads succeed
+func (r *reader) readsOpen() bool {
+	if r.failing.Load() || r.pause.isPaused() || r.unhealthy.isPaused() || r.config.inMaintenance(time.Now()) {
+		return false
+	}
+	if r.config.StatusBreakerPause {
+		if open, _ := r.statusBreaker.rejecting(); open {
+			return false
+		}
+	}
+	return true
+}
+
+// retryPending dispatches the reader's pending entries whose backoff has
+// elapsed, skipping those still being processed. It returns how long until
+// the next pending entry is due, or 0 if there is nothing left to retry.
+func (r *reader) retryPending(ctx context.Context) time.Duration {
+	pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   r.stream,
+		Group:    r.group,
+		Start:    "-",
+		End:      "+",
+		Count:    retryScanCount,
+		Consumer: r.name,
+	}).Result()
+	if err != nil {
+		if err != redis.Nil && ctx.Err() == nil {
+			r.logger.Error("Error listing pending messages", "error", err)
+		}
+		return 0
+	}
+
+	var next time.Duration
+	for _, entry := range pending {
+		if ctx.Err() != nil {
+			return 0
+		}
+
+		if _, busy := r.inFlight.Load(entry.ID); busy || r.acks.has(&r.groupMember, entry.ID) {
+			continue
+		}
+
+		// RetryCount is the number of times the entry has been delivered so far
+		delay := r.retryPolicy(ctx, entry.ID).delay(entry.ID, int(entry.RetryCount))
+		if entry.Idle < delay {
+			if wait := delay - entry.Idle; next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+
+		if !r.limiter.wait(ctx) {
+			return 0
+		}
+
+		// Claiming the entry again bumps its delivery count and returns its fields
+		messages, err := r.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   r.stream,
+			Group:    r.group,
+			Consumer: r.name,
+			MinIdle:  delay,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil {
+			r.logger.Error("Error claiming message for retry", "entry_id", entry.ID, "error", err)
+			continue
+		}
+
+		attempt := int(entry.RetryCount) + 1
+		r.router.retries.Delete(entry.ID)
+		for _, message := range messages {
+			if !r.dispatch(ctx, message, attempt) {
+				return 0
+			}
+		}
+	}
+	return next
+}
+
+// retryPolicy returns the retry policy of a pending entry. It is recorded when
+// the entry fails, and looked up from the entry's type and fields if the
+// failure happened before a restart or on another worker.
+func (r *reader) retryPolicy(ctx context.Context, entryID string) RetryPolicy {
+	if policy, ok := r.router.retries.Load(entryID); ok {
+		return policy.(RetryPolicy)
+	}
+
+	var route *route
+	var values map[string]any
+	messages, err := r.client.XRange(ctx, r.stream, entryID, entryID).Result()
+	if err == nil && len(messages) > 0 {
+		values = messages[0].Values
+		if r.config.CloudEvents {
+			if decoded, err := decodeCloudEvent(values); err == nil {
+				values = decoded
+			}
+		}
+		jobType, _ := values[TypeField].(string)
+		route = r.router.lookup(jobType)
+	}
+	policy := r.router.messagePolicy(route, values)
+	r.router.retries.Store(entryID, policy)
+	return policy
+}
@@ -0,0 +1,54 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// TestRetryKeepsBackoff checks that retries are dispatched once their backoff
+// has elapsed, rather than when the reader's blocked read returns
+func TestRetryKeepsBackoff(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.ReadBlock = 2 * time.Second
+	h.Config.MaxRetries = 2
+	h.Config.BaseDelay = 10 * time.Millisecond
+	h.Config.MaxDelay = 50 * time.Millisecond
+
+	attempts := make(chan time.Time, 10)
+	w := h.Worker()
+	w.Handle("flaky", func(ctx context.Context, msg worker.Message) (any, error) {
+		attempts <- time.Now()
+		return nil, errors.New("unavailable")
+	})
+	h.Run(w)
+
+	start := time.Now()
+	h.Enqueue("{}", producer.WithType("flaky"), producer.WithID("1"))
+	h.WaitIdle(time.Second)
+
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(attempts))
+	}
+	if dead := h.DeadLetters(); len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	previous := <-attempts
+	for i := 1; i < 3; i++ {
+		at := <-attempts
+		// The jittered delay is between half the backoff and the backoff,
+		// with some leeway for scheduling
+		if gap := at.Sub(previous); gap < 5*time.Millisecond || gap > 500*time.Millisecond {
+			t.Errorf("retry %d came %s after the previous attempt", i, gap)
+		}
+		previous = at
+	}
+	if elapsed := time.Since(start); elapsed >= h.Config.ReadBlock {
+		t.Errorf("retries took %s, as long as a blocked read", elapsed)
+	}
+}
@@ -0,0 +1,274 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// TypeField is the entry field selecting the handler registered with
+// RegisterHandler or Worker.Handle
+const TypeField = "type"
+
+var (
+	// ErrInvalidPayload is returned by handlers registered with RegisterHandler
+	// when the body can't be decoded
+	ErrInvalidPayload = errors.New("invalid payload")
+
+	// ErrUnknownType fails messages whose type has no registered handler when
+	// there is no fallback handler set with WithHandler
+	ErrUnknownType = errors.New("no handler registered for type")
+
+	// ErrHandlerTimeout fails attempts whose handler ran for longer than its
+	// timeout
+	ErrHandlerTimeout = errors.New("handler timed out")
+
+	// ErrHandlerPanic fails attempts whose handler panicked
+	ErrHandlerPanic = errors.New("handler panicked")
+)
+
+// HandlerOption configures a handler registered for a job type
+type HandlerOption func(*route)
+
+// WithTimeout cancels the handler's context when it runs for longer than d,
+// failing the attempt, instead of after the worker's HandlerTimeout
+func WithTimeout(d time.Duration) HandlerOption {
+	return func(r *route) {
+		r.timeout = d
+	}
+}
+
+// WithRetryPolicy retries messages of the type according to policy instead of
+// the worker's MaxRetries, BaseDelay and MaxDelay
+func WithRetryPolicy(policy RetryPolicy) HandlerOption {
+	return func(r *route) {
+		r.retry = &policy
+	}
+}
+
+// WithDeadLetterStream dead-letters messages of the type to stream instead of
+// the worker's dead-letter stream
+func WithDeadLetterStream(stream string) HandlerOption {
+	return func(r *route) {
+		r.deadLetterStream = stream
+	}
+}
+
+// WithRateLimit processes at most perSecond messages of the type per second,
+// in bursts of up to burst messages, on top of the worker's RateLimit.
+// Messages over the limit wait in their consumer before the handler runs.
+func WithRateLimit(perSecond float64, burst int) HandlerOption {
+	return func(r *route) {
+		r.limiter = newRateLimiter(perSecond, burst)
+	}
+}
+
+// route is a handler with the policies it was registered with
+type route struct {
+	handler Handler
+	timeout time.Duration // zero for no timeout
+	retry   *RetryPolicy  // nil for the worker's policy
+	limiter *rateLimiter  // nil for no rate limit
+	schema  *Schema       // nil for no validation
+	ttl     time.Duration // zero for no expiry
+
+	slots       chan struct{} // nil for no limit on handlers running at once
+	globalSlots int           // zero for no limit across workers
+
+	deadLetterStream string // empty for the worker's
+
+	compensate Handler // nil if steps of the type can't be undone
+
+	middleware []Middleware // added with WithMiddleware
+}
+
+// Handle registers handler for messages whose type field is jobType. Messages
+// of other types go to the handler set with WithHandler, or are dead-lettered
+// with ErrUnknownType if there is none. It must be called before Run.
+func (w *Worker) Handle(jobType string, handler Handler, opts ...HandlerOption) {
+	if w.handlers == nil {
+		w.handlers = map[string]*route{}
+	}
+	w.handlers[jobType] = newRoute(handler, opts)
+}
+
+// newRoute creates the route of a handler registered with opts
+func newRoute(handler Handler, opts []HandlerOption) *route {
+	r := &route{handler: handler}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RegisterHandler registers fn on a Worker or Subscription for messages whose
+// type field is jobType, decoding the JSON body into a T before calling it.
+// Bodies that can't be decoded fail with ErrInvalidPayload. It must be called
+// before Run.
+func RegisterHandler[T any](w HandlerRegistry, jobType string, fn func(ctx context.Context, payload T) (any, error), opts ...HandlerOption) {
+	w.Handle(jobType, func(ctx context.Context, msg Message) (any, error) {
+		var payload T
+		if err := json.Unmarshal([]byte(msg.Body), &payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		return fn(ctx, payload)
+	}, opts...)
+}
+
+// router picks the route of each message by its type field
+type router struct {
+	routes   map[string]*route
+	fallback *route             // nil if only typed handlers are registered
+	schemas  map[string]*Schema // from SchemaDir, by type
+	config   *Config
+
+	// worker-wide retry policy, changed by Reload, if set
+	retry *atomic.Pointer[RetryPolicy]
+
+	// retries holds the retry policy of entries waiting for their next attempt,
+	// keyed by entry ID, so the reader knows their backoff without reading them
+	retries sync.Map
+
+	// retryScheduled wakes the reader's retries up when an entry fails
+	retryScheduled chan struct{}
+}
+
+// newRouter creates a router over the registered routes, sending other types
+// to fallback if it isn't nil
+func newRouter(routes map[string]*route, fallback Handler, config *Config) *router {
+	rt := &router{routes: routes, config: config, retryScheduled: make(chan struct{}, 1)}
+	if fallback != nil {
+		rt.fallback = &route{handler: fallback}
+	}
+	return rt
+}
+
+// scheduleRetry records the retry policy of an entry that failed, and wakes
+// the reader up to retry it once its backoff has elapsed
+func (rt *router) scheduleRetry(entryID string, policy RetryPolicy) {
+	rt.retries.Store(entryID, policy)
+	select {
+	case rt.retryScheduled <- struct{}{}:
+	default:
+	}
+}
+
+// lookup returns the route for jobType, or nil if there is none
+func (rt *router) lookup(jobType string) *route {
+	if r, ok := rt.routes[jobType]; ok {
+		return r
+	}
+	return rt.fallback
+}
+
+// metricType returns the type label of jobType in metrics: the type itself if
+// it has a registered route, or "other", so that producers can't create
+// series at will
+func (rt *router) metricType(jobType string) string {
+	if _, ok := rt.routes[jobType]; ok {
+		return jobType
+	}
+	return "other"
+}
+
+// validate checks the body of a message of jobType routed to r against the
+// schema of the type: the one r was registered with, or else the one of
+// SchemaDir
+func (rt *router) validate(jobType string, r *route, body string) error {
+	if r != nil && r.schema != nil {
+		return r.schema.validate(jobType, body)
+	}
+	return rt.schemas[jobType].validate(jobType, body)
+}
+
+// retryPolicy returns the retry policy of a route, r being nil for messages
+// without a route
+func (rt *router) retryPolicy(r *route) RetryPolicy {
+	if r != nil && r.retry != nil {
+		return *r.retry
+	}
+	if rt.retry != nil {
+		if policy := rt.retry.Load(); policy != nil {
+			return *policy
+		}
+	}
+	return rt.config.retryPolicy()
+}
+
+// messagePolicy returns the retry policy of a message routed to r: that of
+// the route, with the retries, base delay and backoff the message set, if any,
+// capped at MessageMaxRetries and MessageMaxRetryDelay
+func (rt *router) messagePolicy(r *route, values map[string]any) RetryPolicy {
+	policy := rt.retryPolicy(r)
+	if n, ok := intField(values, producer.FieldMaxRetries); ok {
+		policy.MaxRetries = int(min(n, int64(rt.config.MessageMaxRetries)))
+	}
+	if ms, ok := intField(values, producer.FieldRetryDelay); ok {
+		policy.BaseDelay = min(time.Duration(ms)*time.Millisecond, rt.config.MessageMaxRetryDelay)
+		policy.MaxDelay = max(policy.MaxDelay, policy.BaseDelay)
+	}
+	if s, ok := values[producer.FieldRetryBackoff].(string); ok {
+		if backoff, err := parseBackoff(s); err == nil {
+			policy.Backoff = backoff
+		}
+	}
+	return policy
+}
+
+// timeout returns the handler timeout of a message routed to r, zero for none:
+// the one the message set, capped at MessageMaxTimeout, or else that of the
+// route. A message can't disable its timeout by setting it to zero.
+func (rt *router) timeout(r *route, values map[string]any) time.Duration {
+	if ms, ok := intField(values, producer.FieldTimeout); ok && ms > 0 {
+		return min(time.Duration(ms)*time.Millisecond, rt.config.MessageMaxTimeout)
+	}
+	if r.timeout > 0 {
+		return r.timeout
+	}
+	return rt.config.HandlerTimeout
+}
+
+// deadLetterStream returns the dead-letter stream of a message: the one it
+// set if it is one of MessageDeadLetterStreams, or else that of the route of
+// its type, or the worker's
+func (rt *router) deadLetterStream(values map[string]any) string {
+	if stream, _ := values[producer.FieldDeadLetterStream].(string); stream != "" {
+		if stream = rt.config.key(stream); rt.config.messageDeadLetterStream(stream) {
+			return stream
+		}
+	}
+	jobType, _ := values[TypeField].(string)
+	if r := rt.lookup(jobType); r != nil && r.deadLetterStream != "" {
+		return rt.config.key(r.deadLetterStream)
+	}
+	return rt.config.deadLetterStream()
+}
+
+// intField returns the non-negative integer in a field of an entry, if it has
+// a valid one
+func intField(values map[string]any, field string) (int64, bool) {
+	s, ok := values[field].(string)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n >= 0
+}
+
+// isPermanent reports whether err can't be fixed by retrying, so the message
+// is dead-lettered right away, unless it was marked Retryable
+func isPermanent(err error) bool {
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return false
+	}
+	var fatal *fatalError
+	return errors.As(err, &fatal) || errors.Is(err, ErrInvalidPayload) || errors.Is(err, ErrUnknownType) || errors.Is(err, ErrUnauthorized)
+}
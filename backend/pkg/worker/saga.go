@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/encryption"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// Fields of the jobs of a saga, a chain whose steps have compensations
+const (
+	// SagaField holds the completed steps of the chain that have a
+	// compensation, oldest first, as a JSON array
+	SagaField = "saga"
+
+	// CompensateField is set to 1 on the jobs running the compensation of a
+	// step, which carry the ID, type and body of the step
+	CompensateField = "compensate"
+
+	// StepResultField holds the result of the step a compensation job undoes,
+	// as JSON. Compensation handlers get it decrypted in Message.Values.
+	StepResultField = "step_result"
+
+	// SagaKeyIDField is the ID of the key the saga and step_result fields are
+	// encrypted with, set when the worker has encryption keys. They are bound
+	// to the job ID like the body.
+	SagaKeyIDField = "saga_key_id"
+)
+
+// ErrNoCompensation fails compensation jobs whose type has no compensation
+// handler
+var ErrNoCompensation = errors.New("no compensation handler registered for type")
+
+// WithCompensation registers handler to undo messages of the type that
+// completed as a step of a chain, enqueued with producer.WithChain or Next,
+// when a later step fails for good. Compensations run one at a time, from the
+// last completed step to the first, each reported with the compensated status
+// under the ID of its step.
+func WithCompensation(handler Handler) HandlerOption {
+	return func(r *route) {
+		r.compensate = handler
+	}
+}
+
+// sagaStep is a completed step of a saga, as stored in the saga field
+type sagaStep struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Stream string `json:"stream"`
+	Body   string `json:"body"`
+	Result string `json:"result,omitempty"`
+}
+
+// isCompensation reports whether the message runs the compensation of a step
+func isCompensation(values map[string]any) bool {
+	flag, _ := values[CompensateField].(string)
+	return flag == "1"
+}
+
+// sagaSteps decodes the saga field of an entry, decrypting it if needed
+func (c *consumer) sagaSteps(ctx context.Context, values map[string]any) ([]sagaStep, error) {
+	saga, err := c.openSagaField(ctx, values, SagaField)
+	if err != nil || saga == "" {
+		return nil, err
+	}
+	var steps []sagaStep
+	if err := json.Unmarshal([]byte(saga), &steps); err != nil {
+		return nil, fmt.Errorf("invalid saga: %w", err)
+	}
+	return steps, nil
+}
+
+// sagaField returns the saga field of the jobs enqueued after msg succeeded
+// with result: its own, with msg added if its type has a compensation, or ""
+// if no step so far has one
+func (c *consumer) sagaField(ctx context.Context, msg Message, r *route, result any) (string, error) {
+	steps, err := c.sagaSteps(ctx, msg.Values)
+	if err != nil {
+		return "", err
+	}
+	if r.compensate != nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode result: %w", err)
+		}
+		steps = append(steps, sagaStep{ID: msg.ID, Type: msg.Type, Stream: msg.Stream, Body: msg.Body, Result: string(encoded)})
+	}
+	if len(steps) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(steps)
+	return string(encoded), err
+}
+
+// compensation builds the job compensating the last of steps, carrying the
+// others, or returns nil if there are none
+func (c *consumer) compensation(ctx context.Context, steps []sagaStep) (*redis.XAddArgs, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	step := steps[len(steps)-1]
+	opts := []producer.Option{
+		producer.WithID(step.ID),
+		producer.WithType(step.Type),
+		producer.WithMetadata(CompensateField, "1"),
+		producer.WithMetadata(StepResultField, step.Result),
+	}
+	if c.keyring != nil {
+		opts = append(opts, producer.WithEncryption(c.keyring))
+	}
+	if rest := steps[:len(steps)-1]; len(rest) > 0 {
+		encoded, err := json.Marshal(rest)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, producer.WithMetadata(SagaField, string(encoded)))
+	}
+	entry, err := producer.New(redisclient.Adapt(c.client)).Entry(ctx, step.Stream, step.Body, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build compensation: %w", err)
+	}
+	if err := c.sealSaga(ctx, entry.Values); err != nil {
+		return nil, fmt.Errorf("failed to encrypt compensation: %w", err)
+	}
+	return xaddArgs(entry), nil
+}
+
+// sealSaga encrypts the saga and step_result fields of an entry built by the
+// producer with the current key, if the worker has encryption keys, as they
+// carry the bodies and results of earlier steps
+func (c *consumer) sealSaga(ctx context.Context, values map[string]any) error {
+	if c.keyring == nil {
+		return nil
+	}
+	jobID, _ := values[producer.FieldID].(string)
+	var keyID string
+	var key []byte
+	for _, field := range []string{SagaField, StepResultField} {
+		plaintext, ok := values[field].(string)
+		if !ok {
+			continue
+		}
+		if key == nil {
+			var err error
+			if keyID, key, err = c.keyring.CurrentKey(ctx); err != nil {
+				return err
+			}
+		}
+		sealed, err := encryption.Seal(key, []byte(plaintext), []byte(jobID))
+		if err != nil {
+			return err
+		}
+		values[field] = string(sealed)
+	}
+	if key != nil {
+		values[SagaKeyIDField] = keyID
+	}
+	return nil
+}
+
+// openSagaField returns a field of an entry encrypted by sealSaga, decrypted
+// with the key named by its saga_key_id field if it has one
+func (c *consumer) openSagaField(ctx context.Context, values map[string]any, field string) (string, error) {
+	s, _ := values[field].(string)
+	keyID, ok := values[SagaKeyIDField].(string)
+	if !ok || s == "" {
+		return s, nil
+	}
+	if c.keyring == nil {
+		return "", fmt.Errorf("%w: %s is encrypted but no encryption keys are set", ErrInvalidPayload, field)
+	}
+	key, err := c.keyring.Key(ctx, keyID)
+	if errors.Is(err, producer.ErrUnknownKey) {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get encryption key %q: %w", keyID, err)
+	}
+	jobID, _ := values[producer.FieldID].(string)
+	opened, err := encryption.Open(key, []byte(s), []byte(jobID))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decrypt %s: %v", ErrInvalidPayload, field, err)
+	}
+	return string(opened), nil
+}
+
+// compensationValues returns the fields of a compensation job for its
+// handler, with its step_result field decrypted
+func (c *consumer) compensationValues(ctx context.Context, values map[string]any) (map[string]any, error) {
+	if _, ok := values[SagaKeyIDField]; !ok {
+		return values, nil
+	}
+	result, err := c.openSagaField(ctx, values, StepResultField)
+	if err != nil {
+		return nil, err
+	}
+	opened := make(map[string]any, len(values))
+	for k, v := range values {
+		opened[k] = v
+	}
+	opened[StepResultField] = result
+	return opened, nil
+}
+
+// acknowledgeCompensating acknowledges a message the worker gave up on without
+// dead-lettering it, unless acked before processing, adding the compensation
+// of its saga if it has one along with the ack
+func (c *consumer) acknowledgeCompensating(message redis.XMessage, acked bool) {
+	if compensation := c.startCompensation(message.Values); compensation != nil {
+		c.acknowledgeWith(message.ID, []*redis.XAddArgs{compensation}, nil, acked)
+	} else if !acked {
+		c.acknowledgeMessage(message.ID)
+	}
+}
+
+// startCompensation returns the job compensating the last completed step of
+// the saga of a message that failed for good, or nil if it has none. A
+// failed compensation doesn't compensate further.
+func (c *consumer) startCompensation(values map[string]any) *redis.XAddArgs {
+	if isCompensation(values) {
+		return nil
+	}
+	ctx := withMetadata(context.Background(), messageMetadata(values))
+	steps, err := c.sagaSteps(ctx, values)
+	if err == nil && len(steps) > 0 {
+		var entry *redis.XAddArgs
+		if entry, err = c.compensation(ctx, steps); err == nil {
+			c.logger.Info("Compensating saga", "message_id", values[producer.FieldID], "steps", len(steps))
+			return entry
+		}
+	}
+	if err != nil {
+		c.logger.Error("Failed to start compensating saga", "message_id", values[producer.FieldID], "error", err)
+	}
+	return nil
+}
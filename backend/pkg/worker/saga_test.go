@@ -0,0 +1,179 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+// compensations records the types of the steps compensated by a saga
+type compensations struct {
+	mu    sync.Mutex
+	types []string
+}
+
+// handler returns a compensation handler recording the type of its step
+func (c *compensations) handler() worker.Handler {
+	return func(ctx context.Context, msg worker.Message) (any, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.types = append(c.types, msg.Type)
+		return nil, nil
+	}
+}
+
+// list returns the types of the steps compensated so far
+func (c *compensations) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.types...)
+}
+
+// runSaga runs a worker for the order saga: reserve and charge have
+// compensations, and ship is handled by ship
+func runSaga(t *testing.T, h *workertest.Harness, ship worker.Handler) *compensations {
+	t.Helper()
+	undone := &compensations{}
+	w := h.Worker()
+	succeed := func(ctx context.Context, msg worker.Message) (any, error) {
+		return map[string]string{"step": msg.Type}, nil
+	}
+	w.Handle("reserve", succeed, worker.WithCompensation(undone.handler()))
+	w.Handle("charge", succeed, worker.WithCompensation(undone.handler()))
+	w.Handle("ship", ship)
+	h.Run(w)
+	return undone
+}
+
+// enqueueOrder enqueues the order saga: reserve, then charge, then ship
+func enqueueOrder(h *workertest.Harness) {
+	h.Enqueue("{}", producer.WithType("reserve"), producer.WithID("order-1"),
+		producer.WithChain(producer.Step{Type: "charge"}, producer.Step{Type: "ship"}))
+}
+
+func TestSagaCompensatesDeadLetteredStep(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	undone := runSaga(t, h, func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, errors.New("carrier unavailable")
+	})
+
+	enqueueOrder(h)
+	waitFor(t, "the compensations", func() bool { return len(undone.list()) == 2 })
+	h.WaitIdle(5 * time.Second)
+
+	if got, want := undone.list(), []string{"charge", "reserve"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("compensated %v, want %v", got, want)
+	}
+	if update, _ := h.Statuses.Last("order-1"); update.Status != "compensated" {
+		t.Errorf("last status of the first step %s, want compensated", update.Status)
+	}
+	if dead := h.DeadLetters(); len(dead) != 1 || dead[0].Values[producer.FieldType] != "ship" {
+		t.Errorf("got dead letters %v, want the ship step", dead)
+	}
+}
+
+func TestSagaCompensatesDroppedStep(t *testing.T) {
+	h := workertest.New(t)
+	undone := runSaga(t, h, func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, fmt.Errorf("%w: address rejected", worker.SkipRetry)
+	})
+
+	enqueueOrder(h)
+	waitFor(t, "the compensations", func() bool { return len(undone.list()) == 2 })
+	h.WaitIdle(5 * time.Second)
+
+	if dead := h.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got %d dead letters for a dropped step, want none", len(dead))
+	}
+}
+
+func TestSagaCompensatesExpiredStep(t *testing.T) {
+	h := workertest.New(t)
+	undone := runSaga(t, h, func(ctx context.Context, msg worker.Message) (any, error) {
+		t.Error("expired step handled")
+		return nil, nil
+	})
+
+	saga := fmt.Sprintf(`[{"id":"order-1","type":"reserve","stream":%q,"body":"{}"}]`, h.Config.StreamName)
+	h.Enqueue("{}", producer.WithType("ship"), producer.WithID("order-1-ship"),
+		producer.WithMetadata(worker.SagaField, saga), producer.WithExpiresAt(time.Now().Add(-time.Minute)))
+	waitFor(t, "the compensation", func() bool { return len(undone.list()) == 1 })
+	h.WaitIdle(5 * time.Second)
+
+	if update, _ := h.Statuses.Last("order-1-ship"); update.Status != "expired" {
+		t.Errorf("last status of the expired step %s, want expired", update.Status)
+	}
+}
+
+func TestSagaSkipsAcknowledgedStep(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	undone := runSaga(t, h, func(ctx context.Context, msg worker.Message) (any, error) {
+		// Another consumer reclaimed the step and acknowledged it meanwhile
+		if _, err := h.Client.XAck(ctx, msg.Stream, h.Config.GroupName, msg.EntryID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("carrier unavailable")
+	})
+
+	enqueueOrder(h)
+	h.WaitIdle(5 * time.Second)
+
+	if got := undone.list(); len(got) != 0 {
+		t.Errorf("compensated %v for a step acknowledged meanwhile, want nothing", got)
+	}
+}
+
+func TestSagaEncrypted(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 0
+	keys, err := producer.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := h.Worker(worker.WithKeyring(keys))
+	w.Handle("reserve", func(ctx context.Context, msg worker.Message) (any, error) {
+		return map[string]string{"card": "4242"}, nil
+	}, worker.WithCompensation(func(ctx context.Context, msg worker.Message) (any, error) {
+		if result := msg.Values[worker.StepResultField]; result != `{"card":"4242"}` {
+			t.Errorf("compensation got step result %q", result)
+		}
+		return nil, nil
+	}))
+	sagas := make(chan map[string]any, 1)
+	w.Handle("ship", func(ctx context.Context, msg worker.Message) (any, error) {
+		sagas <- msg.Values
+		return nil, errors.New("carrier unavailable")
+	})
+	h.Run(w)
+
+	h.Enqueue(`{"card":"4242"}`, producer.WithType("reserve"), producer.WithID("order-1"),
+		producer.WithEncryption(keys), producer.WithChain(producer.Step{Type: "ship"}))
+	var values map[string]any
+	select {
+	case values = <-sagas:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the ship step")
+	}
+	if saga, _ := values[worker.SagaField].(string); saga == "" || strings.Contains(saga, "4242") {
+		t.Errorf("saga field isn't encrypted: %q", saga)
+	}
+	if keyID := values[worker.SagaKeyIDField]; keyID != "k1" {
+		t.Errorf("saga key ID %v, want k1", keyID)
+	}
+	waitFor(t, "the compensation", func() bool {
+		update, _ := h.Statuses.Last("order-1")
+		return update.Status == "compensated"
+	})
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// GroupBacklog is the backlog of a consumer group: the entries not delivered
+// to it yet and those delivered but not acknowledged
+type GroupBacklog struct {
+	Stream  string `json:"stream"`
+	Group   string `json:"group"`
+	Length  int64  `json:"length"`
+	Lag     *int64 `json:"lag"` // nil when Redis can't tell, before Redis 7 or after deletions
+	Pending int64  `json:"pending"`
+
+	// Backlog is Lag plus Pending, or Length when the lag is unknown
+	Backlog int64 `json:"backlog"`
+}
+
+// Backlog is the backlog of the consumer groups of a worker, in the shape
+// scalers like KEDA's metrics-api scaler read
+type Backlog struct {
+	Backlog int64          `json:"backlog"` // sum of the groups' backlogs
+	Streams []GroupBacklog `json:"streams"`
+}
+
+// Backlog returns the backlog of the consumer groups of the worker, of stream
+// only if it isn't empty
+func (w *Worker) Backlog(ctx context.Context, stream string) (*Backlog, error) {
+	b := &Backlog{Streams: []GroupBacklog{}}
+	for _, sub := range w.streams() {
+		if stream != "" && w.config.key(sub.stream.Name) != w.config.key(stream) {
+			continue
+		}
+		g := GroupBacklog{Stream: w.config.key(sub.stream.Name), Group: sub.stream.Group}
+
+		var err error
+		if g.Length, err = w.client.XLen(ctx, g.Stream).Result(); err != nil {
+			return nil, fmt.Errorf("error reading the length of %s: %w", g.Stream, err)
+		}
+		info, err := groupInfo(ctx, w.client, g.Stream, g.Group)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the consumer group of %s: %w", g.Stream, err)
+		}
+		g.Backlog = g.Length
+		if info != nil {
+			g.Lag = optionalInt(info["lag"])
+			g.Pending, _ = info["pending"].(int64)
+			if g.Lag != nil {
+				g.Backlog = *g.Lag + g.Pending
+			}
+		}
+		b.Backlog += g.Backlog
+		b.Streams = append(b.Streams, g)
+	}
+	return b, nil
+}
+
+// handleScaling reports the backlog of the consumer groups, of the stream
+// named by the stream query parameter if given, for autoscalers
+func (w *Worker) handleScaling(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), metricsQueryTimeout)
+	defer cancel()
+
+	stream := r.URL.Query().Get("stream")
+	b, err := w.Backlog(ctx, stream)
+	if err != nil {
+		writeAdminError(rw, http.StatusServiceUnavailable, err)
+		return
+	}
+	if stream != "" && len(b.Streams) == 0 {
+		writeAdminError(rw, http.StatusNotFound, fmt.Errorf("the worker doesn't consume %s", stream))
+		return
+	}
+	writeAdminJSON(rw, http.StatusOK, b)
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// scheduleBatchSize is how many due jobs are moved to the stream per script call
+const scheduleBatchSize = 100
+
+// moveDueJobs moves up to ARGV[2] jobs due by ARGV[1] from the sorted set
+// KEYS[1] to the stream KEYS[2], atomically so concurrent schedulers never add
+// a job twice. It returns the number of jobs moved.
+var moveDueJobs = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, member in ipairs(due) do
+	local ok, job = pcall(cjson.decode, member)
+	if ok and type(job.fields) == "table" then
+		local args = {"XADD", KEYS[2]}
+		if job.maxlen and job.maxlen > 0 then
+			table.insert(args, "MAXLEN")
+			if job.approx then
+				table.insert(args, "~")
+			end
+			table.insert(args, job.maxlen)
+		end
+		table.insert(args, "*")
+		for field, value in pairs(job.fields) do
+			table.insert(args, field)
+			table.insert(args, tostring(value))
+		end
+		redis.call(unpack(args))
+	end
+	redis.call("ZREM", KEYS[1], member)
+end
+return #due
+`)
+
+// runScheduler moves delayed jobs enqueued with producer.EnqueueAt and
+// EnqueueIn to the member's stream once they are due, every ScheduleInterval
+func (w *Worker) runScheduler(ctx context.Context, m groupMember) {
+	if m.config.ScheduleInterval <= 0 {
+		return
+	}
+
+	key := producer.ScheduledKey(m.stream)
+	ticker := time.NewTicker(m.config.ScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			moved, err := moveDueJobs.Run(ctx, m.client, []string{key, m.stream},
+				time.Now().UnixMilli(), scheduleBatchSize).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					m.logger.Error("Error moving scheduled jobs", "key", key, "error", err)
+				}
+				break
+			}
+			if moved > 0 {
+				m.logger.Debug("Moved scheduled jobs to the stream", "count", moved, "key", key)
+			}
+			if moved < scheduleBatchSize {
+				break
+			}
+		}
+	}
+}
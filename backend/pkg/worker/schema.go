@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/soham901/go-redis-stream-worker/internal/jsonschema"
+)
+
+// Schema is a compiled JSON Schema that the bodies of a message type are
+// validated against before their handler runs. It supports the usual
+// keywords of draft 2020-12: type, enum, const, properties, required,
+// additionalProperties, items, minItems and maxItems, minLength, maxLength
+// and pattern, minimum, maximum and their exclusive forms, allOf, anyOf,
+// oneOf, not and local $refs. Schemas using other keywords, such as format,
+// fail to compile, bar annotations such as title and description.
+type Schema struct {
+	schema *jsonschema.Schema
+}
+
+// CompileSchema compiles a JSON Schema
+func CompileSchema(data []byte) (*Schema, error) {
+	s, err := jsonschema.Compile(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{schema: s}, nil
+}
+
+// MustCompileSchema is like CompileSchema but panics if the schema is invalid
+func MustCompileSchema(data string) *Schema {
+	s, err := CompileSchema([]byte(data))
+	if err != nil {
+		panic(fmt.Sprintf("worker: invalid schema: %v", err))
+	}
+	return s
+}
+
+// WithSchema validates the bodies of the type against schema before the
+// handler runs. Messages whose body doesn't match are dead-lettered with a
+// ValidationError, without running the handler or retrying them.
+func WithSchema(schema *Schema) HandlerOption {
+	return func(r *route) {
+		r.schema = schema
+	}
+}
+
+// ValidationError fails messages whose body doesn't match the schema of their
+// type. It wraps ErrInvalidPayload.
+type ValidationError struct {
+	Type   string
+	Errors []string // where the body doesn't match, as "<JSON pointer>: <problem>"
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: body doesn't match the schema of type %q: %s", ErrInvalidPayload, e.Type, strings.Join(e.Errors, "; "))
+}
+
+// Unwrap returns ErrInvalidPayload
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidPayload
+}
+
+// validate checks body against the schema of jobType, or returns nil if it has
+// none
+func (s *Schema) validate(jobType, body string) error {
+	if s == nil {
+		return nil
+	}
+	errs := s.schema.Validate([]byte(body))
+	if len(errs) == 0 {
+		return nil
+	}
+	v := &ValidationError{Type: jobType}
+	for _, err := range errs {
+		v.Errors = append(v.Errors, err.Error())
+	}
+	return v
+}
+
+// loadSchemas compiles the <type>.json schemas of dir, by type, or returns nil
+// if dir is empty
+func loadSchemas(dir string) (map[string]*Schema, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*Schema, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		schema, err := CompileSchema(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema %s: %w", file, err)
+		}
+		schemas[strings.TrimSuffix(filepath.Base(file), ".json")] = schema
+	}
+	return schemas, nil
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+)
+
+// StatusUpdate represents a message status update
+type StatusUpdate struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Result  any    `json:"result"`
+	Attempt int    `json:"attempt,omitempty"`
+
+	// Set from the metadata of the message
+	CorrelationID string `json:"correlation_id,omitempty"`
+	TraceID       string `json:"trace_id,omitempty"`
+
+	// Set on the processing updates sent by Message.Progress
+	Progress        int    `json:"progress,omitempty"`
+	ProgressMessage string `json:"progress_message,omitempty"`
+}
+
+// updateStatus records a status update in the job store, if enabled, and
+// reports it with the correlation and trace IDs of ctx. If it can't be
+// delivered, or earlier updates for the same job are still waiting, it is
+// queued in the status outbox instead when that is enabled. With batching, it
+// is handed to the batcher, which reports errors itself.
+func (c *consumer) updateStatus(ctx context.Context, statusUpdate StatusUpdate) error {
+	statusUpdate = c.recordStatus(ctx, statusUpdate)
+	if c.statusBatcher != nil && c.statusBatcher.add(statusUpdate) {
+		return nil
+	}
+	if c.statusOutbox != nil && c.statusOutbox.has(statusUpdate.ID) {
+		return c.queueStatus(statusUpdate, nil)
+	}
+	err := c.sendStatus(ctx, statusUpdate)
+	if err == nil || c.statusOutbox == nil || isRejectedStatus(err) {
+		return err
+	}
+	return c.queueStatus(statusUpdate, err)
+}
+
+// recordStatus sets the correlation and trace IDs of ctx on an update, unless
+// it has them, and records it in the job store, if enabled
+func (c *consumer) recordStatus(ctx context.Context, statusUpdate StatusUpdate) StatusUpdate {
+	if statusUpdate.CorrelationID == "" {
+		metadata := MetadataFromContext(ctx)
+		statusUpdate.CorrelationID = metadata.CorrelationID
+		statusUpdate.TraceID = metadata.TraceID
+	}
+	if c.jobs != nil {
+		storeCtx, cancel := context.WithTimeout(ctx, statusTimeout)
+		if err := c.jobs.Record(storeCtx, statusUpdate); err != nil {
+			c.logger.Warn("Failed to record job state", "message_id", statusUpdate.ID, "status", statusUpdate.Status, "error", err)
+		}
+		cancel()
+	}
+	return statusUpdate
+}
+
+// statusInAck reports whether the final update of a job can be written in the
+// transaction acknowledging it: the status backend keeps updates in Redis,
+// they aren't batched or failed on purpose, the job has no earlier updates
+// waiting in the outbox, and it wasn't acknowledged before processing
+func (c *consumer) statusInAck(statusUpdate StatusUpdate) bool {
+	if _, ok := c.statusReporter.(TxStatusReporter); !ok {
+		return false
+	}
+	if c.statusBatcher != nil || c.config.ChaosStatusFailRate > 0 || c.config.AckPolicy == AckBeforeProcessing {
+		return false
+	}
+	return c.statusOutbox == nil || !c.statusOutbox.has(statusUpdate.ID)
+}
+
+// queueStatus adds an update to the status outbox, cause being why it wasn't
+// sent directly, if it was tried
+func (m *groupMember) queueStatus(statusUpdate StatusUpdate, cause error) error {
+	if err := m.statusOutbox.push(statusUpdate); err != nil {
+		if cause != nil {
+			return fmt.Errorf("%w (and queueing it failed: %v)", cause, err)
+		}
+		return fmt.Errorf("error queueing status update: %w", err)
+	}
+	if cause != nil {
+		m.logger.Warn("Queued status update in outbox", "message_id", statusUpdate.ID,
+			"status", statusUpdate.Status, "error", cause)
+	}
+	return nil
+}
+
+// sendStatus reports a status update through the circuit breaker
+func (m *groupMember) sendStatus(ctx context.Context, statusUpdate StatusUpdate) error {
+	return m.statusBreaker.call(ctx, func() error {
+		if m.config.chaos(m.config.ChaosStatusFailRate) {
+			return fmt.Errorf("%w: simulated status update failure", ErrChaos)
+		}
+		return m.statusReporter.ReportStatus(ctx, statusUpdate)
+	})
+}
+
+// statusCodeError is returned when the API answers a status update with an
+// unexpected status code
+type statusCodeError struct {
+	code int
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("failed to update status, status code: %d", e.code)
+}
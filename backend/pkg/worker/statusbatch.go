@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusBatchCloseTimeout bounds the final flush of the status batcher on
+// shutdown
+const statusBatchCloseTimeout = 10 * time.Second
+
+// BatchStatusReporter is a StatusReporter that can also report several
+// updates at once. ReportStatuses returns an error if the whole batch failed,
+// and otherwise the error of each update, nil for those that succeeded.
+type BatchStatusReporter interface {
+	StatusReporter
+	ReportStatuses(ctx context.Context, updates []StatusUpdate) ([]error, error)
+}
+
+// batchStatusResponse is the answer of the API to a batch of updates
+type batchStatusResponse struct {
+	Results []struct {
+		ID     string `json:"id"`
+		Status int    `json:"status"`
+	} `json:"results"`
+}
+
+// ReportStatuses implements BatchStatusReporter, posting the updates as
+// {"updates": [...]} to URL + "/batch". The API answers with the status code
+// of each update in {"results": [{"id": ..., "status": 200}, ...]}, in the
+// same order.
+func (r *HTTPStatusReporter) ReportStatuses(ctx context.Context, updates []StatusUpdate) ([]error, error) {
+	jsonData, err := json.Marshal(map[string]any{"updates": updates})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling status updates: %w", err)
+	}
+
+	resp, err := r.post(ctx, r.URL+"/batch", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body batchStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding status batch response: %w", err)
+	}
+	if len(body.Results) != len(updates) {
+		return nil, fmt.Errorf("status batch response has %d results for %d updates", len(body.Results), len(updates))
+	}
+
+	errs := make([]error, len(updates))
+	for i, result := range body.Results {
+		if result.Status != http.StatusOK {
+			errs[i] = &statusCodeError{code: result.Status}
+		}
+	}
+	return errs, nil
+}
+
+// statusBatcher coalesces the status updates of all consumers and reports them
+// in batches, every StatusBatchInterval or once StatusBatchSize updates are
+// waiting, so that consumers don't wait for the backend. Batches are sent one
+// at a time through the circuit breaker, and updates that fail go to the
+// outbox, in order.
+type statusBatcher struct {
+	member   groupMember
+	reporter BatchStatusReporter
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []StatusUpdate
+	closed  bool
+	full    chan struct{} // signalled when size updates are pending
+	stop    chan struct{} // closed by close
+	done    chan struct{} // closed once the last batch was sent
+}
+
+// newStatusBatcher creates the batcher reporting the updates of member's
+// consumers, or returns nil if batching is disabled or the reporter can't
+// report batches
+func newStatusBatcher(m groupMember) *statusBatcher {
+	if m.config.StatusBatchSize <= 1 {
+		return nil
+	}
+	reporter, ok := m.statusReporter.(BatchStatusReporter)
+	if !ok {
+		m.logger.Warn("Status backend can't report batches, sending updates one by one")
+		return nil
+	}
+	return &statusBatcher{
+		member:   m,
+		reporter: reporter,
+		size:     m.config.StatusBatchSize,
+		interval: m.config.StatusBatchInterval,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// add queues an update for the next batch. A later update of a job waiting in
+// the same batch replaces the earlier one. It returns false once the batcher
+// is closed.
+func (b *statusBatcher) add(update StatusUpdate) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	for i, queued := range b.pending {
+		if queued.ID == update.ID {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			break
+		}
+	}
+	b.pending = append(b.pending, update)
+	if len(b.pending) >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// run sends batches until the batcher is closed, then sends what is left
+func (b *statusBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(max(b.interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			for b.flush() {
+			}
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		b.flush()
+	}
+}
+
+// close stops accepting updates and waits for the pending ones to be sent
+func (b *statusBatcher) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	close(b.stop)
+
+	select {
+	case <-b.done:
+	case <-time.After(statusBatchCloseTimeout):
+		b.member.logger.Error("Timed out sending the last status updates")
+	}
+}
+
+// flush sends up to size pending updates, reporting whether it sent any
+func (b *statusBatcher) flush() bool {
+	b.mu.Lock()
+	n := min(len(b.pending), b.size)
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	b.mu.Unlock()
+	if n == 0 {
+		return false
+	}
+
+	// Jobs with updates waiting in the outbox keep them in order
+	m := b.member
+	send := batch[:0:0]
+	for _, update := range batch {
+		if m.statusOutbox != nil && m.statusOutbox.has(update.ID) {
+			b.failed(update, nil)
+			continue
+		}
+		send = append(send, update)
+	}
+	if len(send) == 0 {
+		return true
+	}
+
+	// Reporters bound their own requests, the HTTP one with HTTPTimeout
+	ctx := context.Background()
+	var errs []error
+	err := m.statusBreaker.call(ctx, func() error {
+		var err error
+		errs, err = b.reporter.ReportStatuses(ctx, send)
+		return err
+	})
+	for i, update := range send {
+		switch {
+		case err != nil:
+			b.failed(update, err)
+		case errs[i] != nil:
+			b.failed(update, errs[i])
+		}
+	}
+	return true
+}
+
+// failed queues an update that couldn't be sent in the outbox, or drops it
+// with a warning if there is no outbox or the backend rejected it
+func (b *statusBatcher) failed(update StatusUpdate, cause error) {
+	m := b.member
+	if m.statusOutbox == nil || isRejectedStatus(cause) {
+		m.logger.Warn("Failed to update status", "message_id", update.ID, "status", update.Status, "error", cause)
+		return
+	}
+	if err := m.queueStatus(update, cause); err != nil {
+		m.logger.Warn("Failed to update status", "message_id", update.ID, "status", update.Status, "error", err)
+	}
+}
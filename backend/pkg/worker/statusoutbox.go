@@ -0,0 +1,204 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// statusOutboxPollInterval is how often the flusher checks the outbox when
+	// it has nothing to do, catching updates queued by other workers
+	statusOutboxPollInterval = 5 * time.Second
+
+	// statusOutboxRedisTimeout bounds the Redis calls made to queue updates
+	statusOutboxRedisTimeout = 5 * time.Second
+)
+
+// statusOutboxBackoff spaces the flusher's attempts while the API is failing
+var statusOutboxBackoff = RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}
+
+// popIfHead removes the first item of a list only if it is still the one the
+// flusher delivered, in case another worker flushed it first
+var popIfHead = redis.NewScript(`
+if redis.call("LINDEX", KEYS[1], 0) == ARGV[1] then
+	return redis.call("LPOP", KEYS[1])
+end
+return false
+`)
+
+// statusOutboxKey returns the status outbox list, defaulting to
+// "<stream>:status-outbox"
+func (c *Config) statusOutboxKey() string {
+	if c.StatusOutboxKey != "" {
+		return c.key(c.StatusOutboxKey)
+	}
+	return c.key(c.StreamName + ":status-outbox")
+}
+
+// statusOutbox is a Redis list of status updates that couldn't be delivered,
+// retried in order by a flusher until the API accepts them
+type statusOutbox struct {
+	client redis.UniversalClient
+	key    string
+	logger Logger
+
+	// queued counts the updates waiting in the outbox per job ID. Later updates
+	// for those jobs are queued behind them instead of being sent directly, so
+	// the API sees every job's transitions in order.
+	mu     sync.Mutex
+	queued map[string]int
+	notify chan struct{}
+
+	// failures counts consecutive failed deliveries, only used by flush
+	failures int
+}
+
+// newStatusOutbox creates the outbox stored under key
+func newStatusOutbox(client redis.UniversalClient, key string, logger Logger) *statusOutbox {
+	return &statusOutbox{
+		client: client,
+		key:    key,
+		logger: logger,
+		queued: map[string]int{},
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// has reports whether updates for the job are waiting in the outbox
+func (o *statusOutbox) has(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.queued[id] > 0
+}
+
+// push appends an update to the outbox
+func (o *statusOutbox) push(update StatusUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusOutboxRedisTimeout)
+	defer cancel()
+	if err := o.client.RPush(ctx, o.key, data).Err(); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.queued[update.ID]++
+	o.mu.Unlock()
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// delivered records that an update left the outbox
+func (o *statusOutbox) delivered(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.queued[id] > 1 {
+		o.queued[id]--
+	} else {
+		delete(o.queued, id)
+	}
+}
+
+// load counts the updates left in the outbox by a previous run
+func (o *statusOutbox) load(ctx context.Context) error {
+	items, err := o.client.LRange(ctx, o.key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, item := range items {
+		var update StatusUpdate
+		if json.Unmarshal([]byte(item), &update) == nil {
+			o.queued[update.ID]++
+		}
+	}
+	return nil
+}
+
+// flush delivers the updates in the outbox one by one with send, in order,
+// until ctx is done
+func (o *statusOutbox) flush(ctx context.Context, send func(context.Context, StatusUpdate) error) {
+	if err := o.load(ctx); err != nil && ctx.Err() == nil {
+		o.logger.Error("Error loading status outbox", "key", o.key, "error", err)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+			if o.failures > 0 {
+				// Keep backing off, the API is still failing
+				continue
+			}
+		case <-timer.C:
+		}
+		timer.Reset(o.flushNext(ctx, send))
+	}
+}
+
+// flushNext delivers the first update in the outbox and returns how long to
+// wait before the next one. Failed deliveries are retried with a backoff;
+// updates the API rejects as invalid are dropped.
+func (o *statusOutbox) flushNext(ctx context.Context, send func(context.Context, StatusUpdate) error) time.Duration {
+	item, err := o.client.LIndex(ctx, o.key, 0).Result()
+	if err != nil {
+		if err != redis.Nil && ctx.Err() == nil {
+			o.logger.Error("Error reading status outbox", "key", o.key, "error", err)
+		}
+		return statusOutboxPollInterval
+	}
+
+	var update StatusUpdate
+	if err := json.Unmarshal([]byte(item), &update); err != nil {
+		o.logger.Error("Dropping malformed status outbox item", "item", item, "error", err)
+	} else if err := send(ctx, update); err != nil && !isRejectedStatus(err) {
+		if ctx.Err() != nil {
+			return 0
+		}
+		o.failures++
+		wait := statusOutboxBackoff.delay(update.ID, o.failures)
+		o.logger.Warn("Error flushing status outbox, backing off",
+			"message_id", update.ID, "status", update.Status, "delay", wait, "error", err)
+		return wait
+	} else if err != nil {
+		o.logger.Error("Dropping status update rejected by the API",
+			"message_id", update.ID, "status", update.Status, "error", err)
+	}
+
+	if err := popIfHead.Run(ctx, o.client, []string{o.key}, item).Err(); err != nil && err != redis.Nil {
+		o.logger.Error("Error removing item from status outbox", "key", o.key, "error", err)
+		return statusOutboxPollInterval
+	}
+	o.delivered(update.ID)
+	o.failures = 0
+	return 0
+}
+
+// isRejectedStatus reports whether the API refused an update as invalid, in
+// which case sending it again won't help
+func isRejectedStatus(err error) bool {
+	var codeErr *statusCodeError
+	if !errors.As(err, &codeErr) {
+		return false
+	}
+	return codeErr.code >= 400 && codeErr.code < 500 &&
+		codeErr.code != http.StatusRequestTimeout && codeErr.code != http.StatusTooManyRequests
+}
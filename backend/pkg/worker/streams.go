@@ -0,0 +1,293 @@
+package worker
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+)
+
+// StreamConfig is a stream consumed by the worker and the consumer group it is
+// read through
+type StreamConfig struct {
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"` // defaults to GroupName
+
+	// Weight is the stream's share of the consumers when streams are weighted,
+	// or its rank with StrictPriority. Zero means 1.
+	Weight int `json:"weight,omitempty"`
+
+	// AckPolicy is the ack policy of the stream, AckPolicy of the
+	// configuration if empty
+	AckPolicy AckPolicy `json:"ack_policy,omitempty"`
+}
+
+// parseStreams parses a comma separated list of streams, each optionally
+// followed by a colon and its consumer group, then an equal sign and its
+// weight, such as "critical=6,default:jobs=3,low=1"
+func parseStreams(s string) ([]StreamConfig, error) {
+	var streams []StreamConfig
+	seen := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var stream StreamConfig
+		spec, weight, weighted := strings.Cut(item, "=")
+		if weighted {
+			n, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q, must be a positive integer", item)
+			}
+			stream.Weight = n
+		}
+		name, group, _ := strings.Cut(spec, ":")
+		stream.Name, stream.Group = strings.TrimSpace(name), strings.TrimSpace(group)
+		if stream.Name == "" {
+			return nil, fmt.Errorf("stream without a name in %q", item)
+		}
+		if seen[stream.Name] {
+			return nil, fmt.Errorf("stream %s listed twice", stream.Name)
+		}
+		seen[stream.Name] = true
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// atMostOnce reports whether the stream is one of AtMostOnceStreams, with or
+// without KeyPrefix on either side, as live and discovered streams are named
+// with it
+func (c *Config) atMostOnce(stream string) bool {
+	return slices.ContainsFunc(c.AtMostOnceStreams, func(name string) bool {
+		return c.key(name) == c.key(stream)
+	})
+}
+
+// forStream returns a copy of the configuration for one of the streams, with
+// its ack policy
+func (c *Config) forStream(stream StreamConfig) *Config {
+	config := *c
+	config.StreamName = c.key(stream.Name)
+	config.GroupName = stream.Group
+	switch {
+	case stream.AckPolicy != "":
+		config.AckPolicy = stream.AckPolicy
+	case c.atMostOnce(stream.Name):
+		config.AckPolicy = AckBeforeProcessing
+	}
+	return &config
+}
+
+// HandlerRegistry is what typed handlers are registered on: a Worker, for
+// every stream it consumes, or a Subscription, for a single one
+type HandlerRegistry interface {
+	Handle(jobType string, handler Handler, opts ...HandlerOption)
+}
+
+// Subscription is a stream consumed by a Worker, with the handlers only used
+// for its messages
+type Subscription struct {
+	stream     StreamConfig
+	handler    Handler
+	handlers   map[string]*route
+	middleware []Middleware
+}
+
+// Subscribe adds stream, read through group, to the streams the worker
+// consumes and returns its subscription, to register handlers only used for
+// its messages. An empty group means GroupName. Handlers registered on the
+// Worker are used for every stream, after the subscription's own. It must be
+// called before Run.
+//
+// Without subscriptions the worker consumes the Streams of its configuration,
+// or StreamName if there are none. Subscribing to a stream of Streams returns
+// its subscription.
+func (w *Worker) Subscribe(stream, group string) *Subscription {
+	for _, sub := range w.subscriptions {
+		if sub.stream.Name == stream {
+			if group != "" {
+				sub.stream.Group = group
+			}
+			return sub
+		}
+	}
+	sub := &Subscription{stream: StreamConfig{Name: stream, Group: group}}
+	w.subscriptions = append(w.subscriptions, sub)
+	return sub
+}
+
+// Stream returns the name of the subscribed stream
+func (s *Subscription) Stream() string {
+	return s.stream.Name
+}
+
+// Handle registers handler for messages of the stream whose type field is
+// jobType, taking precedence over a handler registered with Worker.Handle
+func (s *Subscription) Handle(jobType string, handler Handler, opts ...HandlerOption) {
+	if s.handlers == nil {
+		s.handlers = map[string]*route{}
+	}
+	s.handlers[jobType] = newRoute(handler, opts)
+}
+
+// SetWeight sets the stream's share of the consumers relative to the other
+// streams, or its rank with StrictPriority, like StreamConfig.Weight
+func (s *Subscription) SetWeight(weight int) {
+	s.stream.Weight = weight
+}
+
+// SetAckPolicy sets the ack policy of the stream, e.g. AckBeforeProcessing to
+// process its messages at most once while other streams get retries
+func (s *Subscription) SetAckPolicy(policy AckPolicy) {
+	s.stream.AckPolicy = policy
+}
+
+// weight returns the stream's weight, defaulting to 1
+func (s *Subscription) weight() int {
+	return max(s.stream.Weight, 1)
+}
+
+// HandleDefault sets the handler for messages of the stream whose type has no
+// registered handler, instead of the one set with WithHandler
+func (s *Subscription) HandleDefault(handler Handler) {
+	s.handler = handler
+}
+
+// priorityLevels are the streams each stream is consumed as with Priorities,
+// the stream itself first, and their ranks
+var priorityLevels = []struct {
+	priority producer.Priority
+	weight   int
+}{
+	{producer.PriorityDefault, 2},
+	{producer.PriorityHigh, 3},
+	{producer.PriorityLow, 1},
+}
+
+// streams returns the streams the worker consumes, in order: those of the
+// configuration, then the other subscriptions, with their groups defaulted.
+// With Priorities each is followed by its high and low priority streams.
+func (w *Worker) streams() []*Subscription {
+	var list []*Subscription
+	seen := map[string]bool{}
+	add := func(sub *Subscription) {
+		if seen[sub.stream.Name] {
+			return
+		}
+		seen[sub.stream.Name] = true
+		resolved := *sub
+		if resolved.stream.Group == "" {
+			resolved.stream.Group = w.config.GroupName
+		}
+		// Priority streams are processed like the stream they belong to
+		if resolved.stream.AckPolicy == "" && w.config.atMostOnce(resolved.stream.Name) {
+			resolved.stream.AckPolicy = AckBeforeProcessing
+		}
+		if !w.config.Priorities {
+			list = append(list, &resolved)
+			return
+		}
+		for _, level := range priorityLevels {
+			stream := resolved
+			stream.stream.Name = producer.PriorityStream(resolved.stream.Name, level.priority)
+			stream.stream.Weight = level.weight
+			if level.priority != producer.PriorityDefault {
+				if seen[stream.stream.Name] {
+					continue
+				}
+				seen[stream.stream.Name] = true
+			}
+			list = append(list, &stream)
+		}
+	}
+
+	for _, stream := range w.config.Streams {
+		sub := Subscription{stream: stream}
+		for _, s := range w.subscriptions {
+			if s.stream.Name == stream.Name {
+				sub.handler, sub.handlers, sub.middleware = s.handler, s.handlers, s.middleware
+				if s.stream.Group != "" {
+					sub.stream.Group = s.stream.Group
+				}
+				if s.stream.Weight != 0 {
+					sub.stream.Weight = s.stream.Weight
+				}
+				if s.stream.AckPolicy != "" {
+					sub.stream.AckPolicy = s.stream.AckPolicy
+				}
+			}
+		}
+		add(&sub)
+	}
+	for _, sub := range w.subscriptions {
+		add(sub)
+	}
+	if len(list) == 0 {
+		add(&Subscription{stream: StreamConfig{Name: w.config.StreamName, Group: w.config.GroupName}})
+	}
+	return list
+}
+
+// subscription returns the subscription of a stream added while running, with
+// the handlers of the worker's subscription to it if there is one
+func (w *Worker) subscription(stream StreamConfig) *Subscription {
+	for _, sub := range w.subscriptions {
+		if sub.stream.Name == stream.Name {
+			resolved := *sub
+			resolved.stream = stream
+			return &resolved
+		}
+	}
+	return &Subscription{stream: stream}
+}
+
+// streamConfig returns the configuration of the consumed stream named name, or
+// nil if the worker doesn't consume it. An empty name means the first stream.
+func (w *Worker) streamConfig(name string) *Config {
+	streams := w.streams()
+	for _, sub := range streams {
+		if name == "" || sub.stream.Name == name {
+			return w.config.forStream(sub.stream)
+		}
+	}
+	// Or one the running worker added or found
+	if stream, ok := w.live.get(w.config.key(name)); ok && name != "" {
+		return w.config.forStream(stream)
+	}
+	return nil
+}
+
+// routerFor returns the router of a subscription: its own handlers first, then
+// those registered on the worker, wrapped with their middleware
+func (w *Worker) routerFor(sub *Subscription, config *Config) *router {
+	routes := make(map[string]*route, len(w.handlers)+len(sub.handlers))
+	for _, handlers := range []map[string]*route{w.handlers, sub.handlers} {
+		for jobType, r := range handlers {
+			wrapped := *r
+			wrapped.handler = wrap(r.handler, w.middleware, sub.middleware, r.middleware)
+			if r.compensate != nil {
+				wrapped.compensate = wrap(r.compensate, w.middleware, sub.middleware, r.middleware)
+			}
+			routes[jobType] = &wrapped
+		}
+	}
+
+	fallback := sub.handler
+	if fallback == nil {
+		fallback = w.handler
+	}
+	if fallback == nil && len(routes) == 0 {
+		fallback = SimulatedHandler(config.ProcessingTime)
+	}
+	if fallback != nil {
+		fallback = wrap(fallback, w.middleware, sub.middleware)
+	}
+	rt := newRouter(routes, fallback, config)
+	rt.retry = &w.reload.retry
+	rt.schemas = w.schemas
+	return rt
+}
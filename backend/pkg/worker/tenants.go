@@ -0,0 +1,298 @@
+package worker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// sharedSlot is where a tenant holds a message of its shared channel taken
+// over its quota, partition messages being held by consumer
+const sharedSlot = -1
+
+// tenantName returns the tenant whose stream is named stream according to
+// pattern, or "" if the name doesn't match. Tenants can't have colons in their
+// names, so that derived streams such as "jobs:acme:dlq" aren't taken for the
+// stream of a tenant.
+func tenantName(pattern, stream string) string {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	if len(stream) <= len(prefix)+len(suffix) || !strings.HasPrefix(stream, prefix) || !strings.HasSuffix(stream, suffix) {
+		return ""
+	}
+	tenant := stream[len(prefix) : len(stream)-len(suffix)]
+	if strings.Contains(tenant, ":") {
+		return ""
+	}
+	return tenant
+}
+
+// startTenants starts the pool of consumers shared by the tenants and the
+// goroutine discovering their streams, whose readers join starts
+func (w *Worker) startTenants(ctx, handlerCtx context.Context, wg *sync.WaitGroup, join func(stream string) (*queue, func())) {
+	pool := &tenantPool{quota: w.config.TenantConcurrency, changed: make(chan struct{})}
+	scaler := w.newAutoscaler(nil, func(scaler *autoscaler, id int) {
+		if ctx.Err() != nil {
+			return
+		}
+		c := &tenantConsumer{w: w, id: id, pool: pool, scaler: scaler}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, handlerCtx)
+		}()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scaler.run(ctx, w.config.AutoscaleInterval)
+	}()
+
+	pattern := w.config.key(w.config.TenantStreamPattern)
+	logger := withFields(w.logger, "component", "tenants", "pattern", pattern)
+	logger.Info("Discovering tenant streams", "interval", w.config.TenantDiscoveryInterval, "concurrency", w.config.TenantConcurrency)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runDiscovery(ctx, streamDiscovery{
+			pattern:  pattern,
+			interval: w.config.TenantDiscoveryInterval,
+			logger:   logger,
+			accept: func(stream string) bool {
+				return tenantName(pattern, stream) != ""
+			},
+			start: func(stream string) func() {
+				q, leave := join(stream)
+				t := &tenant{name: tenantName(pattern, stream), queue: q, held: map[int][]delivery{}}
+				scaler.addQueue(q)
+				pool.add(t)
+				return func() {
+					leave()
+					scaler.removeQueue(q)
+				}
+			},
+		})
+	}()
+}
+
+// tenantPool hands the messages of the tenant streams to the consumers they
+// share, taking them from the tenants in turn so that a busy tenant can't
+// starve the others, and skipping tenants with quota messages in process
+type tenantPool struct {
+	quota int // 0 for no quota
+
+	mu      sync.Mutex
+	tenants []*tenant
+	next    int           // index of the tenant tried first
+	changed chan struct{} // closed when a tenant is added or one is back under its quota
+}
+
+// tenant is a tenant whose stream the worker reads
+type tenant struct {
+	name  string
+	queue *queue
+
+	running   int                // messages in process
+	held      map[int][]delivery // messages taken while at the quota, by consumer or sharedSlot
+	closed    bool               // the reader stopped
+	consumers []*consumer        // by consumer id, created when first needed
+}
+
+// add adds the tenant whose reader was started
+func (p *tenantPool) add(t *tenant) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tenants = append(p.tenants, t)
+	p.notify()
+}
+
+// notify wakes up the consumers waiting for a message, p.mu being held
+func (p *tenantPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// available reports whether a tenant may have one more message in process,
+// p.mu being held
+func (p *tenantPool) available(t *tenant) bool {
+	return !t.closed && (p.quota <= 0 || t.running < p.quota)
+}
+
+// take returns the next message for consumer id and its tenant, trying the
+// tenants in turn before waiting for any of them. It returns false once ctx
+// is done.
+func (p *tenantPool) take(ctx context.Context, id int) (*tenant, delivery, bool) {
+	for {
+		if ctx.Err() != nil {
+			return nil, delivery{}, false
+		}
+
+		p.mu.Lock()
+		n := len(p.tenants)
+		for i := 0; i < n; i++ {
+			t := p.tenants[(p.next+i)%n]
+			if !p.available(t) {
+				continue
+			}
+			if d, ok := t.receive(id); ok {
+				t.running++
+				p.next = (p.next + i + 1) % n
+				p.mu.Unlock()
+				return t, d, true
+			}
+		}
+		p.prune()
+
+		// Nothing is ready: wait for a message of any tenant under its quota,
+		// or for the tenants to change
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.changed)},
+		}
+		var waiting []*tenant
+		for _, t := range p.tenants {
+			if p.available(t) {
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.partition(id))},
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.queue.deliveries)})
+				waiting = append(waiting, t)
+			}
+		}
+		p.mu.Unlock()
+
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 0 {
+			return nil, delivery{}, false
+		}
+		if chosen == 1 {
+			continue
+		}
+		t := waiting[(chosen-2)/2]
+		slot := id
+		if chosen%2 == 1 {
+			slot = sharedSlot
+		}
+
+		p.mu.Lock()
+		if !ok {
+			t.closed = true
+			p.prune()
+			p.mu.Unlock()
+			continue
+		}
+		d := value.Interface().(delivery)
+		if !p.available(t) {
+			// Another consumer took the tenant's last slot meanwhile
+			t.held[slot] = append(t.held[slot], d)
+			p.mu.Unlock()
+			continue
+		}
+		t.running++
+		p.mu.Unlock()
+		return t, d, true
+	}
+}
+
+// done records that a message of t was processed
+func (p *tenantPool) done(t *tenant) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.running--
+	if p.quota > 0 && t.running == p.quota-1 {
+		p.notify()
+	}
+}
+
+// prune removes the tenants whose reader stopped, p.mu being held. Messages
+// they had read are left pending in the group.
+func (p *tenantPool) prune() {
+	kept := p.tenants[:0]
+	for _, t := range p.tenants {
+		if !t.closed {
+			kept = append(kept, t)
+		}
+	}
+	clear(p.tenants[len(kept):])
+	p.tenants = kept
+	if p.next >= len(kept) {
+		p.next = 0
+	}
+}
+
+// partition returns the partition channel of consumer id, nil if it has none
+func (t *tenant) partition(id int) <-chan delivery {
+	if id < len(t.queue.partitions) {
+		return t.queue.partitions[id]
+	}
+	return nil
+}
+
+// receive returns the next message of the tenant for consumer id if one is
+// ready: held ones first, then those of the consumer's partition
+func (t *tenant) receive(id int) (delivery, bool) {
+	for _, slot := range []int{id, sharedSlot} {
+		if held := t.held[slot]; len(held) > 0 {
+			t.held[slot] = held[1:]
+			return held[0], true
+		}
+	}
+	for _, ch := range []<-chan delivery{t.partition(id), t.queue.deliveries} {
+		select {
+		case d, ok := <-ch:
+			if ok {
+				return d, true
+			}
+			t.closed = true
+			return delivery{}, false
+		default:
+		}
+	}
+	return delivery{}, false
+}
+
+// consumer returns the consumer processing the tenant's messages for
+// consumer id of the pool, p.mu being held
+func (t *tenant) consumer(w *Worker, id int, scaler *autoscaler) *consumer {
+	for len(t.consumers) <= id {
+		t.consumers = append(t.consumers, nil)
+	}
+	if t.consumers[id] == nil {
+		t.consumers[id] = w.newConsumer(t.queue, id)
+		t.consumers[id].scaler = scaler
+	}
+	return t.consumers[id]
+}
+
+// tenantConsumer is one of the consumers shared by the tenants
+type tenantConsumer struct {
+	w      *Worker
+	id     int
+	pool   *tenantPool
+	scaler *autoscaler
+}
+
+// run processes the messages of the tenants until ctx is done
+func (c *tenantConsumer) run(ctx, handlerCtx context.Context) {
+	logger := withFields(c.w.logger, "component", "tenants", "worker_id", c.id)
+	logger.Info("Starting worker")
+	c.w.active.Add(1)
+	defer func() {
+		c.w.active.Add(-1)
+		logger.Info("Worker shutting down")
+	}()
+
+	for c.scaler.acquire(ctx) {
+		t, d, ok := c.pool.take(ctx, c.id)
+		if !ok {
+			c.scaler.release()
+			return
+		}
+		c.pool.mu.Lock()
+		tc := t.consumer(c.w, c.id, c.scaler)
+		c.pool.mu.Unlock()
+		tc.process(ctx, handlerCtx, d)
+		tc.inFlight.Delete(d.message.ID)
+		c.pool.done(t)
+		c.scaler.release()
+	}
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"context"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this package
+const tracerName = "github.com/soham901/go-redis-stream-worker/pkg/worker"
+
+// messageCarrier lets the W3C trace context be read from and written to stream
+// entry fields such as traceparent and tracestate
+type messageCarrier map[string]any
+
+// Get returns the value of a field, or an empty string if it is missing
+func (mc messageCarrier) Get(key string) string {
+	v, _ := mc[key].(string)
+	return v
+}
+
+// Set sets a field
+func (mc messageCarrier) Set(key, value string) {
+	mc[key] = value
+}
+
+// Keys lists the fields
+func (mc messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startMessageSpan starts the span covering a message's whole lifecycle, from
+// being read to being acknowledged. It continues the trace of the producer if
+// the entry carries a traceparent field. The span uses the global tracer
+// provider and propagator, so it is a no-op unless the application sets them.
+func (c *consumer) startMessageSpan(ctx context.Context, d delivery) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, messageCarrier(d.message.Values))
+	return otel.Tracer(tracerName).Start(ctx, "process "+c.stream,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(d.readAt),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", c.stream),
+			attribute.String("messaging.consumer.group.name", c.group),
+			attribute.String("messaging.message.id", d.message.ID),
+			attribute.String("messaging.redis.consumer", c.name),
+			attribute.String("messaging.redis.attempt", strconv.Itoa(d.attempt)),
+		),
+	)
+}
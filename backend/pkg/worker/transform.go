@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+)
+
+// ReplayTransform rewrites the fields of a dead-lettered entry as it is
+// replayed, after its dlq_* fields were removed, e.g. to fix a field that made
+// it fail. An error leaves the entry in the dead-letter stream.
+type ReplayTransform func(values map[string]any) (map[string]any, error)
+
+var (
+	// rewriteSet matches the rewrites setting a path, ".body.version = 2"
+	rewriteSet = regexp.MustCompile(`^\s*(\.[^=\s]+)\s*=\s*(.+?)\s*$`)
+
+	// rewriteDel matches the rewrites deleting a path, "del(.body.legacy)"
+	rewriteDel = regexp.MustCompile(`^\s*del\(\s*(\.[^)\s]+)\s*\)\s*$`)
+)
+
+// ParseRewrite parses a jq-style rewrite of the fields of an entry into a
+// ReplayTransform. A rewrite either sets a path to a JSON value, as in
+// `.type = "email"` or `.body.version = 2`, or deletes it, as in
+// `del(.body.legacy)`. The first name of the path is an entry field, and the
+// following ones are keys of the JSON object the field holds, created if
+// missing. Entry fields set to a JSON string get the string, and to any other
+// value its JSON text.
+func ParseRewrite(expr string) (ReplayTransform, error) {
+	var (
+		path  string
+		value any
+		del   bool
+	)
+	if m := rewriteDel.FindStringSubmatch(expr); m != nil {
+		path, del = m[1], true
+	} else if m := rewriteSet.FindStringSubmatch(expr); m != nil {
+		path = m[1]
+		if err := decodeJSON([]byte(m[2]), &value); err != nil {
+			return nil, fmt.Errorf("invalid rewrite %q: value is not JSON: %w", expr, err)
+		}
+	} else {
+		return nil, fmt.Errorf("invalid rewrite %q, expected .path = value or del(.path)", expr)
+	}
+	keys := strings.Split(strings.TrimPrefix(path, "."), ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid rewrite %q: empty key in %s", expr, path)
+		}
+	}
+
+	return func(values map[string]any) (map[string]any, error) {
+		values = maps.Clone(values)
+		field := keys[0]
+		if len(keys) == 1 {
+			if del {
+				delete(values, field)
+				return values, nil
+			}
+			if s, ok := value.(string); ok {
+				values[field] = s
+				return values, nil
+			}
+			text, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			values[field] = string(text)
+			return values, nil
+		}
+
+		// Rewrite the JSON object held by the field
+		doc := map[string]any{}
+		if raw, ok := values[field]; ok {
+			s, _ := raw.(string)
+			if err := decodeJSON([]byte(s), &doc); err != nil || doc == nil {
+				return nil, fmt.Errorf("rewriting %s: field %s doesn't hold a JSON object", path, field)
+			}
+		} else if del {
+			return values, nil
+		}
+		parent := doc
+		for _, key := range keys[1 : len(keys)-1] {
+			child, ok := parent[key].(map[string]any)
+			if !ok {
+				if del {
+					return values, nil
+				}
+				if _, exists := parent[key]; exists {
+					return nil, fmt.Errorf("rewriting %s: %s isn't a JSON object", path, key)
+				}
+				child = map[string]any{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		if del {
+			delete(parent, keys[len(keys)-1])
+		} else {
+			parent[keys[len(keys)-1]] = value
+		}
+		text, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		values[field] = string(text)
+		return values, nil
+	}, nil
+}
+
+// ParseRewrites parses rewrites with ParseRewrite, in the order they apply
+func ParseRewrites(exprs []string) ([]ReplayTransform, error) {
+	transforms := make([]ReplayTransform, 0, len(exprs))
+	for _, expr := range exprs {
+		t, err := ParseRewrite(expr)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+// applyTransforms rewrites values with transforms in turn
+func applyTransforms(values map[string]any, transforms []ReplayTransform) (map[string]any, error) {
+	for _, t := range transforms {
+		var err error
+		if values, err = t(values); err != nil {
+			return nil, err
+		}
+		if values == nil {
+			return nil, errors.New("transform returned no fields")
+		}
+	}
+	return values, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers as they are written
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the value")
+	}
+	return nil
+}
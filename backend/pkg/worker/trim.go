@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+)
+
+// runTrimmer trims the member's stream to StreamMaxLen entries and removes the
+// entries older than StreamMaxAge, every TrimInterval, on the leader if the
+// workers elect one. A lock keeps the other workers from trimming the stream at
+// the same time. Unless TrimUnprocessed is set,
+// entries that some consumer group hasn't processed yet are kept.
+func (w *Worker) runTrimmer(ctx context.Context, m groupMember) {
+	if m.config.TrimInterval <= 0 || (m.config.StreamMaxLen <= 0 && m.config.StreamMaxAge <= 0) {
+		return
+	}
+	inspector := newInspector(m.client, m.config)
+	ticker := time.NewTicker(m.config.TrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !w.leader.leads() {
+			continue
+		}
+
+		// The lock expires before the next tick, so that the workers trim about
+		// once per interval together
+		acquired, err := m.client.SetNX(ctx, m.stream+":trim:lock", m.name, m.config.TrimInterval/2).Result()
+		if err != nil || !acquired {
+			if err != nil && ctx.Err() == nil {
+				m.logger.Error("Error locking stream for trimming", "error", err)
+			}
+			continue
+		}
+
+		minID, err := trimPosition(ctx, m)
+		if err != nil {
+			if ctx.Err() == nil {
+				m.logger.Error("Error computing where to trim the stream", "error", err)
+			}
+			continue
+		}
+		if minID == "" {
+			continue
+		}
+		removed, err := inspector.TrimBefore(ctx, minID, true)
+		if err != nil {
+			if ctx.Err() == nil {
+				m.logger.Error("Error trimming stream", "error", err)
+			}
+			continue
+		}
+		if removed > 0 {
+			m.metrics.trimmed.Add(float64(removed))
+			m.logger.Info("Trimmed stream", "removed", removed, "min_id", minID)
+		}
+	}
+}
+
+// trimPosition returns the ID the member's stream should be trimmed to, with
+// every entry before it removed, or "" if nothing needs to be
+func trimPosition(ctx context.Context, m groupMember) (string, error) {
+	minID := ""
+
+	// Keep the StreamMaxLen newest entries
+	if m.config.StreamMaxLen > 0 {
+		newest, err := m.client.XRevRangeN(ctx, m.stream, "+", "-", int64(m.config.StreamMaxLen)).Result()
+		if err != nil {
+			return "", err
+		}
+		if len(newest) == m.config.StreamMaxLen {
+			minID = newest[len(newest)-1].ID
+		}
+	}
+
+	// And the entries added within StreamMaxAge
+	if m.config.StreamMaxAge > 0 {
+		cutoff := fmt.Sprintf("%d-0", time.Now().Add(-m.config.StreamMaxAge).UnixMilli())
+		if minID == "" || compareIDs(cutoff, minID) > 0 {
+			minID = cutoff
+		}
+	}
+	if minID == "" || m.config.TrimUnprocessed {
+		return minID, nil
+	}
+
+	// But not the entries some group hasn't processed yet: those pending, and
+	// those after the last one delivered
+	groups, err := redisx.XInfo(ctx, m.client, "GROUPS", m.stream)
+	if err != nil {
+		return "", err
+	}
+	for _, group := range groups {
+		name, _ := group["name"].(string)
+		keep, _ := group["last-delivered-id"].(string)
+		if pending, _ := group["pending"].(int64); pending > 0 {
+			summary, err := m.client.XPending(ctx, m.stream, name).Result()
+			if err != nil {
+				return "", err
+			}
+			keep = summary.Lower
+		}
+		if keep != "" && compareIDs(keep, minID) < 0 {
+			minID = keep
+		}
+	}
+	return minID, nil
+}
+
+// compareIDs compares two stream entry IDs, returning -1, 0 or 1 like
+// strings.Compare. A missing sequence number counts as 0.
+func compareIDs(a, b string) int {
+	aMs, aSeq := splitID(a)
+	bMs, bSeq := splitID(b)
+	if c := cmp.Compare(aMs, bMs); c != 0 {
+		return c
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+// splitID returns the time and sequence parts of a stream entry ID
+func splitID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	msPart, _ := strconv.ParseUint(ms, 10, 64)
+	seqPart, _ := strconv.ParseUint(seq, 10, 64)
+	return msPart, seqPart
+}
@@ -0,0 +1,685 @@
+// Package worker consumes messages from a Redis stream through a consumer
+// group, reporting the status of each message to an HTTP API.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+)
+
+// shutdownCleanupTimeout is how long Run waits for consumers to return after
+// the shutdown grace period has elapsed and in-flight handlers were cancelled
+const shutdownCleanupTimeout = 5 * time.Second
+
+// Worker reads batches from one or more streams, each through its consumer
+// group, and processes them with a pool of consumers per stream
+type Worker struct {
+	client  redis.UniversalClient
+	config  *Config
+	logger  Logger
+	handler Handler
+	metrics *metrics
+
+	// handlers registered per job type with Handle and RegisterHandler
+	handlers map[string]*route
+
+	// middleware added with Use, wrapping every handler
+	middleware []Middleware
+
+	// schemas of SchemaDir by type, compiled by Run
+	schemas map[string]*Schema
+
+	// streams added with Subscribe
+	subscriptions []*Subscription
+
+	// set with WithStatusReporter, or created from StatusBackend
+	statusReporter StatusReporter
+
+	// set with WithBlobStore, or the worker's Redis
+	blobs producer.BlobStore
+
+	// set with WithKeyring, or parsed from EncryptionKeys by Run
+	keyring producer.Keyring
+
+	// set with WithArchiver, or created from ArchiveBackend by Run
+	archiver Archiver
+
+	// added with WithDownstreamCheck
+	downstreamChecks []downstreamCheck
+
+	// added with OnFailure and OnSuccess
+	hooks *hooks
+
+	// last readings of the consumer group lags
+	lags *lagMonitor
+
+	// what Reload changes while Run runs
+	reload reloadState
+
+	// streams consumed while Run runs
+	live liveStreams
+
+	// elects the worker running the maintenance tasks, nil if they all do
+	leader *leaderElector
+
+	// state of Redis as seen by the reads
+	connection *connectionState
+
+	active    atomic.Int64
+	reclaimed atomic.Int64
+}
+
+// Stats holds counters describing the work done by a Worker
+type Stats struct {
+	Processed int64 `json:"processed"` // messages whose handler succeeded
+	Failed    int64 `json:"failed"`    // handler invocations that returned an error
+	Acked     int64 `json:"acked"`     // messages acknowledged in the group
+	Reclaimed int64 `json:"reclaimed"` // stale pending entries taken over by the claimer
+	Active    int64 `json:"active"`    // consumers currently running
+}
+
+// groupMember holds what a stream's reader and its consumers share, all of
+// them acting as one consumer of the group under the same name
+type groupMember struct {
+	name    string
+	group   string
+	stream  string
+	client  redis.UniversalClient
+	config  *Config
+	logger  Logger
+	metrics *streamMetrics
+	router  *router
+	limiter *rateLimiter // shared by all streams
+	pause   *pauseState
+	jobs    *JobStore // nil if disabled
+	cancels *cancelRegistry
+	hooks   *hooks
+	blobs   producer.BlobStore
+	keyring producer.Keyring // nil if bodies aren't encrypted
+
+	statusReporter StatusReporter
+	statusBreaker  *breaker
+	statusOutbox   *statusOutbox  // nil if disabled
+	statusBatcher  *statusBatcher // nil if disabled
+
+	acks       *ackBatcher      // nil if disabled
+	archiver   *archiveBatcher  // nil if disabled
+	unhealthy  *pauseState      // set while a downstream check fails
+	connection *connectionState // of the worker
+}
+
+// New creates a Worker that reads from Redis using client, which must come
+// from NewRedisClient or redisclient.Adapt. Without options the worker uses
+// DefaultConfig, logs to slog.Default and simulates processing with
+// SimulatedHandler.
+func New(client redisclient.Client, opts ...Option) *Worker {
+	w := &Worker{
+		client: redisx.Universal(client),
+		config: DefaultConfig(),
+		logger: slog.Default(),
+		hooks:  &hooks{},
+		lags:   newLagMonitor(),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.blobs == nil {
+		w.blobs = producer.NewRedisBlobStore(client, "", 0)
+	}
+	w.metrics = newMetrics(w)
+	w.connection = newConnectionState(w.logger, w.metrics.redisState)
+	return w
+}
+
+// Config returns the configuration the worker runs with
+func (w *Worker) Config() *Config {
+	return w.config
+}
+
+// Stats returns the worker's counters
+func (w *Worker) Stats() Stats {
+	return Stats{
+		Processed: counterValue(w.metrics.processed),
+		Failed:    counterValue(w.metrics.failed),
+		Acked:     counterValue(w.metrics.acked),
+		Reclaimed: w.reclaimed.Load(),
+		Active:    w.active.Load(),
+	}
+}
+
+// Run creates the consumer group if needed and starts the consumers. It blocks
+// until ctx is done and the consumers have shut down.
+//
+// Once ctx is done consumers stop reading new messages, and messages already
+// being processed get up to ShutdownGrace to finish and be acknowledged. After
+// that their handler contexts are cancelled and unfinished messages are left
+// pending so they are reclaimed later.
+func (w *Worker) Run(ctx context.Context) error {
+	streams := w.streams()
+	if w.config.DeadLetterStream != "" && (len(streams) > 1 || w.config.consumesSeveral()) {
+		return fmt.Errorf("dead-letter stream %s can't be shared by several streams", w.config.DeadLetterStream)
+	}
+
+	// Create the consumer groups if they don't exist
+	for _, sub := range streams {
+		stream := w.config.key(sub.stream.Name)
+		if err := CreateConsumerGroupAt(ctx, redisclient.Adapt(w.client), stream, sub.stream.Group, w.config.GroupStartID); err != nil {
+			return fmt.Errorf("failed to create consumer group %s on %s: %w", sub.stream.Group, stream, err)
+		}
+	}
+
+	// Compile the schemas of the message types
+	schemas, err := loadSchemas(w.config.SchemaDir)
+	if err != nil {
+		return err
+	}
+	w.schemas = schemas
+
+	// Take the keys of encrypted bodies from the configuration
+	if w.keyring == nil && w.config.EncryptionKeys != "" {
+		keyring, err := producer.ParseKeyring(w.config.EncryptionKeys)
+		if err != nil {
+			return fmt.Errorf("invalid encryption keys: %w", err)
+		}
+		w.keyring = keyring
+	}
+
+	// Inject faults once started, if testing how the worker copes with them
+	if w.config.Chaos {
+		w.logger.Warn("Chaos mode enabled, injecting faults",
+			"handler_fail_rate", w.config.ChaosHandlerFailRate, "ack_delay_rate", w.config.ChaosAckDelayRate,
+			"ack_delay", w.config.ChaosAckDelay, "status_fail_rate", w.config.ChaosStatusFailRate,
+			"redis_fail_rate", w.config.ChaosRedisFailRate)
+		if w.config.ChaosRedisFailRate > 0 {
+			w.client.AddHook(chaosHook{rate: w.config.ChaosRedisFailRate})
+		}
+	}
+
+	// Handlers outlive ctx until the shutdown grace period has elapsed
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	// WaitGroup to track the readers and all consumers
+	var wg sync.WaitGroup
+
+	// All streams report to the same status backend, through one breaker and
+	// outbox, the latter keyed by the first stream
+	statusReporter := w.statusReporter
+	if statusReporter == nil {
+		var err error
+		if statusReporter, err = newStatusReporter(ctx, w.config, w.client); err != nil {
+			return err
+		}
+	}
+	statusBreaker := newBreaker(w.config.StatusBreakerThreshold, w.config.StatusBreakerCooldown, w.logger)
+	var statusOutbox *statusOutbox
+	if w.config.StatusOutboxEnabled {
+		statusOutbox = newStatusOutbox(w.client, w.config.forStream(streams[0].stream).statusOutboxKey(), w.logger)
+	}
+	statusBatcher := newStatusBatcher(groupMember{
+		config:         w.config,
+		logger:         withFields(w.logger, "component", "status-batcher"),
+		statusReporter: statusReporter,
+		statusBreaker:  statusBreaker,
+		statusOutbox:   statusOutbox,
+	})
+	if statusBatcher != nil {
+		go statusBatcher.run()
+		// Send the last batch once the consumers are done
+		defer statusBatcher.close()
+	}
+
+	// Acks of all streams are batched together, the last batch being sent
+	// before the consumers leave their groups
+	acks := newAckBatcher(w.config, w.client, withFields(w.logger, "component", "ack-batcher"))
+	if acks != nil {
+		go acks.run()
+	}
+
+	// Records of finished jobs are archived in the background, the last ones
+	// once the consumers are done
+	archiver := w.archiver
+	if archiver == nil {
+		var err error
+		if archiver, err = newArchiver(ctx, w.config); err != nil {
+			return err
+		}
+	}
+	archive := newArchiveBatcher(w.config, archiver, withFields(w.logger, "component", "archiver"), w.metrics.archived)
+	if archive != nil {
+		go archive.run()
+		defer archive.close()
+	}
+
+	// The rate limit applies to all streams together, and Reload changes it
+	// along with the other tunables
+	limiter := &rateLimiter{}
+	limiter.set(w.config.RateLimit, w.config.RateBurst)
+	w.reload.start(w.config, limiter)
+	jobs := w.jobStore()
+
+	// With leader election only the leader trims, reclaims, fires cron jobs
+	// and deletes idle consumers
+	w.leader = w.newLeaderElector(w.config.forStream(streams[0].stream))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.leader.run(ctx)
+	}()
+
+	// Jobs cancelled while running are looked up across all streams
+	cancels := newCancelRegistry()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runCancel(ctx, cancels)
+	}()
+
+	// Reads of every stream stop while a downstream check fails, starting
+	// with the outcome of the first checks
+	unhealthy := newPauseState()
+	if checks := w.checks(); len(checks) > 0 {
+		gate := w.newDownstreamGate(checks, unhealthy)
+		gate.check(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gate.run(ctx)
+		}()
+	}
+
+	// What the members of every stream share
+	shared := groupMember{
+		client:  w.client,
+		limiter: limiter,
+		jobs:    jobs,
+		cancels: cancels,
+		hooks:   w.hooks,
+		blobs:   w.blobs,
+		keyring: w.keyring,
+
+		statusReporter: statusReporter,
+		statusBreaker:  statusBreaker,
+		statusOutbox:   statusOutbox,
+		statusBatcher:  statusBatcher,
+
+		acks:       acks,
+		archiver:   archive,
+		unhealthy:  unhealthy,
+		connection: w.connection,
+	}
+	members := &memberList{}
+
+	// join starts the reader of a stream, and returns the context of the
+	// stream and what stops reading it
+	join := func(sub *Subscription) (context.Context, *queue, func()) {
+		streamCtx, cancel := context.WithCancel(ctx)
+		member := w.newMember(shared, sub)
+		members.add(member)
+		member.logger.Info("Joining consumer group")
+		q := w.startReader(streamCtx, &wg, member)
+		q.weight = sub.weight()
+		return streamCtx, q, func() {
+			cancel()
+			members.remove(member.stream)
+		}
+	}
+
+	// consume starts the reader of a stream and its own consumers, and
+	// returns what stops them
+	consume := func(sub *Subscription) (*queue, func()) {
+		streamCtx, q, leave := join(sub)
+		scaler := w.startConsumers(streamCtx, handlerCtx, &wg, q)
+		return q, func() {
+			leave()
+			w.reload.removeScaler(scaler)
+		}
+	}
+
+	// Streams added with AddStream get their own consumers
+	w.live.run(func(stream StreamConfig) func() {
+		_, stop := consume(w.subscription(stream))
+		return stop
+	})
+
+	// Consume each stream with its own consumers, or all of them with one pool
+	// picking streams by priority
+	queues := make([]*queue, len(streams))
+	if w.prioritized(streams) {
+		leaves := make([]func(), len(streams))
+		for i, sub := range streams {
+			_, queues[i], leaves[i] = join(sub)
+		}
+		scaler := w.startPool(ctx, handlerCtx, &wg, queues)
+		for i, q := range queues {
+			w.live.add(q.member.stream, streams[i].stream, func() {
+				leaves[i]()
+				scaler.removeQueue(q)
+			})
+		}
+	} else {
+		for i, sub := range streams {
+			var stop func()
+			queues[i], stop = consume(sub)
+			w.live.add(queues[i].member.stream, sub.stream, stop)
+		}
+	}
+
+	// Serve metrics and health probes
+	if w.config.HTTPAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.serveHTTP(ctx, w.config.HTTPAddr, w.HTTPHandler(), "metrics and health probes")
+		}()
+	}
+
+	// Serve the admin API and dashboard
+	if w.config.AdminAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.serveHTTP(ctx, w.config.AdminAddr, w.AdminHandler(), "admin API and dashboard")
+		}()
+	}
+
+	// Deliver status updates that failed earlier
+	if statusOutbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusOutbox.flush(ctx, queues[0].member.sendStatus)
+		}()
+	}
+
+	// Consume the streams matching the patterns as they appear
+	if w.config.StreamPattern != "" {
+		w.startPatternStreams(ctx, &wg, func(stream string) func() {
+			_, stop := consume(&Subscription{stream: StreamConfig{Name: stream, Group: w.config.GroupName}})
+			return stop
+		})
+	}
+	if w.config.TenantStreamPattern != "" {
+		w.startTenants(ctx, handlerCtx, &wg, func(stream string) (*queue, func()) {
+			_, q, leave := join(&Subscription{stream: StreamConfig{Name: stream, Group: w.config.GroupName}})
+			return q, leave
+		})
+	}
+
+	// Add recurring jobs to the streams
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runCron(ctx)
+	}()
+
+	<-ctx.Done()
+	w.live.close()
+	w.logger.Info("Shutting down workers, waiting for in-flight messages", "grace", w.config.ShutdownGrace)
+
+	// Wait for all consumers to finish in-flight messages
+	waitCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waitCh)
+	}()
+
+	// Remove the worker's consumers from their groups once they are done and
+	// their messages acknowledged
+	defer func() {
+		acks.close()
+		members.deregister()
+	}()
+
+	select {
+	case <-waitCh:
+		w.logger.Info("All workers shut down gracefully")
+		return nil
+	case <-time.After(w.config.ShutdownGrace):
+	}
+
+	// Abort the remaining handlers, their messages stay pending
+	w.logger.Warn("Shutdown grace period elapsed, cancelling in-flight messages")
+	cancelHandlers()
+
+	select {
+	case <-waitCh:
+		w.logger.Info("All workers shut down")
+	case <-time.After(shutdownCleanupTimeout):
+		w.logger.Error("Timed out waiting for workers to shut down")
+	}
+	return nil
+}
+
+// newMember returns the member of the group of a subscription, with what all
+// streams share
+func (w *Worker) newMember(shared groupMember, sub *Subscription) groupMember {
+	config := w.config.forStream(sub.stream)
+	name := w.consumerName(config.StreamName, config.GroupName)
+	member := shared
+	member.name = name
+	member.group = config.GroupName
+	member.stream = config.StreamName
+	member.config = config
+	member.logger = withFields(w.logger, "stream", config.StreamName, "group", config.GroupName, "consumer", name)
+	member.metrics = w.metrics.forStream(config.StreamName, config.GroupName)
+	w.metrics.semantics.WithLabelValues(config.StreamName, config.GroupName, config.AckPolicy.semantics()).Set(1)
+	member.router = w.routerFor(sub, config)
+	member.pause = newPauseState()
+	return member
+}
+
+// memberList holds the members of the groups the worker joined, to leave
+// them on shutdown
+type memberList struct {
+	mu      sync.Mutex
+	members []groupMember
+}
+
+// add records a member that joined its group
+func (l *memberList) add(m groupMember) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.members = append(l.members, m)
+}
+
+// remove forgets the member of a stream that was deleted
+func (l *memberList) remove(stream string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.members = slices.DeleteFunc(l.members, func(m groupMember) bool {
+		return m.stream == stream
+	})
+}
+
+// deregister deletes the consumers of all members from their groups
+func (l *memberList) deregister() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.members {
+		m.deregister()
+	}
+}
+
+// queue is what the reader of a stream hands messages to its consumers with
+type queue struct {
+	member     groupMember
+	weight     int
+	deliveries <-chan delivery
+	partitions []<-chan delivery // one per consumer, for messages with a partition key
+	inFlight   *sync.Map
+
+	// served is when a pool consumer last took a message of the queue, in
+	// Unix nanoseconds, for PriorityAging
+	served atomic.Int64
+}
+
+// readerConnection returns the dedicated connection of the reader of a
+// stream, named stream-worker:<stream>:<consumer> so that it can be told apart
+// in CLIENT LIST, or nil if the client doesn't allow for one
+func (w *Worker) readerConnection(member groupMember) redis.UniversalClient {
+	name := "stream-worker:" + member.stream + ":" + member.name
+	client := dedicatedReadClient(w.client, name, member.config.ReadBlock)
+	if client == nil {
+		member.logger.Warn("Dedicated reader connections need a go-redis client or cluster client, sharing the pool")
+		return nil
+	}
+	if w.config.Chaos && w.config.ChaosRedisFailRate > 0 {
+		client.AddHook(chaosHook{rate: w.config.ChaosRedisFailRate})
+	}
+	return client
+}
+
+// startReader starts the reader of a stream, along with the goroutines moving
+// its delayed jobs, reclaiming its stale entries, trimming it and deleting its
+// idle consumers, and returns the queue it hands messages to the consumers
+// with
+func (w *Worker) startReader(ctx context.Context, wg *sync.WaitGroup, member groupMember) *queue {
+	// Messages handed from the reader to the consumers, bounded so the reader
+	// stops fetching while all consumers are busy
+	deliveries := make(chan delivery, member.config.BatchSize)
+	q := &queue{member: member, deliveries: deliveries, inFlight: &sync.Map{}}
+	r := &reader{
+		groupMember: member,
+		deliveries:  deliveries,
+		inFlight:    q.inFlight,
+	}
+	for i := 0; i < member.config.consumerCount(); i++ {
+		partition := make(chan delivery, member.config.BatchSize)
+		r.partitions = append(r.partitions, partition)
+		q.partitions = append(q.partitions, partition)
+	}
+	r.logger = withFields(member.logger, "component", "reader")
+	if member.config.RedisDedicatedReaders {
+		r.reads = w.readerConnection(member)
+	}
+
+	// Start paused if the group is
+	syncPause(ctx, member)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.run(ctx)
+	}()
+
+	// Move delayed jobs to the stream when they are due
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runScheduler(ctx, member)
+	}()
+
+	// Reclaim messages abandoned by crashed consumers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runClaimer(ctx, r)
+	}()
+
+	// Trim the stream according to the retention policy
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runTrimmer(ctx, member)
+	}()
+
+	// Measure, trim and alert on the dead-letter stream
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runDeadLetterMonitor(ctx, member)
+	}()
+
+	// Pause and resume reading when told to
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runControl(ctx, member)
+	}()
+
+	// Export the lag of the group
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runLagMonitor(ctx, member)
+	}()
+
+	// Delete consumers left behind by workers that are gone
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runJanitor(ctx, member)
+	}()
+
+	return q
+}
+
+// newConsumer creates consumer id of a stream
+func (w *Worker) newConsumer(q *queue, id int) *consumer {
+	c := &consumer{
+		groupMember: q.member,
+		id:          id,
+		active:      &w.active,
+		deliveries:  q.deliveries,
+		inFlight:    q.inFlight,
+	}
+	// Consumers started by Reload have no partition
+	if id < len(q.partitions) {
+		c.partition = q.partitions[id]
+	}
+	c.logger = withFields(q.member.logger, "worker_id", id)
+	return c
+}
+
+// startConsumers starts WorkerCount consumers processing the messages of one
+// stream, or MaxWorkerCount of them with an autoscaler when autoscaling, and
+// returns their autoscaler
+func (w *Worker) startConsumers(ctx, handlerCtx context.Context, wg *sync.WaitGroup, q *queue) *autoscaler {
+	scaler := w.newAutoscaler([]*queue{q}, func(scaler *autoscaler, id int) {
+		if ctx.Err() != nil {
+			return
+		}
+		c := w.newConsumer(q, id)
+		c.scaler = scaler
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, handlerCtx)
+		}()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scaler.run(ctx, w.config.AutoscaleInterval)
+	}()
+	return scaler
+}
+
+// CreateConsumerGroup creates a Redis stream consumer group if it doesn't
+// exist, starting from the beginning of the stream
+func CreateConsumerGroup(ctx context.Context, client StreamsClient, stream, group string) error {
+	return CreateConsumerGroupAt(ctx, client, stream, group, "0")
+}
+
+// CreateConsumerGroupAt creates a Redis stream consumer group if it doesn't
+// exist, delivering the entries after startID: "0" for the whole stream, "$"
+// for new entries only, or an entry ID. The stream is created if needed. An
+// existing group keeps its position.
+func CreateConsumerGroupAt(ctx context.Context, client StreamsClient, stream, group, startID string) error {
+	err := client.XGroupCreateMkStream(ctx, stream, group, startID)
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+	return nil
+}
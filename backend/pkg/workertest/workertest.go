@@ -0,0 +1,250 @@
+// Package workertest runs workers against miniredis, an in-memory Redis, so
+// that handlers and the processing loop can be tested without a Redis server:
+//
+//	h := workertest.New(t)
+//	w := h.Worker()
+//	w.Handle("email", sendEmail)
+//	h.Run(w)
+//	h.Enqueue(`{"to":"a@example.com"}`, producer.WithType("email"), producer.WithID("1"))
+//	h.WaitIdle(5 * time.Second)
+//	if update, _ := h.Statuses.Last("1"); update.Status != "completed" {
+//		t.Fatalf("status %s", update.Status)
+//	}
+package workertest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/soham901/go-redis-stream-worker/internal/redisx"
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/redisclient"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+)
+
+// idlePollInterval is how often WaitIdle checks the consumer group
+const idlePollInterval = 10 * time.Millisecond
+
+// Harness is a miniredis server with a client, a producer and a worker
+// configuration for it, all closed when the test ends
+type Harness struct {
+	Redis    *miniredis.Miniredis
+	Client   redisclient.Client
+	Producer *producer.Producer
+
+	// Config is used by the workers created with Worker. It is the default
+	// configuration with short retry delays and reads, for tests not to wait
+	// long, and can be changed before creating them.
+	Config *worker.Config
+
+	// Statuses records the status updates of the workers created with Worker
+	Statuses *StatusRecorder
+
+	client redis.UniversalClient // behind Client
+	t      testing.TB
+}
+
+// New starts a miniredis server for the test
+func New(t testing.TB) *Harness {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	adapted := redisclient.Adapt(client)
+
+	config := worker.DefaultConfig()
+	config.BaseDelay = 10 * time.Millisecond
+	config.MaxDelay = 100 * time.Millisecond
+	config.ShutdownGrace = time.Second
+	config.ScheduleInterval = 10 * time.Millisecond
+	config.ReadBlock = 100 * time.Millisecond
+	config.IdlePollDelay = 10 * time.Millisecond
+	config.IdlePollMaxDelay = 100 * time.Millisecond
+	config.LagInterval = 0
+
+	return &Harness{
+		Redis:    server,
+		Client:   adapted,
+		Producer: producer.New(adapted),
+		Config:   config,
+		Statuses: &StatusRecorder{},
+		client:   client,
+		t:        t,
+	}
+}
+
+// Worker creates a worker using the harness's client and Config, reporting
+// status updates to Statuses and discarding its logs. opts are applied after
+// those, e.g. to log with worker.WithLogger.
+func (h *Harness) Worker(opts ...worker.Option) *worker.Worker {
+	defaults := []worker.Option{
+		worker.WithConfig(h.Config),
+		worker.WithStatusReporter(h.Statuses),
+		worker.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}
+	return worker.New(h.Client, append(defaults, opts...)...)
+}
+
+// Run runs w in the background until the returned function is called or the
+// test ends, failing the test if Run returns an error. It returns once the
+// consumer groups exist, so that jobs enqueued afterwards are read.
+func (h *Harness) Run(w *worker.Worker) (stop func()) {
+	h.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := w.Run(ctx); err != nil {
+			h.t.Errorf("worker stopped: %v", err)
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	h.t.Cleanup(stop)
+
+	config := w.Config()
+	for !h.groupExists(prefixed(config, config.StreamName), config.GroupName) {
+		select {
+		case <-done:
+			return stop
+		case <-time.After(idlePollInterval):
+		}
+	}
+	return stop
+}
+
+// Enqueue adds a job to the stream of Config, failing the test on error, and
+// returns the ID of its entry
+func (h *Harness) Enqueue(payload any, opts ...producer.Option) string {
+	h.t.Helper()
+	opts = append([]producer.Option{producer.WithKeyPrefix(h.Config.KeyPrefix)}, opts...)
+	entryID, err := h.Producer.Enqueue(context.Background(), h.Config.StreamName, payload, opts...)
+	if err != nil {
+		h.t.Fatalf("enqueue: %v", err)
+	}
+	return entryID
+}
+
+// WaitIdle waits until the consumer group of Config was delivered every entry
+// of its stream and acknowledged them all, including those being retried,
+// failing the test if it takes longer than timeout
+func (h *Harness) WaitIdle(timeout time.Duration) {
+	h.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !h.idle(prefixed(h.Config, h.Config.StreamName), h.Config.GroupName) {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("consumer group %s of %s still busy after %s", h.Config.GroupName, h.Config.StreamName, timeout)
+		}
+		time.Sleep(idlePollInterval)
+	}
+}
+
+// DeadLetters returns the entries of the dead-letter stream of Config
+func (h *Harness) DeadLetters() []worker.Entry {
+	h.t.Helper()
+	stream := prefixed(h.Config, h.Config.DeadLetterStream)
+	if stream == "" {
+		stream = prefixed(h.Config, h.Config.StreamName+":dlq")
+	}
+	messages, err := h.client.XRange(context.Background(), stream, "-", "+").Result()
+	if err != nil {
+		h.t.Fatalf("read dead-letter stream: %v", err)
+	}
+	entries := make([]worker.Entry, len(messages))
+	for i, message := range messages {
+		entries[i] = worker.Entry{ID: message.ID, Values: message.Values}
+	}
+	return entries
+}
+
+// prefixed returns a key with the KeyPrefix of config, like the worker
+func prefixed(config *worker.Config, key string) string {
+	if key == "" || strings.HasPrefix(key, config.KeyPrefix) {
+		return key
+	}
+	return config.KeyPrefix + key
+}
+
+// groupExists reports whether the consumer group exists on the stream
+func (h *Harness) groupExists(stream, group string) bool {
+	_, ok := h.group(stream, group)
+	return ok
+}
+
+// idle reports whether the consumer group has no pending entries and was
+// delivered the last entry of the stream
+func (h *Harness) idle(stream, group string) bool {
+	info, ok := h.group(stream, group)
+	if !ok {
+		return false
+	}
+	if pending, _ := info["pending"].(int64); pending > 0 {
+		return false
+	}
+	last, err := h.client.XRevRangeN(context.Background(), stream, "+", "-", 1).Result()
+	if err != nil {
+		return false
+	}
+	return len(last) == 0 || info["last-delivered-id"] == last[0].ID
+}
+
+// group returns the fields XINFO GROUPS reports for the consumer group
+func (h *Harness) group(stream, group string) (map[string]any, bool) {
+	groups, err := redisx.XInfo(context.Background(), h.client, "GROUPS", stream)
+	if err != nil {
+		return nil, false
+	}
+	for _, info := range groups {
+		if info["name"] == group {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+// StatusRecorder is a worker.StatusReporter keeping the updates it is given
+type StatusRecorder struct {
+	mu      sync.Mutex
+	updates []worker.StatusUpdate
+}
+
+// ReportStatus implements worker.StatusReporter
+func (r *StatusRecorder) ReportStatus(ctx context.Context, update worker.StatusUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, update)
+	return nil
+}
+
+// Updates returns the updates recorded so far, in order
+func (r *StatusRecorder) Updates() []worker.StatusUpdate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]worker.StatusUpdate(nil), r.updates...)
+}
+
+// Last returns the last update of a job, or false if it had none
+func (r *StatusRecorder) Last(id string) (worker.StatusUpdate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.updates) - 1; i >= 0; i-- {
+		if r.updates[i].ID == id {
+			return r.updates[i], true
+		}
+	}
+	return worker.StatusUpdate{}, false
+}
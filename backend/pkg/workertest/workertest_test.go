@@ -0,0 +1,94 @@
+package workertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soham901/go-redis-stream-worker/pkg/producer"
+	"github.com/soham901/go-redis-stream-worker/pkg/worker"
+	"github.com/soham901/go-redis-stream-worker/pkg/workertest"
+)
+
+func TestHarnessProcessesJobs(t *testing.T) {
+	h := workertest.New(t)
+	w := h.Worker()
+	w.Handle("echo", func(ctx context.Context, msg worker.Message) (any, error) {
+		return msg.Body, nil
+	})
+	h.Run(w)
+
+	h.Enqueue(`"hello"`, producer.WithType("echo"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	update, ok := h.Statuses.Last("1")
+	if !ok || update.Status != "completed" {
+		t.Fatalf("last update %+v, want completed", update)
+	}
+	if update.Result != `"hello"` {
+		t.Errorf("result %v, want %q", update.Result, `"hello"`)
+	}
+	if dead := h.DeadLetters(); len(dead) != 0 {
+		t.Errorf("got %d dead letters, want none", len(dead))
+	}
+}
+
+func TestHarnessDeadLetters(t *testing.T) {
+	h := workertest.New(t)
+	h.Config.MaxRetries = 1
+	w := h.Worker()
+	w.Handle("broken", func(ctx context.Context, msg worker.Message) (any, error) {
+		return nil, errors.New("broken")
+	})
+	h.Run(w)
+
+	entryID := h.Enqueue("{}", producer.WithType("broken"), producer.WithID("1"))
+	h.WaitIdle(5 * time.Second)
+
+	dead := h.DeadLetters()
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	if got := dead[0].Values["dlq_original_id"]; got != entryID {
+		t.Errorf("dead letter of %v, want %s", got, entryID)
+	}
+	if update, _ := h.Statuses.Last("1"); update.Status != "failed" {
+		t.Errorf("last status %s, want failed", update.Status)
+	}
+}
+
+func TestHarnessStopsQuickly(t *testing.T) {
+	h := workertest.New(t)
+	stop := h.Run(h.Worker())
+
+	// Let the readers block on XREADGROUP before stopping them
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stopping took %s", elapsed)
+	}
+	stop()
+}
+
+func TestStatusRecorder(t *testing.T) {
+	var r workertest.StatusRecorder
+	if _, ok := r.Last("1"); ok {
+		t.Fatal("Last of an unknown job reported an update")
+	}
+	ctx := context.Background()
+	r.ReportStatus(ctx, worker.StatusUpdate{ID: "1", Status: "processing"})
+	r.ReportStatus(ctx, worker.StatusUpdate{ID: "2", Status: "processing"})
+	r.ReportStatus(ctx, worker.StatusUpdate{ID: "1", Status: "completed"})
+
+	if updates := r.Updates(); len(updates) != 3 {
+		t.Fatalf("got %d updates, want 3", len(updates))
+	}
+	if update, _ := r.Last("1"); update.Status != "completed" {
+		t.Errorf("last status of 1 is %s, want completed", update.Status)
+	}
+	if update, _ := r.Last("2"); update.Status != "processing" {
+		t.Errorf("last status of 2 is %s, want processing", update.Status)
+	}
+}